@@ -6,6 +6,7 @@
 package umsgpack_test
 
 import (
+	"bytes"
 	"testing"
 	"time"
 
@@ -68,6 +69,73 @@ func BenchmarkMarshalToBytes(b *testing.B) {
 	}
 }
 
+func BenchmarkMarshalToBytes_parallel(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			obj := benchmarkMarshalCorpus[i%len(benchmarkMarshalCorpus)]
+			if _, err := MarshalToBytes(nil, obj); err != nil {
+				b.Errorf("MarshalToBytes failed: %v", err)
+				return
+			}
+			i += 1
+		}
+	})
+}
+
+// benchmarkMarshal1KObject is an object whose encoding is about 1KB.
+var benchmarkMarshal1KObject = []any{
+	string(fillerChars(300)),
+	fillerBytes(300),
+	[]any{string(fillerChars(100)), string(fillerChars(100)), 123, 4.5},
+	map[string]any{"foo": string(fillerChars(100)), "bar": 67},
+}
+
+func BenchmarkMarshalToBytesWithCap(b *testing.B) {
+	b.Run("default", func(b *testing.B) {
+		for i := 0; i < b.N; i += 1 {
+			if encoded, err := MarshalToBytes(nil, benchmarkMarshal1KObject); err != nil {
+				b.Fatalf("MarshalToBytes failed: %v", err)
+			} else {
+				benchmarkMarshalToBytesSink = encoded
+			}
+		}
+	})
+	b.Run("cap=1024", func(b *testing.B) {
+		for i := 0; i < b.N; i += 1 {
+			if encoded, err := MarshalToBytesWithCap(nil, benchmarkMarshal1KObject, 1024); err != nil {
+				b.Fatalf("MarshalToBytesWithCap failed: %v", err)
+			} else {
+				benchmarkMarshalToBytesSink = encoded
+			}
+		}
+	})
+}
+
+var benchmarkMarshalStringStringMapCorpus = []any{
+	map[string]string{
+		"name":     "Some Name",
+		"email":    "someone@example.com",
+		"city":     "Somewhere",
+		"country":  "Someplace",
+		"language": "en",
+		"timezone": "UTC",
+		"status":   "active",
+		"role":     "user",
+	},
+}
+
+func BenchmarkMarshalToBytes_stringStringMap(b *testing.B) {
+	for i := 0; i < b.N; i += 1 {
+		obj := benchmarkMarshalStringStringMapCorpus[i%len(benchmarkMarshalStringStringMapCorpus)]
+		if encoded, err := MarshalToBytes(nil, obj); err != nil {
+			b.Fatalf("MarshalToBytes failed: %v", err)
+		} else {
+			benchmarkMarshalToBytesSink = encoded
+		}
+	}
+}
+
 var benchmarkUnmarshalBytesSink any
 
 func BenchmarkUnmarshalBytes(b *testing.B) {
@@ -81,3 +149,123 @@ func BenchmarkUnmarshalBytes(b *testing.B) {
 		}
 	}
 }
+
+type benchmarkStructInner struct {
+	Label string
+	Value float64
+}
+
+type benchmarkStruct struct {
+	ID      int
+	Name    string
+	Email   string
+	Active  bool
+	Score   float64
+	Tags    []string
+	Inner   benchmarkStructInner
+	Counts  map[string]int
+	Created time.Time
+	Data    []byte
+	private int
+}
+
+var benchmarkStructMarshalCorpus = []any{
+	benchmarkStruct{
+		ID:      1234567,
+		Name:    "Some Name",
+		Email:   "someone@example.com",
+		Active:  true,
+		Score:   98.6,
+		Tags:    []string{"a", "bc", "def"},
+		Inner:   benchmarkStructInner{Label: "inner", Value: 1.5},
+		Counts:  map[string]int{"x": 1, "y": 2},
+		Created: time.Unix(0x12345678, 0),
+		Data:    fillerBytes(32),
+	},
+}
+
+var benchmarkStructMarshalOptions = &MarshalOptions{
+	ApplicationMarshalTransformer: DefaultStructMarshalTransformer,
+}
+
+func BenchmarkStructMarshal(b *testing.B) {
+	for i := 0; i < b.N; i += 1 {
+		obj := benchmarkStructMarshalCorpus[i%len(benchmarkStructMarshalCorpus)]
+		if encoded, err := MarshalToBytes(benchmarkStructMarshalOptions, obj); err != nil {
+			b.Fatalf("MarshalToBytes failed: %v", err)
+		} else {
+			benchmarkMarshalToBytesSink = encoded
+		}
+	}
+}
+
+var benchmarkStructMarshalTransformerSink any
+
+// Benchmarks just the struct marshal transformer (repeatedly on the same struct type), to measure
+// the overhead of determining the fields to include.
+func BenchmarkStructMarshalTransformer(b *testing.B) {
+	obj := benchmarkStructMarshalCorpus[0]
+	for i := 0; i < b.N; i += 1 {
+		if result, err := DefaultStructMarshalTransformer(obj); err != nil {
+			b.Fatalf("DefaultStructMarshalTransformer failed: %v", err)
+		} else {
+			benchmarkStructMarshalTransformerSink = result
+		}
+	}
+}
+
+var benchmarkUnmarshalSink any
+
+// Benchmarks decoding a large (multi-megabyte) bin from an io.Reader.
+func BenchmarkUnmarshal_largeBin(b *testing.B) {
+	encoded, err := MarshalToBytes(nil, fillerBytes(4<<20))
+	if err != nil {
+		b.Fatalf("MarshalToBytes failed: %v", err)
+	}
+	b.SetBytes(int64(len(encoded)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		if obj, err := Unmarshal(nil, bytes.NewReader(encoded)); err != nil {
+			b.Fatalf("Unmarshal failed: %v", err)
+		} else {
+			benchmarkUnmarshalSink = obj
+		}
+	}
+}
+
+// Benchmarks skipping a large (multi-megabyte) bin from an io.Reader (compare to
+// BenchmarkUnmarshal_largeBin).
+func BenchmarkSkip_largeBin(b *testing.B) {
+	encoded, err := MarshalToBytes(nil, fillerBytes(4<<20))
+	if err != nil {
+		b.Fatalf("MarshalToBytes failed: %v", err)
+	}
+	b.SetBytes(int64(len(encoded)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		if err := Skip(nil, bytes.NewReader(encoded)); err != nil {
+			b.Fatalf("Skip failed: %v", err)
+		}
+	}
+}
+
+// Benchmarks decoding a large (100k-entry) map.
+func BenchmarkUnmarshalBytes_largeMap(b *testing.B) {
+	m := make(map[any]any, 100_000)
+	for i := 0; i < 100_000; i += 1 {
+		m[i] = i
+	}
+	encoded, err := MarshalToBytes(nil, m)
+	if err != nil {
+		b.Fatalf("MarshalToBytes failed: %v", err)
+	}
+	b.SetBytes(int64(len(encoded)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		if obj, err := UnmarshalBytes(nil, encoded); err != nil {
+			b.Fatalf("UnmarshalBytes failed: %v", err)
+		} else {
+			benchmarkUnmarshalSink = obj
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains support for an application extension type for big.Int.
+
+package umsgpack
+
+import (
+	"errors"
+	"math/big"
+)
+
+// Errors ------------------------------------------------------------------------------------------
+
+// InvalidBigIntError is the error returned by UnmarshalBigIntExtensionType for an invalid big
+// integer.
+var InvalidBigIntError = errors.New("Invalid big integer")
+
+// Big integer extension ---------------------------------------------------------------------------
+
+// BigIntExtensionType is the (application) extension type used by
+// BigIntExtensionMarshalTransformer and BigIntExtensionUnmarshalTransformer. Note that this is not
+// a standard MessagePack extension type; to use a different extension type, use
+// MakeBigIntExtensionTransformers.
+const BigIntExtensionType int8 = 44
+
+// BigIntExtensionMarshalTransformer is a MarshalTransformerFn that transforms (non-nil) *big.Int to
+// an *UnresolvedExtensionType with extension type BigIntExtensionType, whose data is a sign byte
+// (0 for non-negative, 1 for negative) followed by the absolute value as (minimal) big-endian
+// bytes (so zero is encoded as just the sign byte 0).
+func BigIntExtensionMarshalTransformer(obj any) (any, error) {
+	return marshalBigIntExtension(BigIntExtensionType, obj)
+}
+
+var _ MarshalTransformerFn = BigIntExtensionMarshalTransformer
+
+// BigIntExtensionUnmarshalTransformer is the UnmarshalTransformerFn corresponding to
+// BigIntExtensionMarshalTransformer.
+var BigIntExtensionUnmarshalTransformer UnmarshalTransformerFn = MakeExtensionTypeUnmarshalTransformer(
+	map[int8]UnmarshalExtensionTypeFn{
+		BigIntExtensionType: UnmarshalBigIntExtensionType,
+	},
+)
+
+// MakeBigIntExtensionTransformers makes a MarshalTransformerFn and corresponding
+// UnmarshalTransformerFn like BigIntExtensionMarshalTransformer and
+// BigIntExtensionUnmarshalTransformer, respectively, but using the given extension type.
+func MakeBigIntExtensionTransformers(extType int8) (MarshalTransformerFn, UnmarshalTransformerFn) {
+	marshalTransformer := func(obj any) (any, error) {
+		return marshalBigIntExtension(extType, obj)
+	}
+	unmarshalTransformer := MakeExtensionTypeUnmarshalTransformer(
+		map[int8]UnmarshalExtensionTypeFn{
+			extType: UnmarshalBigIntExtensionType,
+		},
+	)
+	return marshalTransformer, unmarshalTransformer
+}
+
+// marshalBigIntExtension is a helper for the big integer extension marshal transformers.
+func marshalBigIntExtension(extType int8, obj any) (any, error) {
+	x, ok := obj.(*big.Int)
+	if !ok || x == nil {
+		return obj, nil
+	}
+
+	// Note that Bytes returns the absolute value, with no leading zeros (and is empty for zero).
+	magnitude := x.Bytes()
+	data := make([]byte, 1+len(magnitude))
+	if x.Sign() < 0 {
+		data[0] = 1
+	}
+	copy(data[1:], magnitude)
+	return &UnresolvedExtensionType{ExtensionType: extType, Data: data}, nil
+}
+
+// UnmarshalBigIntExtensionType is an UnmarshalExtensionTypeFn that unmarshals the data for the big
+// integer extension type (see BigIntExtensionMarshalTransformer) to a *big.Int. Only minimal
+// encodings are accepted: the sign byte must be 0 or 1, the absolute value must not have leading
+// zero bytes, and zero must not be negative.
+func UnmarshalBigIntExtensionType(data []byte) (any, bool, error) {
+	if len(data) == 0 || data[0] > 1 {
+		return nil, false, InvalidBigIntError
+	}
+	negative := data[0] == 1
+	magnitude := data[1:]
+	if len(magnitude) > 0 && magnitude[0] == 0 {
+		return nil, false, InvalidBigIntError
+	}
+	if negative && len(magnitude) == 0 {
+		return nil, false, InvalidBigIntError
+	}
+
+	x := new(big.Int).SetBytes(magnitude)
+	if negative {
+		x.Neg(x)
+	}
+	return x, false, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests bigintext.go.
+
+package umsgpack_test
+
+import (
+	"math/big"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+// mustParseBigInt parses a (base 10) big integer, panicking on failure.
+func mustParseBigInt(s string) *big.Int {
+	x, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid big integer: " + s)
+	}
+	return x
+}
+
+func TestBigIntExtension(t *testing.T) {
+	mopts := &MarshalOptions{ApplicationMarshalTransformer: BigIntExtensionMarshalTransformer}
+	uopts := &UnmarshalOptions{ApplicationUnmarshalTransformer: BigIntExtensionUnmarshalTransformer}
+	magnitude := []byte{0x13, 0xaa, 0xf5, 0x04, 0xe4, 0xbc, 0x1e, 0x62, 0x17, 0x3f, 0x87, 0xa4, 0x37, 0x8c, 0x37, 0xb4, 0x9c, 0x8c, 0xcf, 0xf1, 0x96, 0xce, 0x3f, 0x0a, 0xd2}
+	testCases := []marshalTestCase{
+		{obj: big.NewInt(0), encoded: []byte{0xd4, 0x2c, 0x00}},
+		{obj: big.NewInt(1), encoded: []byte{0xd5, 0x2c, 0x00, 0x01}},
+		{obj: big.NewInt(-1), encoded: []byte{0xd5, 0x2c, 0x01, 0x01}},
+		{obj: big.NewInt(255), encoded: []byte{0xd5, 0x2c, 0x00, 0xff}},
+		{obj: big.NewInt(256), encoded: []byte{0xc7, 0x03, 0x2c, 0x00, 0x01, 0x00}},
+		{obj: big.NewInt(-256), encoded: []byte{0xc7, 0x03, 0x2c, 0x01, 0x01, 0x00}},
+		{obj: big.NewInt(-0x10203), encoded: []byte{0xd6, 0x2c, 0x01, 0x01, 0x02, 0x03}},
+		{obj: new(big.Int).Lsh(big.NewInt(1), 64), encoded: []byte{0xc7, 0x0a, 0x2c, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{obj: mustParseBigInt("123456789012345678901234567890123456789012345678901234567890"), encoded: append([]byte{0xc7, 0x1a, 0x2c, 0x00}, magnitude...)},
+		{obj: mustParseBigInt("-123456789012345678901234567890123456789012345678901234567890"), encoded: append([]byte{0xc7, 0x1a, 0x2c, 0x01}, magnitude...)},
+		// Other objects are unaffected.
+		{obj: int64(123), encoded: []byte{0x7b}, decoded: 123},
+	}
+	testMarshal(t, mopts, testCases)
+	testUnmarshal(t, uopts, unmarshalTestCasesFor(testCases))
+
+	// A nil *big.Int is marshalled as nil.
+	testMarshal(t, mopts, []marshalTestCase{
+		{obj: (*big.Int)(nil), encoded: []byte{0xc0}},
+	})
+}
+
+func TestMakeBigIntExtensionTransformers(t *testing.T) {
+	const extType = 17
+	marshalTransformer, unmarshalTransformer := MakeBigIntExtensionTransformers(extType)
+	mopts := &MarshalOptions{ApplicationMarshalTransformer: marshalTransformer}
+	uopts := &UnmarshalOptions{ApplicationUnmarshalTransformer: unmarshalTransformer}
+	testCases := []marshalTestCase{
+		{obj: big.NewInt(-256), encoded: []byte{0xc7, 0x03, 0x11, 0x01, 0x01, 0x00}},
+	}
+	testMarshal(t, mopts, testCases)
+	testUnmarshal(t, uopts, unmarshalTestCasesFor(testCases))
+
+	// It shouldn't be unmarshalled as a big integer with the default extension type.
+	testUnmarshal(t, &UnmarshalOptions{ApplicationUnmarshalTransformer: BigIntExtensionUnmarshalTransformer}, []unmarshalTestCase{
+		{encoded: testCases[0].encoded, decoded: &UnresolvedExtensionType{ExtensionType: extType, Data: []byte{0x01, 0x01, 0x00}}},
+	})
+}
+
+func TestUnmarshalBigIntExtensionType(t *testing.T) {
+	for _, data := range [][]byte{nil, {2}, {0xff, 0x01}, {0x00, 0x00}, {0x01, 0x00, 0x01}, {0x01}} {
+		if obj, _, err := UnmarshalBigIntExtensionType(data); err != InvalidBigIntError {
+			t.Errorf("Unexpected result for data=%v: %v, %v", data, obj, err)
+		}
+	}
+
+	if obj, mapKeySupported, err := UnmarshalBigIntExtensionType([]byte{0x01, 0x01, 0x00}); err != nil || obj.(*big.Int).Cmp(big.NewInt(-256)) != 0 || mapKeySupported {
+		t.Errorf("Unexpected result: %v, %v, %v", obj, mapKeySupported, err)
+	}
+}
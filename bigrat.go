@@ -0,0 +1,42 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains a MarshalTransformerFn (and its inverse) for marshalling big.Rat as a string.
+
+package umsgpack
+
+import (
+	"errors"
+	"math/big"
+)
+
+// InvalidBigRatError is the error returned by UnmarshalBigRat if the string is not a valid
+// rational number.
+var InvalidBigRatError = errors.New("Invalid big rational")
+
+// BigRatMarshalTransformer is a MarshalTransformerFn that transforms (non-nil) *big.Rat to the
+// result of its RatString method (e.g., "3/4", or "-2" for an integer), which is then marshalled as
+// a string. This is lossless and human-readable.
+//
+// Since the result is just a string, there is no corresponding unmarshal transformer; use
+// UnmarshalBigRat to do the inverse.
+func BigRatMarshalTransformer(obj any) (any, error) {
+	x, ok := obj.(*big.Rat)
+	if !ok || x == nil {
+		return obj, nil
+	}
+	return x.RatString(), nil
+}
+
+var _ MarshalTransformerFn = BigRatMarshalTransformer
+
+// UnmarshalBigRat is the inverse of BigRatMarshalTransformer: it parses s (as unmarshalled from a
+// string) to a *big.Rat. It accepts any format accepted by (*big.Rat).SetString (including, e.g.,
+// "0.75"), returning InvalidBigRatError if s is not valid.
+func UnmarshalBigRat(s string) (*big.Rat, error) {
+	x, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, InvalidBigRatError
+	}
+	return x, nil
+}
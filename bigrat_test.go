@@ -0,0 +1,77 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests bigrat.go.
+
+package umsgpack_test
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestBigRat_roundTrip(t *testing.T) {
+	large, _ := new(big.Rat).SetString("123456789012345678901234567890/987654321098765432109876543211")
+	opts := &MarshalOptions{ApplicationMarshalTransformer: BigRatMarshalTransformer}
+	for _, x := range []*big.Rat{
+		new(big.Rat),
+		big.NewRat(3, 4),
+		big.NewRat(-3, 4),
+		big.NewRat(-2, 1),
+		big.NewRat(6, 8), // Normalized to 3/4.
+		large,
+		new(big.Rat).Neg(large),
+	} {
+		encoded, err := MarshalToBytes(opts, x)
+		if err != nil {
+			t.Errorf("Unexpected error marshalling %v: %v", x, err)
+			continue
+		}
+		decoded, err := UnmarshalBytes(nil, encoded)
+		if err != nil {
+			t.Errorf("Unexpected error unmarshalling %v: %v", x, err)
+			continue
+		}
+		if s, ok := decoded.(string); !ok || s != x.RatString() {
+			t.Errorf("Unexpected result unmarshalling %v: %#v", x, decoded)
+		} else if y, err := UnmarshalBigRat(s); err != nil || y.Cmp(x) != 0 {
+			t.Errorf("Unexpected result from UnmarshalBigRat for %v: %v, %v", x, y, err)
+		}
+	}
+}
+
+func TestBigRat_encoding(t *testing.T) {
+	opts := &MarshalOptions{ApplicationMarshalTransformer: BigRatMarshalTransformer}
+	testCases := []struct {
+		obj      any
+		expected []byte
+	}{
+		{new(big.Rat), []byte{0xa1, 0x30}},
+		{big.NewRat(3, 4), []byte{0xa3, 0x33, 0x2f, 0x34}},
+		{big.NewRat(-2, 1), []byte{0xa2, 0x2d, 0x32}},
+		// Nil *big.Rat is not transformed (and marshalled as nil).
+		{(*big.Rat)(nil), []byte{0xc0}},
+		// Other objects are unaffected.
+		{"3/4", []byte{0xa3, 0x33, 0x2f, 0x34}},
+	}
+	for _, tc := range testCases {
+		if encoded, err := MarshalToBytes(opts, tc.obj); err != nil || !bytes.Equal(encoded, tc.expected) {
+			t.Errorf("Unexpected result marshalling %v: %v, %v (expected: %v)", tc.obj, encoded, err, tc.expected)
+		}
+	}
+}
+
+func TestUnmarshalBigRat(t *testing.T) {
+	for _, s := range []string{"", "x", "1/0", "3/", "/4"} {
+		if x, err := UnmarshalBigRat(s); err != InvalidBigRatError {
+			t.Errorf("Unexpected result for %q: %v, %v", s, x, err)
+		}
+	}
+
+	if x, err := UnmarshalBigRat("0.75"); err != nil || x.Cmp(big.NewRat(3, 4)) != 0 {
+		t.Errorf("Unexpected result: %v, %v", x, err)
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains support for unmarshalling binary data marshalled as an extension (see
+// MarshalOptions.BinaryAsExtension).
+
+package umsgpack
+
+// Binary extension --------------------------------------------------------------------------------
+
+// MakeBinaryExtensionUnmarshalTransformer makes an UnmarshalTransformerFn that unmarshals
+// extensions of the given type, as marshalled with the BinaryAsExtension option (with
+// BinaryExtensionType set to extType), to []byte.
+func MakeBinaryExtensionUnmarshalTransformer(extType int8) UnmarshalTransformerFn {
+	return MakeExtensionTypeUnmarshalTransformer(
+		map[int8]UnmarshalExtensionTypeFn{
+			extType: UnmarshalBinaryExtensionType,
+		},
+	)
+}
+
+// UnmarshalBinaryExtensionType is an UnmarshalExtensionTypeFn that unmarshals the data for a binary
+// extension type (see MarshalOptions.BinaryAsExtension) to a []byte, which is just the data.
+func UnmarshalBinaryExtensionType(data []byte) (any, bool, error) {
+	return data, false, nil
+}
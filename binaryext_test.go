@@ -0,0 +1,48 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests binaryext.go.
+
+package umsgpack_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestBinaryExtension_roundTrip(t *testing.T) {
+	const extType = 7
+	mopts := &MarshalOptions{BinaryAsExtension: true, BinaryExtensionType: extType}
+	uopts := &UnmarshalOptions{ApplicationUnmarshalTransformer: MakeBinaryExtensionUnmarshalTransformer(extType)}
+	for _, obj := range []any{
+		[]byte{},
+		[]byte{0x01},
+		[]byte("hello, world"),
+		fillerBytes(300),
+		[]any{[]byte{0x01, 0x02}, "str", map[any]any{"bin": []byte{0x03}}},
+	} {
+		if encoded, err := MarshalToBytes(mopts, obj); err != nil {
+			t.Errorf("Unexpected error marshalling %v: %v", obj, err)
+		} else if decoded, err := UnmarshalBytes(uopts, encoded); err != nil || !reflect.DeepEqual(decoded, obj) {
+			t.Errorf("Unexpected result unmarshalling %v: %v, %v", obj, decoded, err)
+		} else if decoded, err := UnmarshalBytes(nil, encoded); err != nil || reflect.DeepEqual(decoded, obj) {
+			// Without the transformer, binary data should be unmarshalled as an extension.
+			t.Errorf("Unexpected result unmarshalling %v without transformer: %v, %v", obj, decoded, err)
+		}
+	}
+
+	// Other extension types are unaffected.
+	encoded := []byte{0xd4, 0x08, 0x01}
+	if decoded, err := UnmarshalBytes(uopts, encoded); err != nil || !reflect.DeepEqual(decoded, &UnresolvedExtensionType{ExtensionType: 8, Data: []byte{0x01}}) {
+		t.Errorf("Unexpected result: %v, %v", decoded, err)
+	}
+}
+
+func TestUnmarshalBinaryExtensionType(t *testing.T) {
+	data := []byte{0x01, 0x02}
+	if obj, mapKeySupported, err := UnmarshalBinaryExtensionType(data); err != nil || !reflect.DeepEqual(obj, data) || mapKeySupported {
+		t.Errorf("Unexpected result: %v, %v, %v", obj, mapKeySupported, err)
+	}
+}
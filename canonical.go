@@ -0,0 +1,160 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains IsCanonical and Canonicalize.
+
+package umsgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// isCanonicalOptions are the options used by IsCanonical for its (strict) unmarshal.
+var isCanonicalOptions = &UnmarshalOptions{
+	DisableUnsupportedKeyTypeError:      true,
+	RequireMinimalEncoding:              true,
+	RejectTrailingBytes:                 true,
+	DisableStandardUnmarshalTransformer: true,
+}
+
+// IsCanonical determines whether data consists of exactly one object in canonical form: all
+// encodings are minimal (see UnmarshalOptions.RequireMinimalEncoding), the entries of all maps are
+// in (strictly) increasing order of their keys' encoded bytes (as produced by Marshal with the
+// SortUnlistedMapKeys option), hence there are no duplicate keys, and there are no trailing bytes.
+//
+// It returns false (with no error) if data is valid but not canonical, and an error (as for
+// UnmarshalBytes) if data is invalid. Extension data is not checked (e.g., timestamps need not be
+// valid).
+func IsCanonical(data []byte) (bool, error) {
+	if _, err := UnmarshalBytes(isCanonicalOptions, data); err != nil {
+		if errors.Is(err, NonMinimalEncodingError) || errors.Is(err, TrailingBytesError) || errors.Is(err, DuplicateKeyError) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	_, sorted := checkSortedMapKeys(data, 0)
+	return sorted, nil
+}
+
+// checkSortedMapKeys checks that the entries of all maps in the (valid) object at data[pos:] are in
+// strictly increasing order of their keys' encoded bytes. It returns the position just after the
+// object and whether the check passed (if not, the returned position is meaningless).
+func checkSortedMapKeys(data []byte, pos int) (int, bool) {
+	b := data[pos]
+	var n int
+	isMap := false
+	switch {
+	case b >= 0x80 && b <= 0x8f: // fixmap
+		n, pos, isMap = int(b&0b1111), pos+1, true
+	case b >= 0x90 && b <= 0x9f: // fixarray
+		n, pos = int(b&0b1111), pos+1
+	case b == 0xdc: // array 16
+		n, pos = int(binary.BigEndian.Uint16(data[pos+1:])), pos+3
+	case b == 0xdd: // array 32
+		n, pos = int(binary.BigEndian.Uint32(data[pos+1:])), pos+5
+	case b == 0xde: // map 16
+		n, pos, isMap = int(binary.BigEndian.Uint16(data[pos+1:])), pos+3, true
+	case b == 0xdf: // map 32
+		n, pos, isMap = int(binary.BigEndian.Uint32(data[pos+1:])), pos+5, true
+	default:
+		// Not a container, so just skip it.
+		size, err := SkipBytesN(nil, data[pos:])
+		return pos + size, err == nil
+	}
+
+	if !isMap {
+		for i := 0; i < n; i += 1 {
+			var ok bool
+			if pos, ok = checkSortedMapKeys(data, pos); !ok {
+				return 0, false
+			}
+		}
+		return pos, true
+	}
+
+	var prevKey []byte
+	for i := 0; i < n; i += 1 {
+		keyEnd, ok := checkSortedMapKeys(data, pos)
+		if !ok {
+			return 0, false
+		}
+		key := data[pos:keyEnd]
+		if prevKey != nil && bytes.Compare(prevKey, key) >= 0 {
+			return 0, false
+		}
+		prevKey = key
+
+		if pos, ok = checkSortedMapKeys(data, keyEnd); !ok {
+			return 0, false
+		}
+	}
+	return pos, true
+}
+
+// canonicalizeUnmarshalOptions are the options used by Canonicalize to unmarshal.
+var canonicalizeUnmarshalOptions = &UnmarshalOptions{
+	RejectTrailingBytes:                 true,
+	DisableStandardUnmarshalTransformer: true,
+}
+
+// canonicalizeMarshalOptions are the options used by Canonicalize to marshal.
+var canonicalizeMarshalOptions = &MarshalOptions{
+	ApplicationMarshalTransformer:     sortedMapMarshalTransformer,
+	DisableStandardMarshalTransformer: true,
+}
+
+// canonicalizeMapKeyMarshalOptions are the options used by sortedMapMarshalTransformer to marshal
+// map keys (which are never maps, so need no transformer).
+var canonicalizeMapKeyMarshalOptions = &MarshalOptions{DisableStandardMarshalTransformer: true}
+
+// Canonicalize returns the canonical form (see IsCanonical) of data, which must consist of exactly
+// one object: it unmarshals data and marshals the result again, with all encodings minimal and the
+// entries of all maps (at any level) sorted by their keys' encoded bytes. Thus the result is
+// deterministic, and canonicalizing it again yields the same bytes.
+//
+// Extensions are left unresolved (i.e., their data, e.g., for timestamps, is kept as-is). Errors
+// are as for UnmarshalBytes (with RejectTrailingBytes set) and Marshal; in particular, data
+// containing maps with keys that Unmarshal doesn't support (e.g., arrays or extensions), with
+// duplicate keys, or with NaN keys can't be canonicalized.
+func Canonicalize(data []byte) ([]byte, error) {
+	obj, err := UnmarshalBytes(canonicalizeUnmarshalOptions, data)
+	if err != nil {
+		return nil, err
+	}
+	return MarshalToBytesWithCap(canonicalizeMarshalOptions, obj, len(data))
+}
+
+// sortedMapMarshalTransformer is a MarshalTransformerFn that transforms map[any]any (as produced by
+// Unmarshal) to an *OrderedMap whose entries are sorted by their keys' encoded bytes.
+func sortedMapMarshalTransformer(obj any) (any, error) {
+	m, ok := obj.(map[any]any)
+	if !ok {
+		return obj, nil
+	}
+
+	type entry struct {
+		key        any
+		encodedKey []byte
+	}
+	entries := make([]entry, 0, len(m))
+	for key := range m {
+		encodedKey, err := MarshalToBytes(canonicalizeMapKeyMarshalOptions, key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{key: key, encodedKey: encodedKey})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].encodedKey, entries[j].encodedKey) < 0
+	})
+
+	keys := make([]any, len(entries))
+	for i, e := range entries {
+		keys[i] = e.key
+	}
+	return &OrderedMap{Keys: keys, Values: m}, nil
+}
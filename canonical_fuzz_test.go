@@ -0,0 +1,38 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file fuzz tests canonical.go.
+
+package umsgpack_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func FuzzCanonicalize(f *testing.F) {
+	for _, tC := range commonUnmarshalTestCases {
+		// Skip really large test cases.
+		if len(tC.encoded) > 5000 {
+			continue
+		}
+		f.Add(tC.encoded)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		canonical, err := Canonicalize(data)
+		if err != nil {
+			return
+		}
+
+		// The result should be canonical, and canonicalizing it again should be a no-op.
+		if isCanonical, err := IsCanonical(canonical); err != nil || !isCanonical {
+			t.Errorf("IsCanonical(%q) = %v, %v", canonical, isCanonical, err)
+		}
+		if again, err := Canonicalize(canonical); err != nil || !bytes.Equal(again, canonical) {
+			t.Errorf("Canonicalize(%q) = %q, %v", canonical, again, err)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests canonical.go.
+
+package umsgpack_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestIsCanonical(t *testing.T) {
+	testCases := []struct {
+		data      []byte
+		canonical bool
+		err       error
+	}{
+		// Scalars.
+		{[]byte{0x01}, true, nil},
+		{[]byte{0xcc, 0x01}, true, nil},
+		{[]byte{0xd0, 0x01}, false, nil},
+		{[]byte{0xa1, 0x61}, true, nil},
+		{[]byte{0xd9, 0x01, 0x61}, false, nil},
+		{[]byte{0xd6, 0x01, 0x00, 0x00, 0x00, 0x00}, true, nil},
+		{[]byte{0xc7, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00}, false, nil},
+		// Extension data isn't checked.
+		{[]byte{0xc7, 0x00, 0xff}, true, nil},
+		// Trailing bytes.
+		{[]byte{0x01, 0x02}, false, nil},
+		// Arrays.
+		{[]byte{0x92, 0x01, 0xa1, 0x61}, true, nil},
+		{[]byte{0xdc, 0x00, 0x01, 0x01}, false, nil},
+		// Maps: sorted by encoded keys.
+		{[]byte{0x80}, true, nil},
+		{[]byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0x02}, true, nil},
+		{[]byte{0x82, 0xa1, 0x62, 0x01, 0xa1, 0x61, 0x02}, false, nil},
+		{[]byte{0x82, 0xa1, 0x61, 0x01, 0xa2, 0x61, 0x61, 0x02}, true, nil},
+		{[]byte{0x82, 0xa1, 0x62, 0x01, 0xa2, 0x61, 0x61, 0x02}, true, nil},
+		{[]byte{0x82, 0x01, 0xc0, 0xa1, 0x61, 0xc0}, true, nil},
+		{[]byte{0x82, 0xa1, 0x61, 0xc0, 0x01, 0xc0}, false, nil},
+		{[]byte{0x82, 0x01, 0xc0, 0xcc, 0x01, 0xc0}, true, nil},
+		{[]byte{0xde, 0x00, 0x01, 0x01, 0xc0}, false, nil},
+		// Duplicate keys.
+		{[]byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x61, 0x02}, false, nil},
+		// Keys that Unmarshal doesn't support.
+		{[]byte{0x82, 0xd4, 0x01, 0x00, 0xc0, 0xd4, 0x01, 0x01, 0xc0}, true, nil},
+		{[]byte{0x82, 0xd4, 0x01, 0x01, 0xc0, 0xd4, 0x01, 0x00, 0xc0}, false, nil},
+		{[]byte{0x82, 0x91, 0x01, 0xc0, 0x91, 0x01, 0xc0}, false, nil},
+		// Nested.
+		{[]byte{0x91, 0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0x02}, true, nil},
+		{[]byte{0x91, 0x82, 0xa1, 0x62, 0x01, 0xa1, 0x61, 0x02}, false, nil},
+		{[]byte{0x81, 0x01, 0x82, 0xa1, 0x62, 0x01, 0xa1, 0x61, 0x02}, false, nil},
+		{[]byte{0x82, 0x81, 0x02, 0xc0, 0xc0, 0x81, 0x01, 0xc0, 0xc0}, false, nil},
+		// Invalid.
+		{[]byte{}, false, io.EOF},
+		{[]byte{0xc1}, false, InvalidFormatError},
+		{[]byte{0x92, 0x01}, false, io.ErrUnexpectedEOF},
+		{[]byte{0x82, 0xa1, 0x62, 0x01, 0xa1, 0x61}, false, io.ErrUnexpectedEOF},
+	}
+	for _, tc := range testCases {
+		if canonical, err := IsCanonical(tc.data); !errors.Is(err, tc.err) {
+			t.Errorf("unexpected error for data=%q: %v (expected: %v)", tc.data, err, tc.err)
+		} else if canonical != tc.canonical {
+			t.Errorf("unexpected result for data=%q: %v", tc.data, canonical)
+		}
+	}
+}
+
+func TestIsCanonical_marshalled(t *testing.T) {
+	opts := &MarshalOptions{SortUnlistedMapKeys: true, MapKeyOrder: []any{}}
+	for _, obj := range []any{
+		nil,
+		-1,
+		uint(300),
+		"hello",
+		[]any{1, "two", 3.0},
+		map[string]any{"c": 1, "a": 2, "b": 3, "aa": 4},
+		map[any]any{1: "x", "y": 2, false: nil},
+	} {
+		if data, err := MarshalToBytes(opts, obj); err != nil {
+			t.Errorf("unexpected error marshalling %v: %v", obj, err)
+		} else if canonical, err := IsCanonical(data); err != nil || !canonical {
+			t.Errorf("unexpected result for %v (data=%q): %v, %v", obj, data, canonical, err)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	testCases := []struct {
+		data      []byte
+		canonical []byte
+		err       error
+	}{
+		// Already canonical.
+		{[]byte{0x01}, []byte{0x01}, nil},
+		{[]byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0x02}, []byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0x02}, nil},
+		// Non-minimal encodings.
+		{[]byte{0xd0, 0x01}, []byte{0x01}, nil},
+		{[]byte{0xcd, 0x00, 0x01}, []byte{0xcc, 0x01}, nil},
+		{[]byte{0xd9, 0x01, 0x61}, []byte{0xa1, 0x61}, nil},
+		{[]byte{0xdc, 0x00, 0x01, 0x01}, []byte{0x91, 0x01}, nil},
+		{[]byte{0xc7, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00}, []byte{0xd6, 0x01, 0x00, 0x00, 0x00, 0x00}, nil},
+		// Extension data is kept as-is.
+		{[]byte{0xc7, 0x00, 0xff}, []byte{0xc7, 0x00, 0xff}, nil},
+		{[]byte{0xd7, 0xff, 0, 0, 0, 0, 0, 0, 0, 0}, []byte{0xd7, 0xff, 0, 0, 0, 0, 0, 0, 0, 0}, nil},
+		// Unsorted maps, at any level.
+		{[]byte{0x82, 0xa1, 0x62, 0x01, 0xa1, 0x61, 0x02}, []byte{0x82, 0xa1, 0x61, 0x02, 0xa1, 0x62, 0x01}, nil},
+		{[]byte{0x82, 0xa1, 0x61, 0xc0, 0x01, 0xc0}, []byte{0x82, 0x01, 0xc0, 0xa1, 0x61, 0xc0}, nil},
+		{
+			[]byte{0x91, 0x81, 0x01, 0xde, 0x00, 0x02, 0xa1, 0x62, 0x01, 0xa1, 0x61, 0x02},
+			[]byte{0x91, 0x81, 0x01, 0x82, 0xa1, 0x61, 0x02, 0xa1, 0x62, 0x01},
+			nil,
+		},
+		// Errors.
+		{[]byte{}, nil, io.EOF},
+		{[]byte{0xc1}, nil, InvalidFormatError},
+		{[]byte{0x01, 0x02}, nil, TrailingBytesError},
+		{[]byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x61, 0x02}, nil, DuplicateKeyError},
+		{[]byte{0x81, 0xd4, 0x01, 0x00, 0xc0}, nil, UnsupportedKeyTypeError},
+		{[]byte{0x81, 0xcb, 0x7f, 0xf8, 0, 0, 0, 0, 0, 0x01, 0xc0}, nil, InvalidMapKeyError},
+	}
+	for _, tc := range testCases {
+		if canonical, err := Canonicalize(tc.data); !errors.Is(err, tc.err) {
+			t.Errorf("unexpected error for data=%q: %v (expected: %v)", tc.data, err, tc.err)
+		} else if !bytes.Equal(canonical, tc.canonical) {
+			t.Errorf("unexpected result for data=%q: %q", tc.data, canonical)
+		}
+	}
+}
+
+func TestCanonicalize_idempotent(t *testing.T) {
+	for _, tC := range commonUnmarshalTestCases {
+		canonical, err := Canonicalize(tC.encoded)
+		if err != nil {
+			continue
+		}
+		if isCanonical, err := IsCanonical(canonical); err != nil || !isCanonical {
+			t.Errorf("unexpected IsCanonical result for data=%q (canonical=%q): %v, %v", tC.encoded, canonical, isCanonical, err)
+		}
+		if again, err := Canonicalize(canonical); err != nil || !bytes.Equal(again, canonical) {
+			t.Errorf("unexpected result for data=%q (canonical=%q): %q, %v", tC.encoded, canonical, again, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains support for an application extension type for complex numbers.
+
+package umsgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Errors ------------------------------------------------------------------------------------------
+
+// InvalidComplexError is the error returned by UnmarshalComplexExtensionType for an invalid
+// complex number.
+var InvalidComplexError = errors.New("Invalid complex number")
+
+// Complex extension -------------------------------------------------------------------------------
+
+// ComplexExtensionType is the (application) extension type used by
+// ComplexExtensionMarshalTransformer and ComplexExtensionUnmarshalTransformer. Note that this is
+// not a standard MessagePack extension type; to use a different extension type, use
+// MakeComplexExtensionTransformers.
+const ComplexExtensionType int8 = 43
+
+// ComplexExtensionMarshalTransformer is a MarshalTransformerFn that transforms complex128 and
+// complex64 to an *UnresolvedExtensionType with extension type ComplexExtensionType, whose data is
+// the real and imaginary parts as big-endian 64-bit floats (so it is marshalled as a fixext 16).
+func ComplexExtensionMarshalTransformer(obj any) (any, error) {
+	return marshalComplexExtension(ComplexExtensionType, obj)
+}
+
+var _ MarshalTransformerFn = ComplexExtensionMarshalTransformer
+
+// ComplexExtensionUnmarshalTransformer is the UnmarshalTransformerFn corresponding to
+// ComplexExtensionMarshalTransformer. Note that complex numbers are always unmarshalled to
+// complex128.
+var ComplexExtensionUnmarshalTransformer UnmarshalTransformerFn = MakeExtensionTypeUnmarshalTransformer(
+	map[int8]UnmarshalExtensionTypeFn{
+		ComplexExtensionType: UnmarshalComplexExtensionType,
+	},
+)
+
+// MakeComplexExtensionTransformers makes a MarshalTransformerFn and corresponding
+// UnmarshalTransformerFn like ComplexExtensionMarshalTransformer and
+// ComplexExtensionUnmarshalTransformer, respectively, but using the given extension type.
+func MakeComplexExtensionTransformers(extType int8) (MarshalTransformerFn, UnmarshalTransformerFn) {
+	marshalTransformer := func(obj any) (any, error) {
+		return marshalComplexExtension(extType, obj)
+	}
+	unmarshalTransformer := MakeExtensionTypeUnmarshalTransformer(
+		map[int8]UnmarshalExtensionTypeFn{
+			extType: UnmarshalComplexExtensionType,
+		},
+	)
+	return marshalTransformer, unmarshalTransformer
+}
+
+// marshalComplexExtension is a helper for the complex extension marshal transformers.
+func marshalComplexExtension(extType int8, obj any) (any, error) {
+	var c complex128
+	switch o := obj.(type) {
+	case complex128:
+		c = o
+	case complex64:
+		c = complex128(o)
+	default:
+		return obj, nil
+	}
+
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data[0:8], math.Float64bits(real(c)))
+	binary.BigEndian.PutUint64(data[8:16], math.Float64bits(imag(c)))
+	return &UnresolvedExtensionType{ExtensionType: extType, Data: data}, nil
+}
+
+// UnmarshalComplexExtensionType is an UnmarshalExtensionTypeFn that unmarshals the data for the
+// complex extension type (see ComplexExtensionMarshalTransformer) to a complex128.
+func UnmarshalComplexExtensionType(data []byte) (any, bool, error) {
+	if len(data) != 16 {
+		return nil, false, InvalidComplexError
+	}
+	re := math.Float64frombits(binary.BigEndian.Uint64(data[0:8]))
+	im := math.Float64frombits(binary.BigEndian.Uint64(data[8:16]))
+	return complex(re, im), true, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests complexext.go.
+
+package umsgpack_test
+
+import (
+	"math"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestComplexExtension(t *testing.T) {
+	mopts := &MarshalOptions{ApplicationMarshalTransformer: ComplexExtensionMarshalTransformer}
+	uopts := &UnmarshalOptions{ApplicationUnmarshalTransformer: ComplexExtensionUnmarshalTransformer}
+	testCases := []marshalTestCase{
+		{obj: complex(0, 0), encoded: []byte{0xd8, 0x2b, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{obj: complex(1, 0), encoded: []byte{0xd8, 0x2b, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{obj: complex(0, 1), encoded: []byte{0xd8, 0x2b, 0, 0, 0, 0, 0, 0, 0, 0, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}},
+		{obj: complex(-1.5, 2.25), encoded: []byte{0xd8, 0x2b, 0xbf, 0xf8, 0, 0, 0, 0, 0, 0, 0x40, 0x02, 0, 0, 0, 0, 0, 0}},
+		{obj: complex(math.MaxFloat64, math.SmallestNonzeroFloat64), encoded: []byte{0xd8, 0x2b, 0x7f, 0xef, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0x01}},
+		{obj: complex(math.Inf(1), math.Inf(-1)), encoded: []byte{0xd8, 0x2b, 0x7f, 0xf0, 0, 0, 0, 0, 0, 0, 0xff, 0xf0, 0, 0, 0, 0, 0, 0}},
+		// complex64 is unmarshalled as complex128.
+		{obj: complex64(complex(1.5, -2)), encoded: []byte{0xd8, 0x2b, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0, 0xc0, 0, 0, 0, 0, 0, 0, 0}, decoded: complex(1.5, -2)},
+		// In containers.
+		{obj: []any{complex(1, 2), "x"}, encoded: []byte{0x92, 0xd8, 0x2b, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0, 0x40, 0, 0, 0, 0, 0, 0, 0, 0xa1, 0x78}},
+		// Other objects are unaffected.
+		{obj: 1.5, encoded: []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+	}
+	testMarshal(t, mopts, testCases)
+	testUnmarshal(t, uopts, unmarshalTestCasesFor(testCases))
+
+	// NaNs are preserved bitwise (including the sign of zero); reflect.DeepEqual can't compare them.
+	nanTestCases := []marshalTestCase{
+		{obj: complex(math.NaN(), 0), encoded: []byte{0xd8, 0x2b, 0x7f, 0xf8, 0, 0, 0, 0, 0, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{obj: complex(0, math.NaN()), encoded: []byte{0xd8, 0x2b, 0, 0, 0, 0, 0, 0, 0, 0, 0x7f, 0xf8, 0, 0, 0, 0, 0, 0x01}},
+		{obj: complex(math.Copysign(0, -1), math.NaN()), encoded: []byte{0xd8, 0x2b, 0x80, 0, 0, 0, 0, 0, 0, 0, 0x7f, 0xf8, 0, 0, 0, 0, 0, 0x01}},
+	}
+	testMarshal(t, mopts, nanTestCases)
+	for _, tc := range nanTestCases {
+		c := tc.obj.(complex128)
+		if decoded, err := UnmarshalBytes(uopts, tc.encoded); err != nil {
+			t.Errorf("Unexpected error unmarshalling %v: %v", c, err)
+		} else if d, ok := decoded.(complex128); !ok || math.Float64bits(real(d)) != math.Float64bits(real(c)) || math.Float64bits(imag(d)) != math.Float64bits(imag(c)) {
+			t.Errorf("Unexpected result unmarshalling %v: %v", c, decoded)
+		}
+	}
+
+	// Without the transformer, complex numbers are unsupported.
+	testMarshal(t, nil, []marshalTestCase{
+		{obj: complex(1, 2), err: UnsupportedTypeForMarshallingError},
+	})
+}
+
+func TestMakeComplexExtensionTransformers(t *testing.T) {
+	const extType = 17
+	marshalTransformer, unmarshalTransformer := MakeComplexExtensionTransformers(extType)
+	mopts := &MarshalOptions{ApplicationMarshalTransformer: marshalTransformer}
+	uopts := &UnmarshalOptions{ApplicationUnmarshalTransformer: unmarshalTransformer}
+	data := []byte{0x3f, 0xf8, 0, 0, 0, 0, 0, 0, 0xc0, 0, 0, 0, 0, 0, 0, 0}
+	testCases := []marshalTestCase{
+		{obj: complex(1.5, -2), encoded: append([]byte{0xd8, 0x11}, data...)},
+	}
+	testMarshal(t, mopts, testCases)
+	testUnmarshal(t, uopts, unmarshalTestCasesFor(testCases))
+
+	// It shouldn't be unmarshalled as a complex number with the default extension type.
+	testUnmarshal(t, &UnmarshalOptions{ApplicationUnmarshalTransformer: ComplexExtensionUnmarshalTransformer}, []unmarshalTestCase{
+		{encoded: testCases[0].encoded, decoded: &UnresolvedExtensionType{ExtensionType: extType, Data: data}},
+	})
+}
+
+func TestUnmarshalComplexExtensionType(t *testing.T) {
+	for _, data := range [][]byte{nil, {1, 2, 3, 4, 5, 6, 7, 8}, make([]byte, 15), make([]byte, 17)} {
+		if obj, _, err := UnmarshalComplexExtensionType(data); err != InvalidComplexError {
+			t.Errorf("Unexpected result for data=%v: %v, %v", data, obj, err)
+		}
+	}
+
+	if obj, mapKeySupported, err := UnmarshalComplexExtensionType(make([]byte, 16)); err != nil || obj != complex128(0) || !mapKeySupported {
+		t.Errorf("Unexpected result: %v, %v, %v", obj, mapKeySupported, err)
+	}
+}
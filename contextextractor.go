@@ -0,0 +1,36 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains MakeContextExtractor, for marshalling payloads from contexts.
+
+package umsgpack
+
+import (
+	"errors"
+)
+
+// Errors ------------------------------------------------------------------------------------------
+
+// ContextNotMarshallableError is the error returned if Marshal encounters a context.Context (after
+// transformers are applied). Contexts are not data, so they are never marshalled, even if their
+// underlying types would otherwise be marshallable. (To marshal data carried by a context, use a
+// marshal transformer, e.g., one made by MakeContextExtractor, to extract it.)
+var ContextNotMarshallableError = errors.New("Context not marshallable")
+
+// MakeContextExtractor ----------------------------------------------------------------------------
+
+// MakeContextExtractor makes a MarshalTransformerFn that extracts a marshallable payload from
+// objects, typically from a context.Context or something wrapping one (e.g., a request metadata
+// struct that embeds a context.Context, and so itself implements context.Context).
+//
+// extractFn is called for each object: if it returns true, the object is transformed to the
+// returned payload; otherwise the object is returned as-is. Note that the payload is marshalled as
+// usual (and so must not itself be a context.Context).
+func MakeContextExtractor(extractFn func(obj any) (payload any, ok bool)) MarshalTransformerFn {
+	return func(obj any) (any, error) {
+		if payload, ok := extractFn(obj); ok {
+			return payload, nil
+		}
+		return obj, nil
+	}
+}
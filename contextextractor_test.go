@@ -0,0 +1,73 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests contextextractor.go.
+
+package umsgpack_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+type testContextKey struct{}
+
+// testRequestContext wraps a context.Context (so it is itself a context.Context).
+type testRequestContext struct {
+	context.Context
+	RequestID string
+}
+
+func TestMakeContextExtractor(t *testing.T) {
+	extractor := MakeContextExtractor(func(obj any) (any, bool) {
+		rc, ok := obj.(*testRequestContext)
+		if !ok {
+			return nil, false
+		}
+		payload := map[string]any{"request_id": rc.RequestID}
+		if user, ok := rc.Value(testContextKey{}).(string); ok {
+			payload["user"] = user
+		}
+		return payload, true
+	})
+	opts := &MarshalOptions{ApplicationMarshalTransformer: extractor}
+
+	ctx := context.WithValue(context.Background(), testContextKey{}, "alice")
+	rc := &testRequestContext{Context: ctx, RequestID: "abc"}
+	if encoded, err := MarshalToBytes(opts, rc); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if decoded, err := UnmarshalBytes(nil, encoded); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if expected := (map[any]any{"request_id": "abc", "user": "alice"}); !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Unexpected result: %#v (expected: %#v)", decoded, expected)
+	}
+
+	// Nested.
+	if encoded, err := MarshalToBytes(opts, []any{1, rc}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if decoded, err := UnmarshalBytes(nil, encoded); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if expected := []any{1, map[any]any{"request_id": "abc", "user": "alice"}}; !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Unexpected result: %#v (expected: %#v)", decoded, expected)
+	}
+
+	// Plain contexts (not extracted) error.
+	cancelCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for _, obj := range []any{context.Background(), ctx, cancelCtx, []any{ctx}, testRequestContext{Context: ctx}} {
+		if _, err := MarshalToBytes(opts, obj); err != ContextNotMarshallableError {
+			t.Errorf("Unexpected error for obj=%#v: %v", obj, err)
+		}
+		if _, err := MarshalToBytes(nil, obj); err != ContextNotMarshallableError {
+			t.Errorf("Unexpected error for obj=%#v: %v", obj, err)
+		}
+	}
+	// Even with the struct marshal transformer.
+	structOpts := &MarshalOptions{ApplicationMarshalTransformer: DefaultStructMarshalTransformer}
+	if _, err := MarshalToBytes(structOpts, testRequestContext{Context: ctx}); err != ContextNotMarshallableError {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
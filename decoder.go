@@ -6,11 +6,15 @@
 package umsgpack
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"math"
+	"reflect"
 	"time"
+	"unsafe"
 
 	"github.com/viettrungluu/umsgpack/internal"
 )
@@ -29,37 +33,240 @@ var DuplicateKeyError = errors.New("Duplicate key")
 // This may be suppressed by setting the DisableUnsupportedKeyTypeError option.
 var UnsupportedKeyTypeError = errors.New("Unsupported key type")
 
+// NonStringKeyError is the error returned if Unmarshal encounters data for a map with a key that
+// is not a string, if the RequireStringKeys option is set.
+var NonStringKeyError = errors.New("Non-string key")
+
+// NonMinimalEncodingError is the error returned if Unmarshal encounters data that is not minimally
+// encoded (i.e., a more compact format could have been used), if the RequireMinimalEncoding option
+// is set.
+var NonMinimalEncodingError = errors.New("Non-minimal encoding")
+
+// UnexpectedNilError is the error returned if Unmarshal encounters nil (at any level), if the
+// RejectNil option is set.
+var UnexpectedNilError = errors.New("Unexpected nil")
+
+// MessageTooLargeError is the error returned if Unmarshal would have to read more data than
+// permitted by the MaxReaderBytes option.
+var MessageTooLargeError = errors.New("Message too large")
+
+// TooManyEntriesError is the error returned if Unmarshal encounters a map or array with more entries
+// than permitted by the MaxMapEntries or MaxArrayEntries option, respectively.
+var TooManyEntriesError = errors.New("Too many entries")
+
+// TooDeeplyNestedError is the error returned if Unmarshal encounters data nested more deeply than
+// permitted by the MaxDepth option.
+var TooDeeplyNestedError = errors.New("Too deeply nested")
+
+// ExtensionTooLargeError is the error returned if Unmarshal encounters an extension whose data is
+// longer than permitted by the MaxExtensionBytes option.
+var ExtensionTooLargeError = errors.New("Extension too large")
+
+// StringTooLongError is the error returned if Unmarshal encounters a string that is longer than
+// permitted by the MaxStringBytes option.
+var StringTooLongError = errors.New("String too long")
+
+// BinaryTooLongError is the error returned if Unmarshal encounters binary data that is longer than
+// permitted by the MaxBinaryBytes option.
+var BinaryTooLongError = errors.New("Binary too long")
+
 // InvalidFormatError is the error returned if Unmarshal encounters an invalid format (0xc1).
 var InvalidFormatError = errors.New("Invalid format")
 
+// InvalidReadChunkSizeError is the error returned if the ReadChunkSize option is invalid (i.e.,
+// negative).
+var InvalidReadChunkSizeError = errors.New("Invalid read chunk size")
+
+// TrailingBytesError is the error returned if Unmarshal encounters data after the object, if the
+// RejectTrailingBytes option is set.
+var TrailingBytesError = errors.New("Trailing bytes")
+
+// InternalDecodeError is the error returned if Unmarshal reaches a state that should be
+// unreachable. This indicates a bug in umsgpack.
+var InternalDecodeError = errors.New("Internal decode error")
+
+// A *DecodeError is the error returned by Unmarshal, etc., for errors that occur while
+// unmarshalling (other than io.EOF if there is no data at all), wrapping the underlying error
+// (which may be compared using errors.Is, e.g., with InvalidFormatError or io.ErrUnexpectedEOF).
+type DecodeError struct {
+	// Err is the underlying error.
+	Err error
+	// Offset is the byte offset (from the start of the object) at which the error was detected,
+	// i.e., the number of bytes successfully read before the error. (Note that this will
+	// typically be after, e.g., a key for DuplicateKeyError.)
+	Offset int
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%v (at offset %v)", e.Err, e.Offset)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
 // Unmarshal ---------------------------------------------------------------------------------------
 
 // DefaultUnmarshalOptions is the default options used by Unmarshal/UnmarshalBytes if it is passed
 // nil options.
 var DefaultUnmarshalOptions = &UnmarshalOptions{}
 
+// SecureUnmarshalOptions returns (new) options suitable for unmarshalling untrusted data, which
+// callers may modify further (e.g., to raise limits for a known schema). Compared to the default
+// options, they set:
+//   - MaxReaderBytes to 1 MiB, which bounds the total amount of data read (and thus also the
+//     lengths of strings, binary data, and extensions)
+//   - MaxMapEntries and MaxArrayEntries to 65536, which bounds the size of any single map or
+//     array (and so the cost of, e.g., iterating over it) more tightly than MaxReaderBytes
+//   - MaxDepth to 1000, which bounds the nesting depth (and so the stack and memory used by
+//     unmarshalling deeply-nested data)
+//   - RejectTrailingBytes, so that data with anything appended to the object is rejected rather
+//     than silently truncated
+//   - DuplicateKeyByEncoding, so that keys with different encodings of the same value (e.g., int
+//     and uint) are also rejected as duplicates (DuplicateKeyError), since other unmarshallers
+//     may treat them as the same key
+//
+// Duplicate keys and unsupported key types are rejected as by default. Note that strings are not
+// validated (in particular, they may contain invalid UTF-8), so callers that require valid UTF-8
+// must check it themselves (e.g., using utf8.ValidString).
+func SecureUnmarshalOptions() *UnmarshalOptions {
+	return &UnmarshalOptions{
+		MaxReaderBytes:         1 << 20,
+		MaxMapEntries:          1 << 16,
+		MaxArrayEntries:        1 << 16,
+		MaxDepth:               1000,
+		RejectTrailingBytes:    true,
+		DuplicateKeyByEncoding: true,
+	}
+}
+
 // Unmarshal unmarshals a single MessagePack object from r. It is very simplistic, and produces the
 // following types:
 //   - nil for nil
 //   - bool for true or false
-//   - int for any integer serialized as signed
-//   - uint for any integer serialized as unsigned
+//   - int for any integer serialized as signed (int64 if the WideIntegers option is set)
+//   - uint for any integer serialized as unsigned (uint64 if the WideIntegers option is set),
+//     unless the NormalizeUintToInt option is set and it fits in an int
 //   - float32 and float64 for 32- and 64-bit floats, respectively
 //   - string for (UTF-8) string
 //   - []byte for binary
 //   - []any for array
-//   - map[any]any for map
+//   - map[any]any for map (or map[string]any if all keys are strings and the StringKeyedMaps
+//     option is set, or map[string]T if additionally all values have the same scalar type T and
+//     the InferMapValueTypes option is set, or *OrderedMap if the OrderedMaps option is set)
 //   - time.Time for timestamp (extension type -1), unless disabled via options
 //   - UnresolvedExtensionType for other extension types
 //   - other types per opts.ApplicationUnmarshalTransformer (which typically maps
 //     UnresolvedExtensionType to other types)
+//
+// If there is no data at all, it returns io.EOF. Other errors are returned as a *DecodeError
+// (wrapping the underlying error, e.g., io.ErrUnexpectedEOF or InvalidFormatError), so should be
+// checked using errors.Is or errors.As.
 func Unmarshal(opts *UnmarshalOptions, r io.Reader) (any, error) {
-	return unmarshalReadViewer(opts, internal.ReadViewerForReader{Reader: r})
+	if opts == nil {
+		opts = DefaultUnmarshalOptions
+	}
+	rv, err := newReadViewerForReader(opts, r)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalReadViewer(opts, rv)
 }
 
 // UnmarshalBytes is like Unmarshal, except taking byte data instead of an io.Reader.
 func UnmarshalBytes(opts *UnmarshalOptions, data []byte) (any, error) {
-	return unmarshalReadViewer(opts, &internal.ReadViewerForBuffer{Buffer: data})
+	rv, _, err := UnmarshalBytesN(opts, data)
+	return rv, err
+}
+
+// UnmarshalBytesN is like UnmarshalBytes, except that it also returns the number of bytes consumed
+// (i.e., the offset in data just after the object) on success. This is useful if data may contain
+// more than just the object.
+func UnmarshalBytesN(opts *UnmarshalOptions, data []byte) (any, int, error) {
+	r := &internal.ReadViewerForBuffer{Buffer: data}
+	rv, err := unmarshalReadViewer(opts, r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rv, int(r.Pos()), nil
+}
+
+// UnmarshalAll unmarshals MessagePack objects from r, one after another, until there is no more
+// data, returning all of them. This is useful for reading record-oriented data, e.g., as written
+// by MarshalAll.
+//
+// Options apply to each object individually (e.g., MaxReaderBytes limits the size of each object),
+// except that RejectTrailingBytes is ignored.
+//
+// Reaching the end of the data (io.EOF) between objects is not an error, but reaching it in the
+// middle of an object is (a *DecodeError wrapping io.ErrUnexpectedEOF). On error, the objects
+// successfully unmarshalled before the error are returned along with it; the Offset of a
+// *DecodeError is relative to the start of r.
+func UnmarshalAll(opts *UnmarshalOptions, r io.Reader) ([]any, error) {
+	var objs []any
+	err := UnmarshalEach(opts, r, func(obj any) error {
+		objs = append(objs, obj)
+		return nil
+	})
+	return objs, err
+}
+
+// UnmarshalEach is like UnmarshalAll, except that instead of returning the objects, it calls fn for
+// each object as soon as it has been unmarshalled. If fn returns an error, UnmarshalEach stops and
+// returns that error (as is, i.e., not wrapped in a *DecodeError).
+func UnmarshalEach(opts *UnmarshalOptions, r io.Reader, fn func(obj any) error) error {
+	if opts == nil {
+		opts = DefaultUnmarshalOptions
+	}
+	rv, err := newReadViewerForReader(opts, r)
+	if err != nil {
+		return err
+	}
+	offset := 0
+	for {
+		obj, n, err := unmarshalNext(opts, rv)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if decodeErr, ok := err.(*DecodeError); ok {
+				decodeErr.Offset += offset
+			}
+			return err
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+		offset += int(n)
+	}
+}
+
+// UnmarshalExtension unmarshals a single extension-formatted object (fixext {1,2,4,8,16}, ext
+// {8,16,32}) from data, returning its extension type, its payload, and the number of bytes consumed.
+// This is useful for applications that frame extensions themselves (without transformers); note
+// that even the standard timestamp extension (type -1) is returned as-is.
+//
+// Errors are as for UnmarshalBytes; in particular, if data does not start with an extension
+// format, InvalidFormatError is returned (as a *DecodeError).
+func UnmarshalExtension(data []byte) (extType int8, payload []byte, n int, err error) {
+	if len(data) > 0 && !isExtensionFormat(data[0]) {
+		return 0, nil, 0, &DecodeError{Err: InvalidFormatError, Offset: 1}
+	}
+
+	obj, n, err := UnmarshalBytesN(unmarshalExtensionOptions, data)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	ext := obj.(*UnresolvedExtensionType)
+	return ext.ExtensionType, ext.Data, n, nil
+}
+
+// unmarshalExtensionOptions are the options used by UnmarshalExtension.
+var unmarshalExtensionOptions = &UnmarshalOptions{DisableStandardUnmarshalTransformer: true}
+
+// isExtensionFormat returns true if b is the first byte of an extension format.
+func isExtensionFormat(b byte) bool {
+	return (b >= 0xc7 && b <= 0xc9) || (b >= 0xd4 && b <= 0xd8)
 }
 
 // unmarshalReadViewer is like Unmarshal, except that it takes a ReadViewer insteada of an
@@ -68,9 +275,48 @@ func unmarshalReadViewer(opts *UnmarshalOptions, r internal.ReadViewer) (any, er
 	if opts == nil {
 		opts = DefaultUnmarshalOptions
 	}
+	rv, pos, err := unmarshalNext(opts, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.RejectTrailingBytes {
+		// Try to read one more byte: success means that there are trailing bytes.
+		if _, err := r.ReadByte(); err == nil {
+			return nil, &DecodeError{Err: TrailingBytesError, Offset: int(pos)}
+		} else if err != io.EOF {
+			return nil, &DecodeError{Err: err, Offset: int(pos)}
+		}
+	}
+
+	return rv, nil
+}
+
+// newReadViewerForReader makes a ReadViewer for r, using the ReadChunkSize option (opts must be
+// non-nil).
+func newReadViewerForReader(opts *UnmarshalOptions, r io.Reader) (internal.ReadViewer, error) {
+	if opts.ReadChunkSize < 0 {
+		return nil, InvalidReadChunkSizeError
+	}
+	return &internal.ReadViewerForReader{Reader: r, ChunkSize: uint(opts.ReadChunkSize)}, nil
+}
+
+// unmarshalNext unmarshals the next object from r (with non-nil opts), returning it and the number of
+// bytes consumed. Errors are as for Unmarshal, except that RejectTrailingBytes is not checked.
+func unmarshalNext(opts *UnmarshalOptions, r internal.ReadViewer) (any, uint, error) {
 	u := &unmarshaller{opts: opts, r: r}
-	rv, _, err := u.unmarshalObject(true)
-	return rv, err
+	if opts.MaxReaderBytes > 0 {
+		u.r = &limitedReadViewer{r: r, left: uint(opts.MaxReaderBytes)}
+	}
+	rv, _, err := u.unmarshalObject()
+	if err != nil {
+		// Don't wrap io.EOF at the very beginning (i.e., no data), which is a "normal" error.
+		if err == io.EOF && u.pos == 0 {
+			return nil, 0, err
+		}
+		return nil, 0, &DecodeError{Err: err, Offset: int(u.pos)}
+	}
+	return rv, u.pos, nil
 }
 
 // UnmarshalOptions specifies options for Unmarshal.
@@ -82,6 +328,19 @@ type UnmarshalOptions struct {
 	// lead to security problems.
 	DisableDuplicateKeyError bool
 
+	// If DuplicateKeyByEncoding is set, then keys are considered duplicates (for
+	// DuplicateKeyError, or for which key-value pair "wins") if their canonical encodings are
+	// the same -- rather than only if they're equal as Go values. The canonical encoding is the
+	// minimal encoding (as produced by Marshal), except that integers are encoded the same
+	// regardless of whether they're signed or unsigned. E.g., by default, a key in a uint format
+	// (decoded as uint) and a key with the same value in an int format (decoded as int) are
+	// distinct, but they're duplicates if this is set. (Similarly, NaN keys with the same
+	// encoding are duplicates.)
+	//
+	// Keys that can't be marshalled (e.g., as produced by an ApplicationUnmarshalTransformer)
+	// are only compared as Go values.
+	DuplicateKeyByEncoding bool
+
 	// If DisableUnsupportedKeyTypeError is set, then UnsupportedKeyTypeErrors will not be
 	// returned. Instead, the key-value pair will be "dropped".
 	//
@@ -89,6 +348,206 @@ type UnmarshalOptions struct {
 	// lead to security problems.
 	DisableUnsupportedKeyTypeError bool
 
+	// If RequireStringKeys is set, then NonStringKeyError will be returned for any map key
+	// that is not a string (after transformers are applied). This enforces JSON-object
+	// semantics. It takes precedence over DisableUnsupportedKeyTypeError.
+	RequireStringKeys bool
+
+	// If RequireMinimalEncoding is set, then NonMinimalEncodingError will be returned if data
+	// is not encoded using the most compact format possible. This applies to integers, the
+	// lengths of strings, binary, arrays, and maps, and the selection of extension formats
+	// (e.g., fixext 4 must be used for 4 bytes of extension data). Minimality is as produced
+	// by Marshal: integers serialized as unsigned must use the most compact uint format (never
+	// a fixint), and integers serialized as signed must use the most compact signed format
+	// (including fixint). (Floats are not checked.)
+	//
+	// Note that this does not check the extension data itself (e.g., a timestamp that fits in
+	// timestamp 32 may use timestamp 64), only its framing (e.g., timestamp 32 and 64 must use
+	// fixext 4 and 8, respectively, not ext 8/16/32).
+	RequireMinimalEncoding bool
+
+	// If RejectNil is set, then UnexpectedNilError will be returned if the nil format (0xc0) is
+	// encountered at any level (including as an array element, map key or map value). Other
+	// objects that are unmarshalled as nil (e.g., empty binary data with EmptyBinAsNil, or nil
+	// returned by a transformer) are not affected.
+	//
+	// The default is to allow nil.
+	RejectNil bool
+
+	// If StringKeyedMaps is set, then maps whose keys are all strings (after transformers are
+	// applied) will be unmarshalled as map[string]any instead of map[any]any. (This includes
+	// empty maps.) Other maps will still be unmarshalled as map[any]any, so the concrete type
+	// of an unmarshalled map will depend on its contents.
+	//
+	// Duplicate and unsupported keys are handled as usual (with key-value pairs that are
+	// dropped not affecting the result type).
+	StringKeyedMaps bool
+
+	// If InferMapValueTypes is set (in addition to StringKeyedMaps), then non-empty maps whose
+	// keys are all strings and whose values all have the same scalar type (after transformers
+	// are applied) will be unmarshalled as map[string]T, where T is that type: one of bool, int,
+	// uint, int64, uint64 (see WideIntegers), float32, float64, or string. (E.g., a map with all int values will be unmarshalled as
+	// map[string]int.)
+	//
+	// Other string-keyed maps (empty maps, maps with values of different types, including both
+	// int and uint, or with nil or non-scalar values) will still be unmarshalled as
+	// map[string]any. It has no effect if StringKeyedMaps is not set.
+	InferMapValueTypes bool
+
+	// If OrderedMaps is set, then maps will be unmarshalled as *OrderedMap instead of map[any]any,
+	// preserving the order in which their entries appear in the data. It takes precedence over
+	// StringKeyedMaps (and InferMapValueTypes).
+	//
+	// Duplicate and unsupported keys are handled as usual (with key-value pairs that are dropped
+	// not appearing in the result). Note that UnmarshalInto does not support *OrderedMap, so this
+	// should not be set for it.
+	OrderedMaps bool
+
+	// If WideIntegers is set, then integers are unmarshalled as int64 (for signed formats) or
+	// uint64 (for unsigned formats), instead of int or uint, regardless of the platform's int
+	// size. Note that this changes the types of results (e.g., for comparisons using
+	// reflect.DeepEqual).
+	WideIntegers bool
+
+	// If NormalizeUintToInt is set, then integers serialized as unsigned whose values fit in an
+	// int (i.e., are at most math.MaxInt) are unmarshalled as int instead of uint, as if they had
+	// been serialized as signed; larger values are still unmarshalled as uint. (If WideIntegers
+	// is also set, then int64, math.MaxInt64, and uint64 apply instead.) This smooths over
+	// differences between encoders, some of which serialize non-negative values as unsigned.
+	NormalizeUintToInt bool
+
+	// If MaxReaderBytes is positive, then MessageTooLargeError will be returned if unmarshalling
+	// the object would require reading more than that many bytes in total (including formats,
+	// lengths, etc.). The check is done before reading, so at most MaxReaderBytes bytes will be
+	// read from the io.Reader. (The one additional byte read to check for trailing bytes if
+	// RejectTrailingBytes is set is not counted.)
+	//
+	// The default (0) is to not limit the amount of data read.
+	MaxReaderBytes int
+
+	// ReadChunkSize is the maximum size of a single read from an io.Reader (for Unmarshal, etc.;
+	// it has no effect on UnmarshalBytes, etc.). Larger values may reduce the number of reads
+	// (e.g., system calls) for large strings, binary data, and extensions, at the cost of larger
+	// allocations up front (for data that turns out to be truncated). It must not be negative
+	// (otherwise InvalidReadChunkSizeError is returned).
+	//
+	// The default (0) is 4096.
+	ReadChunkSize int
+
+	// If MaxMapEntries is positive, then TooManyEntriesError will be returned if Unmarshal
+	// encounters a map with more than that many entries (key-value pairs). Similarly for
+	// MaxArrayEntries and arrays. The checks are done on the length prefix (before unmarshalling
+	// any entries), and apply to nested maps/arrays individually.
+	//
+	// The default (0) is to not limit the number of entries.
+	MaxMapEntries uint
+	// See MaxMapEntries.
+	MaxArrayEntries uint
+
+	// If MaxDepth is positive, then TooDeeplyNestedError will be returned if Unmarshal encounters
+	// objects nested more deeply than that: i.e., the top-level object is at depth 1, the elements
+	// of an array (or the keys and values of a map) at depth 1 are at depth 2, etc. Since
+	// unmarshalling nested objects is recursive, this bounds the stack used (MaxReaderBytes alone
+	// does not, since each level of nesting needs only one byte).
+	//
+	// The default (0) is to not limit the depth.
+	MaxDepth uint
+
+	// If MaxExtensionBytes is positive, then ExtensionTooLargeError will be returned if Unmarshal
+	// encounters an extension whose data is longer than that many bytes. The check is done on the
+	// length (before reading the data). (Unlike MaxReaderBytes, this allows, e.g., large strings
+	// while limiting extensions.)
+	//
+	// The default (0) is to not limit the size of extensions.
+	MaxExtensionBytes uint
+
+	// If MaxStringBytes is positive, then StringTooLongError will be returned if Unmarshal
+	// encounters a string longer than that many bytes. Similarly, if MaxBinaryBytes is positive,
+	// then BinaryTooLongError will be returned for binary data longer than that many bytes. The
+	// checks are done on the lengths (before reading the data), to bound memory usage for
+	// individual objects (MaxReaderBytes bounds the total).
+	//
+	// The default (0) is to not limit the lengths.
+	MaxStringBytes uint
+	MaxBinaryBytes uint
+
+	// If RejectTrailingBytes is set, then TrailingBytesError will be returned if there is any
+	// data after the (single) object. For Unmarshal, this means that it will try to read one
+	// more byte from the io.Reader (consuming it, if available).
+	//
+	// The default is to ignore any trailing data (and, for Unmarshal, to not read past the
+	// object).
+	RejectTrailingBytes bool
+
+	// If UnsafeStrings is set, then strings are produced without a second copy of their data: the
+	// data is read into a freshly-allocated byte slice owned by Unmarshal, which is then aliased
+	// (using unsafe.String) as the resulting string, instead of being copied into the string.
+	// This may reduce memory usage for very large strings (e.g., when unmarshalling from an
+	// io.Reader).
+	//
+	// WARNING: This relies on the backing byte slice never being modified or reused, which
+	// Unmarshal guarantees (it never retains it or hands it out). The input data (passed to
+	// UnmarshalBytes, etc.) is never aliased. However, this may change the characteristics of
+	// memory usage: e.g., a small substring of a large string keeps the whole backing slice alive
+	// (just as with any string).
+	//
+	// The default is to copy the data into the string (i.e., using a conversion).
+	UnsafeStrings bool
+
+	// If EmptyBinAsNil is set, then zero-length binary data is unmarshalled as a nil []byte.
+	//
+	// The default is to unmarshal it as a non-nil, empty []byte.
+	EmptyBinAsNil bool
+
+	// Recycler, if non-nil, is used to get the containers (map[any]any and []any) for maps and
+	// arrays, instead of always making new ones. Maps that Unmarshal itself discards (e.g., since
+	// they are converted to map[string]any per the StringKeyedMaps option) and containers for
+	// objects that failed to unmarshal are put back. The application may put back containers
+	// that it is done with, e.g., using RecycleObject.
+	//
+	// The default (nil) is to always make new containers.
+	Recycler Recycler
+
+	// If BinToBase64String is set, then UnmarshalInto will assign binary data ([]byte) to a string
+	// target by base64-encoding it (with standard encoding, like encoding/json), instead of
+	// returning an *UnmarshalIntoTypeError. It has no effect on Unmarshal itself.
+	BinToBase64String bool
+
+	// UnknownKeyFn, if non-nil, is called by UnmarshalInto for each key (with its value) in a map
+	// being assigned to a struct that does not correspond to an (exported) field of the struct.
+	// Such keys are otherwise ignored. It has no effect on Unmarshal itself.
+	UnknownKeyFn func(key string, value any)
+
+	// If StrictSignedness is set, then UnmarshalInto will return SignednessMismatchError when
+	// assigning an unsigned integer (from a MessagePack uint format) to a signed integer type, or
+	// a signed integer (from a MessagePack int or fixint format) to an unsigned integer type. It
+	// has no effect on Unmarshal itself.
+	//
+	// The default is to allow such assignments if the value is representable.
+	StrictSignedness bool
+
+	// TimestampLocation, if non-nil, is the location to which timestamps (from the standard
+	// timestamp extension, as unmarshalled by the standard unmarshal transformer) are converted
+	// (using time.Time.In), e.g., time.UTC.
+	//
+	// The default is to leave timestamps in the local location (as returned by time.Unix).
+	TimestampLocation *time.Location
+
+	// If TimestampAsUnixNano is set, then timestamps (from the standard timestamp extension, as
+	// unmarshalled by the standard unmarshal transformer) are instead unmarshalled as an int64
+	// number of nanoseconds since the Unix epoch (see time.Time.UnixNano). Since this can only
+	// represent times from about 1678 to 2262, TimestampOutOfRangeError is returned for timestamps
+	// outside that range. TimestampLocation has no effect in this case.
+	TimestampAsUnixNano bool
+
+	// If DisableTimestampDecoding is set, then timestamps (the standard timestamp extension, -1)
+	// are not unmarshalled by the standard unmarshal transformer, but are left as
+	// *UnresolvedExtensionType (e.g., for applications that treat them as opaque, avoiding the
+	// cost of converting them to time.Time). Unlike DisableStandardUnmarshalTransformer, other
+	// extension types handled by the standard unmarshal transformer are unaffected.
+	// TimestampLocation and TimestampAsUnixNano have no effect in this case.
+	DisableTimestampDecoding bool
+
 	// If set, then the standard unmarshal transformer will not be run.
 	DisableStandardUnmarshalTransformer bool
 
@@ -96,6 +555,21 @@ type UnmarshalOptions struct {
 	// unmarshalling (and after the standard unmarshal transformer).
 	// This is run before the standard marshal transformer.
 	ApplicationUnmarshalTransformer UnmarshalTransformerFn
+
+	// UnknownExtensionFn, if non-nil, is called for extensions that were not handled by the
+	// standard or application unmarshal transformers (i.e., that would otherwise be unmarshalled
+	// as *UnresolvedExtensionType), with the extension type and data. Like an
+	// UnmarshalExtensionTypeFn, it returns the object and whether it may be a map key, or an error
+	// (e.g., to reject unknown extension types). To preserve the default behavior (e.g., after
+	// logging), it may return an *UnresolvedExtensionType with the given extension type and data
+	// (and false).
+	UnknownExtensionFn func(extType int8, data []byte) (any, bool, error)
+
+	// ExtensionTypeFns, if non-nil, maps extension types to functions that unmarshal extensions
+	// of those types directly from the input, before any transformers are run (which then see
+	// the resulting objects instead of *UnresolvedExtensionType). The data passed to them may be
+	// borrowed (see UnmarshalBorrowedExtensionTypeFn), which avoids copying it.
+	ExtensionTypeFns map[int8]UnmarshalBorrowedExtensionTypeFn
 }
 
 // An UnmarshalTransformerFn transforms an object after unmarshalling.
@@ -116,6 +590,89 @@ type UnmarshalTransformerFn func(obj any, mapKeySupported bool) (any, bool, erro
 type unmarshaller struct {
 	opts *UnmarshalOptions
 	r    internal.ReadViewer
+
+	// The current position, i.e., the number of bytes successfully read (for errors).
+	pos uint
+
+	// The current depth (only tracked if the MaxDepth option is set).
+	depth uint
+}
+
+// readByte is like u.r.ReadByte, but tracks the position and returns io.ErrUnexpectedEOF instead of
+// io.EOF if any data has already been read (see mapEOF).
+func (u *unmarshaller) readByte() (byte, error) {
+	b, err := u.r.ReadByte()
+	if err != nil {
+		return 0, u.mapEOF(err)
+	}
+	u.pos += 1
+	return b, nil
+}
+
+// readView is like u.r.ReadView, but tracks the position and maps io.EOF like readByte.
+func (u *unmarshaller) readView(n uint) ([]byte, error) {
+	data, err := u.r.ReadView(n)
+	if err != nil {
+		return nil, u.mapEOF(err)
+	}
+	u.pos += n
+	return data, nil
+}
+
+// readCopy is like u.r.ReadCopy, but tracks the position and maps io.EOF like readByte.
+func (u *unmarshaller) readCopy(n uint) ([]byte, error) {
+	data, err := u.r.ReadCopy(n)
+	if err != nil {
+		return nil, u.mapEOF(err)
+	}
+	u.pos += n
+	return data, nil
+}
+
+// mapEOF maps io.EOF to io.ErrUnexpectedEOF if any data has been read (i.e., io.EOF is only
+// returned if there's no data at all for the top-level object).
+func (u *unmarshaller) mapEOF(err error) error {
+	if err == io.EOF && u.pos > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// A limitedReadViewer is a ReadViewer that wraps another ReadViewer, limiting the total number of
+// bytes that may be read. Reads that would exceed the limit fail with MessageTooLargeError (without
+// reading anything).
+type limitedReadViewer struct {
+	r    internal.ReadViewer
+	left uint
+}
+
+var _ internal.ReadViewer = (*limitedReadViewer)(nil)
+
+// ReadByte implements ReadViewer.ReadByte.
+func (r *limitedReadViewer) ReadByte() (byte, error) {
+	if r.left < 1 {
+		return 0, MessageTooLargeError
+	}
+	r.left -= 1
+	return r.r.ReadByte()
+}
+
+// ReadView implements ReadViewer.ReadView.
+func (r *limitedReadViewer) ReadView(n uint) ([]byte, error) {
+	if r.left < n {
+		return nil, MessageTooLargeError
+	}
+	r.left -= n
+	return r.r.ReadView(n)
+}
+
+// ReadCopy implements ReadViewer.ReadCopy.
+func (r *limitedReadViewer) ReadCopy(n uint) ([]byte, error) {
+	if r.left < n {
+		return nil, MessageTooLargeError
+	}
+	r.left -= n
+	return r.r.ReadCopy(n)
 }
 
 // Internal configuration:
@@ -127,49 +684,103 @@ const (
 	// (This is less efficient for valid input, but prevents bad input from causing huge
 	// allocations.)
 	unmarshalMaxArrayAllocElements = 1000
+
+	// unmarshalMaxMapAllocEntries is the maximum initial map size hint (in number of entries)
+	// when unmarshalling a map. For maps larger than this, the map will be grown as needed.
+	//
+	// (As above, this prevents bad input from causing huge allocations.)
+	unmarshalMaxMapAllocEntries = 1000
 )
 
-// unmarshalObject unmarshals an object. The next byte is expected to be the format. topLevel should
-// be true only for the top-most call.
+// unmarshalObject unmarshals an object. The next byte is expected to be the format.
 //
 // Note: All internal unmarshal functions are like an UnmarshalExtensionTypeFn and return either an
 // error, or on success the object and a boolean indicating if the value is a valid map key (for a
 // map[any]any).
-func (u *unmarshaller) unmarshalObject(topLevel bool) (obj any, mapKeySupported bool, err error) {
-	obj, mapKeySupported, err = u.unmarshalStandardObject(topLevel)
+func (u *unmarshaller) unmarshalObject() (obj any, mapKeySupported bool, err error) {
+	if u.opts.MaxDepth > 0 {
+		if u.depth >= u.opts.MaxDepth {
+			return nil, false, TooDeeplyNestedError
+		}
+		u.depth += 1
+		defer func() { u.depth -= 1 }()
+	}
+
+	obj, mapKeySupported, err = u.unmarshalStandardObject()
 	if err != nil {
 		return
 	}
 
-	if !u.opts.DisableStandardUnmarshalTransformer {
+	if !u.opts.DisableStandardUnmarshalTransformer && !(u.opts.DisableTimestampDecoding && isTimestampExtension(obj)) {
 		obj, mapKeySupported, err = StandardUnmarshalTransformer(obj, mapKeySupported)
 		if err != nil {
 			return
 		}
+
+		if t, ok := obj.(time.Time); ok {
+			obj, err = u.convertTimestamp(t)
+			if err != nil {
+				return
+			}
+		}
 	}
 
 	if u.opts.ApplicationUnmarshalTransformer != nil {
 		obj, mapKeySupported, err = u.opts.ApplicationUnmarshalTransformer(obj, mapKeySupported)
+		if err != nil {
+			return
+		}
+	}
+
+	if u.opts.UnknownExtensionFn != nil {
+		if ext, ok := obj.(*UnresolvedExtensionType); ok {
+			obj, mapKeySupported, err = u.opts.UnknownExtensionFn(ext.ExtensionType, ext.Data)
+		}
 	}
 
 	return
 }
 
+// isTimestampExtension returns whether obj is an (unresolved) timestamp extension, i.e., an
+// *UnresolvedExtensionType with ExtensionType -1.
+func isTimestampExtension(obj any) bool {
+	ext, ok := obj.(*UnresolvedExtensionType)
+	return ok && ext != nil && ext.ExtensionType == -1
+}
+
+// The earliest and latest times representable as an int64 number of nanoseconds since the Unix
+// epoch (see TimestampAsUnixNano).
+var (
+	minUnixNanoTime = time.Unix(0, math.MinInt64)
+	maxUnixNanoTime = time.Unix(0, math.MaxInt64)
+)
+
+// convertTimestamp converts a timestamp (unmarshalled by the standard unmarshal transformer)
+// according to the TimestampLocation and TimestampAsUnixNano options.
+func (u *unmarshaller) convertTimestamp(t time.Time) (any, error) {
+	if u.opts.TimestampAsUnixNano {
+		if t.Before(minUnixNanoTime) || t.After(maxUnixNanoTime) {
+			return nil, TimestampOutOfRangeError
+		}
+		return t.UnixNano(), nil
+	}
+	if u.opts.TimestampLocation != nil {
+		return t.In(u.opts.TimestampLocation), nil
+	}
+	return t, nil
+}
+
 // unmarshalStandardObject unmarshals an object to a standard (built-in) object (i.e., without
 // applying transformers).
-func (u *unmarshaller) unmarshalStandardObject(topLevel bool) (any, bool, error) {
-	b, err := u.r.ReadByte()
+func (u *unmarshaller) unmarshalStandardObject() (any, bool, error) {
+	b, err := u.readByte()
 	if err != nil {
-		if topLevel {
-			return nil, false, err
-		} else {
-			return nil, false, mapEOF(err)
-		}
+		return nil, false, err
 	}
 
 	switch {
 	case b <= 0x7f: // positive fixint: 0xxxxxxx: 0x00 - 0x7f
-		return int(b), true, nil
+		return u.makeInt(int64(b)), true, nil
 	case b <= 0x8f: // fixmap: 1000xxxx: 0x80 - 0x8f
 		return u.unmarshalNMap(uint(b & 0b1111))
 	case b <= 0x9f: // fixarray: 1001xxxx: 0x90 - 0x9f
@@ -178,12 +789,15 @@ func (u *unmarshaller) unmarshalStandardObject(topLevel bool) (any, bool, error)
 		return u.unmarshalNString(uint(b & 0b11111))
 	// Reaches individual range (handled below), until:
 	case b >= 0xe0: // negative fixint: 111xxxxx: 0xe0 - 0xff
-		// Cast to an int8 first, so that casting to an int will sign-extend.
-		return int(int8(b)), true, nil
+		// Cast to an int8 first, so that casting to an int64 will sign-extend.
+		return u.makeInt(int64(int8(b))), true, nil
 	}
 
 	switch b {
 	case 0xc0: // nil: 11000000: 0xc0
+		if u.opts.RejectNil {
+			return nil, false, UnexpectedNilError
+		}
 		return nil, true, nil
 	case 0xc1: // (never used): 11000001: 0xc1
 		return nil, false, InvalidFormatError
@@ -202,51 +816,119 @@ func (u *unmarshaller) unmarshalStandardObject(topLevel bool) (any, bool, error)
 		if err != nil {
 			return nil, false, err
 		}
+		if err := u.checkMinimal(n > math.MaxUint8); err != nil {
+			return nil, false, err
+		}
 		return u.unmarshalNBytes(n)
 	case 0xc6: // bin 32: 11000110: 0xc6
 		n, _, err := u.unmarshalUint32()
 		if err != nil {
 			return nil, false, err
 		}
+		if err := u.checkMinimal(n > math.MaxUint16); err != nil {
+			return nil, false, err
+		}
 		return u.unmarshalNBytes(n)
 	case 0xc7: // ext 8: 11000111: 0xc7
 		n, _, err := u.unmarshalUint8()
 		if err != nil {
 			return nil, false, err
 		}
+		if err := u.checkMinimal(n != 1 && n != 2 && n != 4 && n != 8 && n != 16); err != nil {
+			return nil, false, err
+		}
 		return u.unmarshalNExt(n)
 	case 0xc8: // ext 16: 11001000: 0xc8
 		n, _, err := u.unmarshalUint16()
 		if err != nil {
 			return nil, false, err
 		}
+		if err := u.checkMinimal(n > math.MaxUint8); err != nil {
+			return nil, false, err
+		}
 		return u.unmarshalNExt(n)
 	case 0xc9: // ext 32: 11001001: 0xc9
 		n, _, err := u.unmarshalUint32()
 		if err != nil {
 			return nil, false, err
 		}
+		if err := u.checkMinimal(n > math.MaxUint16); err != nil {
+			return nil, false, err
+		}
 		return u.unmarshalNExt(n)
 	case 0xca: // float 32: 11001010: 0xca
 		return u.unmarshalFloat32()
 	case 0xcb: // float 64: 11001011: 0xcb
 		return u.unmarshalFloat64()
 	case 0xcc: // uint 8: 11001100: 0xcc
-		return u.unmarshalUint8()
+		v, _, err := u.unmarshalUint8()
+		if err != nil {
+			return nil, false, err
+		}
+		return u.makeUint(uint64(v)), true, nil
 	case 0xcd: // uint 16: 11001101: 0xcd
-		return u.unmarshalUint16()
+		v, _, err := u.unmarshalUint16()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := u.checkMinimal(v > math.MaxUint8); err != nil {
+			return nil, false, err
+		}
+		return u.makeUint(uint64(v)), true, nil
 	case 0xce: // uint 32: 11001110: 0xce
-		return u.unmarshalUint32()
+		v, _, err := u.unmarshalUint32()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := u.checkMinimal(v > math.MaxUint16); err != nil {
+			return nil, false, err
+		}
+		return u.makeUint(uint64(v)), true, nil
 	case 0xcf: // uint 64: 11001111: 0xcf
-		return u.unmarshalUint64()
+		v, _, err := u.unmarshalUint64()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := u.checkMinimal(v > math.MaxUint32); err != nil {
+			return nil, false, err
+		}
+		return u.makeUint(v), true, nil
 	case 0xd0: // int 8: 11010000: 0xd0
-		return u.unmarshalInt8()
+		v, _, err := u.unmarshalInt8()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := u.checkMinimal(v < -(0x100 - 0xe0)); err != nil {
+			return nil, false, err
+		}
+		return u.makeInt(int64(v)), true, nil
 	case 0xd1: // int 16: 11010001: 0xd1
-		return u.unmarshalInt16()
+		v, _, err := u.unmarshalInt16()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := u.checkMinimal(v < math.MinInt8 || v > math.MaxInt8); err != nil {
+			return nil, false, err
+		}
+		return u.makeInt(int64(v)), true, nil
 	case 0xd2: // int 32: 11010010: 0xd2
-		return u.unmarshalInt32()
+		v, _, err := u.unmarshalInt32()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := u.checkMinimal(v < math.MinInt16 || v > math.MaxInt16); err != nil {
+			return nil, false, err
+		}
+		return u.makeInt(int64(v)), true, nil
 	case 0xd3: // int 64: 11010011: 0xd3
-		return u.unmarshalInt64()
+		v, _, err := u.unmarshalInt64()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := u.checkMinimal(v < math.MinInt32 || v > math.MaxInt32); err != nil {
+			return nil, false, err
+		}
+		return u.makeInt(v), true, nil
 	case 0xd4: // fixext 1: 11010100: 0xd4
 		return u.unmarshalNExt(1)
 	case 0xd5: // fixext 2: 11010101: 0xd5
@@ -262,52 +944,84 @@ func (u *unmarshaller) unmarshalStandardObject(topLevel bool) (any, bool, error)
 		if err != nil {
 			return nil, false, err
 		}
+		if err := u.checkMinimal(n > (0xbf - 0xa0)); err != nil {
+			return nil, false, err
+		}
 		return u.unmarshalNString(n)
 	case 0xda: // str 16: 11011010: 0xda
 		n, _, err := u.unmarshalUint16()
 		if err != nil {
 			return nil, false, err
 		}
+		if err := u.checkMinimal(n > math.MaxUint8); err != nil {
+			return nil, false, err
+		}
 		return u.unmarshalNString(n)
 	case 0xdb: // str 32: 11011011: 0xdb
 		n, _, err := u.unmarshalUint32()
 		if err != nil {
 			return nil, false, err
 		}
+		if err := u.checkMinimal(n > math.MaxUint16); err != nil {
+			return nil, false, err
+		}
 		return u.unmarshalNString(n)
 	case 0xdc: // array 16: 11011100: 0xdc
 		n, _, err := u.unmarshalUint16()
 		if err != nil {
 			return nil, false, err
 		}
+		if err := u.checkMinimal(n > (0x9f - 0x90)); err != nil {
+			return nil, false, err
+		}
 		return u.unmarshalNArray(n)
 	case 0xdd: // array 32: 11011101: 0xdd
 		n, _, err := u.unmarshalUint32()
 		if err != nil {
 			return nil, false, err
 		}
+		if err := u.checkMinimal(n > math.MaxUint16); err != nil {
+			return nil, false, err
+		}
 		return u.unmarshalNArray(n)
 	case 0xde: // map 16: 11011110: 0xde
 		n, _, err := u.unmarshalUint16()
 		if err != nil {
 			return nil, false, err
 		}
+		if err := u.checkMinimal(n > (0x8f - 0x80)); err != nil {
+			return nil, false, err
+		}
 		return u.unmarshalNMap(n)
 	case 0xdf: // map 32: 11011111: 0xdf
 		n, _, err := u.unmarshalUint32()
 		if err != nil {
 			return nil, false, err
 		}
+		if err := u.checkMinimal(n > math.MaxUint16); err != nil {
+			return nil, false, err
+		}
 		return u.unmarshalNMap(n)
 	}
 
-	panic("Should be unreachable!")
+	// Should be unreachable (all first bytes are handled above), but return an error rather than
+	// panicking in case of a bug.
+	return nil, false, InternalDecodeError
+}
+
+// checkMinimal checks that the encoding just read was minimal (as indicated by the caller), if the
+// RequireMinimalEncoding option is set.
+func (u *unmarshaller) checkMinimal(minimal bool) error {
+	if u.opts.RequireMinimalEncoding && !minimal {
+		return NonMinimalEncodingError
+	}
+	return nil
 }
 
 // unmarshalUint8 unmarshals a uint 8 (as a uint).
 func (u *unmarshaller) unmarshalUint8() (uint, bool, error) {
-	if b, err := u.r.ReadByte(); err != nil {
-		return 0, false, mapEOF(err)
+	if b, err := u.readByte(); err != nil {
+		return 0, false, err
 	} else {
 		return uint(b), true, nil
 	}
@@ -315,8 +1029,8 @@ func (u *unmarshaller) unmarshalUint8() (uint, bool, error) {
 
 // unmarshalUint16 unmarshals a uint 16 (as a uint).
 func (u *unmarshaller) unmarshalUint16() (uint, bool, error) {
-	if data, err := u.r.ReadView(2); err != nil {
-		return 0, false, mapEOF(err)
+	if data, err := u.readView(2); err != nil {
+		return 0, false, err
 	} else {
 		return uint(binary.BigEndian.Uint16(data)), true, nil
 	}
@@ -324,26 +1038,26 @@ func (u *unmarshaller) unmarshalUint16() (uint, bool, error) {
 
 // unmarshalUint32 unmarshals a uint 32 (as a uint).
 func (u *unmarshaller) unmarshalUint32() (uint, bool, error) {
-	if data, err := u.r.ReadView(4); err != nil {
-		return 0, false, mapEOF(err)
+	if data, err := u.readView(4); err != nil {
+		return 0, false, err
 	} else {
 		return uint(binary.BigEndian.Uint32(data)), true, nil
 	}
 }
 
-// unmarshalUint64 unmarshals a uint 64 (as a uint).
-func (u *unmarshaller) unmarshalUint64() (uint, bool, error) {
-	if data, err := u.r.ReadView(8); err != nil {
-		return 0, false, mapEOF(err)
+// unmarshalUint64 unmarshals a uint 64 (as a uint64).
+func (u *unmarshaller) unmarshalUint64() (uint64, bool, error) {
+	if data, err := u.readView(8); err != nil {
+		return 0, false, err
 	} else {
-		return uint(binary.BigEndian.Uint64(data)), true, nil
+		return binary.BigEndian.Uint64(data), true, nil
 	}
 }
 
 // unmarshalInt8 unmarshals an int 8 (as an int).
 func (u *unmarshaller) unmarshalInt8() (int, bool, error) {
-	if b, err := u.r.ReadByte(); err != nil {
-		return 0, false, mapEOF(err)
+	if b, err := u.readByte(); err != nil {
+		return 0, false, err
 	} else {
 		// Cast to an int8 first, so that casting to an int will sign-extend.
 		return int(int8(b)), true, nil
@@ -352,8 +1066,8 @@ func (u *unmarshaller) unmarshalInt8() (int, bool, error) {
 
 // unmarshalInt16 unmarshals an int 16 (as an int).
 func (u *unmarshaller) unmarshalInt16() (int, bool, error) {
-	if data, err := u.r.ReadView(2); err != nil {
-		return 0, false, mapEOF(err)
+	if data, err := u.readView(2); err != nil {
+		return 0, false, err
 	} else {
 		// Cast to an int16 first, so that casting to an int will sign-extend.
 		return int(int16(binary.BigEndian.Uint16(data))), true, nil
@@ -362,28 +1076,55 @@ func (u *unmarshaller) unmarshalInt16() (int, bool, error) {
 
 // unmarshalInt32 unmarshals an int 32 (as an int).
 func (u *unmarshaller) unmarshalInt32() (int, bool, error) {
-	if data, err := u.r.ReadView(4); err != nil {
-		return 0, false, mapEOF(err)
+	if data, err := u.readView(4); err != nil {
+		return 0, false, err
 	} else {
 		// Cast to an int32 first, so that casting to an int will sign-extend.
 		return int(int32(binary.BigEndian.Uint32(data))), true, nil
 	}
 }
 
-// unmarshalInt64 unmarshals an int 64 (as an int).
-func (u *unmarshaller) unmarshalInt64() (int, bool, error) {
-	if data, err := u.r.ReadView(8); err != nil {
-		return 0, false, mapEOF(err)
+// unmarshalInt64 unmarshals an int 64 (as an int64).
+func (u *unmarshaller) unmarshalInt64() (int64, bool, error) {
+	if data, err := u.readView(8); err != nil {
+		return 0, false, err
 	} else {
-		// Cast to an int64 first, so that casting to an int will sign-extend.
-		return int(int64(binary.BigEndian.Uint64(data))), true, nil
+		return int64(binary.BigEndian.Uint64(data)), true, nil
+	}
+}
+
+// makeInt returns the (standard) object for a signed integer value: an int, or an int64 if the
+// WideIntegers option is set.
+func (u *unmarshaller) makeInt(v int64) any {
+	if u.opts.WideIntegers {
+		return v
+	}
+	return int(v)
+}
+
+// makeUint returns the (standard) object for an unsigned integer value: a uint, or a uint64 if the
+// WideIntegers option is set (or as for a signed integer value, if the NormalizeUintToInt option is
+// set and it fits).
+func (u *unmarshaller) makeUint(v uint64) any {
+	if u.opts.NormalizeUintToInt {
+		if u.opts.WideIntegers {
+			if v <= math.MaxInt64 {
+				return int64(v)
+			}
+		} else if v <= math.MaxInt {
+			return int(v)
+		}
+	}
+	if u.opts.WideIntegers {
+		return v
 	}
+	return uint(v)
 }
 
 // unmarshalFloat32 unmarshals a float 32 (as a float32).
 func (u *unmarshaller) unmarshalFloat32() (float32, bool, error) {
-	if data, err := u.r.ReadView(4); err != nil {
-		return 0, false, mapEOF(err)
+	if data, err := u.readView(4); err != nil {
+		return 0, false, err
 	} else {
 		return math.Float32frombits(binary.BigEndian.Uint32(data)), true, nil
 	}
@@ -391,53 +1132,221 @@ func (u *unmarshaller) unmarshalFloat32() (float32, bool, error) {
 
 // unmarshalFloat64 unmarshals a float 64 (as a float64).
 func (u *unmarshaller) unmarshalFloat64() (float64, bool, error) {
-	if data, err := u.r.ReadView(8); err != nil {
-		return 0, false, mapEOF(err)
+	if data, err := u.readView(8); err != nil {
+		return 0, false, err
 	} else {
 		return math.Float64frombits(binary.BigEndian.Uint64(data)), true, nil
 	}
 }
 
 // unmarshalNMap unmarshals a map with n entries.
-func (u *unmarshaller) unmarshalNMap(n uint) (map[any]any, bool, error) {
-	rv := map[any]any{}
+func (u *unmarshaller) unmarshalNMap(n uint) (any, bool, error) {
+	if u.opts.MaxMapEntries > 0 && n > u.opts.MaxMapEntries {
+		return nil, false, TooManyEntriesError
+	}
+
+	rv := u.makeMap(min(n, unmarshalMaxMapAllocEntries))
+	// The keys in rv, in order (only tracked if OrderedMaps is set).
+	var keys []any
+	if u.opts.OrderedMaps {
+		keys = make([]any, 0, min(n, unmarshalMaxArrayAllocElements))
+	}
+	// Whether all the keys in rv are strings (only tracked if StringKeyedMaps is set).
+	allStringKeys := u.opts.StringKeyedMaps && !u.opts.OrderedMaps
+	// The encodings of the keys in rv (only tracked if DuplicateKeyByEncoding is set).
+	var encodedKeys map[string]struct{}
+	if u.opts.DuplicateKeyByEncoding {
+		encodedKeys = make(map[string]struct{}, min(n, unmarshalMaxMapAllocEntries))
+	}
 	for i := uint(0); i < n; i += 1 {
 		// Always try to unmarshal both the key and value even if we're going to return a
 		// higher-level error (duplicate key or unsupported key type) -- because if we
 		// ignore the error, then we need to "advance" our position properly.
-		key, mapKeySupported, err := u.unmarshalObject(false)
+		key, mapKeySupported, err := u.unmarshalObject()
 		if err != nil {
+			u.recycle(rv)
 			return nil, false, err
 		}
 
-		value, _, err := u.unmarshalObject(false)
+		value, _, err := u.unmarshalObject()
 		if err != nil {
+			u.recycle(rv, key)
 			return nil, false, err
 		}
 
+		if u.opts.RequireStringKeys {
+			if _, ok := key.(string); !ok {
+				u.recycle(rv, key, value)
+				return nil, false, NonStringKeyError
+			}
+		}
+
 		if !mapKeySupported {
 			if !u.opts.DisableUnsupportedKeyTypeError {
+				u.recycle(rv, key, value)
 				return nil, false, UnsupportedKeyTypeError
 			}
 			// Else ignore this key-value pair.
-		} else if _, alreadyPresent := rv[key]; alreadyPresent {
+			u.recycle(key, value)
+		} else if isDuplicateKey(rv, encodedKeys, key) {
 			if !u.opts.DisableDuplicateKeyError {
+				u.recycle(rv, value)
 				return nil, false, DuplicateKeyError
 			}
 			// Else let the first key-value pair with the same key win.
+			u.recycle(value)
 		} else {
+			if allStringKeys {
+				_, allStringKeys = key.(string)
+			}
+			if u.opts.OrderedMaps {
+				keys = append(keys, key)
+			}
 			rv[key] = value
 		}
 	}
+
+	if u.opts.OrderedMaps {
+		return &OrderedMap{Keys: keys, Values: rv}, false, nil
+	}
+
+	if allStringKeys {
+		if u.opts.InferMapValueTypes {
+			if typedRv, ok := makeTypedStringKeyedMap(rv); ok {
+				u.putMap(rv)
+				return typedRv, false, nil
+			}
+		}
+
+		stringKeyedRv := make(map[string]any, len(rv))
+		for key, value := range rv {
+			stringKeyedRv[key.(string)] = value
+		}
+		u.putMap(rv)
+		return stringKeyedRv, false, nil
+	}
+
 	return rv, false, nil
 }
 
+// duplicateKeyMarshalOptions are the options used by isDuplicateKey to marshal keys (independent of
+// DefaultMarshalOptions, which may be modified).
+var duplicateKeyMarshalOptions = &MarshalOptions{}
+
+// isDuplicateKey returns whether key is a duplicate of a key in rv. If encodedKeys is non-nil (for
+// DuplicateKeyByEncoding), it also checks key's encoding against encodedKeys, adding it if key isn't
+// a duplicate.
+func isDuplicateKey(rv map[any]any, encodedKeys map[string]struct{}, key any) bool {
+	if _, alreadyPresent := rv[key]; alreadyPresent {
+		return true
+	}
+	if encodedKeys == nil {
+		return false
+	}
+
+	// Encode unsigned integers (that fit) as signed integers, so that they're encoded the same.
+	switch k := key.(type) {
+	case uint:
+		if uint64(k) <= math.MaxInt64 {
+			key = int64(k)
+		}
+	case uint64:
+		if k <= math.MaxInt64 {
+			key = int64(k)
+		}
+	case uint32:
+		key = int64(k)
+	case uint16:
+		key = int64(k)
+	case uint8:
+		key = int64(k)
+	}
+	encodedKey, err := MarshalToBytes(duplicateKeyMarshalOptions, key)
+	if err != nil {
+		// Fall back to comparing as Go values.
+		return false
+	}
+	if _, alreadyPresent := encodedKeys[string(encodedKey)]; alreadyPresent {
+		return true
+	}
+	encodedKeys[string(encodedKey)] = struct{}{}
+	return false
+}
+
+// makeMap makes an (empty) map for unmarshalling a map with (about) the given number of entries,
+// using the recycler if any (in which case the size hint is ignored).
+func (u *unmarshaller) makeMap(sizeHint uint) map[any]any {
+	if u.opts.Recycler != nil {
+		return u.opts.Recycler.GetMap()
+	}
+	return make(map[any]any, sizeHint)
+}
+
+// putMap puts back a map (made by makeMap) that is no longer needed, if there is a recycler.
+func (u *unmarshaller) putMap(m map[any]any) {
+	if u.opts.Recycler != nil {
+		u.opts.Recycler.PutMap(m)
+	}
+}
+
+// makeSlice makes an (empty) slice with the given capacity for unmarshalling an array, using the
+// recycler if any.
+func (u *unmarshaller) makeSlice(capacity uint) []any {
+	if u.opts.Recycler != nil {
+		return u.opts.Recycler.GetSlice(int(capacity))
+	}
+	return make([]any, 0, capacity)
+}
+
+// recycle puts back all the containers in the given objects (which are no longer needed), if
+// there is a recycler. (See RecycleObject.)
+func (u *unmarshaller) recycle(objs ...any) {
+	if u.opts.Recycler != nil {
+		for _, obj := range objs {
+			RecycleObject(u.opts.Recycler, obj)
+		}
+	}
+}
+
+// makeTypedStringKeyedMap converts m, which must have only string keys, to a map[string]T if all its
+// values have the same scalar type T (see UnmarshalOptions.InferMapValueTypes). If not, it returns
+// false.
+func makeTypedStringKeyedMap(m map[any]any) (any, bool) {
+	var valueType reflect.Type
+	for _, value := range m {
+		switch value.(type) {
+		case bool, int, int64, uint, uint64, float32, float64, string:
+		default:
+			return nil, false
+		}
+		if t := reflect.TypeOf(value); valueType == nil {
+			valueType = t
+		} else if t != valueType {
+			return nil, false
+		}
+	}
+	if valueType == nil {
+		return nil, false
+	}
+
+	rv := reflect.MakeMapWithSize(reflect.MapOf(reflect.TypeOf(""), valueType), len(m))
+	for key, value := range m {
+		rv.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(value))
+	}
+	return rv.Interface(), true
+}
+
 // unmarshalNArray unmarshals an array with n entries.
 func (u *unmarshaller) unmarshalNArray(n uint) ([]any, bool, error) {
-	rv := make([]any, 0, min(n, unmarshalMaxArrayAllocElements))
+	if u.opts.MaxArrayEntries > 0 && n > u.opts.MaxArrayEntries {
+		return nil, false, TooManyEntriesError
+	}
+
+	rv := u.makeSlice(min(n, unmarshalMaxArrayAllocElements))
 	for i := uint(0); i < n; i += 1 {
-		element, _, err := u.unmarshalObject(false)
+		element, _, err := u.unmarshalObject()
 		if err != nil {
+			u.recycle(rv)
 			return nil, false, err
 		}
 		rv = append(rv, element)
@@ -449,9 +1358,22 @@ func (u *unmarshaller) unmarshalNArray(n uint) ([]any, bool, error) {
 // Note that it does not validate that it is valid UTF-8.
 // TODO: Should it be an option?
 func (u *unmarshaller) unmarshalNString(n uint) (string, bool, error) {
+	if u.opts.MaxStringBytes > 0 && n > u.opts.MaxStringBytes {
+		return "", false, StringTooLongError
+	}
+
+	if u.opts.UnsafeStrings && n > 0 {
+		// We own the copy (and never modify or reuse it), so it may be aliased as a string.
+		if data, err := u.readCopy(n); err != nil {
+			return "", false, err
+		} else {
+			return unsafe.String(&data[0], len(data)), true, nil
+		}
+	}
+
 	// The conversion to string makes a copy, so we can take a view.
-	if data, err := u.r.ReadView(n); err != nil {
-		return "", false, mapEOF(err)
+	if data, err := u.readView(n); err != nil {
+		return "", false, err
 	} else {
 		return string(data), true, nil
 	}
@@ -459,9 +1381,16 @@ func (u *unmarshaller) unmarshalNString(n uint) (string, bool, error) {
 
 // unmarshalNBytes unmarshals a byte array of length n (bytes).
 func (u *unmarshaller) unmarshalNBytes(n uint) ([]byte, bool, error) {
+	if u.opts.MaxBinaryBytes > 0 && n > u.opts.MaxBinaryBytes {
+		return nil, false, BinaryTooLongError
+	}
+	if n == 0 && u.opts.EmptyBinAsNil {
+		return nil, false, nil
+	}
+
 	// We need a copy, since we return the slice.
-	if data, err := u.r.ReadCopy(n); err != nil {
-		return nil, false, mapEOF(err)
+	if data, err := u.readCopy(n); err != nil {
+		return nil, false, err
 	} else {
 		return data, false, nil
 	}
@@ -469,12 +1398,23 @@ func (u *unmarshaller) unmarshalNBytes(n uint) ([]byte, bool, error) {
 
 // unmarshalNExt unmarshals an extension with data of length n (bytes).
 func (u *unmarshaller) unmarshalNExt(n uint) (any, bool, error) {
+	if u.opts.MaxExtensionBytes > 0 && n > u.opts.MaxExtensionBytes {
+		return nil, false, ExtensionTooLargeError
+	}
+
 	if extensionType, _, err := u.unmarshalInt8(); err != nil {
 		return nil, false, err
+	} else if extensionTypeFn, ok := u.opts.ExtensionTypeFns[int8(extensionType)]; ok {
+		// The function is told that the data is borrowed, so we can take a view.
+		if data, err := u.readView(n); err != nil {
+			return nil, false, err
+		} else {
+			return extensionTypeFn(data, true)
+		}
 	} else {
 		// We need a copy, since we return the slice (inside an UnresolvedExtensionType).
-		if data, err := u.r.ReadCopy(n); err != nil {
-			return nil, false, mapEOF(err)
+		if data, err := u.readCopy(n); err != nil {
+			return nil, false, err
 		} else {
 			return &UnresolvedExtensionType{ExtensionType: int8(extensionType), Data: data}, false, nil
 		}
@@ -492,6 +1432,21 @@ func (u *unmarshaller) unmarshalNExt(n uint) (any, bool, error) {
 // valid map key (for a map[any]any).
 type UnmarshalExtensionTypeFn func(data []byte) (obj any, mapKeySupported bool, err error)
 
+// An UnmarshalBorrowedExtensionTypeFn is like an UnmarshalExtensionTypeFn, but is also told whether
+// the given data is borrowed. If it is, the data is only valid until the function returns (it may
+// alias an internal buffer or the input), so the function must not keep a reference to it; it may
+// use CopyIfBorrowed to get data that it may keep. (See UnmarshalOptions.ExtensionTypeFns.)
+type UnmarshalBorrowedExtensionTypeFn func(data []byte, borrowed bool) (obj any, mapKeySupported bool, err error)
+
+// CopyIfBorrowed returns a copy of data if it is borrowed (see UnmarshalBorrowedExtensionTypeFn),
+// and otherwise data itself.
+func CopyIfBorrowed(data []byte, borrowed bool) []byte {
+	if !borrowed {
+		return data
+	}
+	return bytes.Clone(data)
+}
+
 // MakeExtensionTypeUnmarshalTransformer makes an unmarshal transformer for the given extensions,
 // specified as a map from an extension type (-128 to 127) to an UnmarshalExtensionTypeFn.
 func MakeExtensionTypeUnmarshalTransformer(unmarshalExtensions map[int8]UnmarshalExtensionTypeFn) UnmarshalTransformerFn {
@@ -515,6 +1470,60 @@ func MakeExtensionTypeUnmarshalTransformer(unmarshalExtensions map[int8]Unmarsha
 	}
 }
 
+// NormalizeStringMapUnmarshalTransformer is an unmarshal transformer that transforms map[any]any
+// whose keys are all strings to an equivalent map[string]any, leaving other maps (and other
+// objects) as-is. It recurses into map values and array elements, so that nested maps are
+// normalized too.
+//
+// (When it's used as an unmarshal transformer, nested objects will already have been transformed,
+// since transformers are applied to nested objects before their containers; the recursion matters
+// when it's called directly on an already-unmarshalled object.)
+func NormalizeStringMapUnmarshalTransformer(obj any, mapKeySupported bool) (any, bool, error) {
+	switch obj.(type) {
+	case map[any]any, map[string]any, []any:
+		return normalizeStringMaps(obj), mapKeySupported, nil
+	default:
+		return obj, mapKeySupported, nil
+	}
+}
+
+var _ UnmarshalTransformerFn = NormalizeStringMapUnmarshalTransformer
+
+// normalizeStringMaps is a helper for NormalizeStringMapUnmarshalTransformer, which normalizes obj
+// and (recursively) its contents.
+func normalizeStringMaps(obj any) any {
+	switch o := obj.(type) {
+	case map[any]any:
+		allStringKeys := true
+		for key, value := range o {
+			if _, ok := key.(string); !ok {
+				allStringKeys = false
+			}
+			o[key] = normalizeStringMaps(value)
+		}
+		if !allStringKeys {
+			return o
+		}
+		rv := make(map[string]any, len(o))
+		for key, value := range o {
+			rv[key.(string)] = value
+		}
+		return rv
+	case map[string]any:
+		for key, value := range o {
+			o[key] = normalizeStringMaps(value)
+		}
+		return o
+	case []any:
+		for i, element := range o {
+			o[i] = normalizeStringMaps(element)
+		}
+		return o
+	default:
+		return obj
+	}
+}
+
 // StandardUnmarshalTransformer is the standard unmarshal transformer run by Unmarshal (before the
 // application unmarshal transformer, if any).
 var StandardUnmarshalTransformer UnmarshalTransformerFn = MakeExtensionTypeUnmarshalTransformer(
@@ -527,8 +1536,26 @@ var StandardUnmarshalTransformer UnmarshalTransformerFn = MakeExtensionTypeUnmar
 // invalid timestamp.
 var InvalidTimestampError = errors.New("Invalid timestamp")
 
+// TimestampOutOfRangeError is the error returned by TimestampExtensionUnmarshalTransformer for a
+// timestamp whose seconds are out of the supported range (see UnmarshalTimestampExtensionType).
+var TimestampOutOfRangeError = errors.New("Timestamp out of range")
+
+// minTimestampSeconds and maxTimestampSeconds are the range of the seconds (since the Unix epoch)
+// of a timestamp that may be unmarshalled: from 0001-01-01T00:00:00Z to 9999-12-31T23:59:59Z
+// (inclusive). time.Time can represent a much larger range, but, e.g., its Sub and Add methods
+// and formatting (e.g., RFC 3339, which only allows 4-digit years) don't work correctly for times
+// far outside this range.
+const (
+	minTimestampSeconds = -62135596800
+	maxTimestampSeconds = 253402300799
+)
+
 // UnmarshalTimestampExtensionType is an UnmarshalExtensionTypeFn that unmarshals the standard (-1)
 // timestamp extension type.
+//
+// The timestamp 32 and timestamp 64 formats are always in range, but for the timestamp 96 format,
+// TimestampOutOfRangeError is returned if the time is not in the years 0001 to 9999 (UTC), i.e., if
+// the seconds are not in the range -62135596800 to 253402300799.
 func UnmarshalTimestampExtensionType(data []byte) (any, bool, error) {
 	switch len(data) {
 	case 4: // timestamp 32
@@ -548,17 +1575,11 @@ func UnmarshalTimestampExtensionType(data []byte) (any, bool, error) {
 		if nsec >= 1_000_000_000 {
 			return nil, false, InvalidTimestampError
 		}
+		if sec < minTimestampSeconds || sec > maxTimestampSeconds {
+			return nil, false, TimestampOutOfRangeError
+		}
 		return time.Unix(sec, nsec), true, nil
 	default:
 		return nil, false, InvalidTimestampError
 	}
 }
-
-// mapEOF maps io.EOF to io.ErrUnexpectedEOF.
-func mapEOF(err error) error {
-	if err == io.EOF {
-		return io.ErrUnexpectedEOF
-	} else {
-		return err
-	}
-}
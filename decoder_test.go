@@ -7,10 +7,12 @@ package umsgpack_test
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"math"
 	"reflect"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	. "github.com/viettrungluu/umsgpack"
@@ -29,13 +31,21 @@ type unmarshalTestCase struct {
 func testUnmarshal(t *testing.T, opts *UnmarshalOptions, tCs []unmarshalTestCase) {
 	for _, tC := range tCs {
 		buf := bytes.NewBuffer(tC.encoded)
-		if actualDecoded, actualErr := Unmarshal(opts, buf); actualErr != tC.err {
+		if actualDecoded, actualErr := Unmarshal(opts, buf); !errors.Is(actualErr, tC.err) {
 			t.Errorf("unexected error for encoded=%q (decoded=%#v, err=%v): actualErr=%v", tC.encoded, tC.decoded, tC.err, actualErr)
 		} else if tC.err == nil && !reflect.DeepEqual(actualDecoded, tC.decoded) {
 			t.Errorf("unexected result for encoded=%q (decoded=%#v): actualDecoded=%#v", tC.encoded, tC.decoded, actualDecoded)
 		}
 
-		if actualDecoded, actualErr := UnmarshalBytes(opts, tC.encoded); actualErr != tC.err {
+		// Also read one byte at a time (and without io.ByteReader), which should be equivalent.
+		oneByteReader := iotest.OneByteReader(bytes.NewBuffer(tC.encoded))
+		if actualDecoded, actualErr := Unmarshal(opts, oneByteReader); !errors.Is(actualErr, tC.err) {
+			t.Errorf("unexected error for encoded=%q (decoded=%#v, err=%v) (one byte reader): actualErr=%v", tC.encoded, tC.decoded, tC.err, actualErr)
+		} else if tC.err == nil && !reflect.DeepEqual(actualDecoded, tC.decoded) {
+			t.Errorf("unexected result for encoded=%q (decoded=%#v) (one byte reader): actualDecoded=%#v", tC.encoded, tC.decoded, actualDecoded)
+		}
+
+		if actualDecoded, actualErr := UnmarshalBytes(opts, tC.encoded); !errors.Is(actualErr, tC.err) {
 			t.Errorf("unexected error for encoded=%q (decoded=%#v, err=%v): actualErr=%v", tC.encoded, tC.decoded, tC.err, actualErr)
 		} else if tC.err == nil && !reflect.DeepEqual(actualDecoded, tC.decoded) {
 			t.Errorf("unexected result for encoded=%q (decoded=%#v): actualDecoded=%#v", tC.encoded, tC.decoded, actualDecoded)
@@ -378,11 +388,11 @@ var timestampUnmarshalTestCases = []unmarshalTestCase{
 	{encoded: []byte{0xc9, 0x00, 0x00, 0x00, 0x08, 0xff, 0x1d, 0x6f, 0x34, 0x56, 0x34, 0x56, 0x78, 0x9a}, decoded: time.Unix(0x23456789a, 123456789)},
 	// - timestamp 96
 	//   (as ext 8, which is canonical/minimal)
-	{encoded: []byte{0xc7, 0x0c, 0xff, 0x12, 0x34, 0x56, 0x78, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}, decoded: time.Unix(0x123456789abcdef0, 0x12345678)},
+	{encoded: []byte{0xc7, 0x0c, 0xff, 0x12, 0x34, 0x56, 0x78, 0x00, 0x00, 0x00, 0x34, 0x56, 0x78, 0x9a, 0xbc}, decoded: time.Unix(0x3456789abc, 0x12345678)},
 	{encoded: []byte{0xc7, 0x0c, 0xff, 0x3b, 0x9a, 0xca, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, err: InvalidTimestampError},
 	//   (as ext 16/32)
-	{encoded: []byte{0xc8, 0x00, 0x0c, 0xff, 0x12, 0x34, 0x56, 0x78, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}, decoded: time.Unix(0x123456789abcdef0, 0x12345678)},
-	{encoded: []byte{0xc9, 0x00, 0x00, 0x00, 0x0c, 0xff, 0x12, 0x34, 0x56, 0x78, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}, decoded: time.Unix(0x123456789abcdef0, 0x12345678)},
+	{encoded: []byte{0xc8, 0x00, 0x0c, 0xff, 0x12, 0x34, 0x56, 0x78, 0x00, 0x00, 0x00, 0x34, 0x56, 0x78, 0x9a, 0xbc}, decoded: time.Unix(0x3456789abc, 0x12345678)},
+	{encoded: []byte{0xc9, 0x00, 0x00, 0x00, 0x0c, 0xff, 0x12, 0x34, 0x56, 0x78, 0x00, 0x00, 0x00, 0x34, 0x56, 0x78, 0x9a, 0xbc}, decoded: time.Unix(0x3456789abc, 0x12345678)},
 	// - invalid lengths (via ext 8)
 	{encoded: []byte{0xc7, 0x00, 0xff}, err: InvalidTimestampError},
 	{encoded: []byte{0xc7, 0x01, 0xff, 0x00}, err: InvalidTimestampError},
@@ -486,6 +496,34 @@ func TestUnmarshal_defaultOpts(t *testing.T) {
 	testUnmarshal(t, opts, defaultOptsUnmarshalTestCases)
 }
 
+func TestSecureUnmarshalOptions(t *testing.T) {
+	opts := SecureUnmarshalOptions()
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0x92, 0xc3, 0xc0}, decoded: map[any]any{"a": 1, "b": []any{true, nil}}},
+		{encoded: []byte{0x82, 0x0c, 0x01, 0xcc, 0x0c, 0x02}, err: DuplicateKeyError},
+		{encoded: []byte{0xdd, 0x00, 0x01, 0x00, 0x01}, err: TooManyEntriesError},
+		{encoded: []byte{0xdf, 0x00, 0x01, 0x00, 0x01}, err: TooManyEntriesError},
+		{encoded: []byte{0xc6, 0x00, 0x10, 0x00, 0x01}, err: MessageTooLargeError},
+	})
+	if _, err := UnmarshalBytes(opts, []byte{0x01, 0x02}); !errors.Is(err, TrailingBytesError) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// Deeply-nested data (within MaxReaderBytes) is rejected.
+	deep := append(bytes.Repeat([]byte{0x91}, 1<<20-1), 0xc0)
+	if _, err := UnmarshalBytes(opts, deep); !errors.Is(err, TooDeeplyNestedError) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := Skip(opts, bytes.NewReader(deep)); !errors.Is(err, TooDeeplyNestedError) {
+		t.Errorf("unexpected error from Skip: %v", err)
+	}
+
+	// Each call returns new options.
+	opts.MaxReaderBytes = 0
+	if SecureUnmarshalOptions().MaxReaderBytes == 0 {
+		t.Errorf("options not new")
+	}
+}
+
 // TestUnmarshal_nonDefaultOpts tests Unmarshal/UnmarshalBytes with all boolean options set to true.
 func TestUnmarshal_nonDefaultOpts(t *testing.T) {
 	opts := &UnmarshalOptions{
@@ -567,6 +605,37 @@ func TestUnmarshal_applicationExtensions(t *testing.T) {
 	testUnmarshal(t, opts, applicationExtensionsUnmarshalTestCases)
 }
 
+func TestUnmarshal_timestampRange(t *testing.T) {
+	// 0001-01-01T00:00:00Z and 9999-12-31T23:59:59Z.
+	const minSec, maxSec = -62135596800, 253402300799
+	testUnmarshal(t, nil, []unmarshalTestCase{
+		// timestamp 32: the full (unsigned) 32-bit range.
+		{encoded: []byte{0xd6, 0xff, 0xff, 0xff, 0xff, 0xff}, decoded: time.Unix(0xffffffff, 0)},
+		// timestamp 64: the full (unsigned) 34-bit range of seconds, with maximum nanoseconds.
+		{encoded: []byte{0xd7, 0xff, 0x00, 0x00, 0x00, 0x03, 0xff, 0xff, 0xff, 0xff}, decoded: time.Unix(0x3ffffffff, 0)},
+		{encoded: []byte{0xd7, 0xff, 0xee, 0x6b, 0x27, 0xff, 0xff, 0xff, 0xff, 0xff}, decoded: time.Unix(0x3ffffffff, 999999999)},
+		// timestamp 96: at and just outside the boundaries.
+		{encoded: []byte{0xc7, 0x0c, 0xff, 0x3b, 0x9a, 0xc9, 0xff, 0x00, 0x00, 0x00, 0x3a, 0xff, 0xf4, 0x41, 0x7f}, decoded: time.Unix(maxSec, 999999999)},
+		{encoded: []byte{0xc7, 0x0c, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x3a, 0xff, 0xf4, 0x41, 0x80}, err: TimestampOutOfRangeError},
+		{encoded: []byte{0xc7, 0x0c, 0xff, 0x3b, 0x9a, 0xc9, 0xff, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, err: TimestampOutOfRangeError},
+		{encoded: []byte{0xc7, 0x0c, 0xff, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xf1, 0x88, 0x6e, 0x09, 0x00}, decoded: time.Unix(minSec, 0)},
+		{encoded: []byte{0xc7, 0x0c, 0xff, 0x3b, 0x9a, 0xc9, 0xff, 0xff, 0xff, 0xff, 0xf1, 0x88, 0x6e, 0x08, 0xff}, err: TimestampOutOfRangeError},
+		{encoded: []byte{0xc7, 0x0c, 0xff, 0x3b, 0x9a, 0xc9, 0xff, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, err: TimestampOutOfRangeError},
+	})
+
+	// The boundaries round-trip.
+	for _, sec := range []int64{0x3ffffffff, 0x400000000, maxSec, minSec} {
+		expected := time.Unix(sec, 999999999)
+		if encoded, err := MarshalToBytes(nil, expected); err != nil {
+			t.Errorf("unexpected marshal error for sec=%v: %v", sec, err)
+		} else if decoded, err := UnmarshalBytes(nil, encoded); err != nil {
+			t.Errorf("unexpected unmarshal error for sec=%v: %v", sec, err)
+		} else if !expected.Equal(decoded.(time.Time)) {
+			t.Errorf("unexpected result for sec=%v: %v (expected: %v)", sec, decoded, expected)
+		}
+	}
+}
+
 var timestampExtensionOverrideUnmarshalTestCases = []unmarshalTestCase{
 	// Timestamp extension type (-1):
 	// - timestamp 32
@@ -599,3 +668,1144 @@ func TestUnmarshal_timestampExtensionOverride(t *testing.T) {
 }
 
 // TODO: test MakeExtensionTypeUnmarshalTransformer.
+
+var rejectTrailingBytesUnmarshalTestCases = []unmarshalTestCase{
+	{encoded: []byte{0xc0, 0xc0}, err: TrailingBytesError},
+	{encoded: []byte{0x2a, 0x00}, err: TrailingBytesError},
+	{encoded: []byte{0xa2, 0x68, 0x69, 0xc1}, err: TrailingBytesError},
+	{encoded: append(append([]byte{0x9f}, genArrayData(15)...), 0x2a), err: TrailingBytesError},
+}
+
+func TestUnmarshal_rejectTrailingBytes(t *testing.T) {
+	opts := &UnmarshalOptions{RejectTrailingBytes: true}
+	testUnmarshal(t, opts, commonUnmarshalTestCases)
+	testUnmarshal(t, opts, timestampUnmarshalTestCases)
+	testUnmarshal(t, opts, defaultOptsUnmarshalTestCases)
+	testUnmarshal(t, opts, rejectTrailingBytesUnmarshalTestCases)
+
+	// Trailing bytes are ignored by default.
+	if decoded, err := UnmarshalBytes(nil, []byte{0x2a, 0x00}); err != nil || decoded != 42 {
+		t.Errorf("Unexpected result: %v, %v", decoded, err)
+	}
+}
+
+var requireStringKeysUnmarshalTestCases = []unmarshalTestCase{
+	{encoded: []byte{0x80}, decoded: map[any]any{}},
+	{encoded: append([]byte{0x8f}, genMapData(15)...), decoded: genMap(15)},
+	{encoded: []byte{0x81, 0xa2, 0x31, 0x32, 0x81, 0xa1, 0x30, 0x2a}, decoded: map[any]any{"12": map[any]any{"0": int(42)}}},
+	{encoded: []byte{0x81, 0x0c, 0x2a}, err: NonStringKeyError},
+	{encoded: []byte{0x81, 0xc0, 0x2a}, err: NonStringKeyError},
+	{encoded: []byte{0x81, 0xc4, 0x00, 0x2a}, err: NonStringKeyError},
+	{encoded: []byte{0x81, 0xd6, 0xff, 0x12, 0x34, 0x56, 0x78, 0x2a}, err: NonStringKeyError},
+	{encoded: []byte{0x82, 0xa1, 0x30, 0x2a, 0xcc, 0x0c, 0x2a}, err: NonStringKeyError},
+	{encoded: []byte{0x91, 0x81, 0x0c, 0x2a}, err: NonStringKeyError},
+}
+
+func TestUnmarshal_requireStringKeys(t *testing.T) {
+	opts := &UnmarshalOptions{RequireStringKeys: true}
+	testUnmarshal(t, opts, requireStringKeysUnmarshalTestCases)
+
+	opts = &UnmarshalOptions{RequireStringKeys: true, DisableUnsupportedKeyTypeError: true}
+	testUnmarshal(t, opts, requireStringKeysUnmarshalTestCases)
+}
+
+var requireMinimalEncodingUnmarshalTestCases = []unmarshalTestCase{
+	// int:
+	{encoded: []byte{0x7f}, decoded: int(127)},
+	{encoded: []byte{0xe0}, decoded: int(-32)},
+	{encoded: []byte{0xd0, 0xdf}, decoded: int(-33)},
+	{encoded: []byte{0xd0, 0x00}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xd0, 0x7f}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xd0, 0xe0}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xd1, 0x00, 0x80}, decoded: int(128)},
+	{encoded: []byte{0xd1, 0xff, 0x7f}, decoded: int(-129)},
+	{encoded: []byte{0xd1, 0x00, 0x7f}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xd1, 0xff, 0x80}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xd2, 0x00, 0x00, 0x80, 0x00}, decoded: int(32768)},
+	{encoded: []byte{0xd2, 0x00, 0x00, 0x00, 0x01}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xd3, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00}, decoded: int(1 << 31)},
+	{encoded: []byte{0xd3, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xd3, 0xff, 0xff, 0xff, 0xff, 0x80, 0x00, 0x00, 0x00}, err: NonMinimalEncodingError},
+	// uint:
+	{encoded: []byte{0xcc, 0x00}, decoded: uint(0)},
+	{encoded: []byte{0xcd, 0x01, 0x00}, decoded: uint(256)},
+	{encoded: []byte{0xcd, 0x00, 0xff}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xce, 0x00, 0x01, 0x00, 0x00}, decoded: uint(1 << 16)},
+	{encoded: []byte{0xce, 0x00, 0x00, 0xff, 0xff}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xcf, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}, decoded: uint(1 << 32)},
+	{encoded: []byte{0xcf, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff}, err: NonMinimalEncodingError},
+	// string:
+	{encoded: []byte{0xa2, 0x30, 0x31}, decoded: "01"},
+	{encoded: append([]byte{0xd9, 0x20}, fillerChars(32)...), decoded: string(fillerChars(32))},
+	{encoded: []byte{0xd9, 0x02, 0x30, 0x31}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xda, 0x00, 0x03, 0x30, 0x31, 0x32}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xdb, 0x00, 0x00, 0x00, 0x02, 0x30, 0x31}, err: NonMinimalEncodingError},
+	// binary:
+	{encoded: []byte{0xc4, 0x02, 0x00, 0x01}, decoded: []byte{0, 1}},
+	{encoded: []byte{0xc5, 0x00, 0x02, 0x00, 0x01}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xc6, 0x00, 0x00, 0x01, 0x00}, err: NonMinimalEncodingError},
+	// array:
+	{encoded: append([]byte{0xdc, 0x00, 0x10}, genArrayData(16)...), decoded: genArray(16)},
+	{encoded: []byte{0xdc, 0x00, 0x00}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xdd, 0x00, 0x00, 0xff, 0xff}, err: NonMinimalEncodingError},
+	{encoded: []byte{0x91, 0xd0, 0x00}, err: NonMinimalEncodingError},
+	// map:
+	{encoded: append([]byte{0xde, 0x00, 0x10}, genMapData(16)...), decoded: genMap(16)},
+	{encoded: []byte{0xde, 0x00, 0x00}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xdf, 0x00, 0x00, 0xff, 0xff}, err: NonMinimalEncodingError},
+	{encoded: []byte{0x81, 0xd9, 0x01, 0x30, 0x2a}, err: NonMinimalEncodingError},
+	// extension types:
+	{encoded: []byte{0xd5, 0x07, 0x00, 0x01}, decoded: &UnresolvedExtensionType{ExtensionType: 7, Data: []byte{0, 1}}},
+	{encoded: []byte{0xc7, 0x03, 0x07, 0x00, 0x01, 0x02}, decoded: &UnresolvedExtensionType{ExtensionType: 7, Data: []byte{0, 1, 2}}},
+	{encoded: []byte{0xc7, 0x00, 0x07}, decoded: &UnresolvedExtensionType{ExtensionType: 7, Data: []byte{}}},
+	{encoded: []byte{0xc7, 0x02, 0x07, 0x00, 0x01}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xc7, 0x04, 0xff, 0x12, 0x34, 0x56, 0x78}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xc8, 0x00, 0x03, 0x07, 0x00, 0x01, 0x02}, err: NonMinimalEncodingError},
+	{encoded: []byte{0xc9, 0x00, 0x00, 0x00, 0x03, 0x07, 0x00, 0x01, 0x02}, err: NonMinimalEncodingError},
+}
+
+func TestUnmarshal_requireMinimalEncoding(t *testing.T) {
+	opts := &UnmarshalOptions{RequireMinimalEncoding: true}
+	testUnmarshal(t, opts, requireMinimalEncodingUnmarshalTestCases)
+
+	// Everything that Marshal produces should be minimal.
+	for i, obj := range roundTrippableObjects {
+		if encoded, err := MarshalToBytes(nil, obj); err != nil {
+			t.Errorf("%v: MarshalToBytes returned error: %v", i, err)
+		} else if _, err := UnmarshalBytes(opts, encoded); err != nil {
+			t.Errorf("%v: UnmarshalBytes returned error: %v", i, err)
+		}
+	}
+}
+
+func TestUnmarshal_requireMinimalEncodingTimestamps(t *testing.T) {
+	ts32 := []byte{0xff, 0x12, 0x34, 0x56, 0x78}
+	ts64 := []byte{0xff, 0x1d, 0x6f, 0x34, 0x56, 0x34, 0x56, 0x78, 0x9a}
+	ts96 := []byte{0xff, 0x12, 0x34, 0x56, 0x78, 0x00, 0x00, 0x00, 0x34, 0x56, 0x78, 0x9a, 0xbc}
+	t32 := time.Unix(0x12345678, 0)
+	t64 := time.Unix(0x23456789a, 123456789)
+	t96 := time.Unix(0x3456789abc, 0x12345678)
+	prefix := func(p []byte, data []byte) []byte {
+		return append(append([]byte{}, p...), data...)
+	}
+
+	// Minimal framings are accepted, and others aren't (unless RequireMinimalEncoding is unset).
+	minimal := []unmarshalTestCase{
+		{encoded: prefix([]byte{0xd6}, ts32), decoded: t32},
+		{encoded: prefix([]byte{0xd7}, ts64), decoded: t64},
+		{encoded: prefix([]byte{0xc7, 0x0c}, ts96), decoded: t96},
+		// (The extension data itself isn't checked: this could be timestamp 32.)
+		{encoded: []byte{0xd7, 0xff, 0x00, 0x00, 0x00, 0x00, 0x12, 0x34, 0x56, 0x78}, decoded: t32},
+	}
+	nonMinimal := []unmarshalTestCase{
+		{encoded: prefix([]byte{0xc7, 0x04}, ts32), decoded: t32},
+		{encoded: prefix([]byte{0xc8, 0x00, 0x04}, ts32), decoded: t32},
+		{encoded: prefix([]byte{0xc9, 0x00, 0x00, 0x00, 0x04}, ts32), decoded: t32},
+		{encoded: prefix([]byte{0xc7, 0x08}, ts64), decoded: t64},
+		{encoded: prefix([]byte{0xc8, 0x00, 0x08}, ts64), decoded: t64},
+		{encoded: prefix([]byte{0xc9, 0x00, 0x00, 0x00, 0x08}, ts64), decoded: t64},
+		{encoded: prefix([]byte{0xc8, 0x00, 0x0c}, ts96), decoded: t96},
+		{encoded: prefix([]byte{0xc9, 0x00, 0x00, 0x00, 0x0c}, ts96), decoded: t96},
+	}
+	testUnmarshal(t, nil, minimal)
+	testUnmarshal(t, nil, nonMinimal)
+
+	opts := &UnmarshalOptions{RequireMinimalEncoding: true}
+	testUnmarshal(t, opts, minimal)
+	for i := range nonMinimal {
+		nonMinimal[i].decoded = nil
+		nonMinimal[i].err = NonMinimalEncodingError
+	}
+	testUnmarshal(t, opts, nonMinimal)
+}
+
+func TestUnmarshal_rejectNil(t *testing.T) {
+	// By default, nil is allowed.
+	testUnmarshal(t, &UnmarshalOptions{}, []unmarshalTestCase{
+		{encoded: []byte{0xc0}, decoded: nil},
+		{encoded: []byte{0x92, 0x01, 0xc0}, decoded: []any{1, nil}},
+	})
+
+	opts := &UnmarshalOptions{RejectNil: true}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0xc0}, err: UnexpectedNilError},
+		{encoded: []byte{0x92, 0x01, 0xc0}, err: UnexpectedNilError},
+		{encoded: []byte{0x81, 0xc0, 0x01}, err: UnexpectedNilError},
+		{encoded: []byte{0x81, 0x01, 0xc0}, err: UnexpectedNilError},
+		{encoded: []byte{0x91, 0x81, 0xa1, 0x61, 0x91, 0xc0}, err: UnexpectedNilError},
+		// Other objects are unaffected.
+		{encoded: []byte{0x93, 0x01, 0xc2, 0xa0}, decoded: []any{1, false, ""}},
+		{encoded: []byte{0x81, 0xa1, 0x61, 0x80}, decoded: map[any]any{"a": map[any]any{}}},
+	})
+
+	// Other objects unmarshalled as nil are allowed.
+	opts = &UnmarshalOptions{RejectNil: true, EmptyBinAsNil: true}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0xc4, 0x00}, decoded: []byte(nil)},
+	})
+
+	// The error is reported at the nil.
+	_, err := UnmarshalBytes(&UnmarshalOptions{RejectNil: true}, []byte{0x93, 0x01, 0x02, 0xc0})
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Err != UnexpectedNilError || decodeErr.Offset != 4 {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestUnmarshalBytesN(t *testing.T) {
+	testCases := []struct {
+		encoded  []byte
+		decoded  any
+		consumed int
+	}{
+		{encoded: []byte{0xc0}, decoded: nil, consumed: 1},
+		{encoded: []byte{0x2a, 0x2b, 0x2c}, decoded: int(42), consumed: 1},
+		{encoded: []byte{0xa2, 0x68, 0x69, 0xc1, 0xc1}, decoded: "hi", consumed: 3},
+		{encoded: []byte{0x92, 0x01, 0x02, 0x03}, decoded: []any{int(1), int(2)}, consumed: 3},
+		{encoded: append(append([]byte{0x8f}, genMapData(15)...), 0xff), decoded: genMap(15), consumed: 1 + len(genMapData(15))},
+	}
+	for i, tC := range testCases {
+		if decoded, consumed, err := UnmarshalBytesN(nil, tC.encoded); err != nil {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if !reflect.DeepEqual(decoded, tC.decoded) || consumed != tC.consumed {
+			t.Errorf("%v: unexpected result: %#v, %v", i, decoded, consumed)
+		}
+	}
+
+	if _, _, err := UnmarshalBytesN(nil, []byte{0x92, 0x01}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestUnmarshalAll(t *testing.T) {
+	testCases := []struct {
+		opts    *UnmarshalOptions
+		encoded []byte
+		decoded []any
+		err     error
+		offset  int
+	}{
+		{encoded: []byte{}, decoded: nil},
+		{encoded: []byte{0xc0}, decoded: []any{nil}},
+		{encoded: []byte{0x2a, 0xa2, 0x68, 0x69, 0x92, 0x01, 0x02}, decoded: []any{int(42), "hi", []any{int(1), int(2)}}},
+		// RejectTrailingBytes is ignored.
+		{opts: &UnmarshalOptions{RejectTrailingBytes: true}, encoded: []byte{0x01, 0x02}, decoded: []any{int(1), int(2)}},
+		// MaxReaderBytes applies to each object.
+		{opts: &UnmarshalOptions{MaxReaderBytes: 3}, encoded: []byte{0xa2, 0x68, 0x69, 0xa2, 0x68, 0x69}, decoded: []any{"hi", "hi"}},
+		{opts: &UnmarshalOptions{MaxReaderBytes: 3}, encoded: []byte{0xa2, 0x68, 0x69, 0xa3, 0x68, 0x69, 0x69}, decoded: []any{"hi"}, err: MessageTooLargeError, offset: 4},
+		// Errors (the objects before the error are returned, and offsets are from the start).
+		{encoded: []byte{0x01, 0x92, 0x01}, decoded: []any{int(1)}, err: io.ErrUnexpectedEOF, offset: 3},
+		{encoded: []byte{0x01, 0x02, 0xcd, 0x01}, decoded: []any{int(1), int(2)}, err: io.ErrUnexpectedEOF, offset: 3},
+		{encoded: []byte{0x01, 0x02, 0xc1}, decoded: []any{int(1), int(2)}, err: InvalidFormatError, offset: 3},
+	}
+	for i, tC := range testCases {
+		decoded, err := UnmarshalAll(tC.opts, bytes.NewReader(tC.encoded))
+		if !reflect.DeepEqual(decoded, tC.decoded) {
+			t.Errorf("%v: unexpected result: %#v", i, decoded)
+		}
+		if tC.err == nil {
+			if err != nil {
+				t.Errorf("%v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		var decodeErr *DecodeError
+		if !errors.Is(err, tC.err) || !errors.As(err, &decodeErr) || decodeErr.Offset != tC.offset {
+			t.Errorf("%v: unexpected error: %v (expected: %v at offset %v)", i, err, tC.err, tC.offset)
+		}
+	}
+}
+
+func TestUnmarshalEach(t *testing.T) {
+	encoded := []byte{0x2a, 0xa2, 0x68, 0x69, 0x92, 0x01, 0x02}
+	var decoded []any
+	err := UnmarshalEach(nil, bytes.NewReader(encoded), func(obj any) error {
+		decoded = append(decoded, obj)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(decoded, []any{int(42), "hi", []any{int(1), int(2)}}) {
+		t.Errorf("Unexpected result: %#v, %v", decoded, err)
+	}
+
+	// An error from fn stops unmarshalling and is returned as is.
+	stopErr := errors.New("stop")
+	decoded = nil
+	err = UnmarshalEach(nil, bytes.NewReader(encoded), func(obj any) error {
+		decoded = append(decoded, obj)
+		if obj == "hi" {
+			return stopErr
+		}
+		return nil
+	})
+	if err != stopErr || !reflect.DeepEqual(decoded, []any{int(42), "hi"}) {
+		t.Errorf("Unexpected result: %#v, %v", decoded, err)
+	}
+
+	// Decoding errors are as for UnmarshalAll.
+	decoded = nil
+	err = UnmarshalEach(nil, bytes.NewReader([]byte{0x01, 0x92, 0x01}), func(obj any) error {
+		decoded = append(decoded, obj)
+		return nil
+	})
+	var decodeErr *DecodeError
+	if !errors.Is(err, io.ErrUnexpectedEOF) || !errors.As(err, &decodeErr) || decodeErr.Offset != 3 || !reflect.DeepEqual(decoded, []any{int(1)}) {
+		t.Errorf("Unexpected result: %#v, %v", decoded, err)
+	}
+}
+
+var stringKeyedMapsUnmarshalTestCases = []unmarshalTestCase{
+	{encoded: []byte{0x80}, decoded: map[string]any{}},
+	{encoded: append([]byte{0x8f}, genMapData(15)...), decoded: genStringAnyMap(15)},
+	{encoded: []byte{0x81, 0xa2, 0x31, 0x32, 0x81, 0xa1, 0x30, 0x2a}, decoded: map[string]any{"12": map[string]any{"0": int(42)}}},
+	{encoded: []byte{0x81, 0x0c, 0x2a}, decoded: map[any]any{int(12): int(42)}},
+	{encoded: []byte{0x82, 0xa1, 0x30, 0x2a, 0xcc, 0x0c, 0x2a}, decoded: map[any]any{"0": int(42), uint(12): int(42)}},
+	{encoded: []byte{0x82, 0x0c, 0x81, 0xa1, 0x30, 0x2a, 0xa1, 0x30, 0x2a}, decoded: map[any]any{int(12): map[string]any{"0": int(42)}, "0": int(42)}},
+	{encoded: []byte{0x81, 0xd6, 0xff, 0x12, 0x34, 0x56, 0x78, 0x2a}, decoded: map[any]any{time.Unix(0x12345678, 0): int(42)}},
+	{encoded: []byte{0x91, 0x81, 0xa1, 0x30, 0x2a}, decoded: []any{map[string]any{"0": int(42)}}},
+}
+
+var stringKeyedMapsDefaultOptsUnmarshalTestCases = []unmarshalTestCase{
+	{encoded: []byte{0x81, 0xc4, 0x00, 0x2a}, err: UnsupportedKeyTypeError},
+	{encoded: []byte{0x82, 0xa1, 0x30, 0x2a, 0xa1, 0x30, 0x2b}, err: DuplicateKeyError},
+}
+
+var stringKeyedMapsNonDefaultOptsUnmarshalTestCases = []unmarshalTestCase{
+	{encoded: []byte{0x81, 0xc4, 0x00, 0x2a}, decoded: map[string]any{}},
+	{encoded: []byte{0x82, 0xa1, 0x30, 0x2a, 0xc4, 0x00, 0x2a}, decoded: map[string]any{"0": int(42)}},
+	{encoded: []byte{0x82, 0xa1, 0x30, 0x2a, 0xa1, 0x30, 0x2b}, decoded: map[string]any{"0": int(42)}},
+}
+
+func TestUnmarshalExtension(t *testing.T) {
+	testCases := []struct {
+		data            []byte
+		expectedExtType int8
+		expectedPayload []byte
+		expectedN       int
+		expectedErr     error
+	}{
+		// fixext 1: 11010100: 0xd4
+		{[]byte{0xd4, 0x0c, 0x68}, 12, []byte{0x68}, 3, nil},
+		// fixext 4: 11010110: 0xd6 (timestamp, not converted)
+		{[]byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x00, 0x99}, -1, []byte{0x00, 0x00, 0x00, 0x00}, 6, nil},
+		// fixext 16: 11011000: 0xd8
+		{append([]byte{0xd8, 0x0c}, fillerBytes(16)...), 12, fillerBytes(16), 18, nil},
+		// ext 8: 11000111: 0xc7
+		{[]byte{0xc7, 0x00, 0x0c}, 12, []byte{}, 3, nil},
+		{append([]byte{0xc7, 0x03, 0x80}, 1, 2, 3), -128, []byte{1, 2, 3}, 6, nil},
+		// ext 16: 11001000: 0xc8
+		{append([]byte{0xc8, 0x01, 0x00, 0x0c}, fillerBytes(256)...), 12, fillerBytes(256), 260, nil},
+		// ext 32: 11001001: 0xc9
+		{append([]byte{0xc9, 0x00, 0x01, 0x00, 0x00, 0x0c}, fillerBytes(65536)...), 12, fillerBytes(65536), 65542, nil},
+		// Errors.
+		{nil, 0, nil, 0, io.EOF},
+		{[]byte{0xd4, 0x0c}, 0, nil, 0, io.ErrUnexpectedEOF},
+		{[]byte{0xc7}, 0, nil, 0, io.ErrUnexpectedEOF},
+		{[]byte{0xc0}, 0, nil, 0, InvalidFormatError},
+		{[]byte{0xc4, 0x00}, 0, nil, 0, InvalidFormatError},
+		{[]byte{0x01}, 0, nil, 0, InvalidFormatError},
+	}
+	for _, tc := range testCases {
+		extType, payload, n, err := UnmarshalExtension(tc.data)
+		if !errors.Is(err, tc.expectedErr) || extType != tc.expectedExtType || !bytes.Equal(payload, tc.expectedPayload) || n != tc.expectedN {
+			t.Errorf("Unexpected result for data=%v: %v, %v, %v, %v", tc.data, extType, payload, n, err)
+		}
+	}
+}
+
+func TestUnmarshal_stringKeyedMaps(t *testing.T) {
+	opts := &UnmarshalOptions{StringKeyedMaps: true}
+	testUnmarshal(t, opts, stringKeyedMapsUnmarshalTestCases)
+	testUnmarshal(t, opts, stringKeyedMapsDefaultOptsUnmarshalTestCases)
+
+	opts = &UnmarshalOptions{
+		StringKeyedMaps:                true,
+		DisableDuplicateKeyError:       true,
+		DisableUnsupportedKeyTypeError: true,
+	}
+	testUnmarshal(t, opts, stringKeyedMapsUnmarshalTestCases)
+	testUnmarshal(t, opts, stringKeyedMapsNonDefaultOptsUnmarshalTestCases)
+}
+
+func TestUnmarshal_orderedMaps(t *testing.T) {
+	testUnmarshal(t, &UnmarshalOptions{OrderedMaps: true, StringKeyedMaps: true}, []unmarshalTestCase{
+		{encoded: []byte{0x80}, decoded: &OrderedMap{Keys: []any{}, Values: map[any]any{}}},
+		{
+			encoded: []byte{0x83, 0xa1, 0x62, 0x01, 0x03, 0x02, 0xa1, 0x61, 0x03},
+			decoded: &OrderedMap{Keys: []any{"b", int(3), "a"}, Values: map[any]any{"b": int(1), int(3): int(2), "a": int(3)}},
+		},
+		// Nested (in both maps and arrays).
+		{
+			encoded: []byte{0x92, 0x81, 0x02, 0x81, 0x01, 0xc0, 0x80},
+			decoded: []any{
+				&OrderedMap{Keys: []any{int(2)}, Values: map[any]any{int(2): &OrderedMap{Keys: []any{int(1)}, Values: map[any]any{int(1): nil}}}},
+				&OrderedMap{Keys: []any{}, Values: map[any]any{}},
+			},
+		},
+		// Maps are not supported as keys.
+		{encoded: []byte{0x81, 0x80, 0x01}, err: UnsupportedKeyTypeError},
+		{encoded: []byte{0x82, 0x01, 0x02, 0x01, 0x03}, err: DuplicateKeyError},
+	})
+
+	opts := &UnmarshalOptions{OrderedMaps: true, DisableDuplicateKeyError: true, DisableUnsupportedKeyTypeError: true}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		// Dropped key-value pairs don't appear.
+		{encoded: []byte{0x83, 0x80, 0x01, 0x02, 0x03, 0xc4, 0x00, 0x04}, decoded: &OrderedMap{Keys: []any{int(2)}, Values: map[any]any{int(2): int(3)}}},
+		{encoded: []byte{0x83, 0x02, 0x03, 0x01, 0x04, 0x02, 0x05}, decoded: &OrderedMap{Keys: []any{int(2), int(1)}, Values: map[any]any{int(2): int(3), int(1): int(4)}}},
+	})
+
+	if obj, err := UnmarshalBytes(opts, []byte{0x82, 0xa1, 0x62, 0x01, 0xa1, 0x61, 0x02}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if om := obj.(*OrderedMap); om.Len() != 2 {
+		t.Errorf("unexpected length: %v", om.Len())
+	} else if value, ok := om.Get("a"); !ok || value != int(2) {
+		t.Errorf("unexpected result for Get: %v, %v", value, ok)
+	} else if value, ok := om.Get("c"); ok {
+		t.Errorf("unexpected result for Get: %v, %v", value, ok)
+	}
+}
+
+func TestUnmarshal_inferMapValueTypes(t *testing.T) {
+	opts := &UnmarshalOptions{StringKeyedMaps: true, InferMapValueTypes: true}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		// Homogeneous.
+		{encoded: []byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0xff}, decoded: map[string]int{"a": 1, "b": -1}},
+		{encoded: []byte{0x82, 0xa1, 0x61, 0xcc, 0x01, 0xa1, 0x62, 0xcc, 0x02}, decoded: map[string]uint{"a": 1, "b": 2}},
+		{encoded: []byte{0x81, 0xa1, 0x61, 0xc3}, decoded: map[string]bool{"a": true}},
+		{encoded: []byte{0x81, 0xa1, 0x61, 0xca, 0x3f, 0xc0, 0x00, 0x00}, decoded: map[string]float32{"a": 1.5}},
+		{encoded: []byte{0x81, 0xa1, 0x61, 0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, decoded: map[string]float64{"a": 1.5}},
+		{encoded: []byte{0x81, 0xa1, 0x61, 0xa1, 0x78}, decoded: map[string]string{"a": "x"}},
+		{encoded: []byte{0x91, 0x81, 0xa1, 0x61, 0x01}, decoded: []any{map[string]int{"a": 1}}},
+		// Heterogeneous.
+		{encoded: []byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0xa1, 0x78}, decoded: map[string]any{"a": 1, "b": "x"}},
+		{encoded: []byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0xcc, 0x01}, decoded: map[string]any{"a": 1, "b": uint(1)}},
+		{encoded: []byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0xc0}, decoded: map[string]any{"a": 1, "b": nil}},
+		// Non-scalar.
+		{encoded: []byte{0x81, 0xa1, 0x61, 0x90}, decoded: map[string]any{"a": []any{}}},
+		{encoded: []byte{0x81, 0xa1, 0x61, 0xc4, 0x00}, decoded: map[string]any{"a": []byte{}}},
+		{encoded: []byte{0x81, 0xa1, 0x61, 0x81, 0xa1, 0x62, 0x01}, decoded: map[string]any{"a": map[string]int{"b": 1}}},
+		// Empty.
+		{encoded: []byte{0x80}, decoded: map[string]any{}},
+		// Non-string keys.
+		{encoded: []byte{0x81, 0x01, 0x01}, decoded: map[any]any{1: 1}},
+	})
+
+	// No effect without StringKeyedMaps.
+	opts = &UnmarshalOptions{InferMapValueTypes: true}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0xff}, decoded: map[any]any{"a": 1, "b": -1}},
+	})
+}
+
+// truncatedContainerUnmarshalTestCases generates unmarshalTestCases for arrays and maps (in all
+// formats) with declared length n but with fewer elements (or key-value pairs) actually present,
+// all of which should yield io.ErrUnexpectedEOF.
+func truncatedContainerUnmarshalTestCases(n int) []unmarshalTestCase {
+	prefixes := [][]byte{
+		// fixarray, array 16, array 32:
+		{0x90 | byte(n)},
+		{0xdc, byte(n >> 8), byte(n)},
+		{0xdd, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)},
+		// fixmap, map 16, map 32:
+		{0x80 | byte(n)},
+		{0xde, byte(n >> 8), byte(n)},
+		{0xdf, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)},
+	}
+
+	var rv []unmarshalTestCase
+	for i, prefix := range prefixes {
+		isMap := i >= 3
+		for k := 0; k < n; k += 1 {
+			var data []byte
+			if isMap {
+				data = genMapData(k)
+			} else {
+				data = genArrayData(k)
+			}
+			encoded := append(append([]byte{}, prefix...), data...)
+			rv = append(rv, unmarshalTestCase{encoded: encoded, err: io.ErrUnexpectedEOF})
+			if isMap {
+				// Also with a key but no value (using a key not in genMapData).
+				rv = append(rv, unmarshalTestCase{encoded: append(encoded, 0xa1, 0x21), err: io.ErrUnexpectedEOF})
+			} else {
+				// Also with a truncated element.
+				rv = append(rv, unmarshalTestCase{encoded: append(encoded, 0xa2, 0x21), err: io.ErrUnexpectedEOF})
+			}
+		}
+	}
+	return rv
+}
+
+func TestUnmarshal_wideIntegers(t *testing.T) {
+	encodedInts := [][]byte{
+		{0x05},                         // positive fixint
+		{0xff},                         // negative fixint
+		{0xd0, 0x80},                   // int 8
+		{0xd1, 0x80, 0x00},             // int 16
+		{0xd2, 0x80, 0x00, 0x00, 0x00}, // int 32
+		{0xd3, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, // int 64
+	}
+	decodedInts := []int64{5, -1, math.MinInt8, math.MinInt16, math.MinInt32, math.MinInt64}
+	encodedUints := [][]byte{
+		{0xcc, 0xff},                   // uint 8
+		{0xcd, 0xff, 0xff},             // uint 16
+		{0xce, 0xff, 0xff, 0xff, 0xff}, // uint 32
+		{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, // uint 64
+	}
+	decodedUints := []uint64{math.MaxUint8, math.MaxUint16, math.MaxUint32, math.MaxUint64}
+
+	var narrowTestCases, wideTestCases []unmarshalTestCase
+	for i, encoded := range encodedInts {
+		narrowTestCases = append(narrowTestCases, unmarshalTestCase{encoded: encoded, decoded: int(decodedInts[i])})
+		wideTestCases = append(wideTestCases, unmarshalTestCase{encoded: encoded, decoded: decodedInts[i]})
+	}
+	for i, encoded := range encodedUints {
+		narrowTestCases = append(narrowTestCases, unmarshalTestCase{encoded: encoded, decoded: uint(decodedUints[i])})
+		wideTestCases = append(wideTestCases, unmarshalTestCase{encoded: encoded, decoded: decodedUints[i]})
+	}
+	testUnmarshal(t, nil, narrowTestCases)
+	testUnmarshal(t, &UnmarshalOptions{WideIntegers: true}, wideTestCases)
+
+	// Also in containers, and with InferMapValueTypes.
+	opts := &UnmarshalOptions{WideIntegers: true, StringKeyedMaps: true, InferMapValueTypes: true}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0x92, 0x01, 0xcc, 0x02}, decoded: []any{int64(1), uint64(2)}},
+		{encoded: []byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0xff}, decoded: map[string]int64{"a": 1, "b": -1}},
+		{encoded: []byte{0x81, 0xa1, 0x61, 0xcc, 0x01}, decoded: map[string]uint64{"a": 1}},
+		{encoded: []byte{0x81, 0x01, 0x02}, decoded: map[any]any{int64(1): int64(2)}},
+	})
+}
+
+func TestUnmarshal_normalizeUintToInt(t *testing.T) {
+	// 0x7fffffffffffffff and 0x8000000000000000, as uint 64.
+	encodedMaxInt64 := []byte{0xcf, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	encodedMaxInt64Plus1 := []byte{0xcf, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	opts := &UnmarshalOptions{NormalizeUintToInt: true}
+	testCases := []unmarshalTestCase{
+		{encoded: []byte{0xcc, 0x00}, decoded: 0},
+		{encoded: []byte{0xcc, 0xff}, decoded: 255},
+		{encoded: []byte{0xcd, 0xff, 0xff}, decoded: math.MaxUint16},
+		{encoded: []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, decoded: uint(math.MaxUint64)},
+		// Signed formats are unaffected.
+		{encoded: []byte{0xd0, 0x80}, decoded: math.MinInt8},
+		{encoded: []byte{0x92, 0xcc, 0x01, 0x01}, decoded: []any{1, 1}},
+	}
+	if math.MaxInt == math.MaxInt64 {
+		testCases = append(testCases,
+			unmarshalTestCase{encoded: encodedMaxInt64, decoded: math.MaxInt},
+			unmarshalTestCase{encoded: encodedMaxInt64Plus1, decoded: uint(math.MaxInt) + 1})
+	} else {
+		testCases = append(testCases,
+			unmarshalTestCase{encoded: []byte{0xce, 0x7f, 0xff, 0xff, 0xff}, decoded: math.MaxInt},
+			unmarshalTestCase{encoded: []byte{0xce, 0x80, 0x00, 0x00, 0x00}, decoded: uint(math.MaxInt) + 1})
+	}
+	testUnmarshal(t, opts, testCases)
+
+	// Without the option.
+	testUnmarshal(t, nil, []unmarshalTestCase{
+		{encoded: []byte{0xcc, 0xff}, decoded: uint(255)},
+		{encoded: encodedMaxInt64, decoded: uint(math.MaxInt64)},
+	})
+
+	// With WideIntegers.
+	opts = &UnmarshalOptions{NormalizeUintToInt: true, WideIntegers: true}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0xcc, 0xff}, decoded: int64(255)},
+		{encoded: encodedMaxInt64, decoded: int64(math.MaxInt64)},
+		{encoded: encodedMaxInt64Plus1, decoded: uint64(math.MaxInt64) + 1},
+	})
+
+	// Duplicate keys are detected across formats, since they're normalized.
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0x82, 0x01, 0xc0, 0xcc, 0x01, 0xc0}, err: DuplicateKeyError},
+	})
+}
+
+func TestUnmarshal_duplicateKeyByEncoding(t *testing.T) {
+	// By default, int and uint keys with the same value are distinct.
+	testUnmarshal(t, &UnmarshalOptions{}, []unmarshalTestCase{
+		{encoded: []byte{0x82, 0x0c, 0x01, 0xcc, 0x0c, 0x02}, decoded: map[any]any{12: 1, uint(12): 2}},
+	})
+
+	opts := &UnmarshalOptions{DuplicateKeyByEncoding: true}
+	testUnmarshal(t, opts, commonUnmarshalTestCases)
+	testUnmarshal(t, opts, defaultOptsUnmarshalTestCases)
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		// int and uint formats:
+		{encoded: []byte{0x82, 0x0c, 0x01, 0xcc, 0x0c, 0x02}, err: DuplicateKeyError},
+		{encoded: []byte{0x82, 0xcd, 0x00, 0x0c, 0x01, 0xd0, 0x0c, 0x02}, err: DuplicateKeyError},
+		{encoded: []byte{0x82, 0xcf, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x01, 0xd3, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x02}, err: DuplicateKeyError},
+		// NaNs (with the same encoding):
+		{encoded: []byte{0x82, 0xcb, 0x7f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0xcb, 0x7f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02}, err: DuplicateKeyError},
+		// Not duplicates:
+		{encoded: []byte{0x82, 0x0c, 0x01, 0xcc, 0x0d, 0x02}, decoded: map[any]any{12: 1, uint(13): 2}},
+		{encoded: []byte{0x82, 0x0c, 0x01, 0xcb, 0x40, 0x28, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02}, decoded: map[any]any{12: 1, float64(12): 2}},
+		{encoded: []byte{0x82, 0xa1, 0x61, 0x01, 0xc4, 0x01, 0x61, 0x02}, err: UnsupportedKeyTypeError},
+	})
+
+	// The first key-value pair wins.
+	opts = &UnmarshalOptions{DuplicateKeyByEncoding: true, DisableDuplicateKeyError: true}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0x82, 0x0c, 0x01, 0xcc, 0x0c, 0x02}, decoded: map[any]any{12: 1}},
+		{encoded: []byte{0x82, 0xcc, 0x0c, 0x01, 0x0c, 0x02}, decoded: map[any]any{uint(12): 1}},
+	})
+
+	// Also with OrderedMaps.
+	opts = &UnmarshalOptions{DuplicateKeyByEncoding: true, OrderedMaps: true}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0x82, 0x0c, 0x01, 0xcc, 0x0c, 0x02}, err: DuplicateKeyError},
+	})
+}
+
+func TestUnmarshal_unsafeStrings(t *testing.T) {
+	opts := &UnmarshalOptions{UnsafeStrings: true}
+	testUnmarshal(t, opts, commonUnmarshalTestCases)
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0x82, 0xa1, 0x61, 0xa1, 0x62, 0xa1, 0x62, 0xa1, 0x61}, decoded: map[any]any{"a": "b", "b": "a"}},
+		{encoded: []byte{0x82, 0xa1, 0x61, 0xc0, 0xa1, 0x61, 0xc0}, err: DuplicateKeyError},
+	})
+
+	// The input data must not be aliased.
+	encoded := []byte{0xa3, 0x61, 0x62, 0x63}
+	if decoded, err := UnmarshalBytes(opts, encoded); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else {
+		encoded[1] = 0x78
+		if decoded != "abc" {
+			t.Errorf("unexpected result: %#v", decoded)
+		}
+	}
+}
+
+func TestUnmarshal_emptyBinAsNil(t *testing.T) {
+	testCases := []unmarshalTestCase{
+		{encoded: []byte{0xc4, 0x01, 0x2a}, decoded: []byte{0x2a}},
+		{encoded: []byte{0xa0}, decoded: ""},
+	}
+
+	// By default, empty binary data is a non-nil []byte.
+	testUnmarshal(t, nil, append([]unmarshalTestCase{
+		{encoded: []byte{0xc4, 0x00}, decoded: []byte{}},
+		{encoded: []byte{0xc5, 0x00, 0x00}, decoded: []byte{}},
+		{encoded: []byte{0x91, 0xc4, 0x00}, decoded: []any{[]byte{}}},
+	}, testCases...))
+	if decoded, err := UnmarshalBytes(nil, []byte{0xc4, 0x00}); err != nil || decoded.([]byte) == nil {
+		t.Errorf("unexpected result: %#v, %v", decoded, err)
+	}
+
+	opts := &UnmarshalOptions{EmptyBinAsNil: true}
+	testUnmarshal(t, opts, append([]unmarshalTestCase{
+		{encoded: []byte{0xc4, 0x00}, decoded: []byte(nil)},
+		{encoded: []byte{0xc5, 0x00, 0x00}, decoded: []byte(nil)},
+		{encoded: []byte{0x91, 0xc4, 0x00}, decoded: []any{[]byte(nil)}},
+	}, testCases...))
+}
+
+func TestUnmarshal_timestampConversion(t *testing.T) {
+	opts := &UnmarshalOptions{TimestampLocation: time.UTC}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0xd6, 0xff, 0x12, 0x34, 0x56, 0x78}, decoded: time.Unix(0x12345678, 0).UTC()},
+		{encoded: []byte{0xd7, 0xff, 0x1d, 0x6f, 0x34, 0x56, 0x34, 0x56, 0x78, 0x9a}, decoded: time.Unix(0x23456789a, 123456789).UTC()},
+		{encoded: []byte{0x81, 0xd6, 0xff, 0x12, 0x34, 0x56, 0x78, 0x2a}, decoded: map[any]any{time.Unix(0x12345678, 0).UTC(): int(42)}},
+		{encoded: []byte{0xd7, 0xff, 0xee, 0x6b, 0x28, 0x00, 0x00, 0x00, 0x00, 0x00}, err: InvalidTimestampError},
+	})
+
+	loc := time.FixedZone("test", -5*60*60)
+	opts = &UnmarshalOptions{TimestampLocation: loc}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0xd6, 0xff, 0x12, 0x34, 0x56, 0x78}, decoded: time.Unix(0x12345678, 0).In(loc)},
+	})
+
+	opts = &UnmarshalOptions{TimestampAsUnixNano: true, TimestampLocation: loc}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x00}, decoded: int64(0)},
+		{encoded: []byte{0xd6, 0xff, 0x12, 0x34, 0x56, 0x78}, decoded: int64(0x12345678) * 1_000_000_000},
+		{encoded: []byte{0xd7, 0xff, 0x1d, 0x6f, 0x34, 0x54, 0x00, 0x00, 0x00, 0x01}, decoded: int64(1_123_456_789)},
+		{encoded: []byte{0x81, 0xd6, 0xff, 0x00, 0x00, 0x00, 0x01, 0x2a}, decoded: map[any]any{int64(1_000_000_000): int(42)}},
+		// The limits of the range (about 1678 to 2262).
+		{encoded: []byte{0xc7, 0x0c, 0xff, 0x32, 0xf2, 0xd7, 0xff, 0x00, 0x00, 0x00, 0x02, 0x25, 0xc1, 0x7d, 0x04}, decoded: int64(math.MaxInt64)},
+		{encoded: []byte{0xc7, 0x0c, 0xff, 0x32, 0xf2, 0xd8, 0x00, 0x00, 0x00, 0x00, 0x02, 0x25, 0xc1, 0x7d, 0x04}, err: TimestampOutOfRangeError},
+		{encoded: []byte{0xc7, 0x0c, 0xff, 0x08, 0xa7, 0xf2, 0x00, 0xff, 0xff, 0xff, 0xfd, 0xda, 0x3e, 0x82, 0xfb}, decoded: int64(math.MinInt64)},
+		{encoded: []byte{0xc7, 0x0c, 0xff, 0x08, 0xa7, 0xf1, 0xff, 0xff, 0xff, 0xff, 0xfd, 0xda, 0x3e, 0x82, 0xfb}, err: TimestampOutOfRangeError},
+		// Seconds 10^12 (in year 33658).
+		{encoded: []byte{0xc7, 0x0c, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xe8, 0xd4, 0xa5, 0x10, 0x00}, err: TimestampOutOfRangeError},
+		// Other objects aren't affected.
+		{encoded: []byte{0x01}, decoded: int(1)},
+	})
+
+	// No effect if the standard unmarshal transformer is disabled.
+	opts = &UnmarshalOptions{TimestampAsUnixNano: true, DisableStandardUnmarshalTransformer: true}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x00}, decoded: &UnresolvedExtensionType{ExtensionType: -1, Data: []byte{0x00, 0x00, 0x00, 0x00}}},
+	})
+}
+
+func TestUnmarshal_disableTimestampDecoding(t *testing.T) {
+	opts := &UnmarshalOptions{DisableTimestampDecoding: true, TimestampAsUnixNano: true}
+	testUnmarshal(t, opts, commonUnmarshalTestCases)
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0xd6, 0xff, 0x12, 0x34, 0x56, 0x78}, decoded: &UnresolvedExtensionType{ExtensionType: -1, Data: []byte{0x12, 0x34, 0x56, 0x78}}},
+		{encoded: []byte{0x91, 0xd7, 0xff, 0x1d, 0x6f, 0x34, 0x56, 0x34, 0x56, 0x78, 0x9a}, decoded: []any{&UnresolvedExtensionType{ExtensionType: -1, Data: []byte{0x1d, 0x6f, 0x34, 0x56, 0x34, 0x56, 0x78, 0x9a}}}},
+		// Invalid timestamps aren't checked.
+		{encoded: []byte{0xd4, 0xff, 0x00}, decoded: &UnresolvedExtensionType{ExtensionType: -1, Data: []byte{0x00}}},
+		// Unresolved, so not supported as map keys.
+		{encoded: []byte{0x81, 0xd6, 0xff, 0x12, 0x34, 0x56, 0x78, 0x2a}, err: UnsupportedKeyTypeError},
+	})
+
+	// Other extension types handled by the standard unmarshal transformer are unaffected.
+	savedStandardUnmarshalTransformer := StandardUnmarshalTransformer
+	defer func() { StandardUnmarshalTransformer = savedStandardUnmarshalTransformer }()
+	StandardUnmarshalTransformer = MakeExtensionTypeUnmarshalTransformer(
+		map[int8]UnmarshalExtensionTypeFn{
+			-1:                    UnmarshalTimestampExtensionType,
+			DurationExtensionType: UnmarshalDurationExtensionType,
+		},
+	)
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0xd7, 0x2a, 0, 0, 0, 0, 0, 0, 0, 0x7b}, decoded: time.Duration(123)},
+		{encoded: []byte{0xd6, 0xff, 0x12, 0x34, 0x56, 0x78}, decoded: &UnresolvedExtensionType{ExtensionType: -1, Data: []byte{0x12, 0x34, 0x56, 0x78}}},
+	})
+}
+
+func TestUnmarshal_unknownExtensionFn(t *testing.T) {
+	var seen []int8
+	opts := &UnmarshalOptions{
+		ApplicationUnmarshalTransformer: DurationExtensionUnmarshalTransformer,
+		UnknownExtensionFn: func(extType int8, data []byte) (any, bool, error) {
+			seen = append(seen, extType)
+			switch extType {
+			case 1:
+				return string(data), true, nil
+			case 2:
+				return nil, false, testError
+			default:
+				return &UnresolvedExtensionType{ExtensionType: extType, Data: data}, false, nil
+			}
+		},
+	}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0xd5, 0x01, 0x68, 0x69}, decoded: "hi"},
+		{encoded: []byte{0x81, 0xd5, 0x01, 0x68, 0x69, 0xc0}, decoded: map[any]any{"hi": nil}},
+		{encoded: []byte{0xd4, 0x02, 0x00}, err: testError},
+		{encoded: []byte{0x91, 0xd4, 0x02, 0x00}, err: testError},
+		{encoded: []byte{0xd4, 0x03, 0x00}, decoded: &UnresolvedExtensionType{ExtensionType: 3, Data: []byte{0x00}}},
+	})
+
+	// Not called for extensions handled by transformers.
+	seen = nil
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x00}, decoded: time.Unix(0, 0)},
+		{encoded: []byte{0xd7, 0x2a, 0, 0, 0, 0, 0, 0, 0, 0x7b}, decoded: time.Duration(123)},
+		{encoded: []byte{0x01}, decoded: int(1)},
+	})
+	if len(seen) != 0 {
+		t.Errorf("unexpected calls: %v", seen)
+	}
+}
+
+func TestUnmarshal_extensionTypeFns(t *testing.T) {
+	allBorrowed := true
+	opts := &UnmarshalOptions{
+		ExtensionTypeFns: map[int8]UnmarshalBorrowedExtensionTypeFn{
+			// Keeps (a copy of) the data.
+			1: func(data []byte, borrowed bool) (any, bool, error) {
+				allBorrowed = allBorrowed && borrowed
+				return &testExtensionType{data: CopyIfBorrowed(data, borrowed)}, false, nil
+			},
+			2: func(data []byte, borrowed bool) (any, bool, error) {
+				return nil, false, testError
+			},
+			// Overrides the standard timestamp extension.
+			-1: func(data []byte, borrowed bool) (any, bool, error) {
+				return len(data), true, nil
+			},
+		},
+		UnknownExtensionFn: func(extType int8, data []byte) (any, bool, error) {
+			return nil, false, testError
+		},
+	}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0xd5, 0x01, 0x68, 0x69}, decoded: &testExtensionType{data: []byte("hi")}},
+		{encoded: []byte{0x92, 0xd5, 0x01, 0x68, 0x69, 0xc7, 0x03, 0x01, 0x61, 0x62, 0x63}, decoded: []any{&testExtensionType{data: []byte("hi")}, &testExtensionType{data: []byte("abc")}}},
+		{encoded: []byte{0xd4, 0x02, 0x00}, err: testError},
+		{encoded: []byte{0x81, 0xd6, 0xff, 0x00, 0x00, 0x00, 0x00, 0x2a}, decoded: map[any]any{4: int(42)}},
+		{encoded: []byte{0xd4, 0x03, 0x00}, err: testError},
+		// Truncated.
+		{encoded: []byte{0xd5, 0x01, 0x68}, err: io.ErrUnexpectedEOF},
+	})
+	if !allBorrowed {
+		t.Errorf("data not borrowed")
+	}
+}
+
+func TestCopyIfBorrowed(t *testing.T) {
+	data := []byte{1, 2, 3}
+	if rv := CopyIfBorrowed(data, false); &rv[0] != &data[0] {
+		t.Errorf("unexpected copy")
+	}
+	if rv := CopyIfBorrowed(data, true); &rv[0] == &data[0] || !bytes.Equal(rv, data) {
+		t.Errorf("unexpected result: %v", rv)
+	}
+}
+
+func TestUnmarshal_maxEntries(t *testing.T) {
+	opts := &UnmarshalOptions{MaxMapEntries: 2, MaxArrayEntries: 3}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		// Within the limits.
+		{encoded: []byte{0x82, 0x01, 0x02, 0x03, 0x04}, decoded: map[any]any{1: 2, 3: 4}},
+		{encoded: []byte{0x93, 0x01, 0x02, 0x03}, decoded: []any{1, 2, 3}},
+		{encoded: []byte{0x93, 0x82, 0x01, 0x02, 0x03, 0x04, 0x92, 0x05, 0x06, 0x07}, decoded: []any{map[any]any{1: 2, 3: 4}, []any{5, 6}, 7}},
+		// Exceeding the limits (the entries aren't even present).
+		{encoded: []byte{0x83}, err: TooManyEntriesError},
+		{encoded: []byte{0x94}, err: TooManyEntriesError},
+		{encoded: []byte{0xdf, 0xff, 0xff, 0xff, 0xff}, err: TooManyEntriesError},
+		{encoded: []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, err: TooManyEntriesError},
+		// Nested.
+		{encoded: []byte{0x91, 0x83}, err: TooManyEntriesError},
+		{encoded: []byte{0x81, 0x01, 0x94}, err: TooManyEntriesError},
+	})
+
+	// Only maps are limited.
+	opts = &UnmarshalOptions{MaxMapEntries: 1}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0x82, 0x01, 0x02, 0x03, 0x04}, err: TooManyEntriesError},
+		{encoded: []byte{0x92, 0x01, 0x02}, decoded: []any{1, 2}},
+	})
+}
+
+func TestUnmarshal_maxDepth(t *testing.T) {
+	opts := &UnmarshalOptions{MaxDepth: 2}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		// Within the limit.
+		{encoded: []byte{0x01}, decoded: 1},
+		{encoded: []byte{0x91, 0x01}, decoded: []any{1}},
+		{encoded: []byte{0x92, 0x90, 0x80}, decoded: []any{[]any{}, map[any]any{}}},
+		{encoded: []byte{0x81, 0x01, 0x02}, decoded: map[any]any{1: 2}},
+		// Exceeding the limit.
+		{encoded: []byte{0x91, 0x91, 0x01}, err: TooDeeplyNestedError},
+		{encoded: []byte{0x91, 0x91, 0x90}, err: TooDeeplyNestedError},
+		{encoded: []byte{0x81, 0x01, 0x91, 0x02}, err: TooDeeplyNestedError},
+		{encoded: []byte{0x81, 0x91, 0x01, 0x02}, err: TooDeeplyNestedError},
+	})
+}
+
+func TestUnmarshal_maxExtensionBytes(t *testing.T) {
+	opts := &UnmarshalOptions{MaxExtensionBytes: 4}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		// Within the limit.
+		{encoded: []byte{0xd4, 0x0c, 0x01}, decoded: &UnresolvedExtensionType{ExtensionType: 12, Data: []byte{0x01}}},
+		{encoded: []byte{0xc7, 0x03, 0x0c, 0x01, 0x02, 0x03}, decoded: &UnresolvedExtensionType{ExtensionType: 12, Data: []byte{0x01, 0x02, 0x03}}},
+		{encoded: []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x00}, decoded: time.Unix(0, 0)},
+		// Exceeding the limit (the data isn't even present).
+		{encoded: []byte{0xd7, 0x0c}, err: ExtensionTooLargeError},
+		{encoded: []byte{0xc7, 0x05}, err: ExtensionTooLargeError},
+		{encoded: []byte{0xc9, 0xff, 0xff, 0xff, 0xff}, err: ExtensionTooLargeError},
+		{encoded: []byte{0x91, 0xd8}, err: ExtensionTooLargeError},
+		// Other objects aren't limited.
+		{encoded: []byte{0xa5, 0x61, 0x62, 0x63, 0x64, 0x65}, decoded: "abcde"},
+		{encoded: []byte{0xc4, 0x05, 0x01, 0x02, 0x03, 0x04, 0x05}, decoded: []byte{0x01, 0x02, 0x03, 0x04, 0x05}},
+	})
+}
+
+func TestUnmarshal_maxStringAndBinaryBytes(t *testing.T) {
+	opts := &UnmarshalOptions{MaxStringBytes: 3, MaxBinaryBytes: 2}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		// Within the limits.
+		{encoded: []byte{0xa0}, decoded: ""},
+		{encoded: []byte{0xa3, 0x61, 0x62, 0x63}, decoded: "abc"},
+		{encoded: []byte{0xd9, 0x03, 0x61, 0x62, 0x63}, decoded: "abc"},
+		{encoded: []byte{0xc4, 0x02, 0x01, 0x02}, decoded: []byte{0x01, 0x02}},
+		// Exceeding the limits (the data isn't even present).
+		{encoded: []byte{0xa4}, err: StringTooLongError},
+		{encoded: []byte{0xd9, 0x04}, err: StringTooLongError},
+		{encoded: []byte{0xda, 0x01, 0x00}, err: StringTooLongError},
+		{encoded: []byte{0xdb, 0xff, 0xff, 0xff, 0xff}, err: StringTooLongError},
+		{encoded: []byte{0x81, 0xa4}, err: StringTooLongError},
+		{encoded: []byte{0xc4, 0x03}, err: BinaryTooLongError},
+		{encoded: []byte{0xc5, 0x01, 0x00}, err: BinaryTooLongError},
+		{encoded: []byte{0xc6, 0xff, 0xff, 0xff, 0xff}, err: BinaryTooLongError},
+		{encoded: []byte{0x91, 0xc4, 0x03}, err: BinaryTooLongError},
+		// The limits don't apply to extensions.
+		{encoded: []byte{0xd7, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}, decoded: &UnresolvedExtensionType{ExtensionType: 1, Data: make([]byte, 8)}},
+	})
+
+	// The limits are independent.
+	testUnmarshal(t, &UnmarshalOptions{MaxStringBytes: 1}, []unmarshalTestCase{
+		{encoded: []byte{0xc4, 0x02, 0x01, 0x02}, decoded: []byte{0x01, 0x02}},
+	})
+	testUnmarshal(t, &UnmarshalOptions{MaxBinaryBytes: 1}, []unmarshalTestCase{
+		{encoded: []byte{0xa2, 0x61, 0x62}, decoded: "ab"},
+	})
+}
+
+func TestUnmarshal_truncatedContainers(t *testing.T) {
+	optss := []*UnmarshalOptions{
+		nil,
+		{DisableDuplicateKeyError: true, DisableUnsupportedKeyTypeError: true},
+		{StringKeyedMaps: true, InferMapValueTypes: true},
+		{RejectTrailingBytes: true},
+	}
+	for _, opts := range optss {
+		for _, n := range []int{1, 2, 15} {
+			testUnmarshal(t, opts, truncatedContainerUnmarshalTestCases(n))
+		}
+		// Nested containers.
+		testUnmarshal(t, opts, []unmarshalTestCase{
+			{encoded: []byte{0x92, 0x92, 0x01}, err: io.ErrUnexpectedEOF},
+			{encoded: []byte{0x92, 0x91, 0x01}, err: io.ErrUnexpectedEOF},
+			{encoded: []byte{0x81, 0xa1, 0x61, 0x81, 0xa1, 0x62}, err: io.ErrUnexpectedEOF},
+			{encoded: []byte{0x92, 0x81, 0xa1, 0x62, 0x90}, err: io.ErrUnexpectedEOF},
+		})
+	}
+
+	// Huge declared lengths.
+	testUnmarshal(t, nil, []unmarshalTestCase{
+		{encoded: []byte{0xdd, 0xff, 0xff, 0xff, 0xff, 0x01}, err: io.ErrUnexpectedEOF},
+		{encoded: []byte{0xdf, 0xff, 0xff, 0xff, 0xff, 0x01, 0x01}, err: io.ErrUnexpectedEOF},
+	})
+}
+
+func TestUnmarshal_decodeError(t *testing.T) {
+	testCases := []struct {
+		opts    *UnmarshalOptions
+		encoded []byte
+		err     error
+		offset  int
+	}{
+		{nil, []byte{0xc1}, InvalidFormatError, 1},
+		{nil, []byte{0x93, 0x01, 0x02, 0xc1}, InvalidFormatError, 4},
+		{nil, []byte{0x93, 0x01, 0x02}, io.ErrUnexpectedEOF, 3},
+		{nil, []byte{0xa3, 0x61, 0x62}, io.ErrUnexpectedEOF, 1},
+		{nil, []byte{0xcd, 0x12}, io.ErrUnexpectedEOF, 1},
+		{nil, []byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x61, 0x02}, DuplicateKeyError, 7},
+		{nil, []byte{0x91, 0x81, 0x90, 0x01}, UnsupportedKeyTypeError, 4},
+		{&UnmarshalOptions{RejectTrailingBytes: true}, []byte{0x92, 0x01, 0x02, 0x03}, TrailingBytesError, 3},
+		{&UnmarshalOptions{MaxReaderBytes: 3}, []byte{0x92, 0x01, 0xcd, 0x12, 0x34}, MessageTooLargeError, 3},
+		{&UnmarshalOptions{RequireMinimalEncoding: true}, []byte{0x91, 0xd0, 0x01}, NonMinimalEncodingError, 3},
+	}
+	for i, tC := range testCases {
+		check := func(err error) {
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Errorf("%v: not a *DecodeError: %#v", i, err)
+			} else if !errors.Is(err, tC.err) || decodeErr.Err != tC.err || decodeErr.Offset != tC.offset {
+				t.Errorf("%v: unexpected error: %#v (expected: %v at %v)", i, decodeErr, tC.err, tC.offset)
+			}
+		}
+		_, err := Unmarshal(tC.opts, bytes.NewBuffer(tC.encoded))
+		check(err)
+		_, err = UnmarshalBytes(tC.opts, tC.encoded)
+		check(err)
+	}
+
+	// No data at all gives io.EOF, unwrapped.
+	if _, err := Unmarshal(nil, bytes.NewBuffer(nil)); err != io.EOF {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+	if _, err := UnmarshalBytes(nil, nil); err != io.EOF {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+
+	err := &DecodeError{Err: InvalidFormatError, Offset: 12}
+	if s := err.Error(); s != "Invalid format (at offset 12)" {
+		t.Errorf("Unexpected Error(): %q", s)
+	}
+}
+
+func TestUnmarshal_eofOnlyAtStart(t *testing.T) {
+	// io.EOF only if there's no data at all; otherwise io.ErrUnexpectedEOF, wherever the data
+	// runs out (including at the start of a nested object).
+	full := []byte{0x82, 0xa1, 0x61, 0x92, 0x01, 0xcd, 0x12, 0x34, 0xa1, 0x62, 0xc4, 0x01, 0x02}
+	if _, err := UnmarshalBytes(nil, full); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for n := 0; n < len(full); n += 1 {
+		expected := io.ErrUnexpectedEOF
+		if n == 0 {
+			expected = io.EOF
+		}
+		readers := []io.Reader{
+			bytes.NewBuffer(full[:n]),
+			iotest.OneByteReader(bytes.NewBuffer(full[:n])),
+			iotest.DataErrReader(bytes.NewBuffer(full[:n])),
+		}
+		for _, r := range readers {
+			if _, err := Unmarshal(nil, r); !errors.Is(err, expected) || (n == 0 && err != io.EOF) {
+				t.Errorf("Unexpected error for %v bytes (%T): %v", n, r, err)
+			}
+		}
+	}
+}
+
+// A *countingReader is an io.Reader that counts the number of bytes read from it.
+type countingReader struct {
+	r     io.Reader
+	count int
+}
+
+var _ io.Reader = (*countingReader)(nil)
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.count += n
+	return n, err
+}
+
+// maxReadSizeReader wraps an io.Reader (hiding any other methods), recording the maximum size of
+// the buffers passed to Read.
+type maxReadSizeReader struct {
+	r   io.Reader
+	max int
+}
+
+func (r *maxReadSizeReader) Read(p []byte) (int, error) {
+	r.max = max(r.max, len(p))
+	return r.r.Read(p)
+}
+
+func TestUnmarshal_readChunkSize(t *testing.T) {
+	for _, readChunkSize := range []int{7, 1 << 20} {
+		testUnmarshal(t, &UnmarshalOptions{ReadChunkSize: readChunkSize}, commonUnmarshalTestCases)
+	}
+
+	encoded := append([]byte{0xc5, 0x27, 0x10}, fillerBytes(10000)...)
+	for _, tC := range []struct {
+		readChunkSize int
+		maxRead       int
+	}{
+		{readChunkSize: 0, maxRead: 4096},
+		{readChunkSize: 100, maxRead: 100},
+		{readChunkSize: 20000, maxRead: 10000},
+	} {
+		opts := &UnmarshalOptions{ReadChunkSize: tC.readChunkSize}
+		r := &maxReadSizeReader{r: bytes.NewReader(encoded)}
+		if decoded, err := Unmarshal(opts, r); err != nil || !bytes.Equal(decoded.([]byte), encoded[3:]) {
+			t.Errorf("unexpected result for readChunkSize=%v: %v", tC.readChunkSize, err)
+		} else if r.max != tC.maxRead {
+			t.Errorf("unexpected max read size for readChunkSize=%v: %v", tC.readChunkSize, r.max)
+		}
+
+		r = &maxReadSizeReader{r: bytes.NewReader(encoded)}
+		if err := Skip(opts, r); err != nil {
+			t.Errorf("unexpected error for readChunkSize=%v: %v", tC.readChunkSize, err)
+		} else if r.max != tC.maxRead {
+			t.Errorf("unexpected max read size for readChunkSize=%v (skip): %v", tC.readChunkSize, r.max)
+		}
+	}
+
+	opts := &UnmarshalOptions{ReadChunkSize: -1}
+	if _, err := Unmarshal(opts, bytes.NewReader([]byte{0x01})); err != InvalidReadChunkSizeError {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := UnmarshalAll(opts, bytes.NewReader([]byte{0x01})); err != InvalidReadChunkSizeError {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := Skip(opts, bytes.NewReader([]byte{0x01})); err != InvalidReadChunkSizeError {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestUnmarshal_maxReaderBytes(t *testing.T) {
+	testCases := []struct {
+		encoded []byte
+		max     int
+		decoded any
+		err     error
+	}{
+		{encoded: []byte{0xc0}, max: 1, decoded: nil},
+		{encoded: []byte{0xa2, 0x68, 0x69}, max: 3, decoded: "hi"},
+		{encoded: []byte{0xa2, 0x68, 0x69}, max: 100, decoded: "hi"},
+		{encoded: []byte{0xa2, 0x68, 0x69}, max: 2, err: MessageTooLargeError},
+		{encoded: []byte{0xa2, 0x68, 0x69}, max: 1, err: MessageTooLargeError},
+		{encoded: []byte{0xcd, 0x12, 0x34}, max: 2, err: MessageTooLargeError},
+		{encoded: []byte{0xda, 0x00, 0x02, 0x68, 0x69}, max: 3, err: MessageTooLargeError},
+		{encoded: append([]byte{0x9f}, genArrayData(15)...), max: 1 + len(genArrayData(15)), decoded: genArray(15)},
+		{encoded: append([]byte{0x9f}, genArrayData(15)...), max: len(genArrayData(15)), err: MessageTooLargeError},
+		// Claims a huge string, which should fail without reading it.
+		{encoded: append([]byte{0xdb, 0x7f, 0xff, 0xff, 0xff}, fillerChars(100)...), max: 50, err: MessageTooLargeError},
+		// Errors from the reader still take effect.
+		{encoded: []byte{0xa2, 0x68}, max: 3, err: io.ErrUnexpectedEOF},
+	}
+	for i, tC := range testCases {
+		opts := &UnmarshalOptions{MaxReaderBytes: tC.max}
+
+		reader := &countingReader{r: bytes.NewBuffer(tC.encoded)}
+		if decoded, err := Unmarshal(opts, reader); !errors.Is(err, tC.err) {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if err == nil && !reflect.DeepEqual(decoded, tC.decoded) {
+			t.Errorf("%v: unexpected result: %#v", i, decoded)
+		}
+		if reader.count > tC.max {
+			t.Errorf("%v: read too much: %v", i, reader.count)
+		}
+
+		if decoded, err := UnmarshalBytes(opts, tC.encoded); !errors.Is(err, tC.err) {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if err == nil && !reflect.DeepEqual(decoded, tC.decoded) {
+			t.Errorf("%v: unexpected result: %#v", i, decoded)
+		}
+	}
+}
+
+func TestUnmarshalBytes_noAliasing(t *testing.T) {
+	// The decoder reads views into the buffer (e.g., for lengths and strings), but must copy data
+	// that's returned (binary and extension data).
+	encoded := []byte{
+		0x93,
+		0xa2, 0x68, 0x69, // "hi"
+		0xc4, 0x02, 0x01, 0x02, // bin 8
+		0xd5, 0x2a, 0x03, 0x04, // fixext 2, type 42
+	}
+	decoded, err := UnmarshalBytes(nil, encoded)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i := range encoded {
+		encoded[i] = 0xff
+	}
+
+	expected := []any{"hi", []byte{0x01, 0x02}, &UnresolvedExtensionType{ExtensionType: 42, Data: []byte{0x03, 0x04}}}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Unexpected result after modifying the input: %#v (expected: %#v)", decoded, expected)
+	}
+}
+
+// Tests that no first byte leads to an internal error (i.e., an "unreachable" state).
+func TestUnmarshal_allFirstBytes(t *testing.T) {
+	for b := 0; b <= 0xff; b += 1 {
+		for _, data := range [][]byte{{byte(b)}, append([]byte{byte(b)}, make([]byte, 16)...)} {
+			if obj, err := UnmarshalBytes(nil, data); errors.Is(err, InternalDecodeError) {
+				t.Errorf("Unexpected result for data=%v: %v, %v", data, obj, err)
+			}
+		}
+	}
+}
+
+func TestNormalizeStringMapUnmarshalTransformer(t *testing.T) {
+	opts := &UnmarshalOptions{ApplicationUnmarshalTransformer: NormalizeStringMapUnmarshalTransformer}
+	testUnmarshal(t, opts, []unmarshalTestCase{
+		{encoded: []byte{0x80}, decoded: map[string]any{}},
+		{encoded: []byte{0x81, 0xa1, 0x61, 0x01}, decoded: map[string]any{"a": 1}},
+		{encoded: []byte{0x82, 0xa1, 0x61, 0x01, 0x02, 0x03}, decoded: map[any]any{"a": 1, 2: 3}},
+		// Nested.
+		{encoded: []byte{0x81, 0xa1, 0x61, 0x81, 0xa1, 0x62, 0x01}, decoded: map[string]any{"a": map[string]any{"b": 1}}},
+		{encoded: []byte{0x81, 0x01, 0x91, 0x81, 0xa1, 0x62, 0x01}, decoded: map[any]any{1: []any{map[string]any{"b": 1}}}},
+		// Other objects are unaffected.
+		{encoded: []byte{0x92, 0x01, 0xa1, 0x61}, decoded: []any{1, "a"}},
+		{encoded: []byte{0xa1, 0x61}, decoded: "a"},
+	})
+
+	// Called directly, it recurses.
+	obj := []any{
+		map[any]any{"a": map[any]any{"b": []any{map[any]any{"c": 1}}}},
+		map[any]any{1: map[any]any{"d": 2}},
+	}
+	expected := []any{
+		map[string]any{"a": map[string]any{"b": []any{map[string]any{"c": 1}}}},
+		map[any]any{1: map[string]any{"d": 2}},
+	}
+	if actual, _, err := NormalizeStringMapUnmarshalTransformer(obj, false); err != nil || !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected result: %#v, %v", actual, err)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains support for an application extension type for time.Duration.
+
+package umsgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Errors ------------------------------------------------------------------------------------------
+
+// InvalidDurationError is the error returned by UnmarshalDurationExtensionType for an invalid
+// duration.
+var InvalidDurationError = errors.New("Invalid duration")
+
+// Duration extension ------------------------------------------------------------------------------
+
+// DurationExtensionType is the (application) extension type used by
+// DurationExtensionMarshalTransformer and DurationExtensionUnmarshalTransformer. Note that this is
+// not a standard MessagePack extension type; to use a different extension type, use
+// MakeDurationExtensionTransformers.
+const DurationExtensionType int8 = 42
+
+// DurationExtensionMarshalTransformer is a MarshalTransformerFn that transforms time.Duration to an
+// *UnresolvedExtensionType with extension type DurationExtensionType, whose data is the duration
+// in nanoseconds as a big-endian 64-bit (signed) integer.
+func DurationExtensionMarshalTransformer(obj any) (any, error) {
+	return marshalDurationExtension(DurationExtensionType, obj)
+}
+
+var _ MarshalTransformerFn = DurationExtensionMarshalTransformer
+
+// DurationExtensionUnmarshalTransformer is the UnmarshalTransformerFn corresponding to
+// DurationExtensionMarshalTransformer.
+var DurationExtensionUnmarshalTransformer UnmarshalTransformerFn = MakeExtensionTypeUnmarshalTransformer(
+	map[int8]UnmarshalExtensionTypeFn{
+		DurationExtensionType: UnmarshalDurationExtensionType,
+	},
+)
+
+// MakeDurationExtensionTransformers makes a MarshalTransformerFn and corresponding
+// UnmarshalTransformerFn like DurationExtensionMarshalTransformer and
+// DurationExtensionUnmarshalTransformer, respectively, but using the given extension type.
+func MakeDurationExtensionTransformers(extType int8) (MarshalTransformerFn, UnmarshalTransformerFn) {
+	marshalTransformer := func(obj any) (any, error) {
+		return marshalDurationExtension(extType, obj)
+	}
+	unmarshalTransformer := MakeExtensionTypeUnmarshalTransformer(
+		map[int8]UnmarshalExtensionTypeFn{
+			extType: UnmarshalDurationExtensionType,
+		},
+	)
+	return marshalTransformer, unmarshalTransformer
+}
+
+// marshalDurationExtension is a helper for the duration extension marshal transformers.
+func marshalDurationExtension(extType int8, obj any) (any, error) {
+	d, ok := obj.(time.Duration)
+	if !ok {
+		return obj, nil
+	}
+
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(d))
+	return &UnresolvedExtensionType{ExtensionType: extType, Data: data}, nil
+}
+
+// UnmarshalDurationExtensionType is an UnmarshalExtensionTypeFn that unmarshals the data for the
+// duration extension type (see DurationExtensionMarshalTransformer) to a time.Duration.
+func UnmarshalDurationExtensionType(data []byte) (any, bool, error) {
+	if len(data) != 8 {
+		return nil, false, InvalidDurationError
+	}
+	return time.Duration(binary.BigEndian.Uint64(data)), true, nil
+}
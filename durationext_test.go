@@ -0,0 +1,61 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests durationext.go.
+
+package umsgpack_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestDurationExtension(t *testing.T) {
+	mopts := &MarshalOptions{ApplicationMarshalTransformer: DurationExtensionMarshalTransformer}
+	uopts := &UnmarshalOptions{ApplicationUnmarshalTransformer: DurationExtensionUnmarshalTransformer}
+	testCases := []marshalTestCase{
+		{obj: time.Duration(0), encoded: []byte{0xd7, 0x2a, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{obj: time.Duration(1), encoded: []byte{0xd7, 0x2a, 0, 0, 0, 0, 0, 0, 0, 0x01}},
+		{obj: time.Duration(-1), encoded: []byte{0xd7, 0x2a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{obj: 123 * time.Millisecond, encoded: []byte{0xd7, 0x2a, 0, 0, 0, 0, 0x07, 0x54, 0xd4, 0xc0}},
+		{obj: -45 * time.Hour, encoded: []byte{0xd7, 0x2a, 0xff, 0xff, 0x6c, 0xa9, 0x6f, 0x8b, 0xe0, 0x00}},
+		{obj: time.Duration(math.MaxInt64), encoded: []byte{0xd7, 0x2a, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{obj: time.Duration(math.MinInt64), encoded: []byte{0xd7, 0x2a, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		// Other objects are unaffected.
+		{obj: int64(123), encoded: []byte{0x7b}, decoded: 123},
+	}
+	testMarshal(t, mopts, testCases)
+	testUnmarshal(t, uopts, unmarshalTestCasesFor(testCases))
+}
+
+func TestMakeDurationExtensionTransformers(t *testing.T) {
+	const extType = 17
+	marshalTransformer, unmarshalTransformer := MakeDurationExtensionTransformers(extType)
+	mopts := &MarshalOptions{ApplicationMarshalTransformer: marshalTransformer}
+	uopts := &UnmarshalOptions{ApplicationUnmarshalTransformer: unmarshalTransformer}
+	testCases := []marshalTestCase{
+		{obj: time.Duration(256), encoded: []byte{0xd7, 0x11, 0, 0, 0, 0, 0, 0, 0x01, 0x00}},
+	}
+	testMarshal(t, mopts, testCases)
+	testUnmarshal(t, uopts, unmarshalTestCasesFor(testCases))
+
+	// It shouldn't be unmarshalled as a duration with the default extension type.
+	testUnmarshal(t, &UnmarshalOptions{ApplicationUnmarshalTransformer: DurationExtensionUnmarshalTransformer}, []unmarshalTestCase{
+		{encoded: testCases[0].encoded, decoded: &UnresolvedExtensionType{ExtensionType: extType, Data: []byte{0, 0, 0, 0, 0, 0, 0x01, 0x00}}},
+	})
+}
+
+func TestUnmarshalDurationExtensionType(t *testing.T) {
+	for _, data := range [][]byte{nil, {1, 2, 3, 4}, {1, 2, 3, 4, 5, 6, 7, 8, 9}} {
+		if obj, _, err := UnmarshalDurationExtensionType(data); err != InvalidDurationError {
+			t.Errorf("Unexpected result for data=%v: %v, %v", data, obj, err)
+		}
+	}
+
+	if obj, mapKeySupported, err := UnmarshalDurationExtensionType([]byte{0, 0, 0, 0, 0, 0, 1, 0}); err != nil || obj != time.Duration(256) || !mapKeySupported {
+		t.Errorf("Unexpected result: %v, %v, %v", obj, mapKeySupported, err)
+	}
+}
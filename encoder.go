@@ -7,23 +7,78 @@ package umsgpack
 
 import (
 	"bytes"
+	"context"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"math"
 	"reflect"
+	"sort"
+	"sync"
 	"time"
 )
 
 // Errors ------------------------------------------------------------------------------------------
 
 // UnsupportedTypeForMarshallingError is the error returned if Marshal encounters an object whose
-// type is unsupported for marshalling.
+// type is unsupported for marshalling. (Marshal actually returns an *UnsupportedTypeError, which
+// wraps this, so it should be compared using errors.Is.)
 var UnsupportedTypeForMarshallingError = errors.New("Unsupported type for marshalling")
 
+// An *UnsupportedTypeError is the error returned if Marshal encounters an object whose type is
+// unsupported for marshalling (e.g., a channel or function), wrapping
+// UnsupportedTypeForMarshallingError.
+type UnsupportedTypeError struct {
+	// Type is the type of the object (after transformers are applied).
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("%v: %v", UnsupportedTypeForMarshallingError, e.Type)
+}
+
+func (e *UnsupportedTypeError) Unwrap() error {
+	return UnsupportedTypeForMarshallingError
+}
+
 // ObjectTooBigForMarshallingError is the error returned if Marshal encounters an object that's too
 // big for marshalling (e.g., a string that's 2**32 bytes or longer).
 var ObjectTooBigForMarshallingError = errors.New("Object too big for marshalling")
 
+// NonStringKeyForMarshallingError is the error returned if Marshal encounters a map key that is not
+// a string (after transformers are applied), if the JSONCompatible option is set.
+var NonStringKeyForMarshallingError = errors.New("Non-string key for marshalling")
+
+// InvalidMapKeyError is the error returned if Marshal encounters a map with a key that is a NaN
+// float (after transformers are applied). (Since NaN is not equal to itself, such a map cannot be
+// round-tripped correctly.)
+var InvalidMapKeyError = errors.New("Invalid map key")
+
+// NonFiniteFloatError is the error returned if Marshal encounters a float that is NaN or infinite,
+// if the RejectNonFiniteFloats option is set.
+var NonFiniteFloatError = errors.New("Non-finite float")
+
+// InvalidJSONNumberError is the error returned by JSONNumberMarshalTransformer for a json.Number
+// that parses as neither an int64 nor a float64.
+var InvalidJSONNumberError = errors.New("Invalid JSON number")
+
+// IntTooWideError is the error returned if Marshal encounters an integer that does not fit in the
+// width specified by the FixedIntWidth option.
+var IntTooWideError = errors.New("Integer too wide")
+
+// InvalidFixedIntWidthError is the error returned if Marshal encounters an integer and the
+// FixedIntWidth option is invalid (i.e., not one of 0, 8, 16, 32, or 64).
+var InvalidFixedIntWidthError = errors.New("Invalid fixed integer width")
+
+// TransformLoopError is the error returned if Marshal encounters objects nested more deeply than
+// permitted by the MaxTransformDepth option. This typically indicates a marshal transformer loop,
+// e.g., a transformer that transforms an object to a container (or pointer) containing an object
+// that it transforms in the same way.
+var TransformLoopError = errors.New("Transform loop")
+
 // Marshal -----------------------------------------------------------------------------------------
 
 // DefaultMarshalOptions is the default options used by Marshal/MarshalToBytes if it is passed nil
@@ -46,42 +101,226 @@ var DefaultMarshalOptions = &MarshalOptions{}
 //   - float64 to float 64; note that it will never marshals a float64 to a MessagePack float 32,
 //     even when the representation would be exact
 //   - string to the most compact str format (fixstr, str {8,16,32}) possible
-//   - []byte to the most compact bin format (bin {8,16,32}) possible
+//   - []byte to the most compact bin format (bin {8,16,32}) possible (or, if
+//     opts.BytesToBase64String or opts.JSONCompatible is set, to its base64 encoding as a string)
 //   - []any to the most compact array format (fixarray, array {16,32}) possible
 //   - map[any]any to the most compact map format (fixmap, map {16,32}) possible
+//   - *OrderedMap to the most compact map format possible, with its entries in order
 //   - *UnresolvedExtensionType to the most compact extension format (fixext {1,2,4,8,16}, ext
 //     {8,16,32}) possible
+//   - PreEncoded verbatim (i.e., its contents are written as-is)
+//   - RawMessage verbatim if non-empty, and otherwise to nil
+//   - LazyBytes like []byte, copying its data from its reader
+//   - []byte types implementing TextMarker (with AsText returning true) to the most compact str
+//     format possible
+//   - other types whose underlying types are bool, integer, float, or string types (e.g.,
+//     type Celsius float64) as their underlying types (as above)
+//   - other pointers to nil if nil, and otherwise to what they point to (marshalled as above)
 //   - types transformed by the standard marshal transformer to the above (unless
 //     opts.DisableStandardMarshalTransformer is set); currently, this just effectively marshals
 //     time.Time to the timestamp extension (type -1), using the most compact format possible
-//     (timestamp {32,64,96}, as fixext {4,8}/ext 8, respectively)
-//   - types transformed by the application marshal transformer (opts.ApplicationMarshalTransformer)
-//     to the above
+//     (timestamp {32,64,96}, as fixext {4,8}/ext 8, respectively); only the instant is marshalled
+//     (see TimestampExtensionMarshalTransformer)
+//   - types transformed by the application marshal transformers (opts.ApplicationMarshalTransformer
+//     and then opts.ApplicationMarshalTransformers, in order) to the above
+//
+// If opts.PreserveGoNumericTypes is set, then the built-in integer and float types are instead
+// marshalled to an extension recording their exact Go types; see GoNumericTypeExtensionType.
+//
+// Note that it never marshals a context.Context (after transformers are applied), instead
+// returning ContextNotMarshallableError; see MakeContextExtractor.
 func Marshal(opts *MarshalOptions, w io.Writer, obj any) error {
 	if opts == nil {
 		opts = DefaultMarshalOptions
 	}
-	m := &marshaller{opts: opts, w: w}
+	m := getMarshaller(opts, w)
+	defer putMarshaller(m)
 	return m.marshalObject(obj)
 }
 
 // MarshalToBytes is like Marshal, except that it returns byte data instead of using an io.Writer.
 func MarshalToBytes(opts *MarshalOptions, obj any) ([]byte, error) {
-	buf := &bytes.Buffer{}
+	return MarshalToBytesWithCap(opts, obj, defaultMarshalToBytesCap)
+}
+
+// defaultMarshalToBytesCap is the initial capacity of the output buffer used by MarshalToBytes.
+const defaultMarshalToBytesCap = 64
+
+// MarshalToBytesWithCap is like MarshalToBytes, except that the output buffer initially has
+// capacity sizeHint (or 0, if it is negative). If the approximate size of the output is known,
+// this avoids reallocations.
+func MarshalToBytesWithCap(opts *MarshalOptions, obj any, sizeHint int) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, max(sizeHint, 0)))
 	if err := Marshal(opts, buf, obj); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
 }
 
+// MarshalAll marshals each of objs (as for Marshal) to w, one after another. This is useful for
+// writing record-oriented data, which may be read back using UnmarshalAll.
+//
+// It stops at the first error, returning it; the objects before the failing one will have been
+// written (but the failing one may have been partially written).
+func MarshalAll(opts *MarshalOptions, w io.Writer, objs []any) error {
+	for _, obj := range objs {
+		if err := Marshal(opts, w, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalExtension marshals an extension with the given extension type and data to w, using the most
+// compact extension format (fixext {1,2,4,8,16}, ext {8,16,32}) possible. This is equivalent to
+// marshalling an *UnresolvedExtensionType (without transformers), but without allocating one; it
+// is useful for writing extensions directly (e.g., when building higher-level codecs).
+//
+// ObjectTooBigForMarshallingError is returned if data is too big (at least 2^32 bytes).
+func MarshalExtension(w io.Writer, extType int8, data []byte) error {
+	m := getMarshaller(marshalExtensionOptions, w)
+	defer putMarshaller(m)
+	return m.marshalExtensionType(int(extType), data)
+}
+
+// marshalExtensionOptions are the options used by MarshalExtension (independent of
+// DefaultMarshalOptions, which may be modified).
+var marshalExtensionOptions = &MarshalOptions{}
+
 // MarshalOptions specifies options for Marshal.
 type MarshalOptions struct {
 	// If set, then the standard marshal transformer will not be run.
+	//
+	// Note that the standard marshal transformer marshals time.Time as just an instant: its
+	// location (time zone) and monotonic clock reading are not marshalled. So a time.Time that is
+	// round-tripped is equal (see time.Time.Equal), but not necessarily identical (==), to the
+	// original; see TimestampExtensionMarshalTransformer.
 	DisableStandardMarshalTransformer bool
 
 	// ApplicationMarshalTransformer is a marshal transformer run on objects before marshalling
 	// (and before the standard marshal transformer).
 	ApplicationMarshalTransformer MarshalTransformerFn
+
+	// ApplicationMarshalTransformers are additional marshal transformers, run on objects in order
+	// (each on the result of the previous one) after ApplicationMarshalTransformer (if any) and
+	// before the standard marshal transformer. If any returns an error, marshalling stops with
+	// that error. This is equivalent to (but more convenient than) setting
+	// ApplicationMarshalTransformer to the composition (see ComposeMarshalTransformers) of
+	// ApplicationMarshalTransformer and these.
+	ApplicationMarshalTransformers []MarshalTransformerFn
+
+	// If MapKeyOrder is non-nil and the top-level object is a map (after transformers are
+	// applied), then its entries will be marshalled in the given key order (skipping keys not
+	// present in the map), followed by the remaining entries (see SortUnlistedMapKeys). Keys
+	// must have the same type as the map's keys (or be assignable to it, e.g., for map[any]any)
+	// to match.
+	//
+	// This is useful for producing reproducible output (for a known schema). Note that it only
+	// applies to the top-level map, not to nested maps.
+	MapKeyOrder []any
+
+	// If SortUnlistedMapKeys is set, then map entries whose keys are not in MapKeyOrder are
+	// marshalled in order of their keys' (marshalled) bytes. Otherwise, they're marshalled in
+	// map iteration order (which is not deterministic).
+	SortUnlistedMapKeys bool
+
+	// If BytesToBase64String is set, then []byte is marshalled as a string (str format) containing
+	// its base64 encoding (with standard encoding, like encoding/json), instead of to a bin
+	// format. (This is the inverse of UnmarshalOptions.BinToBase64String.)
+	BytesToBase64String bool
+
+	// If BinaryAsExtension is set, then []byte (and other binary data, e.g., [4]byte) is
+	// marshalled as an extension of type BinaryExtensionType (whose data is the binary data)
+	// instead of to a bin format. This allows binary data to be distinguished from strings by
+	// systems that conflate bin and str (e.g., bridges to JSON); see
+	// MakeBinaryExtensionUnmarshalTransformer for the corresponding unmarshal transformer. It
+	// takes precedence over BytesToBase64String and JSONCompatible.
+	BinaryAsExtension bool
+
+	// BinaryExtensionType is the extension type used if BinaryAsExtension is set.
+	BinaryExtensionType int8
+
+	// If NilCollectionsAsNil is set, then nil slices and maps (of any type, including []byte) are
+	// marshalled to nil, instead of to empty arrays/maps (or bin/str). Non-nil empty slices and
+	// maps are unaffected.
+	NilCollectionsAsNil bool
+
+	// If JSONCompatible is set, then objects are marshalled so as to be representable in JSON
+	// (e.g., for sharing a data model between JSON and MessagePack), as follows:
+	//   - unsigned integers are marshalled as signed (int formats), or as float 64 if they're too
+	//     large for an int64 (losing precision)
+	//   - float32 is marshalled as float 64
+	//   - []byte is marshalled as a string containing its base64 encoding (as for
+	//     BytesToBase64String), so it will be unmarshalled as a string
+	//   - map keys that are not strings (after transformers are applied) result in
+	//     NonStringKeyForMarshallingError
+	//
+	// Note that this is lossy: the distinction between signed and unsigned integers, between
+	// float32 and float64, and between binary data and strings is not preserved. Extension types
+	// (e.g., timestamps for time.Time) are not affected, so typically transformers should also be
+	// used to convert any such types to JSON-representable ones.
+	JSONCompatible bool
+
+	// If RejectNonFiniteFloats is set, then NonFiniteFloatError is returned for floats (float32
+	// or float64) that are NaN or infinite. The default is to marshal them (as their IEEE 754
+	// bit patterns).
+	RejectNonFiniteFloats bool
+
+	// If DisableReflection is set, then Marshal never falls back to using reflection for objects
+	// that are not of a concretely-supported type (e.g., named types, pointers, and arrays,
+	// slices, and maps other than []any, map[any]any, map[string]any, map[string]string, and
+	// map[string]int); instead,
+	// UnsupportedTypeForMarshallingError is returned. Thus transformers must handle (i.e.,
+	// transform to concretely-supported types) any such objects. This is useful for guaranteeing
+	// performance (e.g., that marshalling doesn't allocate unnecessarily).
+	//
+	// Note that some options (NilCollectionsAsNil, MapKeyOrder, and JSONCompatible) still use
+	// reflection.
+	DisableReflection bool
+
+	// If MaxTransformDepth is positive, then TransformLoopError is returned if objects are nested
+	// more deeply than that: i.e., the top-level object is at depth 1, its elements (for arrays),
+	// keys and values (for maps), or target (for non-nil pointers) are at depth 2, etc. Since
+	// transformers are run on nested objects, this guards against transformer loops (which
+	// would otherwise recurse until the stack is exhausted).
+	//
+	// If it is 0 (the default), then a depth of 10000 is used, which is generous for real data. If
+	// it is negative, then the depth is not limited.
+	MaxTransformDepth int
+
+	// If FixedIntWidth is nonzero, then all integers are marshalled using the int or uint format
+	// of that width in bits (8, 16, 32, or 64), even if a more compact format (including a fixint)
+	// is possible: e.g., if it is 64, then signed integers are always marshalled as int 64 and
+	// unsigned integers as uint 64. IntTooWideError is returned for integers that don't fit, and
+	// InvalidFixedIntWidthError if it is not one of the above values. This is useful for
+	// generating test vectors (e.g., for testing other implementations).
+	//
+	// The default (0) is to use the most compact format possible.
+	FixedIntWidth int
+
+	// If PreserveGoNumericTypes is set, then objects of the built-in integer and float types (int,
+	// int{8,16,32,64}, uint, uint{8,16,32,64}, uintptr, float32, and float64) are marshalled as
+	// extensions of type GoNumericTypeExtensionType, tagged with their exact types, instead of as
+	// MessagePack ints, uints, or floats. Unmarshalling with
+	// GoNumericTypeExtensionUnmarshalTransformer then yields values of the same types (e.g., int8
+	// rather than int). This is useful for generating test vectors. Other types (including named
+	// types such as time.Duration) are not affected. It takes precedence over FixedIntWidth and
+	// JSONCompatible.
+	//
+	// Note that this costs space: each number is marshalled as 4 bytes (for 8-bit types), 6 bytes
+	// (16-bit), 8 bytes (32-bit), or 12 bytes (64-bit, including int, uint, and uintptr), whereas
+	// e.g. a small integer is usually marshalled as a single byte.
+	PreserveGoNumericTypes bool
+
+	// OnWrite, if non-nil, is called for each format emitted (i.e., for each object, or for each
+	// array or map prefix), with its format byte (its first byte) and the number of bytes written
+	// for it: this includes the payload of strings, binary data, and extensions (and the
+	// extension type), but not the elements or entries of arrays or maps (which are formats in
+	// their own right). Pre-encoded data (PreEncoded or RawMessage) is reported as a single
+	// format, with its total length. It is called once the format's prefix (or all of the
+	// pre-encoded data) has been written. This is useful for instrumentation (e.g., to build
+	// histograms of formats and sizes); it doesn't affect the output.
+	OnWrite func(formatByte byte, n int)
 }
 
 // A MarshalTransformerFn transforms an object for marshalling.
@@ -95,6 +334,12 @@ type MarshalOptions struct {
 // applies, it should return the transformed object, but may also return an error if there is some
 // fatal problem. It may determine applicability however it wants (e.g., based on type, on
 // reflection, or on nothing at all).
+//
+// Marshal runs transformers on every object, not just the top-level one: i.e., also on the
+// elements of arrays, the keys and values of maps, and the targets of pointers (after the
+// containing object has been transformed). Thus a transformer need not (and should not) recurse
+// into the objects it returns. It is run once per object, so its result is not transformed again
+// (though the objects that the result contains are); see also MaxTransformDepth.
 type MarshalTransformerFn func(obj any) (any, error)
 
 // Marshaller --------------------------------------------------------------------------------------
@@ -107,10 +352,82 @@ type marshaller struct {
 	opts *MarshalOptions
 	w    io.Writer
 	sbuf [sbufSize]byte
+
+	// Whether the next object (i.e., the top-level object) should be marshalled using
+	// opts.MapKeyOrder if it's a map.
+	orderTopLevelMap bool
+
+	// Whether the next object is a map key, which is checked after transformers are applied; see
+	// marshalMapKey.
+	mapKey bool
+
+	// The current depth (only tracked if the depth is limited; see maxTransformDepth).
+	depth int
+
+	// The length of the payload of the format whose prefix is being written (for
+	// opts.OnWrite); see reportFormat.
+	formatPayload int
+
+	// Whether StandardMarshalTransformer has its default value (so need not be called for built-in
+	// scalars); see isDefaultStandardMarshalTransformer. This is determined once, by reset.
+	defaultStandardTransformer bool
+}
+
+// reset (fully) resets a marshaller to marshal using the given options to w. The next object is not
+// treated as a top-level object (see orderTopLevelMap).
+func (m *marshaller) reset(opts *MarshalOptions, w io.Writer) {
+	m.opts = opts
+	m.w = w
+	m.orderTopLevelMap = false
+	m.mapKey = false
+	m.depth = 0
+	m.formatPayload = 0
+	// Only check StandardMarshalTransformer if it may be called.
+	m.defaultStandardTransformer = !opts.DisableStandardMarshalTransformer &&
+		isDefaultStandardMarshalTransformer()
+}
+
+// marshallerPool is a pool of *marshaller, to avoid allocating one for each call to Marshal.
+var marshallerPool = sync.Pool{
+	New: func() any {
+		return &marshaller{}
+	},
+}
+
+// getMarshaller gets a marshaller from marshallerPool and (fully) resets it for a top-level call to
+// Marshal. It should be returned using putMarshaller.
+func getMarshaller(opts *MarshalOptions, w io.Writer) *marshaller {
+	m := marshallerPool.Get().(*marshaller)
+	m.reset(opts, w)
+	m.orderTopLevelMap = opts.MapKeyOrder != nil
+	return m
+}
+
+// putMarshaller returns a marshaller (gotten using getMarshaller) to marshallerPool. It clears
+// references to the options and writer so that they may be garbage collected.
+func putMarshaller(m *marshaller) {
+	m.opts = nil
+	m.w = nil
+	marshallerPool.Put(m)
 }
 
 // marshalObject marshals an object.
 func (m *marshaller) marshalObject(obj any) error {
+	maxDepth := m.maxTransformDepth()
+	if maxDepth <= 0 {
+		return m.transformAndMarshalObject(obj)
+	}
+	if m.depth >= maxDepth {
+		return TransformLoopError
+	}
+	m.depth += 1
+	err := m.transformAndMarshalObject(obj)
+	m.depth -= 1
+	return err
+}
+
+// transformAndMarshalObject is like marshalObject, but doesn't check or track the depth.
+func (m *marshaller) transformAndMarshalObject(obj any) error {
 	if m.opts.ApplicationMarshalTransformer != nil {
 		var err error
 		obj, err = m.opts.ApplicationMarshalTransformer(obj)
@@ -119,7 +436,17 @@ func (m *marshaller) marshalObject(obj any) error {
 		}
 	}
 
-	if !m.opts.DisableStandardMarshalTransformer {
+	for _, xform := range m.opts.ApplicationMarshalTransformers {
+		var err error
+		obj, err = xform(obj)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The default standard marshal transformer never transforms built-in scalars, so skip calling it
+	// for them (unless it has been replaced).
+	if !m.opts.DisableStandardMarshalTransformer && !(m.defaultStandardTransformer && isBuiltinScalar(obj)) {
 		var err error
 		obj, err = StandardMarshalTransformer(obj)
 		if err != nil {
@@ -127,10 +454,37 @@ func (m *marshaller) marshalObject(obj any) error {
 		}
 	}
 
+	if m.mapKey {
+		m.mapKey = false
+		if isNaN(obj, !m.opts.DisableReflection) {
+			return InvalidMapKeyError
+		}
+		if m.opts.JSONCompatible && !isStringKey(obj) {
+			return NonStringKeyForMarshallingError
+		}
+	}
+
+	if m.opts.NilCollectionsAsNil && isNilSliceOrMap(obj) {
+		return m.marshalNil()
+	}
+
+	if m.orderTopLevelMap {
+		m.orderTopLevelMap = false
+		if obj != nil && reflect.TypeOf(obj).Kind() == reflect.Map {
+			return m.marshalOrderedMap(obj)
+		}
+	}
+
 	if obj == nil {
 		return m.marshalNil()
 	}
 
+	if m.opts.PreserveGoNumericTypes {
+		if data, ok := goNumericTypeExtensionData(obj); ok {
+			return m.marshalExtensionType(int(GoNumericTypeExtensionType), data)
+		}
+	}
+
 	switch v := obj.(type) {
 	case bool:
 		return m.marshalBool(v)
@@ -163,25 +517,186 @@ func (m *marshaller) marshalObject(obj any) error {
 	case string:
 		return m.marshalString(v)
 	case []byte:
-		return m.marshalBytes(v)
+		return m.marshalBinary(v)
 	case []any:
 		return m.marshalArray(v)
 	case map[any]any:
 		return m.marshalAnyMap(v)
 	case map[string]any:
 		return m.marshalStringMap(v)
+	// Fast paths for common concrete map types, which bypass marshalObject (and so transformers)
+	// for keys and values. Hence they're only used if no transformer may transform keys or
+	// values; otherwise, the keys and values are marshalled as for other maps (but still without
+	// reflection).
+	case map[string]string:
+		if !m.mayTransformBuiltinScalars() {
+			return m.marshalStringStringMap(v)
+		}
+		return m.marshalTransformedStringStringMap(v)
+	case map[string]int:
+		// The fast path would also bypass PreserveGoNumericTypes for the values.
+		if !m.mayTransformBuiltinScalars() && !m.opts.PreserveGoNumericTypes {
+			return m.marshalStringIntMap(v)
+		}
+		return m.marshalTransformedStringIntMap(v)
 	case *UnresolvedExtensionType:
 		return m.marshalExtensionType(int(v.ExtensionType), v.Data)
+	case *OrderedMap:
+		if v == nil {
+			return m.marshalNil()
+		}
+		return m.marshalOrderedMapType(v)
+	case PreEncoded:
+		return m.writePreEncoded(v)
+	case LazyBytes:
+		return m.marshalLazyBytes(v)
+	case RawMessage:
+		if len(v) == 0 {
+			return m.marshalNil()
+		}
+		return m.writePreEncoded(v)
+	}
+
+	if b, ok := textMarkerBytes(obj); ok {
+		return m.marshalString(string(b))
+	}
+
+	if _, ok := obj.(context.Context); ok {
+		return ContextNotMarshallableError
+	}
+
+	if m.opts.DisableReflection {
+		return &UnsupportedTypeError{Type: reflect.TypeOf(obj)}
 	}
 
 	switch reflect.TypeOf(obj).Kind() {
+	case reflect.Bool:
+		return m.marshalBool(reflect.ValueOf(obj).Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return m.marshalInt64(reflect.ValueOf(obj).Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return m.marshalUint64(reflect.ValueOf(obj).Uint())
+	case reflect.Float32:
+		return m.marshalFloat32(float32(reflect.ValueOf(obj).Float()))
+	case reflect.Float64:
+		return m.marshalFloat64(reflect.ValueOf(obj).Float())
+	case reflect.String:
+		return m.marshalString(reflect.ValueOf(obj).String())
 	case reflect.Array, reflect.Slice:
+		if reflect.TypeOf(obj).Elem().Kind() == reflect.Uint8 {
+			return m.marshalGenericBytes(obj)
+		}
 		return m.marshalGenericArrayOrSlice(obj)
 	case reflect.Map:
 		return m.marshalGenericMap(obj)
+	case reflect.Pointer:
+		v := reflect.ValueOf(obj)
+		if v.IsNil() {
+			return m.marshalNil()
+		}
+		return m.marshalObject(v.Elem().Interface())
 	}
 
-	return UnsupportedTypeForMarshallingError
+	return &UnsupportedTypeError{Type: reflect.TypeOf(obj)}
+}
+
+// defaultMaxTransformDepth is the maximum depth used if the MaxTransformDepth option is 0.
+const defaultMaxTransformDepth = 10000
+
+// maxTransformDepth returns the maximum depth per the MaxTransformDepth option, or 0 if the depth
+// is not limited.
+func (m *marshaller) maxTransformDepth() int {
+	switch {
+	case m.opts.MaxTransformDepth > 0:
+		return m.opts.MaxTransformDepth
+	case m.opts.MaxTransformDepth == 0:
+		return defaultMaxTransformDepth
+	default:
+		return 0
+	}
+}
+
+// mayTransformBuiltinScalars returns true if transformers may transform built-in scalars (see
+// isBuiltinScalar), i.e., if there are application marshal transformers or if the standard marshal
+// transformer is enabled and has been replaced.
+func (m *marshaller) mayTransformBuiltinScalars() bool {
+	return m.hasApplicationMarshalTransformers() ||
+		!(m.opts.DisableStandardMarshalTransformer || m.defaultStandardTransformer)
+}
+
+// hasApplicationMarshalTransformers returns true if there are any application marshal transformers
+// (ApplicationMarshalTransformer or ApplicationMarshalTransformers).
+func (m *marshaller) hasApplicationMarshalTransformers() bool {
+	return m.opts.ApplicationMarshalTransformer != nil ||
+		len(m.opts.ApplicationMarshalTransformers) > 0
+}
+
+// marshalMapKey marshals a map key. After transformers are applied, NaN float keys are rejected
+// and, if the JSONCompatible option is set, the key must be a string.
+func (m *marshaller) marshalMapKey(key any) error {
+	m.mapKey = true
+	return m.marshalObject(key)
+}
+
+// isNaN returns true if obj is a NaN float (of any float type, or only of built-in float types if
+// useReflection is false).
+func isNaN(obj any, useReflection bool) bool {
+	// Fast path for common types.
+	switch v := obj.(type) {
+	case string, int:
+		return false
+	case float64:
+		return math.IsNaN(v)
+	case float32:
+		return math.IsNaN(float64(v))
+	}
+	if !useReflection {
+		return false
+	}
+
+	switch v := reflect.ValueOf(obj); v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return math.IsNaN(v.Float())
+	default:
+		return false
+	}
+}
+
+// isStringKey returns true if obj (a map key) will be marshalled as a string.
+func isStringKey(obj any) bool {
+	if obj == nil {
+		return false
+	}
+	if reflect.TypeOf(obj).Kind() == reflect.String {
+		return true
+	}
+	_, ok := textMarkerBytes(obj)
+	return ok
+}
+
+// isBuiltinScalar returns true if obj's concrete type is a built-in (unnamed) boolean, integer,
+// floating-point, or string type.
+func isBuiltinScalar(obj any) bool {
+	switch obj.(type) {
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64,
+		string:
+		return true
+	default:
+		return false
+	}
+}
+
+// isNilSliceOrMap returns true if obj is a nil slice or map (of any type).
+func isNilSliceOrMap(obj any) bool {
+	if obj == nil {
+		return false
+	}
+	switch v := reflect.ValueOf(obj); v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.IsNil()
+	default:
+		return false
+	}
 }
 
 // marshalNil marshals a nil.
@@ -200,6 +715,10 @@ func (m *marshaller) marshalBool(b bool) error {
 
 // marshalInt64 marshals an int64 (in a minimal way, though never as a MessagePack uint type).
 func (m *marshaller) marshalInt64(i int64) error {
+	if m.opts.FixedIntWidth != 0 {
+		return m.marshalFixedWidthInt64(i)
+	}
+
 	switch {
 	case i >= 0 && i <= 0x7f: // positive fixint: 0xxxxxxx: 0x00 - 0x7f
 		return m.writeByte(byte(i & 0xff))
@@ -217,8 +736,18 @@ func (m *marshaller) marshalInt64(i int64) error {
 }
 
 // marshalUint64 marshals a uint64 (in a minimal way, though only as a MessagePack uint type and
-// never as a fixint).
+// never as a fixint, unless the JSONCompatible option is set).
 func (m *marshaller) marshalUint64(u uint64) error {
+	if m.opts.JSONCompatible {
+		if u <= math.MaxInt64 {
+			return m.marshalInt64(int64(u))
+		}
+		return m.marshalFloat64(float64(u))
+	}
+	if m.opts.FixedIntWidth != 0 {
+		return m.marshalFixedWidthUint64(u)
+	}
+
 	switch {
 	case u <= math.MaxUint8: // uint 8: 11001100: 0xcc
 		return m.write2Bytes(0xcc, byte(u&0xff))
@@ -231,8 +760,65 @@ func (m *marshaller) marshalUint64(u uint64) error {
 	}
 }
 
+// marshalFixedWidthInt64 marshals a signed integer using the int format of width
+// opts.FixedIntWidth.
+func (m *marshaller) marshalFixedWidthInt64(i int64) error {
+	switch m.opts.FixedIntWidth {
+	case 8:
+		if i < math.MinInt8 || i > math.MaxInt8 {
+			return IntTooWideError
+		}
+		return m.write2Bytes(0xd0, byte(i&0xff))
+	case 16:
+		if i < math.MinInt16 || i > math.MaxInt16 {
+			return IntTooWideError
+		}
+		return m.write3Bytes(0xd1, byte((i>>8)&0xff), byte(i&0xff))
+	case 32:
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return IntTooWideError
+		}
+		return m.write5Bytes(0xd2, byte((i>>24)&0xff), byte((i>>16)&0xff), byte((i>>8)&0xff), byte(i&0xff))
+	case 64:
+		return m.write9Bytes(0xd3, byte((i>>56)&0xff), byte((i>>48)&0xff), byte((i>>40)&0xff), byte((i>>32)&0xff), byte((i>>24)&0xff), byte((i>>16)&0xff), byte((i>>8)&0xff), byte(i&0xff))
+	}
+	return InvalidFixedIntWidthError
+}
+
+// marshalFixedWidthUint64 is like marshalFixedWidthInt64, but for unsigned integers (and the uint
+// formats).
+func (m *marshaller) marshalFixedWidthUint64(u uint64) error {
+	switch m.opts.FixedIntWidth {
+	case 8:
+		if u > math.MaxUint8 {
+			return IntTooWideError
+		}
+		return m.write2Bytes(0xcc, byte(u&0xff))
+	case 16:
+		if u > math.MaxUint16 {
+			return IntTooWideError
+		}
+		return m.write3Bytes(0xcd, byte((u>>8)&0xff), byte(u&0xff))
+	case 32:
+		if u > math.MaxUint32 {
+			return IntTooWideError
+		}
+		return m.write5Bytes(0xce, byte((u>>24)&0xff), byte((u>>16)&0xff), byte((u>>8)&0xff), byte(u&0xff))
+	case 64:
+		return m.write9Bytes(0xcf, byte((u>>56)&0xff), byte((u>>48)&0xff), byte((u>>40)&0xff), byte((u>>32)&0xff), byte((u>>24)&0xff), byte((u>>16)&0xff), byte((u>>8)&0xff), byte(u&0xff))
+	}
+	return InvalidFixedIntWidthError
+}
+
 // marshalFloat32 marshals a float32.
 func (m *marshaller) marshalFloat32(f float32) error {
+	if m.opts.JSONCompatible {
+		return m.marshalFloat64(float64(f))
+	}
+	if m.opts.RejectNonFiniteFloats && !isFinite(float64(f)) {
+		return NonFiniteFloatError
+	}
+
 	u := math.Float32bits(f)
 	// float 32: 11001010: 0xca
 	return m.write5Bytes(0xca, byte((u>>24)&0xff), byte((u>>16)&0xff), byte((u>>8)&0xff), byte(u&0xff))
@@ -240,14 +826,30 @@ func (m *marshaller) marshalFloat32(f float32) error {
 
 // marshalFloat64 marshals a float64.
 func (m *marshaller) marshalFloat64(f float64) error {
+	if m.opts.RejectNonFiniteFloats && !isFinite(f) {
+		return NonFiniteFloatError
+	}
 	u := math.Float64bits(f)
 	// float 64: 11001011: 0xcb
 	return m.write9Bytes(0xcb, byte((u>>56)&0xff), byte((u>>48)&0xff), byte((u>>40)&0xff), byte((u>>32)&0xff), byte((u>>24)&0xff), byte((u>>16)&0xff), byte((u>>8)&0xff), byte(u&0xff))
 }
 
+// isFinite returns true if f is neither NaN nor infinite.
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
 // marshalString marshals a string (in a minimal way).
 func (m *marshaller) marshalString(s string) error {
-	u := len(s)
+	if err := m.writeStringPrefix(len(s)); err != nil {
+		return err
+	}
+	return m.writeRawString(s)
+}
+
+// writeStringPrefix writes the prefix for a string of length u (bytes).
+func (m *marshaller) writeStringPrefix(u int) error {
+	m.formatPayload = u
 	switch {
 	case u <= (0xbf - 0xa0): // fixstr: 101xxxxx: 0xa0 - 0xbf
 		if err := m.writeByte(byte(0xa0 + u)); err != nil {
@@ -268,12 +870,20 @@ func (m *marshaller) marshalString(s string) error {
 	default:
 		return ObjectTooBigForMarshallingError
 	}
-	return m.writeString(s)
+	return nil
 }
 
 // marshalBytes marshals a []byte (in a minimal way).
 func (m *marshaller) marshalBytes(b []byte) error {
-	u := len(b)
+	if err := m.writeBinaryPrefix(len(b)); err != nil {
+		return err
+	}
+	return m.writeRaw(b)
+}
+
+// writeBinaryPrefix writes the prefix for binary data of length u (bytes).
+func (m *marshaller) writeBinaryPrefix(u int) error {
+	m.formatPayload = u
 	switch {
 	case u <= math.MaxUint8: // bin 8: 11000100: 0xc4
 		if err := m.write2Bytes(0xc4, byte(u&0xff)); err != nil {
@@ -290,7 +900,75 @@ func (m *marshaller) marshalBytes(b []byte) error {
 	default:
 		return ObjectTooBigForMarshallingError
 	}
-	return m.writeBytes(b)
+	return nil
+}
+
+// marshalBinary marshals binary data, as bin (or as an extension, if the BinaryAsExtension option is
+// set, or as a base64 string, if the BytesToBase64String or JSONCompatible option is set).
+func (m *marshaller) marshalBinary(b []byte) error {
+	if m.opts.BinaryAsExtension {
+		return m.marshalExtensionType(int(m.opts.BinaryExtensionType), b)
+	}
+	if m.opts.BytesToBase64String || m.opts.JSONCompatible {
+		return m.marshalString(base64.StdEncoding.EncodeToString(b))
+	}
+	return m.marshalBytes(b)
+}
+
+// marshalLazyBytes marshals a LazyBytes like binary data (see marshalBinary), copying its data from
+// its reader.
+func (m *marshaller) marshalLazyBytes(b LazyBytes) error {
+	if b.N < 0 {
+		return InvalidLazyBytesError
+	}
+	if b.N > math.MaxUint32 {
+		return ObjectTooBigForMarshallingError
+	}
+	u := int(b.N)
+
+	copyData := func(w io.Writer) error {
+		if _, err := io.CopyN(w, b.R, b.N); err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		return nil
+	}
+
+	if m.opts.BinaryAsExtension {
+		if err := m.writeExtensionPrefix(int(m.opts.BinaryExtensionType), u); err != nil {
+			return err
+		}
+		return copyData(m.w)
+	}
+	if m.opts.BytesToBase64String || m.opts.JSONCompatible {
+		if err := m.writeStringPrefix(base64.StdEncoding.EncodedLen(u)); err != nil {
+			return err
+		}
+		enc := base64.NewEncoder(base64.StdEncoding, m.w)
+		if err := copyData(enc); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	if err := m.writeBinaryPrefix(u); err != nil {
+		return err
+	}
+	return copyData(m.w)
+}
+
+// marshalGenericBytes marshals an arbitrary array or slice whose elements are of kind uint8 (e.g.,
+// a [4]byte or a named []byte type) as binary data, like []byte.
+func (m *marshaller) marshalGenericBytes(obj any) error {
+	v := reflect.ValueOf(obj)
+	if v.Kind() == reflect.Array {
+		// Bytes requires an addressable array, so make a copy.
+		a := reflect.New(v.Type()).Elem()
+		a.Set(v)
+		v = a
+	}
+	return m.marshalBinary(v.Bytes())
 }
 
 // marshalArray marshals a []any (in a minimal way).
@@ -348,7 +1026,7 @@ func (m *marshaller) marshalAnyMap(kvs map[any]any) error {
 		return err
 	}
 	for k, v := range kvs {
-		if err := m.marshalObject(k); err != nil {
+		if err := m.marshalMapKey(k); err != nil {
 			return err
 		}
 		if err := m.marshalObject(v); err != nil {
@@ -358,6 +1036,22 @@ func (m *marshaller) marshalAnyMap(kvs map[any]any) error {
 	return nil
 }
 
+// marshalOrderedMapType marshals an *OrderedMap (in a minimal way), with its entries in order.
+func (m *marshaller) marshalOrderedMapType(om *OrderedMap) error {
+	if err := m.writeMapPrefix(len(om.Keys)); err != nil {
+		return err
+	}
+	for _, k := range om.Keys {
+		if err := m.marshalMapKey(k); err != nil {
+			return err
+		}
+		if err := m.marshalObject(om.Values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // marshalStringMap marshals a map[string]any (in a minimal way).
 func (m *marshaller) marshalStringMap(kvs map[string]any) error {
 	if err := m.writeMapPrefix(len(kvs)); err != nil {
@@ -374,6 +1068,72 @@ func (m *marshaller) marshalStringMap(kvs map[string]any) error {
 	return nil
 }
 
+// marshalStringStringMap marshals a map[string]string (in a minimal way).
+func (m *marshaller) marshalStringStringMap(kvs map[string]string) error {
+	if err := m.writeMapPrefix(len(kvs)); err != nil {
+		return err
+	}
+	for k, v := range kvs {
+		if err := m.marshalString(k); err != nil {
+			return err
+		}
+		if err := m.marshalString(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalStringIntMap marshals a map[string]int (in a minimal way).
+func (m *marshaller) marshalStringIntMap(kvs map[string]int) error {
+	if err := m.writeMapPrefix(len(kvs)); err != nil {
+		return err
+	}
+	for k, v := range kvs {
+		if err := m.marshalString(k); err != nil {
+			return err
+		}
+		if err := m.marshalInt64(int64(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalTransformedStringStringMap marshals a map[string]string like marshalGenericMap (i.e.,
+// running transformers on keys and values), but without using reflection.
+func (m *marshaller) marshalTransformedStringStringMap(kvs map[string]string) error {
+	if err := m.writeMapPrefix(len(kvs)); err != nil {
+		return err
+	}
+	for k, v := range kvs {
+		if err := m.marshalMapKey(k); err != nil {
+			return err
+		}
+		if err := m.marshalObject(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalTransformedStringIntMap is like marshalTransformedStringStringMap, but for a
+// map[string]int.
+func (m *marshaller) marshalTransformedStringIntMap(kvs map[string]int) error {
+	if err := m.writeMapPrefix(len(kvs)); err != nil {
+		return err
+	}
+	for k, v := range kvs {
+		if err := m.marshalMapKey(k); err != nil {
+			return err
+		}
+		if err := m.marshalObject(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // marshalGenericMap marshals a generic map (i.e., not just map[any]any).
 func (m *marshaller) marshalGenericMap(obj any) error {
 	v := reflect.ValueOf(obj)
@@ -381,7 +1141,7 @@ func (m *marshaller) marshalGenericMap(obj any) error {
 		return err
 	}
 	for it := v.MapRange(); it.Next(); {
-		if err := m.marshalObject(it.Key().Interface()); err != nil {
+		if err := m.marshalMapKey(it.Key().Interface()); err != nil {
 			return err
 		}
 		if err := m.marshalObject(it.Value().Interface()); err != nil {
@@ -391,6 +1151,103 @@ func (m *marshaller) marshalGenericMap(obj any) error {
 	return nil
 }
 
+// marshalOrderedMap marshals a generic map, with entries ordered according to opts.MapKeyOrder and
+// opts.SortUnlistedMapKeys.
+func (m *marshaller) marshalOrderedMap(obj any) error {
+	v := reflect.ValueOf(obj)
+	if err := m.writeMapPrefix(v.Len()); err != nil {
+		return err
+	}
+
+	keyType := v.Type().Key()
+	listed := map[any]bool{}
+	for _, key := range m.opts.MapKeyOrder {
+		// Keys that aren't comparable (e.g., slices) can't be in the map (and can't be hashed).
+		if key == nil || !reflect.TypeOf(key).AssignableTo(keyType) || !reflect.ValueOf(key).Comparable() || listed[key] {
+			continue
+		}
+		value := v.MapIndex(reflect.ValueOf(key))
+		if !value.IsValid() {
+			continue
+		}
+		listed[key] = true
+
+		if err := m.marshalMapKey(key); err != nil {
+			return err
+		}
+		if err := m.marshalObject(value.Interface()); err != nil {
+			return err
+		}
+	}
+
+	if !m.opts.SortUnlistedMapKeys {
+		for it := v.MapRange(); it.Next(); {
+			key := it.Key().Interface()
+			if listed[key] {
+				continue
+			}
+			if err := m.marshalMapKey(key); err != nil {
+				return err
+			}
+			if err := m.marshalObject(it.Value().Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Marshal the unlisted keys first, so that we can sort by their marshalled bytes. The keys are
+	// marshalled at the current depth (for MaxTransformDepth), and their formats are recorded so
+	// that they can be reported to OnWrite when the keys are actually written.
+	type reportedFormat struct {
+		formatByte byte
+		n          int
+	}
+	type entry struct {
+		encodedKey []byte
+		formats    []reportedFormat
+		value      any
+	}
+	kopts := m.opts
+	var formats []reportedFormat
+	if m.opts.OnWrite != nil {
+		kopts = new(MarshalOptions)
+		*kopts = *m.opts
+		kopts.OnWrite = func(formatByte byte, n int) {
+			formats = append(formats, reportedFormat{formatByte: formatByte, n: n})
+		}
+	}
+	var entries []entry
+	for it := v.MapRange(); it.Next(); {
+		key := it.Key().Interface()
+		if listed[key] {
+			continue
+		}
+		buf := &bytes.Buffer{}
+		km := &marshaller{opts: kopts, w: buf, depth: m.depth, defaultStandardTransformer: m.defaultStandardTransformer}
+		formats = nil
+		if err := km.marshalMapKey(key); err != nil {
+			return err
+		}
+		entries = append(entries, entry{encodedKey: buf.Bytes(), formats: formats, value: it.Value().Interface()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].encodedKey, entries[j].encodedKey) < 0
+	})
+	for _, e := range entries {
+		if err := m.writeRaw(e.encodedKey); err != nil {
+			return err
+		}
+		for _, f := range e.formats {
+			m.opts.OnWrite(f.formatByte, f.n)
+		}
+		if err := m.marshalObject(e.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // writeMapPrefix writes the prefix for a map of length u.
 func (m *marshaller) writeMapPrefix(u int) error {
 	switch {
@@ -414,7 +1271,16 @@ func (m *marshaller) writeMapPrefix(u int) error {
 
 // marshalExtensionType marshals an extension type (in a minimal way).
 func (m *marshaller) marshalExtensionType(extType int, extData []byte) error {
-	u := len(extData)
+	if err := m.writeExtensionPrefix(extType, len(extData)); err != nil {
+		return err
+	}
+	return m.writeRaw(extData)
+}
+
+// writeExtensionPrefix writes the prefix, including the extension type, for an extension with data
+// of length u (bytes).
+func (m *marshaller) writeExtensionPrefix(extType int, u int) error {
+	m.formatPayload = 1 + u
 	switch {
 	case u == 1: // fixext 1: 11010100: 0xd4
 		if err := m.writeByte(0xd4); err != nil {
@@ -451,25 +1317,52 @@ func (m *marshaller) marshalExtensionType(extType int, extData []byte) error {
 	default:
 		return ObjectTooBigForMarshallingError
 	}
-	if err := m.writeByte(byte(extType)); err != nil {
+	// The extension type isn't a format, so it's written raw (not using writeByte).
+	m.sbuf[0] = byte(extType)
+	return m.writeRaw(m.sbuf[0:1])
+}
+
+// reportFormat calls opts.OnWrite (if set) for a format whose prefix (of length n, starting with
+// formatByte) has just been written, including the length of its payload (m.formatPayload, which it
+// resets). It's called by the write helpers below (writeByte, etc.), which are only used to write
+// prefixes.
+func (m *marshaller) reportFormat(formatByte byte, n int) {
+	if m.opts.OnWrite != nil {
+		m.opts.OnWrite(formatByte, n+m.formatPayload)
+	}
+	m.formatPayload = 0
+}
+
+// writePreEncoded writes pre-encoded data (which is reported to opts.OnWrite as a single format).
+func (m *marshaller) writePreEncoded(data []byte) error {
+	if err := m.writeRaw(data); err != nil {
 		return err
 	}
-	return m.writeBytes(extData)
+	if m.opts.OnWrite != nil && len(data) > 0 {
+		m.opts.OnWrite(data[0], len(data))
+	}
+	return nil
 }
 
 // writeByte is a helper that writes 1 byte.
 func (m *marshaller) writeByte(b byte) error {
 	m.sbuf[0] = b
-	_, err := m.w.Write(m.sbuf[0:1])
-	return err
+	if _, err := m.w.Write(m.sbuf[0:1]); err != nil {
+		return err
+	}
+	m.reportFormat(b, 1)
+	return nil
 }
 
 // write2Bytes is a helper that writes 2 bytes.
 func (m *marshaller) write2Bytes(b0, b1 byte) error {
 	m.sbuf[0] = b0
 	m.sbuf[1] = b1
-	_, err := m.w.Write(m.sbuf[0:2])
-	return err
+	if _, err := m.w.Write(m.sbuf[0:2]); err != nil {
+		return err
+	}
+	m.reportFormat(b0, 2)
+	return nil
 }
 
 // write3Bytes is a helper that writes 3 bytes.
@@ -477,8 +1370,11 @@ func (m *marshaller) write3Bytes(b0, b1, b2 byte) error {
 	m.sbuf[0] = b0
 	m.sbuf[1] = b1
 	m.sbuf[2] = b2
-	_, err := m.w.Write(m.sbuf[0:3])
-	return err
+	if _, err := m.w.Write(m.sbuf[0:3]); err != nil {
+		return err
+	}
+	m.reportFormat(b0, 3)
+	return nil
 }
 
 // write5Bytes is a helper that writes 5 bytes.
@@ -488,8 +1384,11 @@ func (m *marshaller) write5Bytes(b0, b1, b2, b3, b4 byte) error {
 	m.sbuf[2] = b2
 	m.sbuf[3] = b3
 	m.sbuf[4] = b4
-	_, err := m.w.Write(m.sbuf[0:5])
-	return err
+	if _, err := m.w.Write(m.sbuf[0:5]); err != nil {
+		return err
+	}
+	m.reportFormat(b0, 5)
+	return nil
 }
 
 // write9Bytes is a helper that writes 9 bytes.
@@ -503,26 +1402,35 @@ func (m *marshaller) write9Bytes(b0, b1, b2, b3, b4, b5, b6, b7, b8 byte) error
 	m.sbuf[6] = b6
 	m.sbuf[7] = b7
 	m.sbuf[8] = b8
-	_, err := m.w.Write(m.sbuf[0:9])
-	return err
+	if _, err := m.w.Write(m.sbuf[0:9]); err != nil {
+		return err
+	}
+	m.reportFormat(b0, 9)
+	return nil
 }
 
-// writeBytes is a helper that writes a byte slice.
-func (m *marshaller) writeBytes(data []byte) error {
+// writeRaw is a helper that writes raw data (e.g., the payload of a binary or extension type, or
+// pre-encoded data). All payloads are written using writeRaw or writeRawString.
+func (m *marshaller) writeRaw(data []byte) error {
 	_, err := m.w.Write(data)
 	return err
 }
 
-// writeString is a helper that writes a string.
-func (m *marshaller) writeString(s string) error {
+// writeRawString is like writeRaw, but for string data (e.g., the payload of a string). If the
+// writer implements io.StringWriter, then it is used to avoid copying large strings.
+func (m *marshaller) writeRawString(s string) error {
 	// Small string optimization, which copies to the shared bounce buffer.
 	if len(s) < sbufSize {
 		data := m.sbuf[0:len(s)]
 		copy(data, s)
-		return m.writeBytes(data)
-	} else {
-		return m.writeBytes([]byte(s))
+		return m.writeRaw(data)
+	}
+
+	if sw, ok := m.w.(io.StringWriter); ok {
+		_, err := sw.WriteString(s)
+		return err
 	}
+	return m.writeRaw([]byte(s))
 }
 
 // Marshal transformers ----------------------------------------------------------------------------
@@ -542,6 +1450,31 @@ func ComposeMarshalTransformers(xforms ...MarshalTransformerFn) MarshalTransform
 	}
 }
 
+// A MarshalExtensionTypeFn marshals an object (of a fixed, known type) to an extension, returning
+// the extension type and data, or an error. It is the counterpart of UnmarshalExtensionTypeFn.
+type MarshalExtensionTypeFn func(obj any) (extType int8, data []byte, err error)
+
+// MakeExtensionTypeMarshalTransformer makes a marshal transformer for the given extensions,
+// specified as a map from a (concrete) Go type to a MarshalExtensionTypeFn. Objects whose types are
+// in the map are transformed to *UnresolvedExtensionTypes; other objects are left as-is.
+//
+// Note that the type must match exactly: e.g., a map entry for T does not apply to *T (or vice
+// versa), nor to other types whose underlying type is T.
+func MakeExtensionTypeMarshalTransformer(marshalExtensions map[reflect.Type]MarshalExtensionTypeFn) MarshalTransformerFn {
+	return func(obj any) (any, error) {
+		marshalFn, ok := marshalExtensions[reflect.TypeOf(obj)]
+		if !ok {
+			return obj, nil
+		}
+
+		extType, data, err := marshalFn(obj)
+		if err != nil {
+			return nil, err
+		}
+		return &UnresolvedExtensionType{ExtensionType: extType, Data: data}, nil
+	}
+}
+
 // StandardMarshalTransformer is the standard marshal transformer run by Marshal (after the
 // application marshal transformer, if any).
 //
@@ -549,8 +1482,26 @@ func ComposeMarshalTransformers(xforms ...MarshalTransformerFn) MarshalTransform
 // type), but others may easily be added/combined using ComposeMarshalTransformers.
 var StandardMarshalTransformer MarshalTransformerFn = TimestampExtensionMarshalTransformer
 
+// defaultStandardMarshalTransformerPC is the code pointer of the initial (default) value of
+// StandardMarshalTransformer, which is used to check whether it has been replaced.
+var defaultStandardMarshalTransformerPC = reflect.ValueOf(StandardMarshalTransformer).Pointer()
+
+// isDefaultStandardMarshalTransformer returns true if StandardMarshalTransformer has its initial
+// (default) value.
+func isDefaultStandardMarshalTransformer() bool {
+	return reflect.ValueOf(StandardMarshalTransformer).Pointer() == defaultStandardMarshalTransformerPC
+}
+
 // TimestampExtensionMarshalTransformer is a MarshalTransformerFn supporting the standard (-1)
 // timestamp extension type by transforming time.Time to a minimal *UnresolvedExtensionType.
+//
+// The timestamp extension represents an instant (seconds and nanoseconds since the Unix epoch, as
+// given by time.Time.Unix and time.Time.Nanosecond), independent of location. Thus the location
+// (time zone) of t is dropped, as is its monotonic clock reading: the same instant in different
+// locations is marshalled to identical data. When unmarshalled (by the standard unmarshal
+// transformer), timestamps are in the local location (or UnmarshalOptions.TimestampLocation, if
+// set), so time.Time values should be compared using time.Time.Equal (not ==). To preserve the
+// location, the application must marshal it separately.
 func TimestampExtensionMarshalTransformer(obj any) (any, error) {
 	t, ok := obj.(time.Time)
 	if !ok {
@@ -580,3 +1531,115 @@ func TimestampExtensionMarshalTransformer(obj any) (any, error) {
 }
 
 var _ MarshalTransformerFn = TimestampExtensionMarshalTransformer
+
+// BinaryMarshalerTransformer is a MarshalTransformerFn that transforms objects implementing
+// encoding.BinaryMarshaler to the []byte returned by their MarshalBinary method (which is then
+// marshalled as binary). Errors from MarshalBinary are returned as-is.
+//
+// It is not part of the standard marshal transformer; to use it, set it as (or compose it into)
+// the ApplicationMarshalTransformer option. Note that since the application marshal transformer is
+// run first, this takes precedence over the standard marshal transformer for types that also
+// implement encoding.BinaryMarshaler (notably, time.Time).
+func BinaryMarshalerTransformer(obj any) (any, error) {
+	bm, ok := obj.(encoding.BinaryMarshaler)
+	if !ok {
+		return obj, nil
+	}
+	return bm.MarshalBinary()
+}
+
+var _ MarshalTransformerFn = BinaryMarshalerTransformer
+
+// TextMarshalerTransformer is a MarshalTransformerFn that transforms objects implementing
+// encoding.TextMarshaler to the result of their MarshalText method as a string (which is then
+// marshalled as a string). Errors from MarshalText are returned as-is.
+//
+// Like BinaryMarshalerTransformer, it is not part of the standard marshal transformer and takes
+// precedence over it if used (e.g., time.Time will be marshalled as an RFC 3339 string).
+func TextMarshalerTransformer(obj any) (any, error) {
+	tm, ok := obj.(encoding.TextMarshaler)
+	if !ok {
+		return obj, nil
+	}
+	text, err := tm.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return string(text), nil
+}
+
+var _ MarshalTransformerFn = TextMarshalerTransformer
+
+// JSONNumberMarshalTransformer is a MarshalTransformerFn that transforms json.Number (e.g., from
+// decoding JSON using json.Decoder.UseNumber) to an int64 if it parses as one, and otherwise to a
+// float64 if it parses as one (which are then marshalled as usual). If it parses as neither,
+// InvalidJSONNumberError is returned.
+func JSONNumberMarshalTransformer(obj any) (any, error) {
+	n, ok := obj.(json.Number)
+	if !ok {
+		return obj, nil
+	}
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	if f, err := n.Float64(); err == nil {
+		return f, nil
+	}
+	return nil, InvalidJSONNumberError
+}
+
+var _ MarshalTransformerFn = JSONNumberMarshalTransformer
+
+// ErrorMarshalTransformer is a MarshalTransformerFn that transforms objects implementing the error
+// interface to the result of their Error method (which is then marshalled as a string). This is
+// useful for, e.g., logging.
+//
+// It only applies to objects that would not otherwise be marshallable as-is: objects whose
+// underlying type is a boolean, number, string, slice, array, or map (e.g., `type MyError string`)
+// are returned as-is (and marshalled as usual). Other transformers (e.g., a struct marshal
+// transformer) may still apply to errors, so it should typically be composed late (i.e., last) in
+// the application marshal transformer, using ComposeMarshalTransformers.
+//
+// A nil pointer implementing the error interface is transformed to nil (without calling its Error
+// method, which may panic).
+func ErrorMarshalTransformer(obj any) (any, error) {
+	e, ok := obj.(error)
+	if !ok {
+		return obj, nil
+	}
+	switch v := reflect.ValueOf(obj); v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil, nil
+		}
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return obj, nil
+	}
+	return e.Error(), nil
+}
+
+var _ MarshalTransformerFn = ErrorMarshalTransformer
+
+// SyncMapMarshalTransformer is a MarshalTransformerFn that transforms a *sync.Map to a map[any]any
+// snapshot of its contents (which is then marshalled as a map). Keys and values are then marshalled
+// (and transformed) as usual, so, e.g., unsupported keys or values result in errors as usual.
+//
+// Note that sync.Map (by value, as opposed to *sync.Map) is not supported, since a sync.Map must
+// not be copied (and storing one in an interface value copies it). A nil *sync.Map is returned
+// as-is (and marshalled to nil).
+func SyncMapMarshalTransformer(obj any) (any, error) {
+	sm, ok := obj.(*sync.Map)
+	if !ok || sm == nil {
+		return obj, nil
+	}
+	m := map[any]any{}
+	sm.Range(func(key, value any) bool {
+		m[key] = value
+		return true
+	})
+	return m, nil
+}
+
+var _ MarshalTransformerFn = SyncMapMarshalTransformer
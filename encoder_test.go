@@ -7,13 +7,19 @@ package umsgpack_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"math"
+	"net/netip"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unsafe"
 
 	. "github.com/viettrungluu/umsgpack"
 )
@@ -35,7 +41,7 @@ type marshalTestCase struct {
 func testMarshal(t *testing.T, opts *MarshalOptions, tCs []marshalTestCase) {
 	for _, tC := range tCs {
 		buf := &bytes.Buffer{}
-		if actualErr := Marshal(opts, buf, tC.obj); actualErr != tC.err {
+		if actualErr := Marshal(opts, buf, tC.obj); !errors.Is(actualErr, tC.err) {
 			t.Errorf("unexected error for obj=%#v (encoded=%q, err=%v): actualErr=%v", tC.obj, tC.encoded, tC.err, actualErr)
 		} else if tC.err == nil {
 			if tC.prefix {
@@ -62,6 +68,20 @@ func testMarshal(t *testing.T, opts *MarshalOptions, tCs []marshalTestCase) {
 	}
 }
 
+// unmarshalTestCasesFor returns unmarshalTestCases for the given (successful) marshalTestCases, for
+// which the encoded objects should unmarshal to decoded (or obj, if decoded is nil).
+func unmarshalTestCasesFor(tCs []marshalTestCase) []unmarshalTestCase {
+	var rv []unmarshalTestCase
+	for _, tC := range tCs {
+		decoded := tC.decoded
+		if decoded == nil {
+			decoded = tC.obj
+		}
+		rv = append(rv, unmarshalTestCase{encoded: tC.encoded, decoded: decoded})
+	}
+	return rv
+}
+
 // commonMarshalTestCases contains marshalTestCases that should pass regardless of options.
 var commonMarshalTestCases = []marshalTestCase{
 	// nil: 11000000: 0xc0
@@ -416,21 +436,23 @@ var defaultOptsMarshalTestCases = []marshalTestCase{
 	{obj: time.Unix(math.MinInt64, 1), encoded: []byte{0xc7, 0x0c, 0xff, 0x00, 0x00, 0x00, 0x01, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
 	{obj: time.Unix(math.MinInt64, 999999999), encoded: []byte{0xc7, 0x0c, 0xff, 0x3b, 0x9a, 0xc9, 0xff, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
 	// UnsupportedTypeForMarshallingError
-	{obj: testMarshalType1(""), err: UnsupportedTypeForMarshallingError},
 	{obj: &testMarshalType2{}, err: UnsupportedTypeForMarshallingError},
 	{obj: &testMarshalType3{}, err: UnsupportedTypeForMarshallingError},
-	{obj: testMarshalType4(0), err: UnsupportedTypeForMarshallingError},
-	{obj: testMarshalType5(0), err: UnsupportedTypeForMarshallingError},
+	// Named scalar types (as their underlying types).
+	{obj: testMarshalType1(""), encoded: []byte{0xa0}},
+	{obj: testMarshalType4(0), encoded: []byte{0x00}},
+	{obj: testMarshalType5(0), encoded: []byte{0x00}},
 }
 
 var nonDefaultOptsMarshalTestCases = []marshalTestCase{
 	// UnsupportedTypeForMarshallingError
 	{obj: time.Unix(0, 0), err: UnsupportedTypeForMarshallingError},
-	{obj: testMarshalType1(""), err: UnsupportedTypeForMarshallingError},
 	{obj: &testMarshalType2{}, err: UnsupportedTypeForMarshallingError},
 	{obj: &testMarshalType3{}, err: UnsupportedTypeForMarshallingError},
-	{obj: testMarshalType4(0), err: UnsupportedTypeForMarshallingError},
-	{obj: testMarshalType5(0), err: UnsupportedTypeForMarshallingError},
+	// Named scalar types (as their underlying types).
+	{obj: testMarshalType1(""), encoded: []byte{0xa0}},
+	{obj: testMarshalType4(0), encoded: []byte{0x00}},
+	{obj: testMarshalType5(0), encoded: []byte{0x00}},
 }
 
 var applicationMarshalTransformerMarshalTestCases = []marshalTestCase{
@@ -512,6 +534,18 @@ func (w *limitedDiscardWriter) Write(p []byte) (n int, err error) {
 	}
 }
 
+// A *limitedDiscardStringWriter is like a *limitedDiscardWriter, but also implements
+// io.StringWriter.
+type limitedDiscardStringWriter struct {
+	limitedDiscardWriter
+}
+
+var _ io.StringWriter = (*limitedDiscardStringWriter)(nil)
+
+func (w *limitedDiscardStringWriter) WriteString(s string) (n int, err error) {
+	return w.Write([]byte(s))
+}
+
 // testMarshalWriteError is a helper for testing Marshal with the given options for the given
 // write-error test cases.
 func testMarshalWriteError(t *testing.T, opts *MarshalOptions, tCs []marshalWriteErrorTestCase) {
@@ -520,6 +554,12 @@ func testMarshalWriteError(t *testing.T, opts *MarshalOptions, tCs []marshalWrit
 		if err := Marshal(opts, writer, tC.obj); err != io.ErrShortWrite {
 			t.Errorf("unexected error for obj=%#v (errAt=%v): err=%v", tC.obj, tC.errAt, err)
 		}
+
+		// Also with an io.StringWriter, which should be equivalent.
+		stringWriter := &limitedDiscardStringWriter{limitedDiscardWriter{tC.errAt}}
+		if err := Marshal(opts, stringWriter, tC.obj); err != io.ErrShortWrite {
+			t.Errorf("unexected error for obj=%#v (errAt=%v) (string writer): err=%v", tC.obj, tC.errAt, err)
+		}
 	}
 }
 
@@ -905,47 +945,51 @@ func TestMarshal_nonDefaultOpts(t *testing.T) {
 
 var testError = errors.New("test error")
 
+// applicationMarshalTransformers are the marshal transformers (to be run in order) for
+// applicationMarshalTransformerMarshalTestCases.
+var applicationMarshalTransformers = []MarshalTransformerFn{
+	func(obj any) (any, error) {
+		if t, ok := obj.(testMarshalType1); ok {
+			if t == "oops" {
+				return nil, testError
+			}
+			return &UnresolvedExtensionType{
+				ExtensionType: 12,
+				Data:          []byte(t),
+			}, nil
+		} else {
+			return obj, nil
+		}
+	},
+	func(obj any) (any, error) {
+		if _, ok := obj.(*testMarshalType2); ok {
+			return &UnresolvedExtensionType{
+				ExtensionType: 42,
+				Data:          []byte("hi"),
+			}, nil
+		} else {
+			return obj, nil
+		}
+	},
+	func(obj any) (any, error) {
+		if t, ok := obj.(testMarshalType4); ok {
+			return testMarshalType5(t), nil
+		} else {
+			return obj, nil
+		}
+	},
+	func(obj any) (any, error) {
+		if t, ok := obj.(testMarshalType5); ok {
+			return int(t), nil
+		} else {
+			return obj, nil
+		}
+	},
+}
+
 func TestMarshal_applicationMarshalTransformer(t *testing.T) {
 	opts := &MarshalOptions{
-		ApplicationMarshalTransformer: ComposeMarshalTransformers(
-			func(obj any) (any, error) {
-				if t, ok := obj.(testMarshalType1); ok {
-					if t == "oops" {
-						return nil, testError
-					}
-					return &UnresolvedExtensionType{
-						ExtensionType: 12,
-						Data:          []byte(t),
-					}, nil
-				} else {
-					return obj, nil
-				}
-			},
-			func(obj any) (any, error) {
-				if _, ok := obj.(*testMarshalType2); ok {
-					return &UnresolvedExtensionType{
-						ExtensionType: 42,
-						Data:          []byte("hi"),
-					}, nil
-				} else {
-					return obj, nil
-				}
-			},
-			func(obj any) (any, error) {
-				if t, ok := obj.(testMarshalType4); ok {
-					return testMarshalType5(t), nil
-				} else {
-					return obj, nil
-				}
-			},
-			func(obj any) (any, error) {
-				if t, ok := obj.(testMarshalType5); ok {
-					return int(t), nil
-				} else {
-					return obj, nil
-				}
-			},
-		),
+		ApplicationMarshalTransformer: ComposeMarshalTransformers(applicationMarshalTransformers...),
 	}
 	testMarshal(t, opts, commonMarshalTestCases)
 	testMarshal(t, opts, applicationMarshalTransformerMarshalTestCases)
@@ -953,6 +997,57 @@ func TestMarshal_applicationMarshalTransformer(t *testing.T) {
 	testMarshalWriteError(t, opts, defaultOptsMarshalWriteErrorTestCases)
 }
 
+func TestMarshal_applicationMarshalTransformers(t *testing.T) {
+	for _, opts := range []*MarshalOptions{
+		{ApplicationMarshalTransformers: applicationMarshalTransformers},
+		// ApplicationMarshalTransformer is run first.
+		{
+			ApplicationMarshalTransformer:  applicationMarshalTransformers[0],
+			ApplicationMarshalTransformers: applicationMarshalTransformers[1:],
+		},
+	} {
+		testMarshal(t, opts, commonMarshalTestCases)
+		testMarshal(t, opts, applicationMarshalTransformerMarshalTestCases)
+		testMarshalWriteError(t, opts, commonMarshalWriteErrorTestCases)
+		testMarshalWriteError(t, opts, defaultOptsMarshalWriteErrorTestCases)
+	}
+
+	// They're run in order (each on the result of the previous one), and before the standard
+	// marshal transformer.
+	var toTime MarshalTransformerFn = func(obj any) (any, error) {
+		if s, ok := obj.(string); ok && s == "epoch" {
+			return time.Unix(0, 0), nil
+		}
+		return obj, nil
+	}
+	var toString MarshalTransformerFn = func(obj any) (any, error) {
+		if i, ok := obj.(int); ok {
+			return strconv.Itoa(i), nil
+		}
+		return obj, nil
+	}
+	var toEpoch MarshalTransformerFn = func(obj any) (any, error) {
+		if s, ok := obj.(string); ok && s == "0" {
+			return "epoch", nil
+		}
+		return obj, nil
+	}
+	opts := &MarshalOptions{ApplicationMarshalTransformers: []MarshalTransformerFn{toString, toEpoch, toTime}}
+	if encoded, err := MarshalToBytes(opts, []any{0, 1}); err != nil || !bytes.Equal(encoded, []byte{0x92, 0xd6, 0xff, 0, 0, 0, 0, 0xa1, 0x31}) {
+		t.Errorf("Unexpected result: %v, %v", encoded, err)
+	}
+	opts = &MarshalOptions{ApplicationMarshalTransformers: []MarshalTransformerFn{toTime, toEpoch, toString}}
+	if encoded, err := MarshalToBytes(opts, []any{0, 1}); err != nil || !bytes.Equal(encoded, []byte{0x92, 0xa1, 0x30, 0xa1, 0x31}) {
+		t.Errorf("Unexpected result: %v, %v", encoded, err)
+	}
+
+	// They disable the map[string]string fast path (since they may transform keys and values).
+	opts = &MarshalOptions{ApplicationMarshalTransformers: []MarshalTransformerFn{toEpoch}}
+	if encoded, err := MarshalToBytes(opts, map[string]string{"0": "1"}); err != nil || !bytes.Equal(encoded, []byte{0x81, 0xa5, 0x65, 0x70, 0x6f, 0x63, 0x68, 0xa1, 0x31}) {
+		t.Errorf("Unexpected result: %v, %v", encoded, err)
+	}
+}
+
 func TestMarshalToBytes(t *testing.T) {
 	opts := &MarshalOptions{
 		ApplicationMarshalTransformer: func(obj any) (any, error) {
@@ -971,11 +1066,149 @@ func TestMarshalToBytes(t *testing.T) {
 		t.Errorf("Unexpected result from MarshalToBytes: %v, %v", encoded, err)
 	}
 
-	if encoded, err := MarshalToBytes(opts, &testMarshalType2{}); err != UnsupportedTypeForMarshallingError {
+	if encoded, err := MarshalToBytes(opts, &testMarshalType2{}); !errors.Is(err, UnsupportedTypeForMarshallingError) {
 		t.Errorf("Unexpected result from MarshalToBytes: %v, %v", encoded, err)
 	}
 }
 
+func TestMarshalToBytesWithCap(t *testing.T) {
+	obj := []any{"hello", 123, fillerBytes(100)}
+	expected, err := MarshalToBytes(nil, obj)
+	if err != nil {
+		t.Fatalf("MarshalToBytes failed: %v", err)
+	}
+
+	// (A negative capacity is treated as 0.)
+	for _, c := range []int{-1, 0, 1, len(expected), 1000} {
+		if encoded, err := MarshalToBytesWithCap(nil, obj, c); err != nil || !bytes.Equal(encoded, expected) {
+			t.Errorf("Unexpected result from MarshalToBytesWithCap(cap=%v): %v, %v", c, encoded, err)
+		} else if c >= len(expected) && cap(encoded) != c {
+			t.Errorf("Unexpected capacity from MarshalToBytesWithCap(cap=%v): %v", c, cap(encoded))
+		}
+	}
+
+	if encoded, err := MarshalToBytesWithCap(nil, &testMarshalType2{}, 100); !errors.Is(err, UnsupportedTypeForMarshallingError) {
+		t.Errorf("Unexpected result from MarshalToBytesWithCap: %v, %v", encoded, err)
+	}
+}
+
+func TestMarshalAll(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := MarshalAll(nil, buf, nil); err != nil || buf.Len() != 0 {
+		t.Errorf("Unexpected result from MarshalAll: %v, %v", buf.Bytes(), err)
+	}
+
+	buf.Reset()
+	if err := MarshalAll(nil, buf, []any{42, "hi", []any{1, 2}}); err != nil {
+		t.Errorf("Unexpected error from MarshalAll: %v", err)
+	} else if expected := []byte{0x2a, 0xa2, 0x68, 0x69, 0x92, 0x01, 0x02}; !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Unexpected result from MarshalAll: %v (expected %v)", buf.Bytes(), expected)
+	}
+
+	// Each object is top-level (so MapKeyOrder applies to each).
+	buf.Reset()
+	opts := &MarshalOptions{MapKeyOrder: []any{"b", "a"}}
+	objs := []any{map[string]any{"a": 1, "b": 2}, map[string]any{"a": 3, "b": 4}}
+	if err := MarshalAll(opts, buf, objs); err != nil {
+		t.Errorf("Unexpected error from MarshalAll: %v", err)
+	} else if expected := []byte{0x82, 0xa1, 0x62, 0x02, 0xa1, 0x61, 0x01, 0x82, 0xa1, 0x62, 0x04, 0xa1, 0x61, 0x03}; !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Unexpected result from MarshalAll: %v (expected %v)", buf.Bytes(), expected)
+	}
+
+	// It stops at the first error, having written the objects before it.
+	buf.Reset()
+	if err := MarshalAll(nil, buf, []any{1, 2, &testMarshalType2{}, 3}); !errors.Is(err, UnsupportedTypeForMarshallingError) {
+		t.Errorf("Unexpected error from MarshalAll: %v", err)
+	} else if expected := []byte{0x01, 0x02}; !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Unexpected result from MarshalAll: %v (expected %v)", buf.Bytes(), expected)
+	}
+}
+
+func TestMarshalExtension(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 4, 8, 16, 17, 255, 256, 65535, 65536} {
+		data := fillerBytes(n)
+		expected, err := MarshalToBytes(nil, &UnresolvedExtensionType{ExtensionType: -12, Data: data})
+		if err != nil {
+			t.Fatalf("MarshalToBytes failed: %v", err)
+		}
+		buf := &bytes.Buffer{}
+		if err := MarshalExtension(buf, -12, data); err != nil || !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("Unexpected result from MarshalExtension for len(data)=%v: %v, %v", n, buf.Bytes(), err)
+		}
+	}
+
+	// DefaultMarshalOptions doesn't apply.
+	saved := *DefaultMarshalOptions
+	called := false
+	DefaultMarshalOptions.OnWrite = func(byte, int) { called = true }
+	err := MarshalExtension(io.Discard, 1, []byte{1, 2})
+	*DefaultMarshalOptions = saved
+	if err != nil || called {
+		t.Errorf("Unexpected result from MarshalExtension: %v (called=%v)", err, called)
+	}
+
+	// Write errors are returned.
+	for errAt := 0; errAt < 4; errAt += 1 {
+		if err := MarshalExtension(&limitedDiscardWriter{errAt}, 1, []byte{1, 2}); err != io.ErrShortWrite {
+			t.Errorf("Unexpected result from MarshalExtension (errAt=%v): %v", errAt, err)
+		}
+	}
+}
+
+// Tests that concurrent (and nested) uses of MarshalToBytes, with different options, don't interfere
+// with each other (marshallers are pooled).
+func TestMarshalToBytes_concurrent(t *testing.T) {
+	base64Opts := &MarshalOptions{BytesToBase64String: true}
+	nestedOpts := &MarshalOptions{
+		ApplicationMarshalTransformer: func(obj any) (any, error) {
+			if t, ok := obj.(testMarshalType1); ok {
+				data, err := MarshalToBytes(nil, []byte(t))
+				if err != nil {
+					return nil, err
+				}
+				return &UnresolvedExtensionType{ExtensionType: 12, Data: data}, nil
+			} else {
+				return obj, nil
+			}
+		},
+	}
+	testCases := []struct {
+		opts     *MarshalOptions
+		obj      any
+		expected []byte
+	}{
+		{nil, []byte("hi"), []byte{0xc4, 0x02, 0x68, 0x69}},
+		{base64Opts, []byte("hi"), []byte{0xa4, 0x61, 0x47, 0x6b, 0x3d}},
+		{nestedOpts, testMarshalType1("hi"), []byte{0xd6, 0x0c, 0xc4, 0x02, 0x68, 0x69}},
+	}
+
+	const numGoroutines = 8
+	const numIterations = 1000
+	var wg sync.WaitGroup
+	errs := make(chan error, numGoroutines)
+	for g := 0; g < numGoroutines; g += 1 {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < numIterations; i += 1 {
+				tc := testCases[(g+i)%len(testCases)]
+				if encoded, err := MarshalToBytes(tc.opts, tc.obj); err != nil {
+					errs <- err
+					return
+				} else if !bytes.Equal(encoded, tc.expected) {
+					errs <- fmt.Errorf("unexpected result for %v: %v (expected %v)", tc.obj, encoded, tc.expected)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
 func TestComposeMarshalTransformers(t *testing.T) {
 	err1 := errors.New("err1")
 	// int -> string, else err1.
@@ -1016,6 +1249,56 @@ func TestComposeMarshalTransformers(t *testing.T) {
 	}
 }
 
+type testExtensionPoint struct {
+	X, Y int8
+}
+
+func TestMakeExtensionTypeMarshalTransformer(t *testing.T) {
+	errNegative := errors.New("negative")
+	xform := MakeExtensionTypeMarshalTransformer(map[reflect.Type]MarshalExtensionTypeFn{
+		reflect.TypeOf(time.Duration(0)): func(obj any) (int8, []byte, error) {
+			d := obj.(time.Duration)
+			if d < 0 {
+				return 0, nil, errNegative
+			}
+			return 1, []byte(d.String()), nil
+		},
+		reflect.TypeOf(testExtensionPoint{}): func(obj any) (int8, []byte, error) {
+			p := obj.(testExtensionPoint)
+			return 2, []byte{byte(p.X), byte(p.Y)}, nil
+		},
+	})
+
+	testCases := []struct {
+		obj      any
+		expected any
+		err      error
+	}{
+		{obj: 2 * time.Second, expected: &UnresolvedExtensionType{ExtensionType: 1, Data: []byte("2s")}},
+		{obj: -time.Second, err: errNegative},
+		{obj: testExtensionPoint{X: 1, Y: -1}, expected: &UnresolvedExtensionType{ExtensionType: 2, Data: []byte{0x01, 0xff}}},
+		// Types must match exactly, so these are left as-is.
+		{obj: nil, expected: nil},
+		{obj: int64(123), expected: int64(123)},
+		{obj: &testExtensionPoint{X: 1, Y: 2}, expected: &testExtensionPoint{X: 1, Y: 2}},
+	}
+	for i, tC := range testCases {
+		if obj, err := xform(tC.obj); err != tC.err || !reflect.DeepEqual(obj, tC.expected) {
+			t.Errorf("%v: unexpected result: %#v, %v", i, obj, err)
+		}
+	}
+
+	// Through Marshal, including nested.
+	opts := &MarshalOptions{ApplicationMarshalTransformer: xform}
+	expected := []byte{0x92, 0xd5, 0x02, 0x03, 0x04, 0xd5, 0x01, 0x32, 0x73}
+	if encoded, err := MarshalToBytes(opts, []any{testExtensionPoint{X: 3, Y: 4}, 2 * time.Second}); err != nil || !bytes.Equal(encoded, expected) {
+		t.Errorf("Unexpected result: %v, %v", encoded, err)
+	}
+	if _, err := MarshalToBytes(opts, []any{-time.Second}); err != errNegative {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
 func TestTimestampExtensionMarshalTransformer(t *testing.T) {
 	if obj, err := TimestampExtensionMarshalTransformer("hi"); err != nil || obj != "hi" {
 		t.Errorf("Unexpected result: %#v, %v", obj, err)
@@ -1060,3 +1343,1103 @@ func TestTimestampExtensionMarshalTransformer(t *testing.T) {
 		}
 	}
 }
+
+func TestMarshal_preEncoded(t *testing.T) {
+	// A pre-encoded map {"foo": 123} (validated below).
+	preEncoded := PreEncoded{0x81, 0xa3, 0x66, 0x6f, 0x6f, 0x7b}
+	if decoded, n, err := UnmarshalBytesN(nil, preEncoded); err != nil || n != len(preEncoded) || !reflect.DeepEqual(decoded, map[any]any{"foo": 123}) {
+		t.Fatalf("Invalid pre-encoded data: %#v, %v, %v", decoded, n, err)
+	}
+
+	testCases := []marshalTestCase{
+		{obj: preEncoded, encoded: []byte{0x81, 0xa3, 0x66, 0x6f, 0x6f, 0x7b}},
+		{obj: PreEncoded{}, encoded: []byte{}},
+		{obj: []any{1, preEncoded, "hi"}, encoded: []byte{0x93, 0x01, 0x81, 0xa3, 0x66, 0x6f, 0x6f, 0x7b, 0xa2, 0x68, 0x69}},
+		{obj: []PreEncoded{preEncoded, {0xc0}}, encoded: []byte{0x92, 0x81, 0xa3, 0x66, 0x6f, 0x6f, 0x7b, 0xc0}},
+		{obj: map[string]any{"bar": preEncoded}, encoded: []byte{0x81, 0xa3, 0x62, 0x61, 0x72, 0x81, 0xa3, 0x66, 0x6f, 0x6f, 0x7b}},
+	}
+	testMarshal(t, nil, testCases)
+
+	testMarshalWriteError(t, nil, []marshalWriteErrorTestCase{
+		{obj: preEncoded, errAt: 0},
+		{obj: preEncoded, errAt: 5},
+		{obj: []any{preEncoded}, errAt: 1},
+	})
+}
+
+func TestMarshal_transformersAppliedToNestedObjects(t *testing.T) {
+	// The transformer is run on every object (elements, keys, values, and pointer targets), not
+	// just the top-level one.
+	var seen []any
+	opts := &MarshalOptions{
+		ApplicationMarshalTransformer: func(obj any) (any, error) {
+			seen = append(seen, obj)
+			if t, ok := obj.(testMarshalType5); ok {
+				return int(t) * 10, nil
+			}
+			return obj, nil
+		},
+	}
+	five := testMarshalType5(5)
+	obj := []any{testMarshalType5(1), map[any]any{testMarshalType5(2): testMarshalType5(3)}, &five}
+	expected := []byte{0x93, 0x0a, 0x81, 0x14, 0x1e, 0x32}
+	if encoded, err := MarshalToBytes(opts, obj); err != nil || !bytes.Equal(encoded, expected) {
+		t.Errorf("Unexpected result: %v, %v", encoded, err)
+	}
+	// The array, its 3 elements, the map's key and value, and the pointer's target.
+	if len(seen) != 7 {
+		t.Errorf("Unexpected objects seen by transformer: %#v", seen)
+	}
+}
+
+func TestMarshal_replacedStandardMarshalTransformer(t *testing.T) {
+	var seen []any
+	saved := StandardMarshalTransformer
+	StandardMarshalTransformer = func(obj any) (any, error) {
+		seen = append(seen, obj)
+		return saved(obj)
+	}
+	defer func() { StandardMarshalTransformer = saved }()
+
+	obj := []any{1, uint8(2), 1.5, true, "a", testMarshalType5(5), time.Unix(0, 0)}
+	if _, err := MarshalToBytes(nil, obj); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// A replaced standard transformer sees every object (including built-in scalars).
+	if len(seen) != 8 {
+		t.Errorf("Unexpected objects seen by standard transformer: %#v", seen)
+	}
+
+	// Including the values of map[string]any and the keys and values of (otherwise fast-pathed)
+	// map[string]string and map[string]int.
+	StandardMarshalTransformer = func(obj any) (any, error) {
+		switch v := obj.(type) {
+		case string:
+			return strings.ToUpper(v), nil
+		case int:
+			return v + 1, nil
+		}
+		return obj, nil
+	}
+	testMarshal(t, nil, []marshalTestCase{
+		{obj: map[string]any{"k": "v"}, encoded: []byte{0x81, 0xa1, 0x6b, 0xa1, 0x56}},
+		{obj: map[string]string{"k": "v"}, encoded: []byte{0x81, 0xa1, 0x4b, 0xa1, 0x56}},
+		{obj: map[string]int{"k": 1}, encoded: []byte{0x81, 0xa1, 0x4b, 0x02}},
+	})
+	StandardMarshalTransformer = saved
+
+	// Application transformers are still run on built-in scalars.
+	opts := &MarshalOptions{
+		ApplicationMarshalTransformer: func(obj any) (any, error) {
+			if i, ok := obj.(int); ok {
+				return i * 10, nil
+			}
+			return obj, nil
+		},
+	}
+	if encoded, err := MarshalToBytes(opts, 1); err != nil || !bytes.Equal(encoded, []byte{0x0a}) {
+		t.Errorf("Unexpected result: %v, %v", encoded, err)
+	}
+}
+
+func TestMarshal_maxTransformDepth(t *testing.T) {
+	// A transformer loop: testMarshalType5 -> []any{testMarshalType5}.
+	wrap := func(obj any) (any, error) {
+		if t, ok := obj.(testMarshalType5); ok {
+			return []any{t}, nil
+		}
+		return obj, nil
+	}
+	opts := &MarshalOptions{ApplicationMarshalTransformer: wrap, MaxTransformDepth: 100}
+	if _, err := MarshalToBytes(opts, testMarshalType5(1)); err != TransformLoopError {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := MarshalToBytes(opts, map[string]any{"a": []any{testMarshalType5(1)}}); err != TransformLoopError {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// Similarly, for a transformer that produces a pointer to something that it transforms.
+	opts = &MarshalOptions{
+		ApplicationMarshalTransformer: func(obj any) (any, error) {
+			if t, ok := obj.(testMarshalType5); ok {
+				return &t, nil
+			}
+			return obj, nil
+		},
+		MaxTransformDepth: 100,
+	}
+	if _, err := MarshalToBytes(opts, testMarshalType5(1)); err != TransformLoopError {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// Objects that are no deeper than MaxTransformDepth are fine.
+	testCases := []struct {
+		obj   any
+		depth int
+	}{
+		{obj: 1, depth: 1},
+		{obj: []any{}, depth: 1},
+		{obj: []any{1, 2}, depth: 2},
+		{obj: map[any]any{1: []any{2}}, depth: 3},
+		{obj: []any{[]any{[]any{}}, 1}, depth: 3},
+	}
+	for i, tC := range testCases {
+		opts := &MarshalOptions{MaxTransformDepth: tC.depth}
+		if _, err := MarshalToBytes(opts, tC.obj); err != nil {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		}
+		opts = &MarshalOptions{MaxTransformDepth: tC.depth - 1}
+		if _, err := MarshalToBytes(opts, tC.obj); err != nil && tC.depth == 1 {
+			// (MaxTransformDepth 0 means the default limit.)
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if err != TransformLoopError && tC.depth > 1 {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestMarshal_maxTransformDepthDefault(t *testing.T) {
+	// By default, transformer loops are caught.
+	wrap := func(obj any) (any, error) {
+		if t, ok := obj.(testMarshalType5); ok {
+			return []any{t}, nil
+		}
+		return obj, nil
+	}
+	if _, err := MarshalToBytes(&MarshalOptions{ApplicationMarshalTransformer: wrap}, testMarshalType5(1)); err != TransformLoopError {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// The default limit is 10000.
+	nested := func(depth int) any {
+		var obj any = 1
+		for i := 1; i < depth; i += 1 {
+			obj = []any{obj}
+		}
+		return obj
+	}
+	if _, err := MarshalToBytes(nil, nested(10000)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := MarshalToBytes(nil, nested(10001)); err != TransformLoopError {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// A negative value means no limit.
+	if _, err := MarshalToBytes(&MarshalOptions{MaxTransformDepth: -1}, nested(20000)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestMarshal_mapKeyOrder(t *testing.T) {
+	obj := map[string]any{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}
+
+	// All keys listed (with some that aren't present, or of the wrong type, or repeated).
+	opts := &MarshalOptions{MapKeyOrder: []any{"c", "x", "a", 12, "e", "b", "a", nil, "d"}}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: obj, encoded: []byte{0x85, 0xa1, 0x63, 0x03, 0xa1, 0x61, 0x01, 0xa1, 0x65, 0x05, 0xa1, 0x62, 0x02, 0xa1, 0x64, 0x04}},
+		// Only applies to the top-level map.
+		{obj: []any{map[string]any{"a": 1}}, encoded: []byte{0x91, 0x81, 0xa1, 0x61, 0x01}},
+		{obj: map[any]any{"b": map[any]any{"a": 1}, 12: 34, "c": 5}, encoded: []byte{0x83, 0xa1, 0x63, 0x05, 0x0c, 0x22, 0xa1, 0x62, 0x81, 0xa1, 0x61, 0x01}},
+		{obj: 123, encoded: []byte{0x7b}},
+	})
+
+	// Some keys unlisted, sorted.
+	opts = &MarshalOptions{MapKeyOrder: []any{"c", "a"}, SortUnlistedMapKeys: true}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: obj, encoded: []byte{0x85, 0xa1, 0x63, 0x03, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0x02, 0xa1, 0x64, 0x04, 0xa1, 0x65, 0x05}},
+		{obj: map[int]string{3: "x", 1: "y", 2: "z"}, encoded: []byte{0x83, 0x01, 0xa1, 0x79, 0x02, 0xa1, 0x7a, 0x03, 0xa1, 0x78}},
+		{obj: map[string]int{}, encoded: []byte{0x80}},
+	})
+
+	// Some keys unlisted, not sorted.
+	opts = &MarshalOptions{MapKeyOrder: []any{"e", "d"}}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: obj, encoded: []byte{0x85, 0xa1, 0x65, 0x05, 0xa1, 0x64, 0x04}, prefix: true, decoded: map[any]any{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}},
+	})
+
+	// Applies after transformers (e.g., for structs).
+	opts = &MarshalOptions{
+		ApplicationMarshalTransformer: DefaultStructMarshalTransformer,
+		MapKeyOrder:                   []any{"Foo", "Bar"},
+	}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: struct {
+			Bar int
+			Foo string
+		}{1, "x"}, encoded: []byte{0x82, 0xa3, 0x46, 0x6f, 0x6f, 0xa1, 0x78, 0xa3, 0x42, 0x61, 0x72, 0x01}},
+	})
+}
+
+func TestMarshal_mapKeyOrderNonComparableKeys(t *testing.T) {
+	// Non-comparable listed keys are skipped (rather than panicking).
+	opts := &MarshalOptions{MapKeyOrder: []any{[]int{1}, [1]any{[]int{1}}, "a"}}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: map[any]any{"a": 1}, encoded: []byte{0x81, 0xa1, 0x61, 0x01}},
+	})
+}
+
+func TestMarshal_mapKeyOrderMaxTransformDepth(t *testing.T) {
+	// Transforms n > 0 to []any{n-1}, so n is nested n levels deep.
+	nest := func(obj any) (any, error) {
+		if n, ok := obj.(int); ok && n > 0 {
+			return []any{n - 1}, nil
+		}
+		return obj, nil
+	}
+	// The map is at depth 1, the key 3 at depth 2, and the innermost 0 at depth 5.
+	obj := map[any]any{3: "x"}
+	for _, sort := range []bool{false, true} {
+		opts := &MarshalOptions{
+			ApplicationMarshalTransformer: nest,
+			MapKeyOrder:                   []any{},
+			SortUnlistedMapKeys:           sort,
+			MaxTransformDepth:             4,
+		}
+		if _, err := MarshalToBytes(opts, obj); err != TransformLoopError {
+			t.Errorf("Unexpected error (sort=%v): %v", sort, err)
+		}
+		opts.MaxTransformDepth = 5
+		if _, err := MarshalToBytes(opts, obj); err != nil {
+			t.Errorf("Unexpected error (sort=%v): %v", sort, err)
+		}
+	}
+}
+
+func TestMarshal_orderedMap(t *testing.T) {
+	testCases := []struct {
+		obj      any
+		expected []byte
+	}{
+		{obj: (*OrderedMap)(nil), expected: []byte{0xc0}},
+		{obj: &OrderedMap{}, expected: []byte{0x80}},
+		{
+			obj:      &OrderedMap{Keys: []any{"b", 3, "a"}, Values: map[any]any{"a": 3, 3: 2, "b": 1}},
+			expected: []byte{0x83, 0xa1, 0x62, 0x01, 0x03, 0x02, 0xa1, 0x61, 0x03},
+		},
+		{
+			obj:      []any{&OrderedMap{Keys: []any{2}, Values: map[any]any{2: &OrderedMap{Keys: []any{1}, Values: map[any]any{1: nil}}}}},
+			expected: []byte{0x91, 0x81, 0x02, 0x81, 0x01, 0xc0},
+		},
+	}
+	for i, tC := range testCases {
+		if encoded, err := MarshalToBytes(nil, tC.obj); err != nil || !bytes.Equal(encoded, tC.expected) {
+			t.Errorf("%v: unexpected result: %v, %v", i, encoded, err)
+		}
+	}
+
+	if _, err := MarshalToBytes(nil, &OrderedMap{Keys: []any{math.NaN()}, Values: map[any]any{}}); err != InvalidMapKeyError {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestMarshal_bytesToBase64String(t *testing.T) {
+	opts := &MarshalOptions{BytesToBase64String: true}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: []byte("hello"), encoded: []byte{0xa8, 0x61, 0x47, 0x56, 0x73, 0x62, 0x47, 0x38, 0x3d}},
+		{obj: []byte{}, encoded: []byte{0xa0}},
+		{obj: []any{[]byte{0xff}}, encoded: []byte{0x91, 0xa4, 0x2f, 0x77, 0x3d, 0x3d}},
+		// Strings are unaffected.
+		{obj: "hi", encoded: []byte{0xa2, 0x68, 0x69}},
+	})
+}
+
+// testTextType is a []byte type that's always text.
+type testTextType []byte
+
+func (testTextType) AsText() bool { return true }
+
+// testMaybeTextType is a []byte type that's text if its first byte is not 0.
+type testMaybeTextType []byte
+
+func (x testMaybeTextType) AsText() bool { return len(x) == 0 || x[0] != 0 }
+
+// testNotBytesTextType is not a []byte type, so it's not marshalled as text.
+type testNotBytesTextType []int
+
+func (testNotBytesTextType) AsText() bool { return true }
+
+func TestMarshal_nilCollectionsAsNil(t *testing.T) {
+	nilCollectionsTestCases := []any{
+		[]any(nil),
+		[]int(nil),
+		[]byte(nil),
+		map[any]any(nil),
+		map[string]any(nil),
+		map[string]string(nil),
+		map[int]float64(nil),
+	}
+	for _, obj := range nilCollectionsTestCases {
+		if encoded, err := MarshalToBytes(&MarshalOptions{NilCollectionsAsNil: true}, obj); err != nil || !bytes.Equal(encoded, []byte{0xc0}) {
+			t.Errorf("Unexpected result for obj=%#v: %v, %v", obj, encoded, err)
+		}
+		// Without the option, they're marshalled as empty.
+		if encoded, err := MarshalToBytes(nil, obj); err != nil || len(encoded) == 0 || encoded[0] == 0xc0 {
+			t.Errorf("Unexpected result without option for obj=%#v: %v, %v", obj, encoded, err)
+		}
+	}
+
+	opts := &MarshalOptions{NilCollectionsAsNil: true}
+	testMarshal(t, opts, []marshalTestCase{
+		// Non-nil empty collections are unaffected.
+		{obj: []any{}, encoded: []byte{0x90}},
+		{obj: []int{}, encoded: []byte{0x90}},
+		{obj: []byte{}, encoded: []byte{0xc4, 0x00}},
+		{obj: map[any]any{}, encoded: []byte{0x80}},
+		{obj: map[string]any{}, encoded: []byte{0x80}},
+		// Nested.
+		{obj: []any{[]int(nil), []int{}}, encoded: []byte{0x92, 0xc0, 0x90}},
+		{obj: map[string]any{"a": map[string]int(nil)}, encoded: []byte{0x81, 0xa1, 0x61, 0xc0}},
+	})
+
+	// Also with MapKeyOrder (for the top-level map).
+	testMarshal(t, &MarshalOptions{NilCollectionsAsNil: true, MapKeyOrder: []any{"a"}}, []marshalTestCase{
+		{obj: map[string]any(nil), encoded: []byte{0xc0}},
+	})
+}
+
+func TestMarshal_jsonCompatible(t *testing.T) {
+	opts := &MarshalOptions{JSONCompatible: true}
+	testMarshal(t, opts, []marshalTestCase{
+		// Unsigned integers are marshalled as signed.
+		{obj: uint(0), encoded: []byte{0x00}},
+		{obj: uint8(0xff), encoded: []byte{0xd1, 0x00, 0xff}},
+		{obj: uint64(math.MaxInt64), encoded: []byte{0xd3, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{obj: testMarshalNamedUint16(1), encoded: []byte{0x01}},
+		// ... or as float 64 if too large.
+		{obj: uint64(math.MaxUint64), encoded: []byte{0xcb, 0x43, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		// Signed integers are unaffected.
+		{obj: -1, encoded: []byte{0xff}},
+		// float32 is marshalled as float 64.
+		{obj: float32(1.5), encoded: []byte{0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		// []byte is marshalled as a base64 string.
+		{obj: []byte("hi"), encoded: []byte{0xa4, 0x61, 0x47, 0x6b, 0x3d}},
+		// String keys are fine.
+		{obj: map[string]any{"a": uint(1)}, encoded: []byte{0x81, 0xa1, 0x61, 0x01}},
+		{obj: map[any]any{"a": 1}, encoded: []byte{0x81, 0xa1, 0x61, 0x01}},
+		{obj: map[testMarshalType1]int{"a": 1}, encoded: []byte{0x81, 0xa1, 0x61, 0x01}},
+		// Non-string keys aren't.
+		{obj: map[any]any{1: "a"}, err: NonStringKeyForMarshallingError},
+		{obj: map[any]any{nil: "a"}, err: NonStringKeyForMarshallingError},
+		{obj: map[int]string{1: "a"}, err: NonStringKeyForMarshallingError},
+		{obj: []any{map[any]any{"a": map[any]any{true: 1}}}, err: NonStringKeyForMarshallingError},
+	})
+
+	// Keys are checked after transformers are applied.
+	opts = &MarshalOptions{
+		JSONCompatible: true,
+		ApplicationMarshalTransformer: func(obj any) (any, error) {
+			if i, ok := obj.(int); ok {
+				return strconv.Itoa(i), nil
+			}
+			return obj, nil
+		},
+	}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: map[int]bool{1: true}, encoded: []byte{0x81, 0xa1, 0x31, 0xc3}},
+	})
+
+	// Also with MapKeyOrder.
+	for _, sortUnlisted := range []bool{false, true} {
+		opts = &MarshalOptions{JSONCompatible: true, MapKeyOrder: []any{"a"}, SortUnlistedMapKeys: sortUnlisted}
+		testMarshal(t, opts, []marshalTestCase{
+			{obj: map[any]any{"a": 1, "b": 2}, encoded: []byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62, 0x02}},
+			{obj: map[any]any{"a": 1, 2: 3}, err: NonStringKeyForMarshallingError},
+		})
+	}
+}
+
+func TestMarshal_disableReflection(t *testing.T) {
+	opts := &MarshalOptions{DisableReflection: true}
+	testMarshal(t, opts, []marshalTestCase{
+		// Concretely-supported types.
+		{obj: nil, encoded: []byte{0xc0}},
+		{obj: int16(-1), encoded: []byte{0xff}},
+		{obj: "hi", encoded: []byte{0xa2, 0x68, 0x69}},
+		{obj: []any{1, true}, encoded: []byte{0x92, 0x01, 0xc3}},
+		{obj: map[string]any{"a": 1.5}, encoded: []byte{0x81, 0xa1, 0x61, 0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{obj: map[string]string{"a": "b"}, encoded: []byte{0x81, 0xa1, 0x61, 0xa1, 0x62}},
+		{obj: map[string]int{"a": 1}, encoded: []byte{0x81, 0xa1, 0x61, 0x01}},
+		{obj: map[any]any{math.NaN(): 1}, err: InvalidMapKeyError},
+		// Types that require reflection.
+		{obj: testMarshalType4(1), err: UnsupportedTypeForMarshallingError},
+		{obj: []int{1}, err: UnsupportedTypeForMarshallingError},
+		{obj: [1]any{1}, err: UnsupportedTypeForMarshallingError},
+		{obj: map[int]any{1: 2}, err: UnsupportedTypeForMarshallingError},
+		{obj: map[any]any{testMarshalNamedFloat64(math.NaN()): 1}, err: UnsupportedTypeForMarshallingError},
+		{obj: func() *int { v := 1; return &v }(), err: UnsupportedTypeForMarshallingError},
+		{obj: []any{testMarshalType1("x")}, err: UnsupportedTypeForMarshallingError},
+	})
+
+	// Transformers may handle such types.
+	opts = &MarshalOptions{
+		DisableReflection: true,
+		ApplicationMarshalTransformer: func(obj any) (any, error) {
+			if v, ok := obj.(testMarshalType4); ok {
+				return int(v), nil
+			}
+			return obj, nil
+		},
+	}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: testMarshalType4(1), encoded: []byte{0x01}},
+		{obj: []any{testMarshalType4(2)}, encoded: []byte{0x91, 0x02}},
+		{obj: []int{1}, err: UnsupportedTypeForMarshallingError},
+		// Concretely-supported map types are still supported with transformers.
+		{obj: map[string]string{"a": "b"}, encoded: []byte{0x81, 0xa1, 0x61, 0xa1, 0x62}},
+		{obj: map[string]int{"a": 1}, encoded: []byte{0x81, 0xa1, 0x61, 0x01}},
+	})
+
+	// Transformers are run on the keys and values of such maps.
+	opts = &MarshalOptions{
+		DisableReflection: true,
+		ApplicationMarshalTransformer: func(obj any) (any, error) {
+			if s, ok := obj.(string); ok {
+				return s + s, nil
+			}
+			return obj, nil
+		},
+	}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: map[string]string{"a": "b"}, encoded: []byte{0x81, 0xa2, 0x61, 0x61, 0xa2, 0x62, 0x62}},
+		{obj: map[string]int{"a": 1}, encoded: []byte{0x81, 0xa2, 0x61, 0x61, 0x01}},
+	})
+
+	// Marshalling concretely-supported types shouldn't allocate.
+	var obj any = []any{1, "hello", []any{true, nil}, map[string]any{"a": 1.5}}
+	opts = &MarshalOptions{DisableReflection: true}
+	if allocs := testing.AllocsPerRun(10, func() {
+		if err := Marshal(opts, io.Discard, obj); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}); allocs != 0 {
+		t.Errorf("unexpected number of allocations: %v", allocs)
+	}
+}
+
+func TestMarshal_unsupportedTypeError(t *testing.T) {
+	testCases := []struct {
+		obj     any
+		typ     reflect.Type
+		message string
+	}{
+		{make(chan int), reflect.TypeOf(make(chan int)), "Unsupported type for marshalling: chan int"},
+		{func() {}, reflect.TypeOf(func() {}), "Unsupported type for marshalling: func()"},
+		{[]any{1, unsafe.Pointer(nil)}, reflect.TypeOf(unsafe.Pointer(nil)), "Unsupported type for marshalling: unsafe.Pointer"},
+		{map[string]any{"a": []chan<- string{nil}}, reflect.TypeOf((chan<- string)(nil)), "Unsupported type for marshalling: chan<- string"},
+		{&testMarshalType2{}, reflect.TypeOf(testMarshalType2{}), "Unsupported type for marshalling: umsgpack_test.testMarshalType2"},
+	}
+	for i, tC := range testCases {
+		_, err := MarshalToBytes(nil, tC.obj)
+		var typeErr *UnsupportedTypeError
+		if !errors.Is(err, UnsupportedTypeForMarshallingError) || !errors.As(err, &typeErr) {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if typeErr.Type != tC.typ || err.Error() != tC.message {
+			t.Errorf("%v: unexpected error: %v (type: %v)", i, err, typeErr.Type)
+		}
+	}
+
+	// Also with DisableReflection.
+	_, err := MarshalToBytes(&MarshalOptions{DisableReflection: true}, []int{1})
+	var typeErr *UnsupportedTypeError
+	if !errors.As(err, &typeErr) || typeErr.Type != reflect.TypeOf([]int{}) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMarshal_binaryAsExtension(t *testing.T) {
+	opts := &MarshalOptions{BinaryAsExtension: true, BinaryExtensionType: 7}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: []byte{}, encoded: []byte{0xc7, 0x00, 0x07}},
+		{obj: []byte{0x01}, encoded: []byte{0xd4, 0x07, 0x01}},
+		{obj: []byte{0x01, 0x02, 0x03}, encoded: []byte{0xc7, 0x03, 0x07, 0x01, 0x02, 0x03}},
+		{obj: [2]byte{'h', 'i'}, encoded: []byte{0xd5, 0x07, 0x68, 0x69}},
+		{obj: testMarshalBlob("hi"), encoded: []byte{0xd5, 0x07, 0x68, 0x69}},
+		{obj: []any{[]byte{0x2a}}, encoded: []byte{0x91, 0xd4, 0x07, 0x2a}},
+		// Strings and extensions are unaffected.
+		{obj: "hi", encoded: []byte{0xa2, 0x68, 0x69}},
+		{obj: &UnresolvedExtensionType{ExtensionType: 8, Data: []byte{0x01}}, encoded: []byte{0xd4, 0x08, 0x01}},
+	})
+
+	// It takes precedence over BytesToBase64String.
+	opts = &MarshalOptions{BinaryAsExtension: true, BinaryExtensionType: -2, BytesToBase64String: true}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: []byte{0x01}, encoded: []byte{0xd4, 0xfe, 0x01}},
+	})
+
+	// BinaryExtensionType alone has no effect.
+	testMarshal(t, &MarshalOptions{BinaryExtensionType: 7}, []marshalTestCase{
+		{obj: []byte{0x01}, encoded: []byte{0xc4, 0x01, 0x01}},
+	})
+}
+
+func TestMarshal_fixedIntWidth(t *testing.T) {
+	testCases := []struct {
+		width    int
+		obj      any
+		expected []byte
+		err      error
+	}{
+		{width: 8, obj: 1, expected: []byte{0xd0, 0x01}},
+		{width: 8, obj: int8(-1), expected: []byte{0xd0, 0xff}},
+		{width: 8, obj: uint(1), expected: []byte{0xcc, 0x01}},
+		{width: 8, obj: 128, err: IntTooWideError},
+		{width: 8, obj: -129, err: IntTooWideError},
+		{width: 8, obj: uint16(256), err: IntTooWideError},
+		{width: 16, obj: 1, expected: []byte{0xd1, 0x00, 0x01}},
+		{width: 16, obj: -129, expected: []byte{0xd1, 0xff, 0x7f}},
+		{width: 16, obj: uint8(255), expected: []byte{0xcd, 0x00, 0xff}},
+		{width: 16, obj: 32768, err: IntTooWideError},
+		{width: 16, obj: uint(65536), err: IntTooWideError},
+		{width: 32, obj: 1, expected: []byte{0xd2, 0x00, 0x00, 0x00, 0x01}},
+		{width: 32, obj: uint32(math.MaxUint32), expected: []byte{0xce, 0xff, 0xff, 0xff, 0xff}},
+		{width: 32, obj: int64(math.MinInt32 - 1), err: IntTooWideError},
+		{width: 32, obj: uint64(math.MaxUint32 + 1), err: IntTooWideError},
+		{width: 64, obj: 0, expected: []byte{0xd3, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{width: 64, obj: -1, expected: []byte{0xd3, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{width: 64, obj: uint64(math.MaxUint64), expected: []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		// Also applies to named integer types and integers in containers (including map fast paths).
+		{width: 16, obj: testMarshalType5(2), expected: []byte{0xd1, 0x00, 0x02}},
+		{width: 16, obj: []any{1, uint(2)}, expected: []byte{0x92, 0xd1, 0x00, 0x01, 0xcd, 0x00, 0x02}},
+		{width: 16, obj: map[string]int{"a": 1}, expected: []byte{0x81, 0xa1, 0x61, 0xd1, 0x00, 0x01}},
+		// Non-integers are unaffected.
+		{width: 64, obj: []any{"a", 1.5, true}, expected: []byte{0x93, 0xa1, 0x61, 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0, 0xc3}},
+		// Invalid widths.
+		{width: 1, obj: 1, err: InvalidFixedIntWidthError},
+		{width: -8, obj: uint(1), err: InvalidFixedIntWidthError},
+		{width: 1, obj: "a", expected: []byte{0xa1, 0x61}},
+	}
+	for i, tC := range testCases {
+		opts := &MarshalOptions{FixedIntWidth: tC.width}
+		if encoded, err := MarshalToBytes(opts, tC.obj); err != tC.err {
+			t.Errorf("%v: unexpected error: %v (expected: %v)", i, err, tC.err)
+		} else if err == nil && !bytes.Equal(encoded, tC.expected) {
+			t.Errorf("%v: unexpected result: %v (expected: %v)", i, encoded, tC.expected)
+		}
+	}
+
+	// The results are unmarshalled to the same values (though they're not minimal).
+	opts := &MarshalOptions{FixedIntWidth: 32}
+	obj := []any{int(-1), uint(1), int(1 << 20)}
+	if encoded, err := MarshalToBytes(opts, obj); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if decoded, err := UnmarshalBytes(nil, encoded); err != nil || !reflect.DeepEqual(decoded, obj) {
+		t.Errorf("unexpected result: %#v, %v", decoded, err)
+	}
+}
+
+func TestMarshal_onWrite(t *testing.T) {
+	type format struct {
+		formatByte byte
+		n          int
+	}
+	testCases := []struct {
+		opts    *MarshalOptions
+		obj     any
+		formats []format
+	}{
+		{obj: nil, formats: []format{{0xc0, 1}}},
+		{obj: int64(-1000), formats: []format{{0xd1, 3}}},
+		{obj: strings.Repeat("x", 300), formats: []format{{0xda, 303}}},
+		{
+			obj:     []any{1, "hi", []byte{1, 2}, map[string]any{"a": 1.5}},
+			formats: []format{{0x94, 1}, {0x01, 1}, {0xa2, 3}, {0xc4, 4}, {0x81, 1}, {0xa1, 2}, {0xcb, 9}},
+		},
+		// Extensions (the extension type is included).
+		{obj: &UnresolvedExtensionType{ExtensionType: 5, Data: []byte{1}}, formats: []format{{0xd4, 3}}},
+		{obj: &UnresolvedExtensionType{ExtensionType: 5, Data: []byte{1, 2, 3}}, formats: []format{{0xc7, 6}}},
+		{obj: time.Unix(0, 0), formats: []format{{0xd6, 6}}},
+		// Pre-encoded data is reported as a single format.
+		{obj: []any{PreEncoded{0x92, 0x01, 0x02}, RawMessage{0xc3}}, formats: []format{{0x92, 1}, {0x92, 3}, {0xc3, 1}}},
+		// Sorted map keys (which are marshalled first) are still reported once, when written.
+		{
+			opts:    &MarshalOptions{MapKeyOrder: []any{}, SortUnlistedMapKeys: true},
+			obj:     map[string]int{"b": 2, "a": 1},
+			formats: []format{{0x82, 1}, {0xa1, 2}, {0x01, 1}, {0xa1, 2}, {0x02, 1}},
+		},
+		{
+			opts:    &MarshalOptions{MapKeyOrder: []any{}, SortUnlistedMapKeys: true},
+			obj:     map[any]any{[1]int{2}: nil, "a": 1},
+			formats: []format{{0x82, 1}, {0x91, 1}, {0x02, 1}, {0xc0, 1}, {0xa1, 2}, {0x01, 1}},
+		},
+	}
+	for i, tC := range testCases {
+		var formats []format
+		opts := &MarshalOptions{}
+		if tC.opts != nil {
+			*opts = *tC.opts
+		}
+		opts.OnWrite = func(formatByte byte, n int) {
+			formats = append(formats, format{formatByte, n})
+		}
+
+		encoded, err := MarshalToBytes(opts, tC.obj)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(formats, tC.formats) {
+			t.Errorf("%v: unexpected formats: %v", i, formats)
+		}
+		// The output isn't affected, and all of it is accounted for.
+		opts.OnWrite = nil
+		if expected, _ := MarshalToBytes(opts, tC.obj); !bytes.Equal(encoded, expected) {
+			t.Errorf("%v: unexpected result: %v (expected: %v)", i, encoded, expected)
+		}
+		total := 0
+		for _, f := range formats {
+			total += f.n
+		}
+		if total != len(encoded) {
+			t.Errorf("%v: unexpected total: %v (expected: %v)", i, total, len(encoded))
+		}
+	}
+}
+
+func TestMarshal_rejectNonFiniteFloats(t *testing.T) {
+	nonFiniteFloats := []any{
+		float32(math.NaN()),
+		float32(math.Inf(1)),
+		float32(math.Inf(-1)),
+		math.NaN(),
+		math.Inf(1),
+		math.Inf(-1),
+	}
+
+	opts := &MarshalOptions{RejectNonFiniteFloats: true}
+	for _, obj := range nonFiniteFloats {
+		if encoded, err := MarshalToBytes(opts, obj); err != NonFiniteFloatError {
+			t.Errorf("Unexpected result for obj=%v: %v, %v", obj, encoded, err)
+		}
+		if encoded, err := MarshalToBytes(opts, []any{obj}); err != NonFiniteFloatError {
+			t.Errorf("Unexpected result for []any{obj} (obj=%v): %v, %v", obj, encoded, err)
+		}
+		// Without the option.
+		if encoded, err := MarshalToBytes(nil, obj); err != nil {
+			t.Errorf("Unexpected result without option for obj=%v: %v, %v", obj, encoded, err)
+		}
+	}
+
+	// Finite floats are unaffected.
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: float32(1.5), encoded: []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}},
+		{obj: math.MaxFloat64, encoded: []byte{0xcb, 0x7f, 0xef, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{obj: math.SmallestNonzeroFloat64, encoded: []byte{0xcb, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}},
+	})
+}
+
+func TestMarshal_nanMapKeys(t *testing.T) {
+	testMarshal(t, nil, []marshalTestCase{
+		{obj: map[any]any{math.NaN(): 1}, err: InvalidMapKeyError},
+		{obj: map[any]any{float32(math.NaN()): 1}, err: InvalidMapKeyError},
+		{obj: map[float64]int{math.NaN(): 1}, err: InvalidMapKeyError},
+		{obj: map[testMarshalNamedFloat32]int{testMarshalNamedFloat32(math.NaN()): 1}, err: InvalidMapKeyError},
+		{obj: []any{map[any]any{"a": map[any]any{math.NaN(): 1}}}, err: InvalidMapKeyError},
+		// NaN values are fine.
+		{obj: map[any]any{"a": math.NaN()}, encoded: []byte{0x81, 0xa1, 0x61, 0xcb, 0x7f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}},
+		// As are other float keys.
+		{obj: map[any]any{math.Inf(1): 1}, encoded: []byte{0x81, 0xcb, 0x7f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}},
+	})
+	testMarshal(t, &MarshalOptions{MapKeyOrder: []any{"a"}}, []marshalTestCase{
+		{obj: map[any]any{"a": 1, math.NaN(): 1}, err: InvalidMapKeyError},
+	})
+	testMarshal(t, &MarshalOptions{MapKeyOrder: []any{"a"}, SortUnlistedMapKeys: true}, []marshalTestCase{
+		{obj: map[any]any{"a": 1, math.NaN(): 1}, err: InvalidMapKeyError},
+	})
+
+	// Keys are checked after transformers are applied.
+	opts := &MarshalOptions{
+		ApplicationMarshalTransformer: func(obj any) (any, error) {
+			switch v := obj.(type) {
+			case float64:
+				if math.IsNaN(v) {
+					return "NaN", nil
+				}
+			case string:
+				if v == "nan" {
+					return math.NaN(), nil
+				}
+			}
+			return obj, nil
+		},
+	}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: map[any]any{math.NaN(): 1}, encoded: []byte{0x81, 0xa3, 0x4e, 0x61, 0x4e, 0x01}},
+		{obj: map[string]int{"nan": 1}, err: InvalidMapKeyError},
+	})
+}
+
+func TestMarshal_textMarker(t *testing.T) {
+	testMarshal(t, nil, []marshalTestCase{
+		{obj: testTextType("hi"), encoded: []byte{0xa2, 0x68, 0x69}, decoded: "hi"},
+		{obj: testTextType{}, encoded: []byte{0xa0}, decoded: ""},
+		{obj: testTextType(nil), encoded: []byte{0xa0}, decoded: ""},
+		{obj: testTextType(fillerChars(300)), encoded: append([]byte{0xda, 0x01, 0x2c}, fillerChars(300)...), decoded: string(fillerChars(300))},
+		{obj: []any{testTextType("a")}, encoded: []byte{0x91, 0xa1, 0x61}, decoded: []any{"a"}},
+		{obj: testMaybeTextType("hi"), encoded: []byte{0xa2, 0x68, 0x69}, decoded: "hi"},
+		// Not text.
+		{obj: testMaybeTextType{0, 1}, encoded: []byte{0xc4, 0x02, 0x00, 0x01}, decoded: []byte{0x00, 0x01}},
+		{obj: testNotBytesTextType{1}, encoded: []byte{0x91, 0x01}, decoded: []any{1}},
+	})
+}
+
+func TestMarshal_pointers(t *testing.T) {
+	i := 123
+	s := "hi"
+	pi := &i
+	m := map[string]int{"a": 1}
+	a := []any{&i, (*string)(nil), &s}
+	testMarshal(t, nil, []marshalTestCase{
+		{obj: &i, encoded: []byte{0x7b}, decoded: 123},
+		{obj: &s, encoded: []byte{0xa2, 0x68, 0x69}, decoded: "hi"},
+		{obj: &pi, encoded: []byte{0x7b}, decoded: 123},
+		{obj: (*int)(nil), encoded: []byte{0xc0}, decoded: nil},
+		{obj: (**int)(nil), encoded: []byte{0xc0}, decoded: nil},
+		{obj: &m, encoded: []byte{0x81, 0xa1, 0x61, 0x01}, decoded: map[any]any{"a": 1}},
+		{obj: &a, encoded: []byte{0x93, 0x7b, 0xc0, 0xa2, 0x68, 0x69}, decoded: []any{123, nil, "hi"}},
+		{obj: map[string]*int{"a": &i}, encoded: []byte{0x81, 0xa1, 0x61, 0x7b}, decoded: map[any]any{"a": 123}},
+		{obj: &testMarshalType2{}, err: UnsupportedTypeForMarshallingError},
+	})
+
+	// Transformers are applied to the pointed-to value.
+	opts := &MarshalOptions{ApplicationMarshalTransformer: DefaultStructMarshalTransformer}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: &struct{ A int }{1}, encoded: []byte{0x81, 0xa1, 0x41, 0x01}, decoded: map[any]any{"A": 1}},
+	})
+}
+
+// testBinaryMarshalerType implements encoding.BinaryMarshaler (failing if it's negative).
+type testBinaryMarshalerType int
+
+func (x testBinaryMarshalerType) MarshalBinary() ([]byte, error) {
+	if x < 0 {
+		return nil, testError
+	}
+	return []byte(strconv.Itoa(int(x))), nil
+}
+
+type testMarshalNamedBool bool
+
+type testMarshalNamedUint16 uint16
+
+type testMarshalNamedFloat32 float32
+
+type testMarshalNamedFloat64 float64
+
+func TestMarshal_namedScalars(t *testing.T) {
+	testMarshal(t, nil, []marshalTestCase{
+		{obj: testMarshalNamedBool(true), encoded: []byte{0xc3}},
+		{obj: testMarshalNamedBool(false), encoded: []byte{0xc2}},
+		// Named signed integer types are marshalled as signed (never as uint formats).
+		{obj: testMarshalType4(-1), encoded: []byte{0xff}},
+		{obj: testMarshalType4(1000), encoded: []byte{0xd1, 0x03, 0xe8}},
+		// Named unsigned integer types are marshalled as unsigned (never as fixint).
+		{obj: testMarshalNamedUint16(1), encoded: []byte{0xcc, 0x01}},
+		{obj: testMarshalNamedUint16(1000), encoded: []byte{0xcd, 0x03, 0xe8}},
+		{obj: testMarshalNamedFloat32(1.5), encoded: []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}},
+		{obj: testMarshalNamedFloat64(1.5), encoded: []byte{0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{obj: testMarshalType1("hi"), encoded: []byte{0xa2, 0x68, 0x69}},
+		// In containers and via pointers.
+		{obj: []testMarshalType4{1, 2}, encoded: []byte{0x92, 0x01, 0x02}},
+		{obj: map[testMarshalType1]testMarshalNamedBool{"a": true}, encoded: []byte{0x81, 0xa1, 0x61, 0xc3}},
+		{obj: func() *testMarshalType4 { v := testMarshalType4(5); return &v }(), encoded: []byte{0x05}},
+	})
+}
+
+// Named scalar types should work anywhere the corresponding built-in types would, in particular as
+// elements of (generic) arrays, slices, and maps.
+func TestMarshal_namedScalarsInContainers(t *testing.T) {
+	testMarshal(t, nil, []marshalTestCase{
+		{obj: []testMarshalType4{}, encoded: []byte{0x90}},
+		{obj: []testMarshalType4{-1, 1000}, encoded: []byte{0x92, 0xff, 0xd1, 0x03, 0xe8}},
+		{obj: [3]testMarshalType1{"a", "", "b"}, encoded: []byte{0x93, 0xa1, 0x61, 0xa0, 0xa1, 0x62}},
+		{obj: [2]testMarshalNamedBool{true, false}, encoded: []byte{0x92, 0xc3, 0xc2}},
+		{obj: []testMarshalNamedUint16{1}, encoded: []byte{0x91, 0xcc, 0x01}},
+		{obj: map[string]testMarshalNamedFloat64{"x": 1.5}, encoded: []byte{0x81, 0xa1, 0x78, 0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{obj: map[string]testMarshalNamedFloat32{"x": 1.5}, encoded: []byte{0x81, 0xa1, 0x78, 0xca, 0x3f, 0xc0, 0x00, 0x00}},
+		{obj: map[testMarshalType4]testMarshalType1{7: "q"}, encoded: []byte{0x81, 0x07, 0xa1, 0x71}},
+		// Nested.
+		{obj: map[string][]testMarshalType4{"a": {1}}, encoded: []byte{0x81, 0xa1, 0x61, 0x91, 0x01}},
+		{obj: []map[testMarshalType1]testMarshalNamedBool{{"a": true}}, encoded: []byte{0x91, 0x81, 0xa1, 0x61, 0xc3}},
+		{obj: []any{testMarshalType4(2), testMarshalType1("z")}, encoded: []byte{0x92, 0x02, 0xa1, 0x7a}},
+	})
+}
+
+type testMarshalBlob []byte
+
+type testMarshalNamedByte byte
+
+// Arrays and slices of bytes (of any type) are marshalled as binary data, like []byte.
+func TestMarshal_genericBytes(t *testing.T) {
+	testMarshal(t, nil, []marshalTestCase{
+		{obj: testMarshalBlob{0x01, 0x02}, encoded: []byte{0xc4, 0x02, 0x01, 0x02}},
+		{obj: testMarshalBlob{}, encoded: []byte{0xc4, 0x00}},
+		{obj: testMarshalBlob(nil), encoded: []byte{0xc4, 0x00}},
+		{obj: [4]byte{0x01, 0x02, 0x03, 0x04}, encoded: []byte{0xc4, 0x04, 0x01, 0x02, 0x03, 0x04}},
+		{obj: [0]byte{}, encoded: []byte{0xc4, 0x00}},
+		{obj: &[2]byte{0x01, 0x02}, encoded: []byte{0xc4, 0x02, 0x01, 0x02}},
+		{obj: []testMarshalNamedByte{0x01}, encoded: []byte{0xc4, 0x01, 0x01}},
+		{obj: [1]testMarshalNamedByte{0x01}, encoded: []byte{0xc4, 0x01, 0x01}},
+		{obj: []any{testMarshalBlob{0x01}}, encoded: []byte{0x91, 0xc4, 0x01, 0x01}},
+		{obj: make(testMarshalBlob, 256), encoded: []byte{0xc5, 0x01, 0x00}, prefix: true, decoded: make([]byte, 256)},
+		// Other arrays and slices are still marshalled as arrays.
+		{obj: []any{uint8(1)}, encoded: []byte{0x91, 0xcc, 0x01}},
+		{obj: []string{"a"}, encoded: []byte{0x91, 0xa1, 0x61}},
+		{obj: []uint16{1}, encoded: []byte{0x91, 0xcc, 0x01}},
+		{obj: []int8{1}, encoded: []byte{0x91, 0x01}},
+		{obj: [1][1]byte{{0x01}}, encoded: []byte{0x91, 0xc4, 0x01, 0x01}},
+	})
+
+	// Options for []byte also apply.
+	testMarshal(t, &MarshalOptions{BytesToBase64String: true}, []marshalTestCase{
+		{obj: testMarshalBlob("hi"), encoded: []byte{0xa4, 0x61, 0x47, 0x6b, 0x3d}},
+		{obj: [2]byte{'h', 'i'}, encoded: []byte{0xa4, 0x61, 0x47, 0x6b, 0x3d}},
+	})
+	testMarshal(t, &MarshalOptions{NilCollectionsAsNil: true}, []marshalTestCase{
+		{obj: testMarshalBlob(nil), encoded: []byte{0xc0}},
+	})
+}
+
+// testCountingStringWriter is an io.Writer and io.StringWriter that records calls.
+type testCountingStringWriter struct {
+	bytes.Buffer
+	writeCalls       int
+	writeStringCalls int
+}
+
+func (w *testCountingStringWriter) Write(p []byte) (int, error) {
+	w.writeCalls += 1
+	return w.Buffer.Write(p)
+}
+
+func (w *testCountingStringWriter) WriteString(s string) (int, error) {
+	w.writeStringCalls += 1
+	return w.Buffer.WriteString(s)
+}
+
+func TestMarshal_stringWriter(t *testing.T) {
+	// Small strings are written using Write.
+	w := &testCountingStringWriter{}
+	if err := Marshal(nil, w, "hi"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !bytes.Equal(w.Bytes(), []byte{0xa2, 0x68, 0x69}) || w.writeStringCalls != 0 {
+		t.Errorf("unexpected result: %q (%v Write, %v WriteString)", w.Bytes(), w.writeCalls, w.writeStringCalls)
+	}
+
+	// Large strings are written using WriteString (the header is still written using Write).
+	s := string(fillerChars(300))
+	w = &testCountingStringWriter{}
+	if err := Marshal(nil, w, s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !bytes.Equal(w.Bytes(), append([]byte{0xda, 0x01, 0x2c}, s...)) || w.writeCalls != 1 || w.writeStringCalls != 1 {
+		t.Errorf("unexpected result: %q (%v Write, %v WriteString)", w.Bytes(), w.writeCalls, w.writeStringCalls)
+	}
+}
+
+func TestMarshal_stringStringMap(t *testing.T) {
+	testMarshal(t, nil, []marshalTestCase{
+		{obj: map[string]string{}, encoded: []byte{0x80}},
+		{obj: map[string]string{"a": "b"}, encoded: []byte{0x81, 0xa1, 0x61, 0xa1, 0x62}},
+		{obj: map[string]string{"a": "b", "c": "d"}, encoded: []byte{0x82}, prefix: true, decoded: map[any]any{"a": "b", "c": "d"}},
+	})
+
+	// The application marshal transformer is still applied to keys and values.
+	opts := &MarshalOptions{
+		ApplicationMarshalTransformer: func(obj any) (any, error) {
+			if s, ok := obj.(string); ok {
+				return strings.ToUpper(s), nil
+			}
+			return obj, nil
+		},
+	}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: map[string]string{"a": "b"}, encoded: []byte{0x81, 0xa1, 0x41, 0xa1, 0x42}},
+		{obj: map[string]int{"a": 1}, encoded: []byte{0x81, 0xa1, 0x41, 0x01}},
+	})
+}
+
+func TestBinaryMarshalerTransformer(t *testing.T) {
+	testCases := []struct {
+		obj      any
+		expected any
+		err      error
+	}{
+		{obj: 123, expected: 123},
+		{obj: "hi", expected: "hi"},
+		{obj: testBinaryMarshalerType(123), expected: []byte("123")},
+		{obj: testBinaryMarshalerType(-1), err: testError},
+		{obj: netip.MustParseAddr("1.2.3.4"), expected: []byte{1, 2, 3, 4}},
+	}
+	for _, tc := range testCases {
+		if actual, err := BinaryMarshalerTransformer(tc.obj); err != tc.err {
+			t.Errorf("Unexpected error for obj=%#v: %v (expected: %v)", tc.obj, err, tc.err)
+		} else if err == nil && !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("Unexpected result for obj=%#v: %#v (expected: %#v)", tc.obj, actual, tc.expected)
+		}
+	}
+
+	opts := &MarshalOptions{ApplicationMarshalTransformer: BinaryMarshalerTransformer}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: testBinaryMarshalerType(12), encoded: []byte{0xc4, 0x02, 0x31, 0x32}, decoded: []byte("12")},
+		{obj: []any{testBinaryMarshalerType(1)}, encoded: []byte{0x91, 0xc4, 0x01, 0x31}, decoded: []any{[]byte("1")}},
+		{obj: testBinaryMarshalerType(-1), err: testError},
+		// time.Time implements encoding.BinaryMarshaler, so is marshalled as binary instead of as
+		// a timestamp.
+		{obj: time.Unix(0, 0).UTC(), prefix: true, encoded: []byte{0xc4, 0x0f}, decoded: func() []byte {
+			data, _ := time.Unix(0, 0).UTC().MarshalBinary()
+			return data
+		}()},
+	})
+}
+
+// testTextMarshalerType implements encoding.TextMarshaler (failing if it's negative).
+type testTextMarshalerType int
+
+func (x testTextMarshalerType) MarshalText() ([]byte, error) {
+	if x < 0 {
+		return nil, testError
+	}
+	return []byte(strconv.Itoa(int(x))), nil
+}
+
+func TestTextMarshalerTransformer(t *testing.T) {
+	testCases := []struct {
+		obj      any
+		expected any
+		err      error
+	}{
+		{obj: 123, expected: 123},
+		{obj: []byte("hi"), expected: []byte("hi")},
+		{obj: testTextMarshalerType(123), expected: "123"},
+		{obj: testTextMarshalerType(-1), err: testError},
+		{obj: netip.MustParseAddr("1.2.3.4"), expected: "1.2.3.4"},
+		{obj: time.Unix(0, 0).UTC(), expected: "1970-01-01T00:00:00Z"},
+	}
+	for _, tc := range testCases {
+		if actual, err := TextMarshalerTransformer(tc.obj); err != tc.err {
+			t.Errorf("Unexpected error for obj=%#v: %v (expected: %v)", tc.obj, err, tc.err)
+		} else if err == nil && !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("Unexpected result for obj=%#v: %#v (expected: %#v)", tc.obj, actual, tc.expected)
+		}
+	}
+
+	opts := &MarshalOptions{ApplicationMarshalTransformer: TextMarshalerTransformer}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: testTextMarshalerType(12), encoded: []byte{0xa2, 0x31, 0x32}, decoded: "12"},
+		{obj: []any{testTextMarshalerType(1)}, encoded: []byte{0x91, 0xa1, 0x31}, decoded: []any{"1"}},
+		{obj: testTextMarshalerType(-1), err: testError},
+	})
+}
+
+func TestJSONNumberMarshalTransformer(t *testing.T) {
+	opts := &MarshalOptions{ApplicationMarshalTransformer: JSONNumberMarshalTransformer}
+	testMarshal(t, opts, []marshalTestCase{
+		// Integral.
+		{obj: json.Number("0"), encoded: []byte{0x00}},
+		{obj: json.Number("-1"), encoded: []byte{0xff}},
+		{obj: json.Number("1000"), encoded: []byte{0xd1, 0x03, 0xe8}},
+		{obj: json.Number("9223372036854775807"), encoded: []byte{0xd3, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		// Fractional (or otherwise not an int64).
+		{obj: json.Number("1.5"), encoded: []byte{0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{obj: json.Number("-1.5e0"), encoded: []byte{0xcb, 0xbf, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{obj: json.Number("9223372036854775808"), encoded: []byte{0xcb, 0x43, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		// Malformed.
+		{obj: json.Number(""), err: InvalidJSONNumberError},
+		{obj: json.Number("abc"), err: InvalidJSONNumberError},
+		{obj: json.Number("1.2.3"), err: InvalidJSONNumberError},
+		// Nested, and other objects.
+		{obj: map[string]any{"a": json.Number("1")}, encoded: []byte{0x81, 0xa1, 0x61, 0x01}},
+		{obj: "1", encoded: []byte{0xa1, 0x31}},
+	})
+}
+
+type testErrorType struct {
+	Code int
+}
+
+func (e *testErrorType) Error() string {
+	return "code " + strconv.Itoa(e.Code)
+}
+
+type testStringErrorType string
+
+func (e testStringErrorType) Error() string {
+	return "string error"
+}
+
+func TestErrorMarshalTransformer(t *testing.T) {
+	wrapped := fmt.Errorf("wrapped: %w", &testErrorType{Code: 1})
+	testCases := []struct {
+		obj      any
+		expected any
+	}{
+		{obj: 123, expected: 123},
+		{obj: "hi", expected: "hi"},
+		{obj: testError, expected: "test error"},
+		{obj: &testErrorType{Code: 42}, expected: "code 42"},
+		{obj: wrapped, expected: "wrapped: code 1"},
+		// Typed nil pointer (whose Error method would panic).
+		{obj: (*testErrorType)(nil), expected: nil},
+		// Concretely marshallable, so not transformed.
+		{obj: testStringErrorType("hi"), expected: testStringErrorType("hi")},
+	}
+	for _, tc := range testCases {
+		if actual, err := ErrorMarshalTransformer(tc.obj); err != nil {
+			t.Errorf("Unexpected error for obj=%#v: %v", tc.obj, err)
+		} else if !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("Unexpected result for obj=%#v: %#v (expected: %#v)", tc.obj, actual, tc.expected)
+		}
+	}
+
+	opts := &MarshalOptions{
+		ApplicationMarshalTransformer: ComposeMarshalTransformers(DefaultStructMarshalTransformer, ErrorMarshalTransformer),
+	}
+	testMarshal(t, opts, []marshalTestCase{
+		{obj: &testErrorType{Code: 5}, encoded: []byte{0xa6, 0x63, 0x6f, 0x64, 0x65, 0x20, 0x35}, decoded: "code 5"},
+		{obj: map[string]any{"err": wrapped}, encoded: []byte{0x81, 0xa3, 0x65, 0x72, 0x72, 0xaf}, prefix: true, decoded: map[any]any{"err": "wrapped: code 1"}},
+		{obj: []error{nil, testError}, encoded: []byte{0x92, 0xc0, 0xaa}, prefix: true, decoded: []any{nil, "test error"}},
+		{obj: []error{(*testErrorType)(nil)}, encoded: []byte{0x91, 0xc0}, decoded: []any{nil}},
+		{obj: testStringErrorType("hi"), encoded: []byte{0xa2, 0x68, 0x69}, decoded: "hi"},
+	})
+}
+
+func TestSyncMapMarshalTransformer(t *testing.T) {
+	sm := &sync.Map{}
+	sm.Store("a", 1)
+	sm.Store(2, []any{"b"})
+	sm.Store("c", nil)
+
+	if actual, err := SyncMapMarshalTransformer(sm); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if expected := map[any]any{"a": 1, 2: []any{"b"}, "c": nil}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected result: %#v", actual)
+	}
+	// Other objects are returned as-is.
+	for _, obj := range []any{(*sync.Map)(nil), 123, map[any]any{1: 2}} {
+		if actual, err := SyncMapMarshalTransformer(obj); err != nil || !reflect.DeepEqual(actual, obj) {
+			t.Errorf("Unexpected result for %#v: %#v, %v", obj, actual, err)
+		}
+	}
+
+	opts := &MarshalOptions{
+		ApplicationMarshalTransformer: SyncMapMarshalTransformer,
+		MapKeyOrder:                   []any{},
+		SortUnlistedMapKeys:           true,
+	}
+	expected := []byte{0x83, 0x02, 0x91, 0xa1, 0x62, 0xa1, 0x61, 0x01, 0xa1, 0x63, 0xc0}
+	if encoded, err := MarshalToBytes(opts, sm); err != nil || !bytes.Equal(encoded, expected) {
+		t.Errorf("Unexpected result: %v, %v", encoded, err)
+	}
+	if encoded, err := MarshalToBytes(opts, (*sync.Map)(nil)); err != nil || !bytes.Equal(encoded, []byte{0xc0}) {
+		t.Errorf("Unexpected result: %v, %v", encoded, err)
+	}
+
+	// Nested, and (unless otherwise transformed) unsupported values result in errors.
+	testMarshal(t, &MarshalOptions{ApplicationMarshalTransformer: SyncMapMarshalTransformer}, []marshalTestCase{
+		{obj: []any{sm}, encoded: []byte{0x91, 0x83}, prefix: true, decoded: []any{map[any]any{"a": 1, 2: []any{"b"}, "c": nil}}},
+	})
+	sm.Store("d", &testMarshalType2{})
+	if _, err := MarshalToBytes(opts, sm); !errors.Is(err, UnsupportedTypeForMarshallingError) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
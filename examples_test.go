@@ -10,6 +10,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/viettrungluu/umsgpack"
@@ -95,6 +96,27 @@ func ExampleDefaultStructMarshalTransformer() {
 	// Output: 16
 }
 
+func ExampleTextMarshalerTransformer() {
+	opts := &umsgpack.MarshalOptions{
+		ApplicationMarshalTransformer: umsgpack.ComposeMarshalTransformers(
+			umsgpack.TextMarshalerTransformer,
+			umsgpack.DefaultStructMarshalTransformer,
+		),
+	}
+
+	input := struct {
+		Addr net.IP
+	}{net.IPv4(192, 168, 0, 1)}
+	if output, err := umsgpack.MarshalToBytes(opts, input); err != nil {
+		panic(err)
+	} else if decoded, err := umsgpack.UnmarshalBytes(nil, output); err != nil {
+		panic(err)
+	} else {
+		fmt.Println(decoded)
+	}
+	// Output: map[Addr:192.168.0.1]
+}
+
 // Unmarshal:
 
 func ExampleUnmarshal() {
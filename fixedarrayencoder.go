@@ -0,0 +1,82 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains a MarshalTransformerFn (and its inverse) for marshalling fixed-size numeric
+// arrays as binary.
+
+package umsgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+)
+
+// InvalidFixedArrayDataError is the error returned by UnmarshalFixedArray if the data does not have
+// the size of the target array.
+var InvalidFixedArrayDataError = errors.New("Invalid fixed array data")
+
+// InvalidFixedArrayTargetError is the error returned by UnmarshalFixedArray if the target is not a
+// (non-nil) pointer to a fixed-size numeric array.
+var InvalidFixedArrayTargetError = errors.New("Invalid fixed array target")
+
+// FixedArrayMarshalTransformer is a marshal transformer that transforms fixed-size (Go) arrays of
+// fixed-size numeric types (int{8,16,32,64}, uint{8,16,32,64}, float{32,64}) to a []byte containing
+// the elements' values, in order, each encoded big-endian (i.e., as per encoding/binary with
+// binary.BigEndian). Thus they are marshalled as a single bin instead of as an array.
+//
+// E.g., [2]uint16{0x1234, 0x5678} is transformed to []byte{0x12, 0x34, 0x56, 0x78}.
+//
+// Note that int and uint are not fixed-size, so arrays of those are not transformed. Slices are
+// also not transformed. Use UnmarshalFixedArray to do the inverse.
+func FixedArrayMarshalTransformer(obj any) (any, error) {
+	if obj == nil {
+		return obj, nil
+	}
+	t := reflect.TypeOf(obj)
+	if !isFixedNumericArrayType(t) {
+		return obj, nil
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, t.Len()*int(t.Elem().Size())))
+	if err := binary.Write(buf, binary.BigEndian, obj); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var _ MarshalTransformerFn = FixedArrayMarshalTransformer
+
+// UnmarshalFixedArray is the inverse of FixedArrayMarshalTransformer: it fills the array pointed
+// to by target (which must be a pointer to a fixed-size array of fixed-size numeric type) from
+// data, which should contain the elements' values, in order, each encoded big-endian.
+//
+// E.g., given data from unmarshalling (a []byte):
+//
+//	var a [4]uint32
+//	err := umsgpack.UnmarshalFixedArray(data, &a)
+func UnmarshalFixedArray(data []byte, target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() || !isFixedNumericArrayType(v.Type().Elem()) {
+		return InvalidFixedArrayTargetError
+	}
+	if len(data) != int(v.Type().Elem().Size()) {
+		return InvalidFixedArrayDataError
+	}
+	return binary.Read(bytes.NewReader(data), binary.BigEndian, target)
+}
+
+// isFixedNumericArrayType determines if t is a fixed-size array type of fixed-size numeric type.
+func isFixedNumericArrayType(t reflect.Type) bool {
+	if t.Kind() != reflect.Array {
+		return false
+	}
+	switch t.Elem().Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests fixedarrayencoder.go.
+
+package umsgpack_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestFixedArrayMarshalTransformer(t *testing.T) {
+	testCases := []struct {
+		obj      any
+		expected any
+	}{
+		{nil, nil},
+		{123, 123},
+		{[]uint32{1, 2}, []uint32{1, 2}},
+		{[2]int{1, 2}, [2]int{1, 2}},
+		{[2]string{"a", "b"}, [2]string{"a", "b"}},
+		{[0]uint32{}, []byte{}},
+		{[2]uint8{0x12, 0x34}, []byte{0x12, 0x34}},
+		{[2]uint16{0x1234, 0x5678}, []byte{0x12, 0x34, 0x56, 0x78}},
+		{[2]int16{-1, 1}, []byte{0xff, 0xff, 0x00, 0x01}},
+		{[1]uint64{0x123456789abcdef0}, []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}},
+		{[1]float32{1}, []byte{0x3f, 0x80, 0x00, 0x00}},
+	}
+	for i, tc := range testCases {
+		if result, err := FixedArrayMarshalTransformer(tc.obj); err != nil {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("%v: unexpected result: %#v (expected: %#v)", i, result, tc.expected)
+		}
+	}
+}
+
+func TestUnmarshalFixedArray(t *testing.T) {
+	var a [2]uint16
+	if err := UnmarshalFixedArray([]byte{0x12, 0x34, 0x56, 0x78}, &a); err != nil || a != [2]uint16{0x1234, 0x5678} {
+		t.Errorf("Unexpected result: %v, %v", a, err)
+	}
+	if err := UnmarshalFixedArray([]byte{0x12, 0x34, 0x56}, &a); err != InvalidFixedArrayDataError {
+		t.Errorf("Unexpected result: %v", err)
+	}
+	if err := UnmarshalFixedArray([]byte{0x12, 0x34, 0x56, 0x78, 0x9a}, &a); err != InvalidFixedArrayDataError {
+		t.Errorf("Unexpected result: %v", err)
+	}
+	if err := UnmarshalFixedArray([]byte{0x12, 0x34, 0x56, 0x78}, a); err != InvalidFixedArrayTargetError {
+		t.Errorf("Unexpected result: %v", err)
+	}
+	if err := UnmarshalFixedArray([]byte{0x12, 0x34, 0x56, 0x78}, (*[2]uint16)(nil)); err != InvalidFixedArrayTargetError {
+		t.Errorf("Unexpected result: %v", err)
+	}
+	var b [2]int
+	if err := UnmarshalFixedArray(make([]byte, 16), &b); err != InvalidFixedArrayTargetError {
+		t.Errorf("Unexpected result: %v", err)
+	}
+}
+
+func TestFixedArray_roundtrip(t *testing.T) {
+	opts := &MarshalOptions{ApplicationMarshalTransformer: FixedArrayMarshalTransformer}
+	obj := [4]uint32{0, 1, 0x12345678, 0xffffffff}
+	encoded, err := MarshalToBytes(opts, obj)
+	if err != nil {
+		t.Fatalf("MarshalToBytes returned error: %v", err)
+	}
+	expectedEncoded := []byte{0xc4, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x12, 0x34, 0x56, 0x78, 0xff, 0xff, 0xff, 0xff}
+	if bytes.Compare(encoded, expectedEncoded) != 0 {
+		t.Errorf("Unexpected encoded: %v", encoded)
+	}
+
+	decoded, err := UnmarshalBytes(nil, encoded)
+	if err != nil {
+		t.Fatalf("UnmarshalBytes returned error: %v", err)
+	}
+	data, ok := decoded.([]byte)
+	if !ok {
+		t.Fatalf("Unexpected decoded: %#v", decoded)
+	}
+	var result [4]uint32
+	if err := UnmarshalFixedArray(data, &result); err != nil || result != obj {
+		t.Errorf("Unexpected result: %v, %v", result, err)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains MarshalFramed and UnmarshalFramed, for length-prefixed framing.
+
+package umsgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// frameLengthSize is the size of the length prefix of a frame.
+const frameLengthSize = 4
+
+// MarshalFramed marshals a single object (as for Marshal) to w as a frame: i.e., prefixed by the
+// length of its encoding as a 4-byte big-endian unsigned integer. The frame is written using a
+// single call to w.Write. It may be read using UnmarshalFramed.
+//
+// ObjectTooBigForMarshallingError is returned if the encoding is too big (at least 2^32 bytes).
+func MarshalFramed(opts *MarshalOptions, w io.Writer, obj any) error {
+	buf := bytes.NewBuffer(make([]byte, frameLengthSize, frameLengthSize+defaultMarshalToBytesCap))
+	if err := Marshal(opts, buf, obj); err != nil {
+		return err
+	}
+
+	frame := buf.Bytes()
+	n := len(frame) - frameLengthSize
+	if n > math.MaxUint32 {
+		return ObjectTooBigForMarshallingError
+	}
+	binary.BigEndian.PutUint32(frame, uint32(n))
+	_, err := w.Write(frame)
+	return err
+}
+
+// UnmarshalFramed reads a single frame (as written by MarshalFramed) from r, and unmarshals the
+// object in it (as for UnmarshalBytes). It reads exactly the frame from r (so that r is positioned
+// at the next frame), even if unmarshalling fails.
+//
+// If there is no data at all, it returns io.EOF. Other errors are returned as a *DecodeError (with
+// the offset relative to the start of the frame): in particular, if the frame is truncated, it
+// wraps io.ErrUnexpectedEOF; if the frame contains more than just the object, it wraps
+// TrailingBytesError; and if the frame's length exceeds MaxReaderBytes (if set), it wraps
+// MessageTooLargeError (and the frame's data is not read).
+func UnmarshalFramed(opts *UnmarshalOptions, r io.Reader) (any, error) {
+	if opts == nil {
+		opts = DefaultUnmarshalOptions
+	}
+	rv, err := newReadViewerForReader(opts, r)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefix [frameLengthSize]byte
+	if n, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, &DecodeError{Err: err, Offset: n}
+	}
+	n := uint(binary.BigEndian.Uint32(prefix[:]))
+	if opts.MaxReaderBytes > 0 && n > uint(opts.MaxReaderBytes) {
+		return nil, &DecodeError{Err: MessageTooLargeError, Offset: frameLengthSize}
+	}
+
+	data, err := rv.ReadCopy(n)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, &DecodeError{Err: err, Offset: frameLengthSize}
+	}
+
+	obj, consumed, err := UnmarshalBytesN(opts, data)
+	if err != nil {
+		if err == io.EOF {
+			// The frame is empty.
+			return nil, &DecodeError{Err: io.ErrUnexpectedEOF, Offset: frameLengthSize}
+		}
+		if decodeErr, ok := err.(*DecodeError); ok {
+			decodeErr.Offset += frameLengthSize
+		}
+		return nil, err
+	}
+	if consumed != len(data) {
+		return nil, &DecodeError{Err: TrailingBytesError, Offset: frameLengthSize + consumed}
+	}
+	return obj, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests framing.go.
+
+package umsgpack_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestMarshalFramed(t *testing.T) {
+	testCases := []struct {
+		obj     any
+		encoded []byte
+	}{
+		{obj: nil, encoded: []byte{0x00, 0x00, 0x00, 0x01, 0xc0}},
+		{obj: "hi", encoded: []byte{0x00, 0x00, 0x00, 0x03, 0xa2, 0x68, 0x69}},
+		{obj: []any{1, 2}, encoded: []byte{0x00, 0x00, 0x00, 0x03, 0x92, 0x01, 0x02}},
+		{obj: fillerBytes(300), encoded: append([]byte{0x00, 0x00, 0x01, 0x2f, 0xc5, 0x01, 0x2c}, fillerBytes(300)...)},
+	}
+	for i, tC := range testCases {
+		buf := &bytes.Buffer{}
+		if err := MarshalFramed(nil, buf, tC.obj); err != nil {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if !bytes.Equal(buf.Bytes(), tC.encoded) {
+			t.Errorf("%v: unexpected result: %v", i, buf.Bytes())
+		}
+	}
+
+	// On error, nothing is written.
+	buf := &bytes.Buffer{}
+	if err := MarshalFramed(nil, buf, []any{1, &testMarshalType2{}}); !errors.Is(err, UnsupportedTypeForMarshallingError) {
+		t.Errorf("unexpected error: %v", err)
+	} else if buf.Len() != 0 {
+		t.Errorf("unexpected data written: %v", buf.Bytes())
+	}
+}
+
+func TestUnmarshalFramed(t *testing.T) {
+	// Several frames, back to back.
+	objs := []any{nil, "hi", []any{int(1), map[any]any{"a": fillerBytes(5000)}}, int(-1)}
+	buf := &bytes.Buffer{}
+	for _, obj := range objs {
+		if err := MarshalFramed(nil, buf, obj); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for _, r := range []io.Reader{bytes.NewReader(buf.Bytes()), iotest.OneByteReader(bytes.NewReader(buf.Bytes()))} {
+		for i, obj := range objs {
+			if decoded, err := UnmarshalFramed(nil, r); err != nil {
+				t.Errorf("%v: unexpected error: %v", i, err)
+			} else if !reflect.DeepEqual(decoded, obj) {
+				t.Errorf("%v: unexpected result: %#v", i, decoded)
+			}
+		}
+		if decoded, err := UnmarshalFramed(nil, r); err != io.EOF {
+			t.Errorf("unexpected result at end: %#v, %v", decoded, err)
+		}
+	}
+
+	testCases := []struct {
+		opts    *UnmarshalOptions
+		encoded []byte
+		err     error
+		offset  int
+	}{
+		// Truncated.
+		{encoded: []byte{0x00}, err: io.ErrUnexpectedEOF, offset: 1},
+		{encoded: []byte{0x00, 0x00, 0x00}, err: io.ErrUnexpectedEOF, offset: 3},
+		{encoded: []byte{0x00, 0x00, 0x00, 0x01}, err: io.ErrUnexpectedEOF, offset: 4},
+		{encoded: []byte{0x00, 0x00, 0x00, 0x03, 0xa2, 0x68}, err: io.ErrUnexpectedEOF, offset: 4},
+		{encoded: []byte{0xff, 0xff, 0xff, 0xff, 0xa2, 0x68}, err: io.ErrUnexpectedEOF, offset: 4},
+		// The frame is empty, or its contents are truncated.
+		{encoded: []byte{0x00, 0x00, 0x00, 0x00}, err: io.ErrUnexpectedEOF, offset: 4},
+		{encoded: []byte{0x00, 0x00, 0x00, 0x02, 0xa2, 0x68}, err: io.ErrUnexpectedEOF, offset: 5},
+		// Invalid or trailing data in the frame.
+		{encoded: []byte{0x00, 0x00, 0x00, 0x02, 0x91, 0xc1}, err: InvalidFormatError, offset: 6},
+		{encoded: []byte{0x00, 0x00, 0x00, 0x02, 0x01, 0x02}, err: TrailingBytesError, offset: 5},
+		// Too large.
+		{opts: &UnmarshalOptions{MaxReaderBytes: 2}, encoded: []byte{0x00, 0x00, 0x00, 0x03, 0xa2, 0x68, 0x69}, err: MessageTooLargeError, offset: 4},
+	}
+	for i, tC := range testCases {
+		_, err := UnmarshalFramed(tC.opts, bytes.NewReader(tC.encoded))
+		var decodeErr *DecodeError
+		if !errors.Is(err, tC.err) || !errors.As(err, &decodeErr) || decodeErr.Offset != tC.offset {
+			t.Errorf("%v: unexpected error: %v (expected: %v at offset %v)", i, err, tC.err, tC.offset)
+		}
+	}
+
+	// Even if unmarshalling fails, the whole frame is consumed.
+	r := bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x02, 0x91, 0xc1, 0x00, 0x00, 0x00, 0x01, 0x2a})
+	if _, err := UnmarshalFramed(nil, r); !errors.Is(err, InvalidFormatError) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if decoded, err := UnmarshalFramed(nil, r); err != nil || decoded != int(42) {
+		t.Errorf("unexpected result: %#v, %v", decoded, err)
+	}
+}
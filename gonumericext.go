@@ -0,0 +1,159 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains support for an application extension type preserving Go numeric types (see
+// MarshalOptions.PreserveGoNumericTypes).
+
+package umsgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Errors ------------------------------------------------------------------------------------------
+
+// InvalidGoNumericTypeError is the error returned by UnmarshalGoNumericTypeExtensionType for an
+// invalid type tag or data of the wrong length.
+var InvalidGoNumericTypeError = errors.New("Invalid Go numeric type")
+
+// Go numeric type extension -----------------------------------------------------------------------
+
+// GoNumericTypeExtensionType is the (application) extension type used if the
+// PreserveGoNumericTypes marshal option is set. Note that this is not a standard MessagePack
+// extension type.
+//
+// Its data is a type tag byte followed by the value, big-endian, at the type's width (int, uint,
+// and uintptr are always 8 bytes, and floats are their IEEE 754 bit patterns). The type tags are:
+// 1 for int, 2 for int8, 3 for int16, 4 for int32, 5 for int64, 6 for uint, 7 for uint8, 8 for
+// uint16, 9 for uint32, 10 for uint64, 11 for uintptr, 12 for float32, and 13 for float64.
+const GoNumericTypeExtensionType int8 = 46
+
+// Type tags for the Go numeric type extension type.
+const (
+	goNumericTypeInt     = 1
+	goNumericTypeInt8    = 2
+	goNumericTypeInt16   = 3
+	goNumericTypeInt32   = 4
+	goNumericTypeInt64   = 5
+	goNumericTypeUint    = 6
+	goNumericTypeUint8   = 7
+	goNumericTypeUint16  = 8
+	goNumericTypeUint32  = 9
+	goNumericTypeUint64  = 10
+	goNumericTypeUintptr = 11
+	goNumericTypeFloat32 = 12
+	goNumericTypeFloat64 = 13
+)
+
+// GoNumericTypeExtensionUnmarshalTransformer is the UnmarshalTransformerFn corresponding to the
+// PreserveGoNumericTypes marshal option.
+var GoNumericTypeExtensionUnmarshalTransformer UnmarshalTransformerFn = MakeExtensionTypeUnmarshalTransformer(
+	map[int8]UnmarshalExtensionTypeFn{
+		GoNumericTypeExtensionType: UnmarshalGoNumericTypeExtensionType,
+	},
+)
+
+// goNumericTypeExtensionData returns the data for the Go numeric type extension type for obj and
+// true, or nil and false if obj is not of a built-in numeric type.
+func goNumericTypeExtensionData(obj any) ([]byte, bool) {
+	var tag byte
+	var width int
+	var u uint64
+	switch v := obj.(type) {
+	case int:
+		tag, width, u = goNumericTypeInt, 8, uint64(v)
+	case int8:
+		tag, width, u = goNumericTypeInt8, 1, uint64(v)
+	case int16:
+		tag, width, u = goNumericTypeInt16, 2, uint64(v)
+	case int32:
+		tag, width, u = goNumericTypeInt32, 4, uint64(v)
+	case int64:
+		tag, width, u = goNumericTypeInt64, 8, uint64(v)
+	case uint:
+		tag, width, u = goNumericTypeUint, 8, uint64(v)
+	case uint8:
+		tag, width, u = goNumericTypeUint8, 1, uint64(v)
+	case uint16:
+		tag, width, u = goNumericTypeUint16, 2, uint64(v)
+	case uint32:
+		tag, width, u = goNumericTypeUint32, 4, uint64(v)
+	case uint64:
+		tag, width, u = goNumericTypeUint64, 8, v
+	case uintptr:
+		tag, width, u = goNumericTypeUintptr, 8, uint64(v)
+	case float32:
+		tag, width, u = goNumericTypeFloat32, 4, uint64(math.Float32bits(v))
+	case float64:
+		tag, width, u = goNumericTypeFloat64, 8, math.Float64bits(v)
+	default:
+		return nil, false
+	}
+
+	data := make([]byte, 1+width)
+	data[0] = tag
+	for i := width; i > 0; i -= 1 {
+		data[i] = byte(u & 0xff)
+		u >>= 8
+	}
+	return data, true
+}
+
+// UnmarshalGoNumericTypeExtensionType is an UnmarshalExtensionTypeFn that unmarshals the data for
+// the Go numeric type extension type (see GoNumericTypeExtensionType) to a value of the tagged
+// type (e.g., an int8).
+func UnmarshalGoNumericTypeExtensionType(data []byte) (any, bool, error) {
+	if len(data) == 0 {
+		return nil, false, InvalidGoNumericTypeError
+	}
+	tag, value := data[0], data[1:]
+
+	var width int
+	switch tag {
+	case goNumericTypeInt8, goNumericTypeUint8:
+		width = 1
+	case goNumericTypeInt16, goNumericTypeUint16:
+		width = 2
+	case goNumericTypeInt32, goNumericTypeUint32, goNumericTypeFloat32:
+		width = 4
+	case goNumericTypeInt, goNumericTypeInt64, goNumericTypeUint, goNumericTypeUint64,
+		goNumericTypeUintptr, goNumericTypeFloat64:
+		width = 8
+	default:
+		return nil, false, InvalidGoNumericTypeError
+	}
+	if len(value) != width {
+		return nil, false, InvalidGoNumericTypeError
+	}
+
+	switch tag {
+	case goNumericTypeInt:
+		return int(binary.BigEndian.Uint64(value)), true, nil
+	case goNumericTypeInt8:
+		return int8(value[0]), true, nil
+	case goNumericTypeInt16:
+		return int16(binary.BigEndian.Uint16(value)), true, nil
+	case goNumericTypeInt32:
+		return int32(binary.BigEndian.Uint32(value)), true, nil
+	case goNumericTypeInt64:
+		return int64(binary.BigEndian.Uint64(value)), true, nil
+	case goNumericTypeUint:
+		return uint(binary.BigEndian.Uint64(value)), true, nil
+	case goNumericTypeUint8:
+		return value[0], true, nil
+	case goNumericTypeUint16:
+		return binary.BigEndian.Uint16(value), true, nil
+	case goNumericTypeUint32:
+		return binary.BigEndian.Uint32(value), true, nil
+	case goNumericTypeUint64:
+		return binary.BigEndian.Uint64(value), true, nil
+	case goNumericTypeUintptr:
+		return uintptr(binary.BigEndian.Uint64(value)), true, nil
+	case goNumericTypeFloat32:
+		return math.Float32frombits(binary.BigEndian.Uint32(value)), true, nil
+	default: // goNumericTypeFloat64
+		return math.Float64frombits(binary.BigEndian.Uint64(value)), true, nil
+	}
+}
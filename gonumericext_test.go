@@ -0,0 +1,108 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests gonumericext.go.
+
+package umsgpack_test
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestGoNumericTypeExtension_roundTrip(t *testing.T) {
+	mopts := &MarshalOptions{PreserveGoNumericTypes: true}
+	uopts := &UnmarshalOptions{ApplicationUnmarshalTransformer: GoNumericTypeExtensionUnmarshalTransformer}
+	for _, obj := range []any{
+		int(0), int(-1), int(math.MaxInt64), int(math.MinInt64),
+		int8(5), int8(math.MinInt8), int16(-300), int16(math.MaxInt16),
+		int32(math.MinInt32), int64(math.MaxInt64),
+		uint(0), uint(math.MaxUint64), uint8(5), uint8(math.MaxUint8), uint16(0x1234),
+		uint32(math.MaxUint32), uint64(math.MaxUint64), uintptr(0x1234),
+		float32(1.5), float32(math.Inf(-1)), float64(-0.25), math.MaxFloat64,
+	} {
+		if encoded, err := MarshalToBytes(mopts, obj); err != nil {
+			t.Errorf("Unexpected error marshalling %T(%v): %v", obj, obj, err)
+		} else if decoded, err := UnmarshalBytes(uopts, encoded); err != nil || decoded != obj {
+			t.Errorf("Unexpected result unmarshalling %T(%v): %#v, %v", obj, obj, decoded, err)
+		}
+	}
+
+	// NaNs are preserved bitwise.
+	if encoded, err := MarshalToBytes(mopts, math.NaN()); err != nil {
+		t.Errorf("Unexpected error marshalling NaN: %v", err)
+	} else if decoded, err := UnmarshalBytes(uopts, encoded); err != nil {
+		t.Errorf("Unexpected error unmarshalling NaN: %v", err)
+	} else if f, ok := decoded.(float64); !ok || math.Float64bits(f) != math.Float64bits(math.NaN()) {
+		t.Errorf("Unexpected result unmarshalling NaN: %#v", decoded)
+	}
+
+	// In containers, including as map keys and in (otherwise fast-pathed) map[string]int.
+	for _, tc := range []struct {
+		obj      any
+		expected any
+	}{
+		{[]any{int8(1), uint16(2), "x"}, []any{int8(1), uint16(2), "x"}},
+		{map[any]any{int16(1): float32(2)}, map[any]any{int16(1): float32(2)}},
+		{map[string]int{"a": 1}, map[any]any{"a": int(1)}},
+		{[]uint32{1, 2}, []any{uint32(1), uint32(2)}},
+	} {
+		if encoded, err := MarshalToBytes(mopts, tc.obj); err != nil {
+			t.Errorf("Unexpected error marshalling %v: %v", tc.obj, err)
+		} else if decoded, err := UnmarshalBytes(uopts, encoded); err != nil || !reflect.DeepEqual(decoded, tc.expected) {
+			t.Errorf("Unexpected result unmarshalling %v: %#v, %v", tc.obj, decoded, err)
+		}
+	}
+}
+
+func TestGoNumericTypeExtension_encoding(t *testing.T) {
+	opts := &MarshalOptions{PreserveGoNumericTypes: true}
+	testCases := []struct {
+		obj      any
+		expected []byte
+	}{
+		{int8(5), []byte{0xd5, 0x2e, 0x02, 0x05}},
+		{uint8(0xff), []byte{0xd5, 0x2e, 0x07, 0xff}},
+		{int16(-2), []byte{0xc7, 0x03, 0x2e, 0x03, 0xff, 0xfe}},
+		{uint32(1), []byte{0xc7, 0x05, 0x2e, 0x09, 0x00, 0x00, 0x00, 0x01}},
+		{float32(1.5), []byte{0xc7, 0x05, 0x2e, 0x0c, 0x3f, 0xc0, 0x00, 0x00}},
+		{int(1), []byte{0xc7, 0x09, 0x2e, 0x01, 0, 0, 0, 0, 0, 0, 0, 0x01}},
+		{float64(1.5), []byte{0xc7, 0x09, 0x2e, 0x0d, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		// Other types are unaffected (including named numeric types).
+		{"a", []byte{0xa1, 0x61}},
+		{true, []byte{0xc3}},
+		{time.Duration(1), []byte{0x01}},
+	}
+	for _, tc := range testCases {
+		if encoded, err := MarshalToBytes(opts, tc.obj); err != nil || !bytes.Equal(encoded, tc.expected) {
+			t.Errorf("Unexpected result marshalling %T(%v): %v, %v (expected: %v)", tc.obj, tc.obj, encoded, err, tc.expected)
+		}
+	}
+
+	// It takes precedence over FixedIntWidth and JSONCompatible.
+	for _, opts := range []*MarshalOptions{
+		{PreserveGoNumericTypes: true, FixedIntWidth: 64},
+		{PreserveGoNumericTypes: true, JSONCompatible: true},
+	} {
+		if encoded, err := MarshalToBytes(opts, int8(5)); err != nil || !bytes.Equal(encoded, []byte{0xd5, 0x2e, 0x02, 0x05}) {
+			t.Errorf("Unexpected result marshalling with %+v: %v, %v", opts, encoded, err)
+		}
+	}
+}
+
+func TestUnmarshalGoNumericTypeExtensionType(t *testing.T) {
+	for _, data := range [][]byte{nil, {0x00}, {0x0e, 0x01}, {0x02}, {0x02, 0x01, 0x02}, {0x01, 0x01}, {0x0c, 0, 0, 0}} {
+		if obj, _, err := UnmarshalGoNumericTypeExtensionType(data); err != InvalidGoNumericTypeError {
+			t.Errorf("Unexpected result for data=%v: %v, %v", data, obj, err)
+		}
+	}
+
+	if obj, mapKeySupported, err := UnmarshalGoNumericTypeExtensionType([]byte{0x08, 0x12, 0x34}); err != nil || obj != uint16(0x1234) || !mapKeySupported {
+		t.Errorf("Unexpected result: %v, %v, %v", obj, mapKeySupported, err)
+	}
+}
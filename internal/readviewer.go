@@ -33,58 +33,94 @@ type ReadViewer interface {
 
 // Internal configuration:
 const (
-	// ReaderChunkSize is the maximum single read size from an io.Reader (for a
+	// ReaderChunkSize is the default maximum single read size from an io.Reader (for a
 	// ReadViewerForReader).
 	ReaderChunkSize = 4096
 )
 
-// A ReadViewerForReader is a ReadViewer that wraps an io.Reader. (Note that these are typically
-// passed by value.)
+// A ReadViewerForReader is a ReadViewer that wraps an io.Reader.
 type ReadViewerForReader struct {
 	Reader io.Reader
+
+	// ChunkSize is the maximum single read size from Reader; if zero, ReaderChunkSize is used.
+	ChunkSize uint
+
+	// buf is a buffer that is reused for ReadView (and ReadByte), grown as needed (up to the
+	// chunk size).
+	buf []byte
 }
 
-var _ ReadViewer = ReadViewerForReader{}
+var _ ReadViewer = (*ReadViewerForReader)(nil)
 
 // ReadByte implements ReadViewer.ReadByte.
-func (r ReadViewerForReader) ReadByte() (byte, error) {
-	data := make([]byte, 1)
-	_, err := io.ReadFull(r.Reader, data)
-	return data[0], err
+func (r *ReadViewerForReader) ReadByte() (byte, error) {
+	// Fast path: if the reader is also an io.ByteReader (e.g., a *bufio.Reader or a
+	// *bytes.Buffer), then use it directly (avoiding an allocation).
+	if byteReader, ok := r.Reader.(io.ByteReader); ok {
+		return byteReader.ReadByte()
+	}
+
+	data, err := r.ReadView(1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
 }
 
 // ReadView implements ReadViewer.ReadView.
-func (r ReadViewerForReader) ReadView(n uint) ([]byte, error) {
-	return r.ReadCopy(n)
+func (r *ReadViewerForReader) ReadView(n uint) ([]byte, error) {
+	// Don't keep large buffers around.
+	if n > r.chunkSize() {
+		return r.ReadCopy(n)
+	}
+
+	if uint(cap(r.buf)) < n {
+		r.buf = make([]byte, max(n, 64))
+	}
+	data := r.buf[:n]
+	if _, err := io.ReadFull(r.Reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 // ReadCopy implements ReadViewer.ReadCopy.
-func (r ReadViewerForReader) ReadCopy(n uint) ([]byte, error) {
+func (r *ReadViewerForReader) ReadCopy(n uint) ([]byte, error) {
 	// Fast path:
-	if n <= ReaderChunkSize {
+	chunkSize := r.chunkSize()
+	if n <= chunkSize {
 		return r.readCopyAll(n)
 	}
 
+	// Grow data a chunk at a time (rather than allocating it all up front, since n may come from
+	// untrusted input), reading straight into it.
 	var data []byte
-	for n > 0 {
-		m := min(n, ReaderChunkSize)
-		// TODO: grow data and read straight into it.
-		if chunk, err := r.readCopyAll(m); err != nil {
-			if err == io.EOF && len(data) > 0 {
+	for uint(len(data)) < n {
+		start := len(data)
+		m := min(n-uint(start), chunkSize)
+		data = append(data, make([]byte, m)...)
+		if _, err := io.ReadFull(r.Reader, data[start:]); err != nil {
+			if err == io.EOF && start > 0 {
 				// Return ErrUnexpectedEOF instead of EOF if we've read any data.
 				return nil, io.ErrUnexpectedEOF
 			}
 			return nil, err
-		} else {
-			data = append(data, chunk...)
 		}
-		n -= m
 	}
 	return data, nil
 }
 
+// chunkSize returns the maximum single read size (i.e., r.ChunkSize, or ReaderChunkSize if it's
+// zero).
+func (r *ReadViewerForReader) chunkSize() uint {
+	if r.ChunkSize == 0 {
+		return ReaderChunkSize
+	}
+	return r.ChunkSize
+}
+
 // readCopyAll is a helper for ReadCopy that reads the data all at once.
-func (r ReadViewerForReader) readCopyAll(n uint) ([]byte, error) {
+func (r *ReadViewerForReader) readCopyAll(n uint) ([]byte, error) {
 	data := make([]byte, n)
 	if _, err := io.ReadFull(r.Reader, data); err != nil {
 		return nil, err
@@ -103,6 +139,11 @@ type ReadViewerForBuffer struct {
 
 var _ ReadViewer = (*ReadViewerForBuffer)(nil)
 
+// Pos returns the current read position (i.e., the number of bytes consumed so far).
+func (r *ReadViewerForBuffer) Pos() uint {
+	return r.pos
+}
+
 // ReadByte implements ReadViewer.ReadByte.
 func (r *ReadViewerForBuffer) ReadByte() (byte, error) {
 	len := uint(len(r.Buffer))
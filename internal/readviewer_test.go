@@ -4,6 +4,7 @@
 package internal_test
 
 import (
+	"bufio"
 	"bytes"
 	"io"
 	"testing"
@@ -21,7 +22,31 @@ func makeTestBuf(n int) []byte {
 
 func TestReadViewerForReader_ReadByte(t *testing.T) {
 	reader := bytes.NewBuffer([]byte("12"))
-	r := ReadViewerForReader{reader}
+	r := &ReadViewerForReader{Reader: reader}
+
+	if b, err := r.ReadByte(); err != nil || b != '1' {
+		t.Errorf("Unexpected result: %v, %v", b, err)
+	}
+	if b, err := r.ReadByte(); err != nil || b != '2' {
+		t.Errorf("Unexpected result: %v, %v", b, err)
+	}
+	if b, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("Unexpected result: %v, %v", b, err)
+	}
+}
+
+// onlyReader hides any methods of the wrapped io.Reader other than Read (e.g., ReadByte).
+type onlyReader struct {
+	r io.Reader
+}
+
+func (r onlyReader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func TestReadViewerForReader_ReadByte_notByteReader(t *testing.T) {
+	reader := onlyReader{bytes.NewBuffer([]byte("12"))}
+	r := &ReadViewerForReader{Reader: reader}
 
 	if b, err := r.ReadByte(); err != nil || b != '1' {
 		t.Errorf("Unexpected result: %v, %v", b, err)
@@ -38,7 +63,7 @@ func TestReadViewerForReader_ReadView(t *testing.T) {
 	{
 		data := []byte("123456")
 		reader := bytes.NewBuffer(data)
-		r := ReadViewerForReader{reader}
+		r := &ReadViewerForReader{Reader: reader}
 
 		if buf, err := r.ReadView(0); err != nil {
 			t.Errorf("Unexpected result: %v, %v", buf, err)
@@ -60,7 +85,7 @@ func TestReadViewerForReader_ReadView(t *testing.T) {
 	{
 		data := makeTestBuf(ReaderChunkSize)
 		reader := bytes.NewBuffer(data)
-		r := ReadViewerForReader{reader}
+		r := &ReadViewerForReader{Reader: reader}
 
 		if buf, err := r.ReadView(ReaderChunkSize); err != nil || bytes.Compare(buf, data) != 0 {
 			t.Errorf("Unexpected result: %v, %v", buf, err)
@@ -73,7 +98,7 @@ func TestReadViewerForReader_ReadView(t *testing.T) {
 	{
 		data := makeTestBuf(3 * ReaderChunkSize)
 		reader := bytes.NewBuffer(data)
-		r := ReadViewerForReader{reader}
+		r := &ReadViewerForReader{Reader: reader}
 
 		if buf, err := r.ReadView(2 * ReaderChunkSize); err != nil || bytes.Compare(buf, data[:2*ReaderChunkSize]) != 0 {
 			t.Errorf("Unexpected result: %v, %v", buf, err)
@@ -87,11 +112,40 @@ func TestReadViewerForReader_ReadView(t *testing.T) {
 	}
 }
 
+// Tests that ReadView reuses its buffer (so views are only valid until the next operation), but
+// ReadCopy doesn't.
+func TestReadViewerForReader_ReadViewReusesBuffer(t *testing.T) {
+	data := []byte("12345678")
+	r := &ReadViewerForReader{Reader: onlyReader{bytes.NewBuffer(data)}}
+
+	view1, err := r.ReadView(2)
+	if err != nil || bytes.Compare(view1, []byte("12")) != 0 {
+		t.Fatalf("Unexpected result: %v, %v", view1, err)
+	}
+	copy1, err := r.ReadCopy(2)
+	if err != nil || bytes.Compare(copy1, []byte("34")) != 0 {
+		t.Fatalf("Unexpected result: %v, %v", copy1, err)
+	}
+	view2, err := r.ReadView(2)
+	if err != nil || bytes.Compare(view2, []byte("56")) != 0 {
+		t.Fatalf("Unexpected result: %v, %v", view2, err)
+	}
+	if &view1[0] != &view2[0] {
+		t.Errorf("Expected ReadView to reuse its buffer")
+	}
+	if b, err := r.ReadByte(); err != nil || b != '7' {
+		t.Errorf("Unexpected result: %v, %v", b, err)
+	}
+	if bytes.Compare(copy1, []byte("34")) != 0 {
+		t.Errorf("ReadCopy result was modified: %v", copy1)
+	}
+}
+
 func TestReadViewerForReader_ReadCopy(t *testing.T) {
 	{
 		data := []byte("123456")
 		reader := bytes.NewBuffer(data)
-		r := ReadViewerForReader{reader}
+		r := &ReadViewerForReader{Reader: reader}
 
 		if buf, err := r.ReadCopy(0); err != nil {
 			t.Errorf("Unexpected result: %v, %v", buf, err)
@@ -119,7 +173,7 @@ func TestReadViewerForReader_ReadCopy(t *testing.T) {
 	{
 		data := makeTestBuf(ReaderChunkSize)
 		reader := bytes.NewBuffer(data)
-		r := ReadViewerForReader{reader}
+		r := &ReadViewerForReader{Reader: reader}
 
 		if buf, err := r.ReadCopy(ReaderChunkSize); err != nil || bytes.Compare(buf, data) != 0 {
 			t.Errorf("Unexpected result: %v, %v", buf, err)
@@ -132,7 +186,7 @@ func TestReadViewerForReader_ReadCopy(t *testing.T) {
 	{
 		data := makeTestBuf(3 * ReaderChunkSize)
 		reader := bytes.NewBuffer(data)
-		r := ReadViewerForReader{reader}
+		r := &ReadViewerForReader{Reader: reader}
 
 		if buf, err := r.ReadCopy(2 * ReaderChunkSize); err != nil || bytes.Compare(buf, data[:2*ReaderChunkSize]) != 0 {
 			t.Errorf("Unexpected result: %v, %v", buf, err)
@@ -146,6 +200,48 @@ func TestReadViewerForReader_ReadCopy(t *testing.T) {
 	}
 }
 
+// maxReadSizeReader wraps an io.Reader, recording the maximum size of the buffers passed to Read.
+type maxReadSizeReader struct {
+	r   io.Reader
+	max int
+}
+
+func (r *maxReadSizeReader) Read(p []byte) (int, error) {
+	r.max = max(r.max, len(p))
+	return r.r.Read(p)
+}
+
+func TestReadViewerForReader_ChunkSize(t *testing.T) {
+	data := makeTestBuf(3 * ReaderChunkSize)
+	for _, tC := range []struct {
+		chunkSize uint
+		n         uint
+		maxRead   int
+	}{
+		{chunkSize: 0, n: 100, maxRead: 100},
+		{chunkSize: 0, n: 3 * ReaderChunkSize, maxRead: ReaderChunkSize},
+		{chunkSize: 16, n: 16, maxRead: 16},
+		{chunkSize: 16, n: 100, maxRead: 16},
+		{chunkSize: 2 * ReaderChunkSize, n: 3 * ReaderChunkSize, maxRead: 2 * ReaderChunkSize},
+	} {
+		reader := &maxReadSizeReader{r: bytes.NewBuffer(data)}
+		r := &ReadViewerForReader{Reader: reader, ChunkSize: tC.chunkSize}
+		if buf, err := r.ReadCopy(tC.n); err != nil || bytes.Compare(buf, data[:tC.n]) != 0 {
+			t.Errorf("Unexpected result: %v, %v", buf, err)
+		} else if reader.max != tC.maxRead {
+			t.Errorf("Unexpected max read size for chunkSize=%v, n=%v: %v", tC.chunkSize, tC.n, reader.max)
+		}
+
+		reader = &maxReadSizeReader{r: bytes.NewBuffer(data)}
+		r = &ReadViewerForReader{Reader: reader, ChunkSize: tC.chunkSize}
+		if buf, err := r.ReadView(tC.n); err != nil || bytes.Compare(buf, data[:tC.n]) != 0 {
+			t.Errorf("Unexpected result: %v, %v", buf, err)
+		} else if reader.max != tC.maxRead {
+			t.Errorf("Unexpected max read size for chunkSize=%v, n=%v: %v", tC.chunkSize, tC.n, reader.max)
+		}
+	}
+}
+
 func TestReadViewerForBuffer_ReadByte(t *testing.T) {
 	r := &ReadViewerForBuffer{Buffer: []byte("12")}
 
@@ -207,3 +303,51 @@ func TestReadViewerForBuffer_ReadCopy(t *testing.T) {
 		t.Errorf("Unexpected result: %v, %v", buf, err)
 	}
 }
+
+func TestReadViewerForBuffer_Pos(t *testing.T) {
+	r := &ReadViewerForBuffer{Buffer: []byte("123456")}
+
+	if pos := r.Pos(); pos != 0 {
+		t.Errorf("Unexpected result: %v", pos)
+	}
+	r.ReadByte()
+	if pos := r.Pos(); pos != 1 {
+		t.Errorf("Unexpected result: %v", pos)
+	}
+	r.ReadView(2)
+	if pos := r.Pos(); pos != 3 {
+		t.Errorf("Unexpected result: %v", pos)
+	}
+	r.ReadCopy(2)
+	if pos := r.Pos(); pos != 5 {
+		t.Errorf("Unexpected result: %v", pos)
+	}
+	r.ReadCopy(2)
+	if pos := r.Pos(); pos != 6 {
+		t.Errorf("Unexpected result: %v", pos)
+	}
+}
+
+func BenchmarkReadViewerForReader_ReadByte(b *testing.B) {
+	data := makeTestBuf(4096)
+	benchmarks := []struct {
+		name      string
+		newReader func() io.Reader
+	}{
+		{"ByteReader", func() io.Reader { return bufio.NewReader(bytes.NewReader(data)) }},
+		{"Reader", func() io.Reader { return onlyReader{bufio.NewReader(bytes.NewReader(data))} }},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			r := &ReadViewerForReader{Reader: bm.newReader()}
+			for i := 0; i < b.N; i += 1 {
+				if i%len(data) == 0 {
+					r = &ReadViewerForReader{Reader: bm.newReader()}
+				}
+				if _, err := r.ReadByte(); err != nil {
+					b.Fatalf("ReadByte failed: %v", err)
+				}
+			}
+		})
+	}
+}
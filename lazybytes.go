@@ -0,0 +1,35 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains LazyBytes, used by Marshal.
+
+package umsgpack
+
+import (
+	"errors"
+	"io"
+)
+
+// Errors ------------------------------------------------------------------------------------------
+
+// InvalidLazyBytesError is the error returned if Marshal encounters a LazyBytes with a negative
+// length.
+var InvalidLazyBytesError = errors.New("Invalid lazy bytes")
+
+// LazyBytes ---------------------------------------------------------------------------------------
+
+// A LazyBytes is binary data of a known length (N bytes) to be read from an io.Reader (R) when it
+// is marshalled. Marshal marshals it like a []byte (including with options such as
+// BinaryAsExtension and BytesToBase64String), but writes the prefix and then copies the data from R
+// to the writer (using io.CopyN), without reading it all into memory first. This is useful for
+// streaming large binary data (e.g., from a file).
+//
+// Exactly N bytes are read from R (which must be positioned at the start of the data), so a
+// LazyBytes can only be marshalled once. If R has fewer than N bytes, io.ErrUnexpectedEOF is
+// returned (after the prefix and the available data have been written). Marshal returns
+// ObjectTooBigForMarshallingError if N is at least 2^32, and InvalidLazyBytesError if N is
+// negative. (It is only meaningful for marshalling; Unmarshal never produces it.)
+type LazyBytes struct {
+	R io.Reader
+	N int64
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests lazybytes.go.
+
+package umsgpack_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestLazyBytes(t *testing.T) {
+	for _, n := range []int{0, 1, 255, 256, 65535, 65536, 100_000} {
+		data := fillerBytes(n)
+		for _, opts := range []*MarshalOptions{
+			nil,
+			{BinaryAsExtension: true, BinaryExtensionType: 7},
+			{BytesToBase64String: true},
+			{JSONCompatible: true},
+		} {
+			expected, err := MarshalToBytes(opts, data)
+			if err != nil {
+				t.Fatalf("MarshalToBytes failed: %v", err)
+			}
+			// Also with a reader that returns one byte at a time.
+			for _, r := range []io.Reader{bytes.NewReader(data), iotest.OneByteReader(bytes.NewReader(data))} {
+				if encoded, err := MarshalToBytes(opts, LazyBytes{R: r, N: int64(n)}); err != nil || !bytes.Equal(encoded, expected) {
+					t.Errorf("%v, %+v: unexpected result: %v, %v", n, opts, len(encoded), err)
+				}
+			}
+		}
+	}
+
+	// Nested, and as a pointer.
+	obj := map[string]any{"blob": &LazyBytes{R: strings.NewReader("hello"), N: 5}, "x": 1}
+	if encoded, err := MarshalToBytes(nil, obj); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if decoded, err := UnmarshalBytes(nil, encoded); err != nil || !reflect.DeepEqual(decoded, map[any]any{"blob": []byte("hello"), "x": 1}) {
+		t.Errorf("unexpected result: %v, %v", decoded, err)
+	}
+
+	// Only N bytes are read.
+	r := strings.NewReader("abcdef")
+	if encoded, err := MarshalToBytes(nil, LazyBytes{R: r, N: 2}); err != nil || !bytes.Equal(encoded, []byte{0xc4, 0x02, 'a', 'b'}) {
+		t.Errorf("unexpected result: %v, %v", encoded, err)
+	} else if r.Len() != 4 {
+		t.Errorf("unexpected remaining data: %v", r.Len())
+	}
+}
+
+func TestLazyBytes_errors(t *testing.T) {
+	testCases := []struct {
+		obj LazyBytes
+		err error
+	}{
+		// Short reads.
+		{LazyBytes{R: strings.NewReader(""), N: 1}, io.ErrUnexpectedEOF},
+		{LazyBytes{R: strings.NewReader("abc"), N: 4}, io.ErrUnexpectedEOF},
+		{LazyBytes{R: iotest.ErrReader(testError), N: 4}, testError},
+		// Invalid lengths.
+		{LazyBytes{R: strings.NewReader(""), N: -1}, InvalidLazyBytesError},
+		{LazyBytes{R: strings.NewReader(""), N: math.MaxUint32 + 1}, ObjectTooBigForMarshallingError},
+	}
+	for i, tC := range testCases {
+		for _, opts := range []*MarshalOptions{nil, {BinaryAsExtension: true}, {BytesToBase64String: true}} {
+			if _, err := MarshalToBytes(opts, tC.obj); !errors.Is(err, tC.err) {
+				t.Errorf("%v, %+v: unexpected error: %v", i, opts, err)
+			}
+		}
+	}
+
+	// Writer errors are returned.
+	w := &limitedDiscardWriter{3}
+	if err := Marshal(nil, w, LazyBytes{R: strings.NewReader("abcdef"), N: 6}); err != io.ErrShortWrite {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
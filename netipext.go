@@ -0,0 +1,119 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains support for an application extension type for IP addresses (netip.Addr).
+
+package umsgpack
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// Errors ------------------------------------------------------------------------------------------
+
+// InvalidNetipAddrError is the error returned by UnmarshalNetipAddrExtensionType for an invalid IP
+// address.
+var InvalidNetipAddrError = errors.New("Invalid IP address")
+
+// IP address extension ----------------------------------------------------------------------------
+
+// NetipAddrExtensionType is the (application) extension type used by
+// NetipAddrExtensionMarshalTransformer and NetipAddrExtensionUnmarshalTransformer. Note that this
+// is not a standard MessagePack extension type; to use a different extension type, use
+// MakeNetipAddrExtensionTransformers.
+const NetipAddrExtensionType int8 = 45
+
+// Values of the family byte of the IP address extension type.
+const (
+	netipAddrFamilyInvalid = 0
+	netipAddrFamilyIPv4    = 4
+	netipAddrFamilyIPv6    = 6
+)
+
+// NetipAddrExtensionMarshalTransformer is a MarshalTransformerFn that transforms netip.Addr to an
+// *UnresolvedExtensionType with extension type NetipAddrExtensionType. Its data is variable-length:
+// a family byte (4 for IPv4, 6 for IPv6, or 0 for the zero netip.Addr) followed by the address
+// bytes (4 for IPv4, 16 for IPv6, or none), followed for IPv6 by the zone (if any). Thus, e.g., an
+// IPv4 address is marshalled as an ext 8 with 5 bytes of data and an IPv6 address (without zone) as
+// an ext 8 with 17 bytes. IPv4-mapped IPv6 addresses (e.g., ::ffff:1.2.3.4) are kept as IPv6.
+func NetipAddrExtensionMarshalTransformer(obj any) (any, error) {
+	return marshalNetipAddrExtension(NetipAddrExtensionType, obj)
+}
+
+var _ MarshalTransformerFn = NetipAddrExtensionMarshalTransformer
+
+// NetipAddrExtensionUnmarshalTransformer is the UnmarshalTransformerFn corresponding to
+// NetipAddrExtensionMarshalTransformer.
+var NetipAddrExtensionUnmarshalTransformer UnmarshalTransformerFn = MakeExtensionTypeUnmarshalTransformer(
+	map[int8]UnmarshalExtensionTypeFn{
+		NetipAddrExtensionType: UnmarshalNetipAddrExtensionType,
+	},
+)
+
+// MakeNetipAddrExtensionTransformers makes a MarshalTransformerFn and corresponding
+// UnmarshalTransformerFn like NetipAddrExtensionMarshalTransformer and
+// NetipAddrExtensionUnmarshalTransformer, respectively, but using the given extension type.
+func MakeNetipAddrExtensionTransformers(extType int8) (MarshalTransformerFn, UnmarshalTransformerFn) {
+	marshalTransformer := func(obj any) (any, error) {
+		return marshalNetipAddrExtension(extType, obj)
+	}
+	unmarshalTransformer := MakeExtensionTypeUnmarshalTransformer(
+		map[int8]UnmarshalExtensionTypeFn{
+			extType: UnmarshalNetipAddrExtensionType,
+		},
+	)
+	return marshalTransformer, unmarshalTransformer
+}
+
+// marshalNetipAddrExtension is a helper for the IP address extension marshal transformers.
+func marshalNetipAddrExtension(extType int8, obj any) (any, error) {
+	addr, ok := obj.(netip.Addr)
+	if !ok {
+		return obj, nil
+	}
+
+	var data []byte
+	switch {
+	case addr.Is4():
+		a := addr.As4()
+		data = append([]byte{netipAddrFamilyIPv4}, a[:]...)
+	case addr.Is6():
+		a := addr.As16()
+		data = make([]byte, 0, 1+len(a)+len(addr.Zone()))
+		data = append(data, netipAddrFamilyIPv6)
+		data = append(data, a[:]...)
+		data = append(data, addr.Zone()...)
+	default:
+		data = []byte{netipAddrFamilyInvalid}
+	}
+	return &UnresolvedExtensionType{ExtensionType: extType, Data: data}, nil
+}
+
+// UnmarshalNetipAddrExtensionType is an UnmarshalExtensionTypeFn that unmarshals the data for the
+// IP address extension type (see NetipAddrExtensionMarshalTransformer) to a netip.Addr.
+func UnmarshalNetipAddrExtensionType(data []byte) (any, bool, error) {
+	if len(data) == 0 {
+		return nil, false, InvalidNetipAddrError
+	}
+
+	switch data[0] {
+	case netipAddrFamilyIPv4:
+		if len(data) != 1+4 {
+			return nil, false, InvalidNetipAddrError
+		}
+		return netip.AddrFrom4([4]byte(data[1:])), true, nil
+	case netipAddrFamilyIPv6:
+		if len(data) < 1+16 {
+			return nil, false, InvalidNetipAddrError
+		}
+		return netip.AddrFrom16([16]byte(data[1:17])).WithZone(string(data[17:])), true, nil
+	case netipAddrFamilyInvalid:
+		if len(data) != 1 {
+			return nil, false, InvalidNetipAddrError
+		}
+		return netip.Addr{}, true, nil
+	default:
+		return nil, false, InvalidNetipAddrError
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests netipext.go.
+
+package umsgpack_test
+
+import (
+	"net/netip"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestNetipAddrExtension(t *testing.T) {
+	mopts := &MarshalOptions{ApplicationMarshalTransformer: NetipAddrExtensionMarshalTransformer}
+	uopts := &UnmarshalOptions{ApplicationUnmarshalTransformer: NetipAddrExtensionUnmarshalTransformer}
+	testCases := []marshalTestCase{
+		{obj: netip.Addr{}, encoded: []byte{0xd4, 0x2d, 0x00}},
+		{obj: netip.MustParseAddr("0.0.0.0"), encoded: []byte{0xc7, 0x05, 0x2d, 0x04, 0, 0, 0, 0}},
+		{obj: netip.MustParseAddr("192.0.2.1"), encoded: []byte{0xc7, 0x05, 0x2d, 0x04, 0xc0, 0x00, 0x02, 0x01}},
+		{obj: netip.MustParseAddr("255.255.255.255"), encoded: []byte{0xc7, 0x05, 0x2d, 0x04, 0xff, 0xff, 0xff, 0xff}},
+		{obj: netip.MustParseAddr("::"), encoded: []byte{0xc7, 0x11, 0x2d, 0x06, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{obj: netip.MustParseAddr("::1"), encoded: []byte{0xc7, 0x11, 0x2d, 0x06, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01}},
+		{obj: netip.MustParseAddr("::ffff:192.0.2.1"), encoded: []byte{0xc7, 0x11, 0x2d, 0x06, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xc0, 0x00, 0x02, 0x01}},
+		{obj: netip.MustParseAddr("2001:db8::1"), encoded: []byte{0xc7, 0x11, 0x2d, 0x06, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01}},
+		{obj: netip.MustParseAddr("fe80::1%eth0"), encoded: []byte{0xc7, 0x15, 0x2d, 0x06, 0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 'e', 't', 'h', '0'}},
+		// IP addresses may be map keys.
+		{obj: map[any]any{netip.MustParseAddr("192.0.2.1"): 1}, encoded: []byte{0x81, 0xc7, 0x05, 0x2d, 0x04, 0xc0, 0x00, 0x02, 0x01, 0x01}},
+		// Other objects are unaffected.
+		{obj: "192.0.2.1", encoded: []byte{0xa9, '1', '9', '2', '.', '0', '.', '2', '.', '1'}},
+	}
+	testMarshal(t, mopts, testCases)
+	testUnmarshal(t, uopts, unmarshalTestCasesFor(testCases))
+}
+
+func TestMakeNetipAddrExtensionTransformers(t *testing.T) {
+	const extType = 17
+	marshalTransformer, unmarshalTransformer := MakeNetipAddrExtensionTransformers(extType)
+	mopts := &MarshalOptions{ApplicationMarshalTransformer: marshalTransformer}
+	uopts := &UnmarshalOptions{ApplicationUnmarshalTransformer: unmarshalTransformer}
+	testCases := []marshalTestCase{
+		{obj: netip.MustParseAddr("192.0.2.1"), encoded: []byte{0xc7, 0x05, 0x11, 0x04, 0xc0, 0x00, 0x02, 0x01}},
+	}
+	testMarshal(t, mopts, testCases)
+	testUnmarshal(t, uopts, unmarshalTestCasesFor(testCases))
+
+	// It shouldn't be unmarshalled as an IP address with the default extension type.
+	testUnmarshal(t, &UnmarshalOptions{ApplicationUnmarshalTransformer: NetipAddrExtensionUnmarshalTransformer}, []unmarshalTestCase{
+		{encoded: testCases[0].encoded, decoded: &UnresolvedExtensionType{ExtensionType: extType, Data: []byte{0x04, 0xc0, 0x00, 0x02, 0x01}}},
+	})
+}
+
+func TestUnmarshalNetipAddrExtensionType(t *testing.T) {
+	for _, data := range [][]byte{nil, {0x00, 0x00}, {0x04}, {0x04, 1, 2, 3}, {0x04, 1, 2, 3, 4, 5}, {0x06, 1, 2, 3, 4}, {0x05, 1, 2, 3, 4}} {
+		if obj, _, err := UnmarshalNetipAddrExtensionType(data); err != InvalidNetipAddrError {
+			t.Errorf("Unexpected result for data=%v: %v, %v", data, obj, err)
+		}
+	}
+
+	if obj, mapKeySupported, err := UnmarshalNetipAddrExtensionType([]byte{0x04, 127, 0, 0, 1}); err != nil || obj != netip.MustParseAddr("127.0.0.1") || !mapKeySupported {
+		t.Errorf("Unexpected result: %v, %v, %v", obj, mapKeySupported, err)
+	}
+}
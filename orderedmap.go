@@ -0,0 +1,31 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains OrderedMap, used by both Unmarshal (see UnmarshalOptions.OrderedMaps) and
+// Marshal.
+
+package umsgpack
+
+// An *OrderedMap represents a map whose entries are in a particular order (e.g., the order in which
+// they were unmarshalled). Keys contains the keys, in order, and Values maps each key to its value;
+// they must contain the same keys (and Keys must not contain duplicates).
+//
+// Marshal marshals an *OrderedMap as a map, with its entries in order. Thus (unlike with
+// map[any]any) the order of map entries may be round-tripped exactly: e.g., minimally-encoded data
+// unmarshalled with the OrderedMaps option (and DisableStandardUnmarshalTransformer) is marshalled
+// back to identical bytes.
+type OrderedMap struct {
+	Keys   []any
+	Values map[any]any
+}
+
+// Len returns the number of entries in m.
+func (m *OrderedMap) Len() int {
+	return len(m.Keys)
+}
+
+// Get returns the value for the given key, and whether it is present.
+func (m *OrderedMap) Get(key any) (any, bool) {
+	value, ok := m.Values[key]
+	return value, ok
+}
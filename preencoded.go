@@ -0,0 +1,13 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains PreEncoded, used by Marshal.
+
+package umsgpack
+
+// A PreEncoded contains already-encoded MessagePack data, which Marshal writes verbatim.
+//
+// It is up to the application to ensure that it contains exactly one valid MessagePack object;
+// Marshal does not validate it. (It is only meaningful for marshalling; Unmarshal never produces
+// it.)
+type PreEncoded []byte
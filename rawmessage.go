@@ -0,0 +1,25 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains RawMessage, used by both Marshal and UnmarshalInto.
+
+package umsgpack
+
+import (
+	"reflect"
+)
+
+// A RawMessage is a raw encoded MessagePack object, analogous to json.RawMessage. It may be used to
+// pass through (or delay decoding of) already-encoded data.
+//
+// Marshal writes a non-empty RawMessage verbatim (without validation, as for PreEncoded); an empty
+// (or nil) RawMessage is marshalled to nil.
+//
+// UnmarshalInto/UnmarshalBytesInto with a *RawMessage target captures the raw bytes of the object
+// (a copy). For RawMessage values nested inside the target (e.g., struct fields), the raw bytes are
+// not available, so the unmarshalled value is marshalled again (using fixed options), which may
+// not reproduce the original bytes exactly (e.g., map entries may be reordered).
+type RawMessage []byte
+
+// rawMessageType is the reflect.Type of RawMessage.
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
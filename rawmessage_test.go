@@ -0,0 +1,99 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests rawmessage.go.
+
+package umsgpack_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestRawMessage_marshal(t *testing.T) {
+	testMarshal(t, nil, []marshalTestCase{
+		{obj: RawMessage{0x01}, encoded: []byte{0x01}},
+		{obj: RawMessage{0x92, 0x01, 0xa1, 0x61}, encoded: []byte{0x92, 0x01, 0xa1, 0x61}},
+		{obj: RawMessage(nil), encoded: []byte{0xc0}},
+		{obj: RawMessage{}, encoded: []byte{0xc0}},
+		// Spliced into a larger structure.
+		{obj: []any{RawMessage{0x81, 0xa1, 0x61, 0x01}, 2}, encoded: []byte{0x92, 0x81, 0xa1, 0x61, 0x01, 0x02}},
+	})
+}
+
+func TestRawMessage_unmarshalInto(t *testing.T) {
+	// Non-minimal encoding, which is preserved.
+	data := []byte{0x92, 0xd0, 0x01, 0x81, 0xa1, 0x61, 0xc3, 0xff}
+	n := len(data) - 1
+
+	var raw RawMessage
+	if err := UnmarshalBytesInto(nil, data, &raw); err != nil || !bytes.Equal(raw, data[:n]) {
+		t.Errorf("Unexpected result from UnmarshalBytesInto: %v, %v", raw, err)
+	}
+	// It should be a copy.
+	if len(raw) > 0 && &raw[0] == &data[0] {
+		t.Errorf("Expected UnmarshalBytesInto result to be a copy")
+	}
+
+	raw = nil
+	r := bytes.NewReader(data)
+	if err := UnmarshalInto(nil, r, &raw); err != nil || !bytes.Equal(raw, data[:n]) {
+		t.Errorf("Unexpected result from UnmarshalInto: %v, %v", raw, err)
+	}
+	// Only the object should have been consumed.
+	if b, err := r.ReadByte(); err != nil || b != 0xff {
+		t.Errorf("Unexpected remaining data: %v, %v", b, err)
+	}
+
+	// Errors.
+	if err := UnmarshalBytesInto(nil, data[:3], &raw); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Unexpected result from UnmarshalBytesInto: %v", err)
+	}
+	if err := UnmarshalInto(nil, bytes.NewReader(data[:3]), &raw); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Unexpected result from UnmarshalInto: %v", err)
+	}
+	if err := UnmarshalBytesInto(&UnmarshalOptions{RejectTrailingBytes: true}, data, &raw); !errors.Is(err, TrailingBytesError) {
+		t.Errorf("Unexpected result from UnmarshalBytesInto: %v", err)
+	}
+}
+
+type testRawMessageStruct struct {
+	Kind    string
+	Payload RawMessage
+}
+
+func TestRawMessage_unmarshalIntoNested(t *testing.T) {
+	encoded, err := MarshalToBytes(nil, map[string]any{"Kind": "x", "Payload": []any{1, "a"}})
+	if err != nil {
+		t.Fatalf("MarshalToBytes failed: %v", err)
+	}
+
+	var s testRawMessageStruct
+	if err := UnmarshalBytesInto(nil, encoded, &s); err != nil {
+		t.Fatalf("UnmarshalBytesInto failed: %v", err)
+	}
+	expected := testRawMessageStruct{Kind: "x", Payload: RawMessage{0x92, 0x01, 0xa1, 0x61}}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("Unexpected result: %#v", s)
+	}
+
+	// Round trip.
+	if decoded, err := UnmarshalBytes(nil, s.Payload); err != nil || !reflect.DeepEqual(decoded, []any{1, "a"}) {
+		t.Errorf("Unexpected result: %v, %v", decoded, err)
+	}
+
+	// DefaultMarshalOptions doesn't apply.
+	saved := *DefaultMarshalOptions
+	DefaultMarshalOptions.FixedIntWidth = 64
+	s = testRawMessageStruct{}
+	err = UnmarshalBytesInto(nil, encoded, &s)
+	*DefaultMarshalOptions = saved
+	if err != nil || !reflect.DeepEqual(s, expected) {
+		t.Errorf("Unexpected result: %#v, %v", s, err)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains Recycler (see UnmarshalOptions.Recycler), etc.
+
+package umsgpack
+
+import (
+	"sync"
+)
+
+// A Recycler provides containers (map[any]any and []any) to Unmarshal, and takes back containers
+// that are no longer needed, so that they may be reused (e.g., to reduce garbage collection
+// pressure). See UnmarshalOptions.Recycler.
+//
+// It must be safe to use concurrently if it is used by concurrent Unmarshals.
+type Recycler interface {
+	// GetMap returns an empty map.
+	GetMap() map[any]any
+
+	// PutMap takes back a map that is no longer needed (and which must not be used by the caller
+	// afterwards).
+	PutMap(m map[any]any)
+
+	// GetSlice returns an empty (zero-length) slice, ideally with capacity at least n.
+	GetSlice(n int) []any
+
+	// PutSlice is like PutMap, but for slices.
+	PutSlice(s []any)
+}
+
+// SyncPoolRecycler is a Recycler backed by sync.Pools. The zero value is ready to use.
+type SyncPoolRecycler struct {
+	maps   sync.Pool
+	slices sync.Pool
+}
+
+var _ Recycler = (*SyncPoolRecycler)(nil)
+
+// GetMap implements Recycler.GetMap.
+func (r *SyncPoolRecycler) GetMap() map[any]any {
+	if m, ok := r.maps.Get().(map[any]any); ok {
+		return m
+	}
+	return map[any]any{}
+}
+
+// PutMap implements Recycler.PutMap.
+func (r *SyncPoolRecycler) PutMap(m map[any]any) {
+	clear(m)
+	r.maps.Put(m)
+}
+
+// GetSlice implements Recycler.GetSlice.
+func (r *SyncPoolRecycler) GetSlice(n int) []any {
+	if sp, ok := r.slices.Get().(*[]any); ok && cap(*sp) >= n {
+		return (*sp)[:0]
+	}
+	return make([]any, 0, n)
+}
+
+// PutSlice implements Recycler.PutSlice.
+func (r *SyncPoolRecycler) PutSlice(s []any) {
+	// Clear the elements (up to the capacity), so that they may be garbage collected.
+	s = s[:cap(s)]
+	clear(s)
+	r.slices.Put(&s)
+}
+
+// RecycleObject puts back (to r) all the containers (map[any]any and []any) in obj, recursively
+// (i.e., including obj itself and all its contents). This is typically used to recycle an object
+// returned by Unmarshal (with the Recycler option) once it is no longer needed; it must not be used
+// afterwards.
+func RecycleObject(r Recycler, obj any) {
+	switch o := obj.(type) {
+	case map[any]any:
+		// (Keys can't be containers, since they must be hashable.)
+		for _, value := range o {
+			RecycleObject(r, value)
+		}
+		r.PutMap(o)
+	case map[string]any:
+		for _, value := range o {
+			RecycleObject(r, value)
+		}
+	case *OrderedMap:
+		if o != nil {
+			RecycleObject(r, o.Values)
+		}
+	case []any:
+		for _, element := range o {
+			RecycleObject(r, element)
+		}
+		r.PutSlice(o)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests recycler.go.
+
+package umsgpack_test
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+// testRecycler is a Recycler that counts (outstanding) containers, and reuses them in LIFO order
+// (deterministically, unlike SyncPoolRecycler).
+type testRecycler struct {
+	gotMaps   int
+	gotSlices int
+	maps      []map[any]any
+	slices    [][]any
+}
+
+var _ Recycler = (*testRecycler)(nil)
+
+func (r *testRecycler) GetMap() map[any]any {
+	r.gotMaps += 1
+	if len(r.maps) > 0 {
+		m := r.maps[len(r.maps)-1]
+		r.maps = r.maps[:len(r.maps)-1]
+		return m
+	}
+	return map[any]any{}
+}
+
+func (r *testRecycler) PutMap(m map[any]any) {
+	r.gotMaps -= 1
+	clear(m)
+	r.maps = append(r.maps, m)
+}
+
+func (r *testRecycler) GetSlice(n int) []any {
+	r.gotSlices += 1
+	if len(r.slices) > 0 {
+		s := r.slices[len(r.slices)-1]
+		r.slices = r.slices[:len(r.slices)-1]
+		return s[:0]
+	}
+	return make([]any, 0, n)
+}
+
+func (r *testRecycler) PutSlice(s []any) {
+	r.gotSlices -= 1
+	r.slices = append(r.slices, s)
+}
+
+func TestUnmarshal_recycler(t *testing.T) {
+	// Results should be the same with a recycler.
+	testUnmarshal(t, &UnmarshalOptions{Recycler: &SyncPoolRecycler{}}, commonUnmarshalTestCases)
+	testUnmarshal(t, &UnmarshalOptions{Recycler: &testRecycler{}}, commonUnmarshalTestCases)
+
+	// [{1: 2}, [3], {"a": [4]}]
+	encoded := []byte{0x93, 0x81, 0x01, 0x02, 0x91, 0x03, 0x81, 0xa1, 0x61, 0x91, 0x04}
+	expected := []any{map[any]any{1: 2}, []any{3}, map[any]any{"a": []any{4}}}
+
+	recycler := &testRecycler{}
+	opts := &UnmarshalOptions{Recycler: recycler}
+	obj, err := UnmarshalBytes(opts, encoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(obj, expected) {
+		t.Fatalf("unexpected result: %#v", obj)
+	} else if recycler.gotMaps != 2 || recycler.gotSlices != 3 {
+		t.Errorf("unexpected containers outstanding: %v maps, %v slices", recycler.gotMaps, recycler.gotSlices)
+	}
+
+	// Recycle, and unmarshal again: the containers should be reused.
+	RecycleObject(recycler, obj)
+	if recycler.gotMaps != 0 || recycler.gotSlices != 0 {
+		t.Errorf("unexpected containers outstanding: %v maps, %v slices", recycler.gotMaps, recycler.gotSlices)
+	}
+	if obj, err := UnmarshalBytes(opts, encoded); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(obj, expected) {
+		t.Errorf("unexpected result: %#v", obj)
+	} else if len(recycler.maps) != 0 || len(recycler.slices) != 0 {
+		t.Errorf("containers not reused: %v maps, %v slices", len(recycler.maps), len(recycler.slices))
+	}
+}
+
+func TestUnmarshal_recyclerPutBack(t *testing.T) {
+	// Maps converted to map[string]any are put back.
+	recycler := &testRecycler{}
+	opts := &UnmarshalOptions{Recycler: recycler, StringKeyedMaps: true}
+	if obj, err := UnmarshalBytes(opts, []byte{0x91, 0x81, 0xa1, 0x61, 0x01}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(obj, []any{map[string]any{"a": 1}}) {
+		t.Errorf("unexpected result: %#v", obj)
+	} else if recycler.gotMaps != 0 || recycler.gotSlices != 1 {
+		t.Errorf("unexpected containers outstanding: %v maps, %v slices", recycler.gotMaps, recycler.gotSlices)
+	}
+
+	// The values of an *OrderedMap are recycled.
+	recycler = &testRecycler{}
+	opts = &UnmarshalOptions{Recycler: recycler, OrderedMaps: true}
+	if obj, err := UnmarshalBytes(opts, []byte{0x81, 0x01, 0x91, 0x81, 0x02, 0x03}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if recycler.gotMaps != 2 || recycler.gotSlices != 1 {
+		t.Errorf("unexpected containers outstanding: %v maps, %v slices", recycler.gotMaps, recycler.gotSlices)
+	} else if RecycleObject(recycler, obj); recycler.gotMaps != 0 || recycler.gotSlices != 0 {
+		t.Errorf("unexpected containers outstanding: %v maps, %v slices", recycler.gotMaps, recycler.gotSlices)
+	}
+
+	// Containers are put back on error.
+	recycler = &testRecycler{}
+	opts = &UnmarshalOptions{Recycler: recycler}
+	for _, encoded := range [][]byte{
+		{0x92, 0x81, 0x01, 0x02},
+		{0x92, 0x81, 0x01, 0x02, 0xc1},
+		{0x91, 0x82, 0x01, 0x02, 0x01, 0x03},
+		{0x91, 0x82, 0x01, 0x91, 0x02, 0x01, 0x91, 0x03},
+		{0x82, 0x91, 0x01, 0x91, 0x02, 0x01, 0x03},
+	} {
+		if _, err := UnmarshalBytes(opts, encoded); err == nil {
+			t.Errorf("unexpected success for encoded=%q", encoded)
+		} else if recycler.gotMaps != 0 || recycler.gotSlices != 0 {
+			t.Errorf("unexpected containers outstanding for encoded=%q: %v maps, %v slices", encoded, recycler.gotMaps, recycler.gotSlices)
+		}
+	}
+}
+
+func TestSyncPoolRecycler(t *testing.T) {
+	var recycler SyncPoolRecycler
+
+	m := recycler.GetMap()
+	if m == nil || len(m) != 0 {
+		t.Errorf("unexpected map: %#v", m)
+	}
+	m["a"] = 1
+	recycler.PutMap(m)
+	if len(m) != 0 {
+		t.Errorf("map not cleared: %#v", m)
+	}
+
+	s := recycler.GetSlice(10)
+	if len(s) != 0 || cap(s) < 10 {
+		t.Errorf("unexpected slice: len=%v, cap=%v", len(s), cap(s))
+	}
+	s = append(s, 1, 2)
+	recycler.PutSlice(s)
+	if s[0] != nil || s[1] != nil {
+		t.Errorf("slice not cleared: %#v", s)
+	}
+	if s := recycler.GetSlice(100); len(s) != 0 || cap(s) < 100 {
+		t.Errorf("unexpected slice: len=%v, cap=%v", len(s), cap(s))
+	}
+
+	// Errors aren't affected.
+	opts := &UnmarshalOptions{Recycler: &recycler}
+	if _, err := UnmarshalBytes(opts, []byte{0x92, 0x01}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -8,6 +8,7 @@
 package umsgpack_test
 
 import (
+	"bytes"
 	"math"
 	"reflect"
 	"strconv"
@@ -159,7 +160,7 @@ var roundTrippableObjects = []any{
 	// - timestamp 64
 	time.Unix(0x23456789a, 123456789),
 	// - timestamp 96
-	time.Unix(0x123456789abcdef0, 0x12345678),
+	time.Unix(0x3456789abc, 0x12345678),
 }
 
 func testRoundtripObj(t *testing.T, name string, obj any) {
@@ -189,3 +190,97 @@ func TestRoundtrip(t *testing.T) {
 	// The whole array is roundtrippable!
 	testRoundtripObj(t, "everything", roundTrippableObjects)
 }
+
+func TestRoundtrip_all(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := MarshalAll(nil, buf, roundTrippableObjects); err != nil {
+		t.Fatalf("Unexpected error from MarshalAll: %v", err)
+	}
+	if decoded, err := UnmarshalAll(nil, buf); err != nil {
+		t.Errorf("Unexpected error from UnmarshalAll: %v", err)
+	} else if !reflect.DeepEqual(decoded, roundTrippableObjects) {
+		t.Errorf("Unexpected result from UnmarshalAll: %#v", decoded)
+	}
+}
+
+func TestRoundtrip_orderedMapsBytes(t *testing.T) {
+	// Data that is minimally encoded (but whose maps' entries are in arbitrary order) should be
+	// reproduced exactly by unmarshalling with OrderedMaps and marshalling again. (Extensions are
+	// left unresolved, since extension data isn't checked for minimality.)
+	opts := &UnmarshalOptions{
+		OrderedMaps:                         true,
+		RequireMinimalEncoding:              true,
+		DisableStandardUnmarshalTransformer: true,
+	}
+	count := 0
+	for _, tC := range commonUnmarshalTestCases {
+		decoded, err := UnmarshalBytes(opts, tC.encoded)
+		if err != nil {
+			// Skip invalid and non-minimal data.
+			continue
+		}
+		count += 1
+		if encoded, err := MarshalToBytes(nil, decoded); err != nil {
+			t.Errorf("%q: MarshalToBytes returned error: %v", tC.encoded, err)
+		} else if !bytes.Equal(encoded, tC.encoded) {
+			t.Errorf("%q: roundtrip mismatch: %q", tC.encoded, encoded)
+		}
+	}
+	if count == 0 {
+		t.Errorf("No test cases")
+	}
+
+	// Map entries in non-sorted order, with nested maps.
+	encoded := []byte{0x83, 0xa1, 0x7a, 0x81, 0x02, 0x01, 0x01, 0x80, 0xa1, 0x61, 0x92, 0x81, 0xc3, 0xc2, 0xc0}
+	if decoded, err := UnmarshalBytes(opts, encoded); err != nil {
+		t.Errorf("UnmarshalBytes returned error: %v", err)
+	} else if reencoded, err := MarshalToBytes(nil, decoded); err != nil || !bytes.Equal(reencoded, encoded) {
+		t.Errorf("Unexpected result: %q, %v", reencoded, err)
+	}
+}
+
+func TestRoundtrip_timeInstant(t *testing.T) {
+	// Only the instant is round-tripped; the location and monotonic clock reading are dropped.
+	instant := time.Unix(0x23456789a, 123456789)
+	times := []time.Time{
+		instant.UTC(),
+		instant.In(time.FixedZone("UTC+5:30", 5*60*60+30*60)),
+		instant.In(time.FixedZone("UTC-8", -8*60*60)),
+		time.Now(),
+	}
+	for i, tm := range times {
+		encoded, err := MarshalToBytes(nil, tm)
+		if err != nil {
+			t.Errorf("%v: MarshalToBytes returned error: %v", i, err)
+			continue
+		}
+		// The same instant is marshalled identically, regardless of location.
+		if expected, _ := MarshalToBytes(nil, tm.UTC()); !bytes.Equal(encoded, expected) {
+			t.Errorf("%v: unexpected encoding: %q (expected: %q)", i, encoded, expected)
+		}
+
+		for _, loc := range []*time.Location{nil, time.UTC, time.FixedZone("UTC+1", 60*60)} {
+			decoded, err := UnmarshalBytes(&UnmarshalOptions{TimestampLocation: loc}, encoded)
+			if err != nil {
+				t.Errorf("%v/%v: UnmarshalBytes returned error: %v", i, loc, err)
+				continue
+			}
+			decodedTm, ok := decoded.(time.Time)
+			if !ok || !decodedTm.Equal(tm) {
+				t.Errorf("%v/%v: roundtrip mismatch: %#v (expected: %v)", i, loc, decoded, tm)
+				continue
+			}
+			expectedLoc := loc
+			if expectedLoc == nil {
+				expectedLoc = time.Local
+			}
+			if decodedTm.Location() != expectedLoc {
+				t.Errorf("%v/%v: unexpected location: %v", i, loc, decodedTm.Location())
+			}
+			// The monotonic clock reading is dropped.
+			if decodedTm != decodedTm.Round(0) {
+				t.Errorf("%v/%v: unexpected monotonic clock reading: %v", i, loc, decodedTm)
+			}
+		}
+	}
+}
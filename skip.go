@@ -0,0 +1,265 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains Skip, etc.
+
+package umsgpack
+
+import (
+	"io"
+
+	"github.com/viettrungluu/umsgpack/internal"
+)
+
+// Skip reads and discards exactly one (MessagePack) object from r, including all its contents
+// (for arrays and maps), without unmarshalling it. This is much cheaper than unmarshalling and
+// discarding the result, since no objects are allocated; e.g., large strings and binary data are
+// read in chunks and discarded.
+//
+// Only the size limit options (MaxReaderBytes, MaxMapEntries, MaxArrayEntries, MaxDepth,
+// MaxExtensionBytes, MaxStringBytes, and MaxBinaryBytes) and ReadChunkSize apply; other options are ignored. In
+// particular, RejectTrailingBytes is ignored (so that the rest of the data may be read),
+// RequireMinimalEncoding is not checked, and transformers are not run (so the data of extensions,
+// e.g., timestamps, is not validated).
+// Contained objects are skipped iteratively (not recursively), so deeply-nested data does not
+// consume stack (only a small amount of memory per level of nesting).
+//
+// Errors are as for Unmarshal.
+func Skip(opts *UnmarshalOptions, r io.Reader) error {
+	if opts == nil {
+		opts = DefaultUnmarshalOptions
+	}
+	rv, err := newReadViewerForReader(opts, r)
+	if err != nil {
+		return err
+	}
+	return skipReadViewer(opts, rv)
+}
+
+// SkipBytesN is like Skip, except taking byte data instead of an io.Reader; it returns the number
+// of bytes consumed (i.e., the offset in data just after the object) on success.
+func SkipBytesN(opts *UnmarshalOptions, data []byte) (int, error) {
+	r := &internal.ReadViewerForBuffer{Buffer: data}
+	if err := skipReadViewer(opts, r); err != nil {
+		return 0, err
+	}
+	return int(r.Pos()), nil
+}
+
+// skipReadViewer is like Skip, except that it takes a ReadViewer instead of an io.Reader.
+func skipReadViewer(opts *UnmarshalOptions, r internal.ReadViewer) error {
+	if opts == nil {
+		opts = DefaultUnmarshalOptions
+	}
+	u := &unmarshaller{opts: opts, r: r}
+	if opts.MaxReaderBytes > 0 {
+		u.r = &limitedReadViewer{r: r, left: uint(opts.MaxReaderBytes)}
+	}
+	if err := u.skipObjects(1); err != nil {
+		// Don't wrap io.EOF at the very beginning (i.e., no data), like Unmarshal.
+		if err == io.EOF && u.pos == 0 {
+			return err
+		}
+		return &DecodeError{Err: err, Offset: int(u.pos)}
+	}
+	return nil
+}
+
+// skipObjects skips n objects (and their contents).
+func (u *unmarshaller) skipObjects(n uint64) error {
+	// pending[i] is the number of objects remaining to be skipped at depth i+1.
+	pending := []uint64{n}
+	for len(pending) > 0 {
+		if pending[len(pending)-1] == 0 {
+			pending = pending[:len(pending)-1]
+			continue
+		}
+		pending[len(pending)-1] -= 1
+
+		b, err := u.readByte()
+		if err != nil {
+			return err
+		}
+		dataLen, numChildren, err := u.skipHeader(b)
+		if err != nil {
+			return err
+		}
+		if err := u.skipData(dataLen); err != nil {
+			return err
+		}
+		if numChildren > 0 {
+			if u.opts.MaxDepth > 0 && uint(len(pending)) >= u.opts.MaxDepth {
+				return TooDeeplyNestedError
+			}
+			pending = append(pending, numChildren)
+		}
+	}
+	return nil
+}
+
+// skipHeader reads the rest of the header (i.e., the length, if any) of an object whose first
+// byte is b, returning the length of the data that follows (excluding contained objects) and the
+// number of contained objects (twice the number of entries for maps).
+func (u *unmarshaller) skipHeader(b byte) (dataLen uint, numChildren uint64, err error) {
+	switch {
+	case b <= 0x7f: // positive fixint: 0xxxxxxx: 0x00 - 0x7f
+		return 0, 0, nil
+	case b <= 0x8f: // fixmap: 1000xxxx: 0x80 - 0x8f
+		return u.skipMapHeader(uint(b & 0b1111))
+	case b <= 0x9f: // fixarray: 1001xxxx: 0x90 - 0x9f
+		return u.skipArrayHeader(uint(b & 0b1111))
+	case b <= 0xbf: // fixstr: 101xxxxx: 0xa0 - 0xbf
+		return u.skipStringHeader(uint(b & 0b11111))
+	case b >= 0xe0: // negative fixint: 111xxxxx: 0xe0 - 0xff
+		return 0, 0, nil
+	}
+
+	switch b {
+	case 0xc0, 0xc2, 0xc3: // nil, false, true
+		return 0, 0, nil
+	case 0xc1: // (never used)
+		return 0, 0, InvalidFormatError
+	case 0xc4: // bin 8
+		return u.skipBinaryHeader(u.unmarshalUint8)
+	case 0xc5: // bin 16
+		return u.skipBinaryHeader(u.unmarshalUint16)
+	case 0xc6: // bin 32
+		return u.skipBinaryHeader(u.unmarshalUint32)
+	case 0xc7: // ext 8
+		return u.skipExtHeader(u.unmarshalUint8)
+	case 0xc8: // ext 16
+		return u.skipExtHeader(u.unmarshalUint16)
+	case 0xc9: // ext 32
+		return u.skipExtHeader(u.unmarshalUint32)
+	case 0xca: // float 32
+		return 4, 0, nil
+	case 0xcb: // float 64
+		return 8, 0, nil
+	case 0xcc, 0xd0: // uint 8, int 8
+		return 1, 0, nil
+	case 0xcd, 0xd1: // uint 16, int 16
+		return 2, 0, nil
+	case 0xce, 0xd2: // uint 32, int 32
+		return 4, 0, nil
+	case 0xcf, 0xd3: // uint 64, int 64
+		return 8, 0, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext {1,2,4,8,16}
+		return u.skipFixExtHeader(1 << (b - 0xd4))
+	case 0xd9: // str 8
+		return u.skipNonFixStringHeader(u.unmarshalUint8)
+	case 0xda: // str 16
+		return u.skipNonFixStringHeader(u.unmarshalUint16)
+	case 0xdb: // str 32
+		return u.skipNonFixStringHeader(u.unmarshalUint32)
+	case 0xdc: // array 16
+		n, _, err := u.unmarshalUint16()
+		if err != nil {
+			return 0, 0, err
+		}
+		return u.skipArrayHeader(n)
+	case 0xdd: // array 32
+		n, _, err := u.unmarshalUint32()
+		if err != nil {
+			return 0, 0, err
+		}
+		return u.skipArrayHeader(n)
+	case 0xde: // map 16
+		n, _, err := u.unmarshalUint16()
+		if err != nil {
+			return 0, 0, err
+		}
+		return u.skipMapHeader(n)
+	case 0xdf: // map 32
+		n, _, err := u.unmarshalUint32()
+		if err != nil {
+			return 0, 0, err
+		}
+		return u.skipMapHeader(n)
+	}
+
+	// Should be unreachable (all first bytes are handled above).
+	return 0, 0, InternalDecodeError
+}
+
+// skipBinaryHeader reads the length of binary data using unmarshalLength and checks it, returning
+// the data length.
+func (u *unmarshaller) skipBinaryHeader(unmarshalLength func() (uint, bool, error)) (uint, uint64, error) {
+	n, _, err := unmarshalLength()
+	if err != nil {
+		return 0, 0, err
+	}
+	if u.opts.MaxBinaryBytes > 0 && n > u.opts.MaxBinaryBytes {
+		return 0, 0, BinaryTooLongError
+	}
+	return n, 0, nil
+}
+
+// skipNonFixStringHeader reads the length of a (non-fix) string using unmarshalLength, and is
+// otherwise like skipStringHeader.
+func (u *unmarshaller) skipNonFixStringHeader(unmarshalLength func() (uint, bool, error)) (uint, uint64, error) {
+	n, _, err := unmarshalLength()
+	if err != nil {
+		return 0, 0, err
+	}
+	return u.skipStringHeader(n)
+}
+
+// skipStringHeader checks a string of length n, returning the data length.
+func (u *unmarshaller) skipStringHeader(n uint) (uint, uint64, error) {
+	if u.opts.MaxStringBytes > 0 && n > u.opts.MaxStringBytes {
+		return 0, 0, StringTooLongError
+	}
+	return n, 0, nil
+}
+
+// skipMapHeader checks a map with n entries, returning the number of contained objects.
+func (u *unmarshaller) skipMapHeader(n uint) (uint, uint64, error) {
+	if u.opts.MaxMapEntries > 0 && n > u.opts.MaxMapEntries {
+		return 0, 0, TooManyEntriesError
+	}
+	return 0, 2 * uint64(n), nil
+}
+
+// skipArrayHeader is like skipMapHeader, but for arrays.
+func (u *unmarshaller) skipArrayHeader(n uint) (uint, uint64, error) {
+	if u.opts.MaxArrayEntries > 0 && n > u.opts.MaxArrayEntries {
+		return 0, 0, TooManyEntriesError
+	}
+	return 0, uint64(n), nil
+}
+
+// skipExtHeader reads the length of an (non-fixed) extension using unmarshalLength, and is
+// otherwise like skipFixExtHeader.
+func (u *unmarshaller) skipExtHeader(unmarshalLength func() (uint, bool, error)) (uint, uint64, error) {
+	n, _, err := unmarshalLength()
+	if err != nil {
+		return 0, 0, err
+	}
+	return u.skipFixExtHeader(n)
+}
+
+// skipFixExtHeader checks an extension with n bytes of data, returning the data length (including
+// the extension type).
+func (u *unmarshaller) skipFixExtHeader(n uint) (uint, uint64, error) {
+	if u.opts.MaxExtensionBytes > 0 && n > u.opts.MaxExtensionBytes {
+		return 0, 0, ExtensionTooLargeError
+	}
+	return 1 + n, 0, nil
+}
+
+// skipData reads and discards n bytes (in chunks, per the ReadChunkSize option, to avoid
+// allocating).
+func (u *unmarshaller) skipData(n uint) error {
+	chunkSize := uint(internal.ReaderChunkSize)
+	if u.opts.ReadChunkSize > 0 {
+		chunkSize = uint(u.opts.ReadChunkSize)
+	}
+	for n > 0 {
+		chunk := min(n, chunkSize)
+		if _, err := u.readView(chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
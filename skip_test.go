@@ -0,0 +1,130 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests skip.go.
+
+package umsgpack_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestSkip_validData(t *testing.T) {
+	for _, tC := range commonUnmarshalTestCases {
+		if tC.err != nil {
+			continue
+		}
+
+		// Add trailing data, which should not be consumed.
+		data := append(append([]byte{}, tC.encoded...), 0xc3)
+		if n, err := SkipBytesN(nil, data); err != nil {
+			t.Errorf("unexpected error for encoded=%q: %v", tC.encoded, err)
+		} else if n != len(tC.encoded) {
+			t.Errorf("unexpected result for encoded=%q: %v", tC.encoded, n)
+		}
+
+		buf := bytes.NewBuffer(data)
+		if err := Skip(nil, buf); err != nil {
+			t.Errorf("unexpected error for encoded=%q: %v", tC.encoded, err)
+		} else if buf.Len() != 1 {
+			t.Errorf("unexpected remaining data for encoded=%q: %q", tC.encoded, buf.Bytes())
+		}
+	}
+}
+
+func TestSkip_errors(t *testing.T) {
+	testCases := []struct {
+		opts    *UnmarshalOptions
+		encoded []byte
+		err     error
+	}{
+		{nil, []byte{}, io.EOF},
+		{nil, []byte{0xc1}, InvalidFormatError},
+		{nil, []byte{0x92, 0x01, 0xc1}, InvalidFormatError},
+		// Truncated.
+		{nil, []byte{0xa2, 0x61}, io.ErrUnexpectedEOF},
+		{nil, []byte{0xcd, 0x01}, io.ErrUnexpectedEOF},
+		{nil, []byte{0xc5, 0x01}, io.ErrUnexpectedEOF},
+		{nil, []byte{0xd6, 0xff, 0x00}, io.ErrUnexpectedEOF},
+		{nil, []byte{0x92, 0x01}, io.ErrUnexpectedEOF},
+		{nil, []byte{0x81, 0x01}, io.ErrUnexpectedEOF},
+		{nil, []byte{0xdf, 0xff, 0xff, 0xff, 0xff, 0x01}, io.ErrUnexpectedEOF},
+		// Limits.
+		{&UnmarshalOptions{MaxArrayEntries: 1}, []byte{0x92, 0x01, 0x02}, TooManyEntriesError},
+		{&UnmarshalOptions{MaxArrayEntries: 1}, []byte{0x91, 0x92, 0x01, 0x02}, TooManyEntriesError},
+		{&UnmarshalOptions{MaxMapEntries: 1}, []byte{0x82, 0x01, 0x02, 0x03, 0x04}, TooManyEntriesError},
+		{&UnmarshalOptions{MaxExtensionBytes: 2}, []byte{0xd6, 0x01}, ExtensionTooLargeError},
+		{&UnmarshalOptions{MaxExtensionBytes: 2}, []byte{0xc7, 0x03}, ExtensionTooLargeError},
+		{&UnmarshalOptions{MaxReaderBytes: 2}, []byte{0xa2, 0x61, 0x62}, MessageTooLargeError},
+		{&UnmarshalOptions{MaxStringBytes: 1}, []byte{0xa2}, StringTooLongError},
+		{&UnmarshalOptions{MaxStringBytes: 1}, []byte{0x91, 0xd9, 0x02}, StringTooLongError},
+		{&UnmarshalOptions{MaxBinaryBytes: 1}, []byte{0xc4, 0x02}, BinaryTooLongError},
+		{&UnmarshalOptions{MaxBinaryBytes: 1}, []byte{0xc6, 0x00, 0x00, 0x01, 0x00}, BinaryTooLongError},
+		{&UnmarshalOptions{MaxDepth: 2}, []byte{0x91, 0x91, 0xc0}, TooDeeplyNestedError},
+		{&UnmarshalOptions{MaxDepth: 2}, []byte{0x92, 0x01, 0x81, 0x01, 0x02}, TooDeeplyNestedError},
+	}
+	for _, tC := range testCases {
+		if _, err := SkipBytesN(tC.opts, tC.encoded); !errors.Is(err, tC.err) {
+			t.Errorf("unexpected error for encoded=%q: %v (expected: %v)", tC.encoded, err, tC.err)
+		}
+		if err := Skip(tC.opts, bytes.NewBuffer(tC.encoded)); !errors.Is(err, tC.err) {
+			t.Errorf("unexpected error for encoded=%q: %v (expected: %v)", tC.encoded, err, tC.err)
+		}
+	}
+}
+
+func TestSkip_notValidated(t *testing.T) {
+	// Things that Unmarshal would reject, but which are structurally valid.
+	for _, encoded := range [][]byte{
+		// Duplicate keys.
+		{0x82, 0x01, 0xc0, 0x01, 0xc0},
+		// Invalid timestamp.
+		{0xc7, 0x00, 0xff},
+		// Non-minimal encoding (even with RequireMinimalEncoding).
+		{0xcc, 0x01},
+	} {
+		if n, err := SkipBytesN(&UnmarshalOptions{RequireMinimalEncoding: true}, encoded); err != nil {
+			t.Errorf("unexpected error for encoded=%q: %v", encoded, err)
+		} else if n != len(encoded) {
+			t.Errorf("unexpected result for encoded=%q: %v", encoded, n)
+		}
+	}
+}
+
+func TestSkip_deeplyNested(t *testing.T) {
+	const depth = 1_000_000
+	data := append(bytes.Repeat([]byte{0x91}, depth), 0xc0)
+	if n, err := SkipBytesN(nil, data); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if n != len(data) {
+		t.Errorf("unexpected result: %v", n)
+	}
+
+	if _, err := SkipBytesN(&UnmarshalOptions{MaxDepth: depth}, data); !errors.Is(err, TooDeeplyNestedError) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n, err := SkipBytesN(&UnmarshalOptions{MaxDepth: depth + 1}, data); err != nil || n != len(data) {
+		t.Errorf("unexpected result: %v, %v", n, err)
+	}
+}
+
+func TestSkip_largeBinDoesNotAllocate(t *testing.T) {
+	const size = 1 << 20
+	data := append([]byte{0xc6, 0x00, 0x10, 0x00, 0x00}, make([]byte, size)...)
+	r := bytes.NewReader(data)
+	allocs := testing.AllocsPerRun(10, func() {
+		r.Reset(data)
+		if err := Skip(nil, r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	// There are a few small allocations (e.g., the buffer), but not proportional to size.
+	if allocs > 5 {
+		t.Errorf("unexpected number of allocations: %v", allocs)
+	}
+}
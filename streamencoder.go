@@ -0,0 +1,128 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains ArrayEncoder and MapEncoder, for streaming (large) arrays and maps without
+// materializing them.
+
+package umsgpack
+
+import (
+	"errors"
+	"io"
+)
+
+// Errors ------------------------------------------------------------------------------------------
+
+// WrongElementCountError is the error returned by an ArrayEncoder or MapEncoder if the number of
+// elements (or entries) encoded does not match the number declared: i.e., if too many are encoded,
+// if End is called (or another array/map is begun) before enough have been encoded, or if the
+// declared number is negative.
+var WrongElementCountError = errors.New("Wrong element count")
+
+// ArrayEncoder ------------------------------------------------------------------------------------
+
+// An ArrayEncoder streams arrays to an io.Writer, one element at a time: BeginArray writes the
+// prefix for an array with a given number of elements, which must then be written using exactly
+// that many calls to EncodeElement, followed by End.
+type ArrayEncoder struct {
+	m    marshaller
+	left int
+}
+
+// NewArrayEncoder creates a new ArrayEncoder that writes to w, marshalling elements using the given
+// options (which may be nil, for the default options). StandardMarshalTransformer should not be
+// replaced while the encoder is in use.
+func NewArrayEncoder(opts *MarshalOptions, w io.Writer) *ArrayEncoder {
+	if opts == nil {
+		opts = DefaultMarshalOptions
+	}
+	e := &ArrayEncoder{}
+	e.m.reset(opts, w)
+	return e
+}
+
+// BeginArray writes the prefix for an array with n elements.
+func (e *ArrayEncoder) BeginArray(n int) error {
+	if e.left != 0 || n < 0 {
+		return WrongElementCountError
+	}
+	if err := e.m.writeArrayPrefix(n); err != nil {
+		return err
+	}
+	e.left = n
+	return nil
+}
+
+// EncodeElement marshals obj as the next element of the current array.
+func (e *ArrayEncoder) EncodeElement(obj any) error {
+	if e.left <= 0 {
+		return WrongElementCountError
+	}
+	e.left -= 1
+	return e.m.marshalObject(obj)
+}
+
+// End checks that all the elements of the current array have been encoded.
+func (e *ArrayEncoder) End() error {
+	if e.left != 0 {
+		return WrongElementCountError
+	}
+	return nil
+}
+
+// MapEncoder --------------------------------------------------------------------------------------
+
+// A MapEncoder streams maps to an io.Writer, one entry at a time: BeginMap writes the prefix for a
+// map with a given number of entries, which must then be written using exactly that many calls to
+// EncodeKeyValue, followed by End.
+//
+// Note that the MapKeyOrder option does not apply (entries are written in the order given), and
+// keys are not checked for duplicates.
+type MapEncoder struct {
+	m    marshaller
+	left int
+}
+
+// NewMapEncoder creates a new MapEncoder that writes to w, marshalling keys and values using the
+// given options (which may be nil, for the default options). StandardMarshalTransformer should not
+// be replaced while the encoder is in use.
+func NewMapEncoder(opts *MarshalOptions, w io.Writer) *MapEncoder {
+	if opts == nil {
+		opts = DefaultMarshalOptions
+	}
+	e := &MapEncoder{}
+	e.m.reset(opts, w)
+	return e
+}
+
+// BeginMap writes the prefix for a map with n entries.
+func (e *MapEncoder) BeginMap(n int) error {
+	if e.left != 0 || n < 0 {
+		return WrongElementCountError
+	}
+	if err := e.m.writeMapPrefix(n); err != nil {
+		return err
+	}
+	e.left = n
+	return nil
+}
+
+// EncodeKeyValue marshals key and value as the next entry of the current map.
+func (e *MapEncoder) EncodeKeyValue(key any, value any) error {
+	if e.left <= 0 {
+		return WrongElementCountError
+	}
+	e.left -= 1
+	if err := e.m.marshalMapKey(key); err != nil {
+		return err
+	}
+	return e.m.marshalObject(value)
+}
+
+// End checks that all the entries of the current map have been encoded.
+func (e *MapEncoder) End() error {
+	if e.left != 0 {
+		return WrongElementCountError
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests streamencoder.go.
+
+package umsgpack_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+func TestArrayEncoder(t *testing.T) {
+	for _, n := range []int{0, 1, 15, 16, 0xffff, 0x10000} {
+		buf := &bytes.Buffer{}
+		enc := NewArrayEncoder(nil, buf)
+		if err := enc.BeginArray(n); err != nil {
+			t.Fatalf("BeginArray(%v) failed: %v", n, err)
+		}
+		expected := make([]any, n)
+		for i := 0; i < n; i += 1 {
+			expected[i] = i
+			if err := enc.EncodeElement(i); err != nil {
+				t.Fatalf("EncodeElement failed: %v", err)
+			}
+		}
+		if err := enc.End(); err != nil {
+			t.Errorf("End failed: %v", err)
+		}
+
+		if expectedEncoded, err := MarshalToBytes(nil, expected); err != nil || !bytes.Equal(buf.Bytes(), expectedEncoded) {
+			t.Errorf("Unexpected result for n=%v (err=%v)", n, err)
+		}
+	}
+}
+
+func TestArrayEncoder_multiple(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := NewArrayEncoder(nil, buf)
+	for _, a := range [][]any{{1, "a"}, {}, {[]any{true}}} {
+		if err := enc.BeginArray(len(a)); err != nil {
+			t.Fatalf("BeginArray failed: %v", err)
+		}
+		for _, element := range a {
+			if err := enc.EncodeElement(element); err != nil {
+				t.Fatalf("EncodeElement failed: %v", err)
+			}
+		}
+		if err := enc.End(); err != nil {
+			t.Fatalf("End failed: %v", err)
+		}
+	}
+	expected := []byte{0x92, 0x01, 0xa1, 0x61, 0x90, 0x91, 0x91, 0xc3}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Unexpected result: %v", buf.Bytes())
+	}
+}
+
+func TestArrayEncoder_wrongElementCount(t *testing.T) {
+	enc := NewArrayEncoder(nil, io.Discard)
+	// No array.
+	if err := enc.EncodeElement(1); err != WrongElementCountError {
+		t.Errorf("Unexpected result from EncodeElement: %v", err)
+	}
+	if err := enc.BeginArray(-1); err != WrongElementCountError {
+		t.Errorf("Unexpected result from BeginArray: %v", err)
+	}
+
+	if err := enc.BeginArray(2); err != nil {
+		t.Fatalf("BeginArray failed: %v", err)
+	}
+	if err := enc.EncodeElement(1); err != nil {
+		t.Fatalf("EncodeElement failed: %v", err)
+	}
+	// Too few.
+	if err := enc.End(); err != WrongElementCountError {
+		t.Errorf("Unexpected result from End: %v", err)
+	}
+	if err := enc.BeginArray(1); err != WrongElementCountError {
+		t.Errorf("Unexpected result from BeginArray: %v", err)
+	}
+	if err := enc.EncodeElement(2); err != nil {
+		t.Fatalf("EncodeElement failed: %v", err)
+	}
+	// Too many.
+	if err := enc.EncodeElement(3); err != WrongElementCountError {
+		t.Errorf("Unexpected result from EncodeElement: %v", err)
+	}
+	if err := enc.End(); err != nil {
+		t.Errorf("End failed: %v", err)
+	}
+}
+
+func TestArrayEncoder_errors(t *testing.T) {
+	enc := NewArrayEncoder(nil, &limitedDiscardWriter{0})
+	if err := enc.BeginArray(1); err != io.ErrShortWrite {
+		t.Errorf("Unexpected result from BeginArray: %v", err)
+	}
+
+	enc = NewArrayEncoder(nil, io.Discard)
+	if err := enc.BeginArray(1); err != nil {
+		t.Fatalf("BeginArray failed: %v", err)
+	}
+	if err := enc.EncodeElement(make(chan int)); !errors.Is(err, UnsupportedTypeForMarshallingError) {
+		t.Errorf("Unexpected result from EncodeElement: %v", err)
+	}
+}
+
+func TestMapEncoder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := NewMapEncoder(nil, buf)
+	if err := enc.BeginMap(2); err != nil {
+		t.Fatalf("BeginMap failed: %v", err)
+	}
+	if err := enc.EncodeKeyValue("a", 1); err != nil {
+		t.Fatalf("EncodeKeyValue failed: %v", err)
+	}
+	if err := enc.EncodeKeyValue(2, []any{"b"}); err != nil {
+		t.Fatalf("EncodeKeyValue failed: %v", err)
+	}
+	if err := enc.End(); err != nil {
+		t.Errorf("End failed: %v", err)
+	}
+	expected := []byte{0x82, 0xa1, 0x61, 0x01, 0x02, 0x91, 0xa1, 0x62}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Unexpected result: %v", buf.Bytes())
+	}
+	if decoded, err := UnmarshalBytes(nil, buf.Bytes()); err != nil || !reflect.DeepEqual(decoded, map[any]any{"a": 1, 2: []any{"b"}}) {
+		t.Errorf("Unexpected result from UnmarshalBytes: %v, %v", decoded, err)
+	}
+}
+
+func TestMapEncoder_wrongElementCount(t *testing.T) {
+	enc := NewMapEncoder(nil, io.Discard)
+	if err := enc.EncodeKeyValue("a", 1); err != WrongElementCountError {
+		t.Errorf("Unexpected result from EncodeKeyValue: %v", err)
+	}
+	if err := enc.BeginMap(-1); err != WrongElementCountError {
+		t.Errorf("Unexpected result from BeginMap: %v", err)
+	}
+	if err := enc.BeginMap(1); err != nil {
+		t.Fatalf("BeginMap failed: %v", err)
+	}
+	if err := enc.End(); err != WrongElementCountError {
+		t.Errorf("Unexpected result from End: %v", err)
+	}
+	if err := enc.EncodeKeyValue("a", 1); err != nil {
+		t.Fatalf("EncodeKeyValue failed: %v", err)
+	}
+	if err := enc.EncodeKeyValue("b", 2); err != WrongElementCountError {
+		t.Errorf("Unexpected result from EncodeKeyValue: %v", err)
+	}
+	if err := enc.End(); err != nil {
+		t.Errorf("End failed: %v", err)
+	}
+}
+
+func TestMapEncoder_keyChecks(t *testing.T) {
+	enc := NewMapEncoder(&MarshalOptions{JSONCompatible: true}, io.Discard)
+	if err := enc.BeginMap(2); err != nil {
+		t.Fatalf("BeginMap failed: %v", err)
+	}
+	if err := enc.EncodeKeyValue(1, 1); err != NonStringKeyForMarshallingError {
+		t.Errorf("Unexpected result from EncodeKeyValue: %v", err)
+	}
+	// The value should not be treated as a key.
+	if err := enc.EncodeKeyValue("a", 1); err != nil {
+		t.Errorf("Unexpected result from EncodeKeyValue: %v", err)
+	}
+}
+
+func TestStreamEncoders_replacedStandardMarshalTransformer(t *testing.T) {
+	saved := StandardMarshalTransformer
+	StandardMarshalTransformer = func(obj any) (any, error) {
+		if i, ok := obj.(int); ok {
+			return i + 1, nil
+		}
+		return saved(obj)
+	}
+	defer func() { StandardMarshalTransformer = saved }()
+
+	buf := &bytes.Buffer{}
+	arrayEnc := NewArrayEncoder(nil, buf)
+	if err := arrayEnc.BeginArray(1); err != nil || arrayEnc.EncodeElement(1) != nil || arrayEnc.End() != nil {
+		t.Fatalf("ArrayEncoder failed: %v", err)
+	}
+	mapEnc := NewMapEncoder(nil, buf)
+	if err := mapEnc.BeginMap(1); err != nil || mapEnc.EncodeKeyValue(3, 5) != nil || mapEnc.End() != nil {
+		t.Fatalf("MapEncoder failed: %v", err)
+	}
+	if expected := []byte{0x91, 0x02, 0x81, 0x04, 0x06}; !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Unexpected result: %v", buf.Bytes())
+	}
+}
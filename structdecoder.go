@@ -0,0 +1,88 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains a simple UnmarshalTransformerFn for unmarshalling structs.
+
+package umsgpack
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Errors ------------------------------------------------------------------------------------------
+
+// UnknownStructKeyError is the error returned by a struct unmarshal transformer if a map has a key
+// that does not correspond to a field and the RejectUnknownKeys option is set.
+var UnknownStructKeyError = errors.New("Unknown struct key")
+
+// MakeStructUnmarshalTransformer ------------------------------------------------------------------
+
+// StructUnmarshalTransformerOptions are options for MakeStructUnmarshalTransformer.
+type StructUnmarshalTransformerOptions struct {
+	// If UseTags is set, then fields are matched to map keys using struct tags (with key
+	// TagName), like the corresponding option for MakeStructMarshalTransformer: the tag's name
+	// (if non-empty) is used instead of the field name, and fields with tag `msgpack:"-"` are
+	// never matched.
+	UseTags bool
+
+	// TagName is the struct tag key used if UseTags is set. If empty, the default is "msgpack".
+	TagName string
+
+	// If RejectUnknownKeys is set, then UnknownStructKeyError is returned for map keys that do
+	// not correspond to fields. Otherwise, such keys are ignored.
+	RejectUnknownKeys bool
+}
+
+// MakeStructUnmarshalTransformer makes an UnmarshalTransformerFn for transforming unmarshalled
+// maps with string keys (map[string]any or other string-keyed maps, or map[any]any with only
+// string keys) to values of the same type as prototype (typically a struct, or a pointer to a
+// struct). A new value is allocated for each map, and map values are assigned to the fields as for
+// UnmarshalInto (including conversion of compatible scalar types). An *UnmarshalIntoTypeError is
+// returned if a value cannot be assigned to its field. It panics if prototype is nil (since its
+// type is needed).
+//
+// Other objects are returned as-is. Note that unmarshal transformers are applied to all objects,
+// including nested ones (before their containers), so typically this is only useful if the
+// prototype's fields (recursively) do not themselves need to be unmarshalled from maps.
+func MakeStructUnmarshalTransformer(prototype any, opts *StructUnmarshalTransformerOptions) UnmarshalTransformerFn {
+	if opts == nil {
+		opts = &StructUnmarshalTransformerOptions{}
+	}
+
+	t := reflect.TypeOf(prototype)
+	if t == nil {
+		panic("MakeStructUnmarshalTransformer requires a non-nil prototype")
+	}
+	a := &assigner{opts: &UnmarshalOptions{}, rejectUnknownKeys: opts.RejectUnknownKeys}
+	if opts.UseTags {
+		a.tagName = opts.TagName
+		if a.tagName == "" {
+			a.tagName = "msgpack"
+		}
+	}
+
+	return func(obj any, mapKeySupported bool) (any, bool, error) {
+		switch o := obj.(type) {
+		case map[string]any:
+		case map[any]any:
+			for key := range o {
+				if _, ok := key.(string); !ok {
+					return obj, mapKeySupported, nil
+				}
+			}
+		default:
+			// Other string-keyed maps, e.g., map[string]int (see
+			// UnmarshalOptions.InferMapValueTypes).
+			if objT := reflect.TypeOf(obj); objT == nil || objT.Kind() != reflect.Map || objT.Key().Kind() != reflect.String {
+				return obj, mapKeySupported, nil
+			}
+		}
+
+		v := reflect.New(t).Elem()
+		if err := a.assign(v, obj, ""); err != nil {
+			return nil, false, err
+		}
+		return v.Interface(), false, nil
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests structdecoder.go.
+
+package umsgpack_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+type testStructUnmarshalType struct {
+	Name   string
+	Count  uint16
+	Ratio  float64
+	Flag   bool
+	Tags   []string
+	Tagged int `msgpack:"tagged"`
+	Hidden int `msgpack:"-"`
+	secret int
+}
+
+func TestMakeStructUnmarshalTransformer(t *testing.T) {
+	typeErr := &UnmarshalIntoTypeError{}
+	transformer := MakeStructUnmarshalTransformer(testStructUnmarshalType{}, nil)
+	tagsTransformer := MakeStructUnmarshalTransformer(testStructUnmarshalType{}, &StructUnmarshalTransformerOptions{UseTags: true})
+	rejectTransformer := MakeStructUnmarshalTransformer(testStructUnmarshalType{}, &StructUnmarshalTransformerOptions{RejectUnknownKeys: true})
+	ptrTransformer := MakeStructUnmarshalTransformer(&testStructUnmarshalType{}, nil)
+
+	testCases := []struct {
+		transformer UnmarshalTransformerFn
+		obj         any
+		expected    any
+		err         error
+	}{
+		// Not applicable.
+		{transformer, 123, 123, nil},
+		{transformer, []any{"Name"}, []any{"Name"}, nil},
+		{transformer, map[any]any{"Name": "x", 1: 2}, map[any]any{"Name": "x", 1: 2}, nil},
+		// By name.
+		{transformer, map[string]any{}, testStructUnmarshalType{}, nil},
+		{transformer, map[string]any{
+			"Name":    "x",
+			"Count":   12,
+			"Ratio":   3,
+			"Flag":    true,
+			"Tags":    []any{"a", "b"},
+			"Tagged":  1,
+			"Hidden":  2,
+			"secret":  3,
+			"Unknown": 4,
+		}, testStructUnmarshalType{Name: "x", Count: 12, Ratio: 3.0, Flag: true, Tags: []string{"a", "b"}, Tagged: 1, Hidden: 2}, nil},
+		{transformer, map[any]any{"Name": "x", "Count": uint(1)}, testStructUnmarshalType{Name: "x", Count: 1}, nil},
+		{ptrTransformer, map[any]any{"Name": "x"}, &testStructUnmarshalType{Name: "x"}, nil},
+		{transformer, map[string]int{"Count": 1, "Tagged": 2}, testStructUnmarshalType{Count: 1, Tagged: 2}, nil},
+		{transformer, map[int]int{1: 2}, map[int]int{1: 2}, nil},
+		// By tag.
+		{tagsTransformer, map[string]any{"Name": "x", "Tagged": 1, "tagged": 2, "Hidden": 3}, testStructUnmarshalType{Name: "x", Tagged: 2}, nil},
+		// Unknown keys.
+		{rejectTransformer, map[string]any{"Name": "x"}, testStructUnmarshalType{Name: "x"}, nil},
+		{rejectTransformer, map[string]any{"Name": "x", "Unknown": 1}, nil, UnknownStructKeyError},
+		{rejectTransformer, map[string]any{"secret": 1}, nil, UnknownStructKeyError},
+		// Incompatible types.
+		{transformer, map[string]any{"Name": 1}, nil, typeErr},
+		{transformer, map[string]any{"Count": -1}, nil, typeErr},
+		{transformer, map[string]any{"Tags": []any{1}}, nil, typeErr},
+	}
+	for i, tc := range testCases {
+		result, _, err := tc.transformer(tc.obj, false)
+		if tc.err == nil {
+			if err != nil {
+				t.Errorf("%v: unexpected error: %v", i, err)
+			} else if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("%v: unexpected result: %#v (expected: %#v)", i, result, tc.expected)
+			}
+		} else if err != tc.err && reflect.TypeOf(err) != reflect.TypeOf(tc.err) {
+			t.Errorf("%v: unexpected error: %v (expected: %v)", i, err, tc.err)
+		}
+	}
+}
+
+func TestMakeStructUnmarshalTransformer_roundTrip(t *testing.T) {
+	obj := testStructUnmarshalType{Name: "x", Count: 12, Ratio: 0.5, Tags: []string{"a"}, Tagged: 3, Hidden: 4}
+
+	mopts := &MarshalOptions{
+		ApplicationMarshalTransformer: MakeStructMarshalTransformer(&StructMarshalTransformerOptions{UseTags: true}),
+	}
+	encoded, err := MarshalToBytes(mopts, obj)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	uopts := &UnmarshalOptions{
+		ApplicationUnmarshalTransformer: MakeStructUnmarshalTransformer(testStructUnmarshalType{}, &StructUnmarshalTransformerOptions{UseTags: true}),
+	}
+	decoded, err := UnmarshalBytes(uopts, encoded)
+	expected := obj
+	expected.Hidden = 0
+	if err != nil || !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Unexpected result: %#v, %v (expected: %#v)", decoded, err, expected)
+	}
+}
+
+func TestMakeStructUnmarshalTransformer_nilPrototype(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Expected panic")
+		}
+	}()
+	MakeStructUnmarshalTransformer(nil, nil)
+}
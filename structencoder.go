@@ -7,6 +7,8 @@ package umsgpack
 
 import (
 	"reflect"
+	"strings"
+	"sync"
 )
 
 // StructMarshalTransformerOptions are options for MakeStructMarshalTransformer.
@@ -14,11 +16,43 @@ type StructMarshalTransformerOptions struct {
 	// FieldFn "handles" a field: it decides whether it should be included and if so the map key
 	// to use. If nil, the default is to include all (expored) fields and use the field name
 	// (field.Name) verbatim as the key.
+	//
+	// Fields with tag `msgpack:"-"` (or the equivalent for TagName) are always skipped, even if
+	// UseTags is not set; FieldFn is not called for them.
 	FieldFn func(field reflect.StructField) (includeField bool, mapKey string)
+
+	// If UseTags is set, then struct tags (with key TagName) are honored, similar to
+	// encoding/json: for a field (included by FieldFn) with tag `msgpack:"name,omitempty"`, the
+	// name (if non-empty) is used as the map key instead of the one from FieldFn, and the
+	// omitempty qualifier (if present) causes the field to be skipped if it has the zero value
+	// for its type. (Fields with tag `msgpack:"-"` are skipped regardless.)
+	UseTags bool
+
+	// TagName is the struct tag key used for tags. If empty, the default is "msgpack".
+	TagName string
+
+	// If OmitEmpty is set, then all fields (included by FieldFn) that have the zero value for
+	// their type (as determined by reflect.Value.IsZero) are skipped, as if they had the
+	// omitempty qualifier.
+	OmitEmpty bool
+}
+
+// A MsgpackFieldsProvider provides its own fields for marshalling, as a map from key to value.
+//
+// This allows a type to expose a curated serialization (e.g., including state from unexported
+// fields).
+type MsgpackFieldsProvider interface {
+	MsgpackFields() map[string]any
 }
 
 // MakeStructMarshalTransformer makes a MarshalTransformerFn for transforming structs to a
 // marshallable map[string]any.
+//
+// The fields to include (and their keys) are determined once per struct type and cached, so
+// opts.FieldFn should be deterministic.
+//
+// Objects that implement MsgpackFieldsProvider (whether or not they are structs) are instead
+// transformed to the result of their MsgpackFields method, without using reflection or FieldFn.
 func MakeStructMarshalTransformer(opts *StructMarshalTransformerOptions) MarshalTransformerFn {
 	if opts == nil {
 		opts = &StructMarshalTransformerOptions{}
@@ -31,33 +65,105 @@ func MakeStructMarshalTransformer(opts *StructMarshalTransformerOptions) Marshal
 		}
 	}
 
-	return func(obj any) (any, error) {
-		t := reflect.TypeOf(obj)
-		if t.Kind() != reflect.Struct {
-			return obj, nil
+	tagName := opts.TagName
+	if tagName == "" {
+		tagName = "msgpack"
+	}
+
+	// Cache of the (included) fields for each struct type, as a map from reflect.Type to
+	// []structFieldInfo.
+	var fieldsCache sync.Map
+	getFields := func(t reflect.Type) []structFieldInfo {
+		if fields, ok := fieldsCache.Load(t); ok {
+			return fields.([]structFieldInfo)
 		}
 
-		fields := reflect.VisibleFields(t)
-		v := reflect.ValueOf(obj)
-		rv := map[string]any{}
-		for _, field := range fields {
+		var fields []structFieldInfo
+		for _, field := range reflect.VisibleFields(t) {
 			if !field.IsExported() {
 				continue
 			}
 
+			tag, hasTag := field.Tag.Lookup(tagName)
+			if hasTag && tag == "-" {
+				continue
+			}
+
 			includeField, key := fieldFn(field)
 			if !includeField {
 				continue
 			}
 
-			value := v.FieldByIndex(field.Index).Interface()
-			rv[key] = value
+			omitEmpty := opts.OmitEmpty
+			if opts.UseTags {
+				if hasTag {
+					name, qualifiers, _ := strings.Cut(tag, ",")
+					if name != "" {
+						key = name
+					}
+					omitEmpty = omitEmpty || hasTagQualifier(qualifiers, "omitempty")
+				}
+			}
+
+			fields = append(fields, structFieldInfo{index: field.Index, key: key, omitEmpty: omitEmpty})
+		}
+
+		actual, _ := fieldsCache.LoadOrStore(t, fields)
+		return actual.([]structFieldInfo)
+	}
+
+	return func(obj any) (any, error) {
+		if provider, ok := obj.(MsgpackFieldsProvider); ok {
+			return provider.MsgpackFields(), nil
+		}
+
+		t := reflect.TypeOf(obj)
+		if t == nil || t.Kind() != reflect.Struct {
+			return obj, nil
+		}
+
+		fields := getFields(t)
+		v := reflect.ValueOf(obj)
+		rv := make(map[string]any, len(fields))
+		for _, field := range fields {
+			var fieldV reflect.Value
+			if len(field.index) == 1 {
+				fieldV = v.Field(field.index[0])
+			} else {
+				fieldV = v.FieldByIndex(field.index)
+			}
+			if field.omitEmpty && fieldV.IsZero() {
+				continue
+			}
+
+			rv[field.key] = fieldV.Interface()
 		}
 
 		return rv, nil
 	}
 }
 
+// structFieldInfo is the information about a struct field (that is to be included) used by the
+// struct marshal transformer.
+type structFieldInfo struct {
+	index     []int
+	key       string
+	omitEmpty bool
+}
+
+// hasTagQualifier returns true if the (comma-separated) qualifiers part of a struct tag contains
+// the given qualifier.
+func hasTagQualifier(qualifiers string, qualifier string) bool {
+	for qualifiers != "" {
+		var q string
+		q, qualifiers, _ = strings.Cut(qualifiers, ",")
+		if q == qualifier {
+			return true
+		}
+	}
+	return false
+}
+
 // DefaultStructMarshalTransformer is a marshal transformer that transforms structs to maps, using
-// field names and including all (exported) fields.
+// field names and including all (exported) fields, except those with tag `msgpack:"-"`.
 var DefaultStructMarshalTransformer = MakeStructMarshalTransformer(nil)
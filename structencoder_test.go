@@ -18,6 +18,7 @@ func TestDefaultStructMarshalTransformer(t *testing.T) {
 		obj      any
 		expected any
 	}{
+		{nil, nil},
 		{123, 123},
 		{[]int{123, 45}, []int{123, 45}},
 		{struct{}{}, map[string]any{}},
@@ -71,3 +72,280 @@ func TestMakeStructMarshalTransformer(t *testing.T) {
 		}
 	}
 }
+
+// testFieldsProviderType has only private state, which it exposes via MsgpackFields.
+type testFieldsProviderType struct {
+	name   string
+	secret int
+}
+
+func (x testFieldsProviderType) MsgpackFields() map[string]any {
+	return map[string]any{"name": x.name}
+}
+
+// testFieldsProviderPtrType implements MsgpackFieldsProvider on the pointer type.
+type testFieldsProviderPtrType struct {
+	value int
+}
+
+func (x *testFieldsProviderPtrType) MsgpackFields() map[string]any {
+	return map[string]any{"value": x.value, "double": 2 * x.value}
+}
+
+func TestStructMarshalTransformer_fieldsProvider(t *testing.T) {
+	testCases := []struct {
+		obj      any
+		expected any
+	}{
+		{testFieldsProviderType{"hello", 123}, map[string]any{"name": "hello"}},
+		{&testFieldsProviderPtrType{21}, map[string]any{"value": 21, "double": 42}},
+		// Not a provider (only the pointer type is).
+		{testFieldsProviderPtrType{21}, map[string]any{}},
+	}
+	for i, tc := range testCases {
+		if result, err := DefaultStructMarshalTransformer(tc.obj); err != nil {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("%v: unexpected result: %v (expected: %v)", i, result, tc.expected)
+		}
+	}
+
+	opts := &MarshalOptions{ApplicationMarshalTransformer: DefaultStructMarshalTransformer}
+	if encoded, err := MarshalToBytes(opts, testFieldsProviderType{"hi", 123}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if decoded, err := UnmarshalBytes(nil, encoded); err != nil || !reflect.DeepEqual(decoded, map[any]any{"name": "hi"}) {
+		t.Errorf("Unexpected result: %#v, %v", decoded, err)
+	}
+}
+
+func TestMakeStructMarshalTransformer_tags(t *testing.T) {
+	type testStruct struct {
+		Renamed   string `msgpack:"renamed"`
+		Skipped   string `msgpack:"-"`
+		OmitStr   string `msgpack:"omit_str,omitempty"`
+		OmitInt   int    `msgpack:",omitempty"`
+		OmitSlice []int  `msgpack:"omit_slice,omitempty"`
+		KeepSlice []int  `msgpack:"keep_slice"`
+		Other     bool   `json:"other"`
+		Untagged  int
+		secret    int      `msgpack:"secret"`
+		Custom    []string `custom:"cust,omitempty"`
+	}
+
+	testCases := []struct {
+		opts     *StructMarshalTransformerOptions
+		obj      any
+		expected any
+	}{
+		// Tags are ignored by default (except for "-").
+		{nil, testStruct{Renamed: "x"}, map[string]any{
+			"Renamed":   "x",
+			"OmitStr":   "",
+			"OmitInt":   0,
+			"OmitSlice": []int(nil),
+			"KeepSlice": []int(nil),
+			"Other":     false,
+			"Untagged":  0,
+			"Custom":    []string(nil),
+		}},
+		// Zero values.
+		{&StructMarshalTransformerOptions{UseTags: true}, testStruct{}, map[string]any{
+			"renamed":    "",
+			"keep_slice": []int(nil),
+			"Other":      false,
+			"Untagged":   0,
+			"Custom":     []string(nil),
+		}},
+		// Non-zero values.
+		{&StructMarshalTransformerOptions{UseTags: true}, testStruct{
+			Renamed:   "a",
+			Skipped:   "b",
+			OmitStr:   "c",
+			OmitInt:   1,
+			OmitSlice: []int{},
+			KeepSlice: []int{2},
+			Other:     true,
+			Untagged:  3,
+			secret:    4,
+			Custom:    []string{"d"},
+		}, map[string]any{
+			"renamed":    "a",
+			"omit_str":   "c",
+			"OmitInt":    1,
+			"omit_slice": []int{},
+			"keep_slice": []int{2},
+			"Other":      true,
+			"Untagged":   3,
+			"Custom":     []string{"d"},
+		}},
+		// Custom tag name.
+		{&StructMarshalTransformerOptions{UseTags: true, TagName: "custom"}, testStruct{Renamed: "a"}, map[string]any{
+			"Renamed":   "a",
+			"Skipped":   "",
+			"OmitStr":   "",
+			"OmitInt":   0,
+			"OmitSlice": []int(nil),
+			"KeepSlice": []int(nil),
+			"Other":     false,
+			"Untagged":  0,
+		}},
+		// With a FieldFn: tag names (if non-empty) take precedence.
+		{&StructMarshalTransformerOptions{
+			UseTags: true,
+			FieldFn: func(field reflect.StructField) (bool, string) {
+				return strings.HasPrefix(field.Name, "Omit") || field.Name == "Renamed", strings.ToLower(field.Name)
+			},
+		}, testStruct{Renamed: "a", OmitInt: 1}, map[string]any{
+			"renamed": "a",
+			"omitint": 1,
+		}},
+	}
+	for i, tc := range testCases {
+		transformer := MakeStructMarshalTransformer(tc.opts)
+		if result, err := transformer(tc.obj); err != nil {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("%v: unexpected result: %#v (expected: %#v)", i, result, tc.expected)
+		}
+	}
+}
+
+func TestMakeStructMarshalTransformer_omitEmpty(t *testing.T) {
+	type testStruct struct {
+		Str    string
+		Int    int
+		Bool   bool
+		Slice  []int
+		Map    map[string]int
+		Ptr    *int
+		Struct struct{ X int }
+		Any    any
+	}
+	i := 0
+
+	testCases := []struct {
+		opts     *StructMarshalTransformerOptions
+		obj      any
+		expected any
+	}{
+		{&StructMarshalTransformerOptions{OmitEmpty: true}, testStruct{}, map[string]any{}},
+		{&StructMarshalTransformerOptions{OmitEmpty: true}, testStruct{
+			Str:    "x",
+			Int:    1,
+			Bool:   true,
+			Slice:  []int{},
+			Map:    map[string]int{},
+			Ptr:    &i,
+			Struct: struct{ X int }{1},
+			Any:    0,
+		}, map[string]any{
+			"Str":    "x",
+			"Int":    1,
+			"Bool":   true,
+			"Slice":  []int{},
+			"Map":    map[string]int{},
+			"Ptr":    &i,
+			"Struct": struct{ X int }{1},
+			"Any":    0,
+		}},
+		// With a FieldFn (which includes the field).
+		{&StructMarshalTransformerOptions{
+			OmitEmpty: true,
+			FieldFn: func(field reflect.StructField) (bool, string) {
+				return field.Name != "Int", strings.ToLower(field.Name)
+			},
+		}, testStruct{Str: "x", Int: 1}, map[string]any{"str": "x"}},
+		// With tags.
+		{&StructMarshalTransformerOptions{OmitEmpty: true, UseTags: true}, struct {
+			Foo string `msgpack:"foo"`
+			Bar int    `msgpack:"bar"`
+		}{Foo: "x"}, map[string]any{"foo": "x"}},
+	}
+	for i, tc := range testCases {
+		transformer := MakeStructMarshalTransformer(tc.opts)
+		if result, err := transformer(tc.obj); err != nil {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("%v: unexpected result: %#v (expected: %#v)", i, result, tc.expected)
+		}
+	}
+}
+
+func TestMakeStructMarshalTransformer_skipTag(t *testing.T) {
+	type testStruct struct {
+		Normal   string
+		Password string `msgpack:"-"`
+		cache    int
+	}
+	obj := testStruct{Normal: "x", Password: "secret", cache: 123}
+
+	testCases := []struct {
+		opts     *StructMarshalTransformerOptions
+		expected any
+	}{
+		{nil, map[string]any{"Normal": "x"}},
+		{&StructMarshalTransformerOptions{UseTags: true}, map[string]any{"Normal": "x"}},
+		// The skip takes precedence over FieldFn.
+		{&StructMarshalTransformerOptions{
+			FieldFn: func(field reflect.StructField) (bool, string) {
+				if field.Name == "Password" {
+					t.Errorf("FieldFn unexpectedly called for Password")
+				}
+				return true, strings.ToLower(field.Name)
+			},
+		}, map[string]any{"normal": "x"}},
+		// Only the tag with key TagName is considered.
+		{&StructMarshalTransformerOptions{TagName: "other"}, map[string]any{"Normal": "x", "Password": "secret"}},
+	}
+	for i, tc := range testCases {
+		transformer := MakeStructMarshalTransformer(tc.opts)
+		if result, err := transformer(obj); err != nil {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("%v: unexpected result: %#v (expected: %#v)", i, result, tc.expected)
+		}
+	}
+}
+
+type testStructCacheType struct {
+	Foo string `msgpack:"foo"`
+	Bar int    `msgpack:"bar,omitempty"`
+}
+
+func TestMakeStructMarshalTransformer_cache(t *testing.T) {
+	fieldFnCalls := 0
+	upperTransformer := MakeStructMarshalTransformer(&StructMarshalTransformerOptions{
+		FieldFn: func(field reflect.StructField) (bool, string) {
+			fieldFnCalls += 1
+			return true, strings.ToUpper(field.Name)
+		},
+	})
+	tagsTransformer := MakeStructMarshalTransformer(&StructMarshalTransformerOptions{UseTags: true})
+
+	obj := testStructCacheType{Foo: "hello"}
+	// Interleave the transformers, to check that each one's cache is independent of the other's.
+	for i := 0; i < 3; i += 1 {
+		if result, err := upperTransformer(obj); err != nil {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if expected := map[string]any{"FOO": "hello", "BAR": 0}; !reflect.DeepEqual(result, expected) {
+			t.Errorf("%v: unexpected result: %v (expected: %v)", i, result, expected)
+		}
+
+		if result, err := tagsTransformer(obj); err != nil {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if expected := map[string]any{"foo": "hello"}; !reflect.DeepEqual(result, expected) {
+			t.Errorf("%v: unexpected result: %v (expected: %v)", i, result, expected)
+		}
+
+		if result, err := DefaultStructMarshalTransformer(obj); err != nil {
+			t.Errorf("%v: unexpected error: %v", i, err)
+		} else if expected := map[string]any{"Foo": "hello", "Bar": 0}; !reflect.DeepEqual(result, expected) {
+			t.Errorf("%v: unexpected result: %v (expected: %v)", i, result, expected)
+		}
+	}
+
+	// The fields should only have been determined once (FieldFn is called once per field).
+	if fieldFnCalls != 2 {
+		t.Errorf("unexpected number of FieldFn calls: %v (expected: 2)", fieldFnCalls)
+	}
+}
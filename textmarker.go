@@ -0,0 +1,34 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains TextMarker, used by Marshal.
+
+package umsgpack
+
+import (
+	"reflect"
+)
+
+// A TextMarker is a type that may be marked as containing text. If a (named) []byte type
+// implements TextMarker and AsText returns true, Marshal marshals it as a string (str format)
+// instead of as binary (bin format).
+//
+// It is up to the application to ensure that such data is valid UTF-8; Marshal does not validate
+// it. (Unmarshal always produces string for str formats, never the named type.)
+type TextMarker interface {
+	AsText() bool
+}
+
+// textMarkerBytes returns the bytes of obj if it is a []byte type that implements TextMarker
+// (with AsText returning true).
+func textMarkerBytes(obj any) ([]byte, bool) {
+	tm, ok := obj.(TextMarker)
+	if !ok || !tm.AsText() {
+		return nil, false
+	}
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Uint8 {
+		return nil, false
+	}
+	return v.Bytes(), true
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains support for an application extension type carrying Go type names, for
+// (approximately) self-describing messages.
+
+package umsgpack
+
+import (
+	"errors"
+	"io"
+	"reflect"
+)
+
+// Errors ------------------------------------------------------------------------------------------
+
+// InvalidTypeNameExtensionError is the error returned by a type name extension unmarshaller (see
+// MakeTypeNameExtensionUnmarshaller) if the extension data is invalid.
+var InvalidTypeNameExtensionError = errors.New("Invalid type name extension")
+
+// Type name extension -----------------------------------------------------------------------------
+
+// TypeNameOf returns the type name of obj as used by the type name extension, which is the full
+// package path and name of its type (e.g., "github.com/foo/bar.Baz"), or the empty string if obj
+// is not of a defined type declared in a package (e.g., if it is nil, of a built-in type like int,
+// or of an unnamed type like []int or *Baz).
+func TypeNameOf(obj any) string {
+	t := reflect.TypeOf(obj)
+	if t == nil || t.Name() == "" || t.PkgPath() == "" {
+		return ""
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+// typeNameMarshalOptions are the options used to marshal type names for the type name extension
+// (independent of DefaultMarshalOptions, which may be modified).
+var typeNameMarshalOptions = &MarshalOptions{}
+
+// MakeTypeNameMarshalTransformer makes a MarshalTransformerFn that wraps objects of defined types
+// (those for which TypeNameOf returns a non-empty name) in an extension with the given extension
+// type. Other objects are returned as-is.
+//
+// The extension data consists of the type name (marshalled as a string) followed by the object
+// marshalled using opts (which must not itself include this transformer, and typically includes
+// some transformer to handle the defined types, e.g., a struct marshal transformer). Nested
+// objects are thus not wrapped (since they're marshalled using opts).
+func MakeTypeNameMarshalTransformer(extType int8, opts *MarshalOptions) MarshalTransformerFn {
+	return func(obj any) (any, error) {
+		name := TypeNameOf(obj)
+		if name == "" {
+			return obj, nil
+		}
+
+		data, err := MarshalToBytes(typeNameMarshalOptions, name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := MarshalToBytes(opts, obj)
+		if err != nil {
+			return nil, err
+		}
+		return &UnresolvedExtensionType{ExtensionType: extType, Data: append(data, value...)}, nil
+	}
+}
+
+// A TypeNameConstructorFn constructs an object of a registered type from the unmarshalled value
+// (for the type name extension; see MakeTypeNameExtensionUnmarshaller).
+type TypeNameConstructorFn func(value any) (any, error)
+
+// A *TypeNamedValue is the result of unmarshalling the type name extension if the type name is not
+// registered: it just contains the type name and unmarshalled value.
+type TypeNamedValue struct {
+	TypeName string
+	Value    any
+}
+
+// typeNameUnmarshalOptions are the options used to unmarshal type names for the type name extension
+// (independent of DefaultUnmarshalOptions, which may be modified).
+var typeNameUnmarshalOptions = &UnmarshalOptions{}
+
+// MakeTypeNameExtensionUnmarshaller makes an UnmarshalExtensionTypeFn for the type name extension
+// (see MakeTypeNameMarshalTransformer), for use with MakeExtensionTypeUnmarshalTransformer. The
+// value is unmarshalled using opts, and then passed to the constructor registered for the type name
+// in constructors (if any); otherwise, a *TypeNamedValue is returned.
+//
+// InvalidTypeNameExtensionError is returned if the data does not consist of a string (the type
+// name) followed by exactly one object.
+func MakeTypeNameExtensionUnmarshaller(constructors map[string]TypeNameConstructorFn, opts *UnmarshalOptions) UnmarshalExtensionTypeFn {
+	return func(data []byte) (any, bool, error) {
+		nameObj, n, err := UnmarshalBytesN(typeNameUnmarshalOptions, data)
+		if err != nil {
+			return nil, false, InvalidTypeNameExtensionError
+		}
+		name, ok := nameObj.(string)
+		if !ok {
+			return nil, false, InvalidTypeNameExtensionError
+		}
+
+		valueOpts := UnmarshalOptions{}
+		if opts != nil {
+			valueOpts = *opts
+		}
+		valueOpts.RejectTrailingBytes = true
+		value, err := UnmarshalBytes(&valueOpts, data[n:])
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, TrailingBytesError) {
+			return nil, false, InvalidTypeNameExtensionError
+		} else if err != nil {
+			return nil, false, err
+		}
+
+		constructor, ok := constructors[name]
+		if !ok {
+			return &TypeNamedValue{TypeName: name, Value: value}, false, nil
+		}
+		obj, err := constructor(value)
+		if err != nil {
+			return nil, false, err
+		}
+		return obj, false, nil
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests typenameext.go.
+
+package umsgpack_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+type testTypeNamePoint struct {
+	X int
+	Y int
+}
+
+type testTypeNameLabel struct {
+	Text string
+}
+
+type testTypeNameUnknown struct {
+	Value int
+}
+
+// makeTestTypeNameConstructor makes a TypeNameConstructorFn for the type of prototype (a struct),
+// using the struct unmarshal transformer.
+func makeTestTypeNameConstructor(prototype any) TypeNameConstructorFn {
+	xform := MakeStructUnmarshalTransformer(prototype, nil)
+	return func(value any) (any, error) {
+		obj, _, err := xform(value, false)
+		return obj, err
+	}
+}
+
+func TestTypeNameOf(t *testing.T) {
+	testCases := []struct {
+		obj      any
+		expected string
+	}{
+		{nil, ""},
+		{123, ""},
+		{[]int{}, ""},
+		{&testTypeNamePoint{}, ""},
+		{testTypeNamePoint{}, "github.com/viettrungluu/umsgpack_test.testTypeNamePoint"},
+		{testMarshalType4(1), "github.com/viettrungluu/umsgpack_test.testMarshalType4"},
+	}
+	for _, tc := range testCases {
+		if actual := TypeNameOf(tc.obj); actual != tc.expected {
+			t.Errorf("Unexpected result for obj=%#v: %q (expected: %q)", tc.obj, actual, tc.expected)
+		}
+	}
+}
+
+func TestTypeNameExtension_roundTrip(t *testing.T) {
+	const extType = 42
+	mopts := &MarshalOptions{
+		ApplicationMarshalTransformer: MakeTypeNameMarshalTransformer(extType, &MarshalOptions{
+			ApplicationMarshalTransformer: DefaultStructMarshalTransformer,
+		}),
+	}
+	uopts := &UnmarshalOptions{
+		ApplicationUnmarshalTransformer: MakeExtensionTypeUnmarshalTransformer(map[int8]UnmarshalExtensionTypeFn{
+			extType: MakeTypeNameExtensionUnmarshaller(map[string]TypeNameConstructorFn{
+				TypeNameOf(testTypeNamePoint{}): makeTestTypeNameConstructor(testTypeNamePoint{}),
+				TypeNameOf(testTypeNameLabel{}): makeTestTypeNameConstructor(testTypeNameLabel{}),
+			}, nil),
+		}),
+	}
+
+	testCases := []struct {
+		obj      any
+		expected any
+	}{
+		{testTypeNamePoint{1, 2}, testTypeNamePoint{1, 2}},
+		{testTypeNameLabel{"hi"}, testTypeNameLabel{"hi"}},
+		{[]any{testTypeNamePoint{3, 4}, "x", testTypeNameLabel{"y"}}, []any{testTypeNamePoint{3, 4}, "x", testTypeNameLabel{"y"}}},
+		{map[string]any{"p": testTypeNamePoint{}}, map[any]any{"p": testTypeNamePoint{}}},
+		{123, 123},
+		// Unknown type.
+		{testTypeNameUnknown{5}, &TypeNamedValue{
+			TypeName: "github.com/viettrungluu/umsgpack_test.testTypeNameUnknown",
+			Value:    map[any]any{"Value": 5},
+		}},
+	}
+	for _, tc := range testCases {
+		encoded, err := MarshalToBytes(mopts, tc.obj)
+		if err != nil {
+			t.Errorf("Unexpected error marshalling obj=%#v: %v", tc.obj, err)
+			continue
+		}
+		if decoded, err := UnmarshalBytes(uopts, encoded); err != nil {
+			t.Errorf("Unexpected error unmarshalling obj=%#v: %v", tc.obj, err)
+		} else if !reflect.DeepEqual(decoded, tc.expected) {
+			t.Errorf("Unexpected result for obj=%#v: %#v (expected: %#v)", tc.obj, decoded, tc.expected)
+		}
+	}
+
+	// Without a transformer, it's just an extension type.
+	encoded, _ := MarshalToBytes(mopts, testTypeNameLabel{"hi"})
+	if decoded, err := UnmarshalBytes(nil, encoded); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if ext, ok := decoded.(*UnresolvedExtensionType); !ok || ext.ExtensionType != extType {
+		t.Errorf("Unexpected result: %#v", decoded)
+	}
+
+	// DefaultMarshalOptions and DefaultUnmarshalOptions don't apply to the type name.
+	savedMarshalOptions := *DefaultMarshalOptions
+	savedUnmarshalOptions := *DefaultUnmarshalOptions
+	DefaultMarshalOptions.ApplicationMarshalTransformer = func(obj any) (any, error) {
+		if s, ok := obj.(string); ok {
+			return s + "x", nil
+		}
+		return obj, nil
+	}
+	DefaultUnmarshalOptions.RejectTrailingBytes = true
+	encoded, err := MarshalToBytes(mopts, testTypeNameLabel{"hi"})
+	var decoded any
+	if err == nil {
+		decoded, err = UnmarshalBytes(uopts, encoded)
+	}
+	*DefaultMarshalOptions = savedMarshalOptions
+	*DefaultUnmarshalOptions = savedUnmarshalOptions
+	if err != nil || !reflect.DeepEqual(decoded, testTypeNameLabel{"hi"}) {
+		t.Errorf("Unexpected result: %#v, %v", decoded, err)
+	}
+}
+
+func TestMakeTypeNameExtensionUnmarshaller_invalid(t *testing.T) {
+	unmarshaller := MakeTypeNameExtensionUnmarshaller(nil, nil)
+	testCases := [][]byte{
+		{},
+		{0xa1},
+		{0x01, 0x02},
+		{0xa1, 0x61},
+		{0xa1, 0x61, 0x01, 0x02},
+		{0xa1, 0x61, 0x92, 0x01},
+	}
+	for _, data := range testCases {
+		if obj, _, err := unmarshaller(data); err != InvalidTypeNameExtensionError {
+			t.Errorf("Unexpected result for data=%v: %#v, %v", data, obj, err)
+		}
+	}
+
+	if obj, _, err := unmarshaller([]byte{0xa1, 0x61, 0xc1}); !errors.Is(err, InvalidFormatError) {
+		t.Errorf("Unexpected result: %#v, %v", obj, err)
+	}
+	if obj, _, err := unmarshaller([]byte{0xa1, 0x61, 0x01}); err != nil || !reflect.DeepEqual(obj, &TypeNamedValue{TypeName: "a", Value: 1}) {
+		t.Errorf("Unexpected result: %#v, %v", obj, err)
+	}
+}
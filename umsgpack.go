@@ -48,17 +48,17 @@
 // To support extensions:
 //
 //	// Marshals a time.Duration to a extension type 42, containing a 64-bit value, big-endian.
-//	marshalDuration := func(obj any) (any, error) {
-//		if duration, ok := obj.(time.Duration); !ok {
-//			return obj, nil
-//		} else {
-//			data := make([]byte, 8)
-//			binary.BigEndian.PutUint64(data, uint64(duration))
-//			return &umsgpack.UnresolvedExtensionType{ExtensionType: 42, Data: data}, nil
-//		}
+//	marshalDuration := func(obj any) (int8, []byte, error) {
+//		data := make([]byte, 8)
+//		binary.BigEndian.PutUint64(data, uint64(obj.(time.Duration)))
+//		return 42, data, nil
 //	}
 //	opts := &umsgpack.MarshalOptions{
-//		ApplicationMarshalTransformer: marshalDuration,
+//		ApplicationMarshalTransformer: umsgpack.MakeExtensionTypeMarshalTransformer(
+//			map[reflect.Type]umsgpack.MarshalExtensionTypeFn{
+//				reflect.TypeOf(time.Duration(0)): marshalDuration,
+//			},
+//		),
 //	}
 //	output, err := umsgpack.MarshalToBytes(opts, input)
 package umsgpack
@@ -0,0 +1,473 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file contains UnmarshalInto, etc.
+
+package umsgpack
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Errors ------------------------------------------------------------------------------------------
+
+// InvalidUnmarshalTargetError is the error returned by UnmarshalInto if the target is not a non-nil
+// pointer.
+var InvalidUnmarshalTargetError = errors.New("Invalid unmarshal target")
+
+// SignednessMismatchError is the error returned by UnmarshalInto if the StrictSignedness option is
+// set and an unsigned integer is assigned to a signed integer type, or vice versa.
+var SignednessMismatchError = errors.New("Signedness mismatch")
+
+// An *UnmarshalIntoTypeError is the error returned by UnmarshalInto if an unmarshalled value cannot
+// be assigned to (some part of) the target.
+type UnmarshalIntoTypeError struct {
+	// Value is the (unmarshalled) value that could not be assigned.
+	Value any
+	// Type is the type that it could not be assigned to.
+	Type reflect.Type
+	// Path is the path (from the target) of the value, e.g., ".Foo[3]" or `["bar"]`; it is
+	// empty for the target itself.
+	Path string
+}
+
+func (e *UnmarshalIntoTypeError) Error() string {
+	return fmt.Sprintf("Cannot unmarshal %T into %v at %q", e.Value, e.Type, e.Path)
+}
+
+// UnmarshalInto ------------------------------------------------------------------------------------
+
+// UnmarshalInto is like Unmarshal, except that it assigns the unmarshalled object to the value
+// pointed to by target (which must be a non-nil pointer) using reflection, similar to
+// json.Unmarshal. (The object is unmarshalled as usual, including applying unmarshal transformers,
+// and then assigned.)
+//
+// Assignment is as follows:
+//   - if the object is assignable to the target type, it is just assigned
+//   - nil sets the target to its zero value
+//   - pointers are allocated as needed, and the object is assigned to what they point to
+//   - an integer (int or uint) may be assigned to any integer type, and any numeric object may be
+//     assigned to any float type, as long as the value is representable (without overflow); if
+//     opts.StrictSignedness is set, integers must instead be assigned to integer types of the
+//     same signedness (else SignednessMismatchError is returned)
+//   - binary may be assigned to a string if opts.BinToBase64String is set, as its base64 encoding
+//   - an array may be assigned to a slice, or to an array of the same length, assigning each
+//     element; binary may similarly be assigned to a byte array
+//   - a map may be assigned to a map (which is allocated), converting each key and value as above
+//     (e.g., for a map[string]int, keys must be strings and values integers); if a key can't be
+//     converted, the *UnmarshalIntoTypeError's Value is the key
+//   - any object may be assigned to a RawMessage (see RawMessage)
+//   - a map with string keys may be assigned to a struct, assigning values to exported fields
+//     (including promoted fields) with exactly the same name as the key; keys that do not
+//     correspond to such fields are ignored (after being passed to opts.UnknownKeyFn, if set)
+//
+// Otherwise, an *UnmarshalIntoTypeError is returned. Note that the target may have been partially
+// assigned in that case.
+func UnmarshalInto(opts *UnmarshalOptions, r io.Reader, target any) error {
+	if raw, ok := target.(*RawMessage); ok && raw != nil {
+		// Capture the bytes read.
+		buf := &bytes.Buffer{}
+		if _, err := Unmarshal(opts, io.TeeReader(r, buf)); err != nil {
+			return err
+		}
+		*raw = buf.Bytes()
+		return nil
+	}
+
+	return unmarshalInto(opts, target, func() (any, error) {
+		return Unmarshal(opts, r)
+	})
+}
+
+// UnmarshalBytesInto is like UnmarshalInto, except taking byte data instead of an io.Reader.
+func UnmarshalBytesInto(opts *UnmarshalOptions, data []byte, target any) error {
+	if raw, ok := target.(*RawMessage); ok && raw != nil {
+		_, n, err := UnmarshalBytesN(opts, data)
+		if err != nil {
+			return err
+		}
+		*raw = append(RawMessage(nil), data[:n]...)
+		return nil
+	}
+
+	return unmarshalInto(opts, target, func() (any, error) {
+		return UnmarshalBytes(opts, data)
+	})
+}
+
+// unmarshalInto is a helper for UnmarshalInto/UnmarshalBytesInto, which checks target, unmarshals
+// using unmarshalFn, and assigns the result to target.
+func unmarshalInto(opts *UnmarshalOptions, target any, unmarshalFn func() (any, error)) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return InvalidUnmarshalTargetError
+	}
+
+	obj, err := unmarshalFn()
+	if err != nil {
+		return err
+	}
+
+	if opts == nil {
+		opts = DefaultUnmarshalOptions
+	}
+	a := &assigner{opts: opts}
+	return a.assign(v.Elem(), obj, "")
+}
+
+// assigner ----------------------------------------------------------------------------------------
+
+// rawMessageMarshalOptions are the options used by assigner.assign to marshal objects for nested
+// RawMessage values (independent of DefaultMarshalOptions, which may be modified).
+var rawMessageMarshalOptions = &MarshalOptions{}
+
+// An assigner handles assigning unmarshalled objects for UnmarshalInto (and the struct unmarshal
+// transformer).
+type assigner struct {
+	opts *UnmarshalOptions
+
+	// If non-empty, struct fields are matched using struct tags with this key (see
+	// StructUnmarshalTransformerOptions.UseTags).
+	tagName string
+	// If set, UnknownStructKeyError is returned for keys that do not correspond to fields.
+	rejectUnknownKeys bool
+}
+
+// assign assigns obj to v (which must be settable); path is the path to v (for errors).
+func (a *assigner) assign(v reflect.Value, obj any, path string) error {
+	if v.Type() == rawMessageType {
+		// We don't have the raw bytes, so marshal the object again.
+		encoded, err := MarshalToBytes(rawMessageMarshalOptions, obj)
+		if err != nil {
+			return err
+		}
+		v.SetBytes(encoded)
+		return nil
+	}
+
+	if obj == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	objV := reflect.ValueOf(obj)
+	if objV.Type().AssignableTo(v.Type()) {
+		v.Set(objV)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return a.assign(v.Elem(), obj, path)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch o := obj.(type) {
+		case int:
+			if !v.OverflowInt(int64(o)) {
+				v.SetInt(int64(o))
+				return nil
+			}
+		case int64:
+			if !v.OverflowInt(o) {
+				v.SetInt(o)
+				return nil
+			}
+		case uint:
+			if a.opts.StrictSignedness {
+				return SignednessMismatchError
+			}
+			if int64(o) >= 0 && !v.OverflowInt(int64(o)) {
+				v.SetInt(int64(o))
+				return nil
+			}
+		case uint64:
+			if a.opts.StrictSignedness {
+				return SignednessMismatchError
+			}
+			if int64(o) >= 0 && !v.OverflowInt(int64(o)) {
+				v.SetInt(int64(o))
+				return nil
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch o := obj.(type) {
+		case int:
+			if a.opts.StrictSignedness {
+				return SignednessMismatchError
+			}
+			if o >= 0 && !v.OverflowUint(uint64(o)) {
+				v.SetUint(uint64(o))
+				return nil
+			}
+		case int64:
+			if a.opts.StrictSignedness {
+				return SignednessMismatchError
+			}
+			if o >= 0 && !v.OverflowUint(uint64(o)) {
+				v.SetUint(uint64(o))
+				return nil
+			}
+		case uint:
+			if !v.OverflowUint(uint64(o)) {
+				v.SetUint(uint64(o))
+				return nil
+			}
+		case uint64:
+			if !v.OverflowUint(o) {
+				v.SetUint(o)
+				return nil
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch o := obj.(type) {
+		case int:
+			f = float64(o)
+		case int64:
+			f = float64(o)
+		case uint:
+			f = float64(o)
+		case uint64:
+			f = float64(o)
+		case float32:
+			f = float64(o)
+		case float64:
+			f = o
+		default:
+			return a.typeError(v, obj, path)
+		}
+		if !v.OverflowFloat(f) {
+			v.SetFloat(f)
+			return nil
+		}
+	case reflect.Bool:
+		if b, ok := obj.(bool); ok {
+			v.SetBool(b)
+			return nil
+		}
+	case reflect.String:
+		switch o := obj.(type) {
+		case string:
+			v.SetString(o)
+			return nil
+		case []byte:
+			if a.opts.BinToBase64String {
+				v.SetString(base64.StdEncoding.EncodeToString(o))
+				return nil
+			}
+		}
+	case reflect.Slice:
+		return a.assignSlice(v, obj, path)
+	case reflect.Array:
+		return a.assignArray(v, obj, path)
+	case reflect.Map:
+		return a.assignMap(v, obj, path)
+	case reflect.Struct:
+		return a.assignStruct(v, obj, path)
+	}
+
+	return a.typeError(v, obj, path)
+}
+
+// assignSlice assigns obj to v, which is a slice.
+func (a *assigner) assignSlice(v reflect.Value, obj any, path string) error {
+	switch o := obj.(type) {
+	case []byte:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			s := reflect.MakeSlice(v.Type(), len(o), len(o))
+			reflect.Copy(s, reflect.ValueOf(o))
+			v.Set(s)
+			return nil
+		}
+	case []any:
+		s := reflect.MakeSlice(v.Type(), len(o), len(o))
+		for i, element := range o {
+			if err := a.assign(s.Index(i), element, fmt.Sprintf("%v[%v]", path, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	}
+	return a.typeError(v, obj, path)
+}
+
+// assignArray assigns obj to v, which is an array.
+func (a *assigner) assignArray(v reflect.Value, obj any, path string) error {
+	switch o := obj.(type) {
+	case []byte:
+		if v.Type().Elem().Kind() == reflect.Uint8 && len(o) == v.Len() {
+			reflect.Copy(v, reflect.ValueOf(o))
+			return nil
+		}
+	case []any:
+		if len(o) == v.Len() {
+			for i, element := range o {
+				if err := a.assign(v.Index(i), element, fmt.Sprintf("%v[%v]", path, i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return a.typeError(v, obj, path)
+}
+
+// assignMap assigns obj to v, which is a map.
+func (a *assigner) assignMap(v reflect.Value, obj any, path string) error {
+	t := v.Type()
+	assignKeyValue := func(m reflect.Value, key any, value any) error {
+		keyPath := fmt.Sprintf("%v[%#v]", path, key)
+		k := reflect.New(t.Key()).Elem()
+		if err := a.assign(k, key, keyPath); err != nil {
+			return err
+		}
+		e := reflect.New(t.Elem()).Elem()
+		if err := a.assign(e, value, keyPath); err != nil {
+			return err
+		}
+		m.SetMapIndex(k, e)
+		return nil
+	}
+
+	switch o := obj.(type) {
+	case map[any]any:
+		m := reflect.MakeMapWithSize(t, len(o))
+		for key, value := range o {
+			if err := assignKeyValue(m, key, value); err != nil {
+				return err
+			}
+		}
+		v.Set(m)
+		return nil
+	case map[string]any:
+		m := reflect.MakeMapWithSize(t, len(o))
+		for key, value := range o {
+			if err := assignKeyValue(m, key, value); err != nil {
+				return err
+			}
+		}
+		v.Set(m)
+		return nil
+	default:
+		// Other maps, e.g., map[string]int (see UnmarshalOptions.InferMapValueTypes).
+		if objV := reflect.ValueOf(obj); objV.Kind() == reflect.Map {
+			m := reflect.MakeMapWithSize(t, objV.Len())
+			for it := objV.MapRange(); it.Next(); {
+				if err := assignKeyValue(m, it.Key().Interface(), it.Value().Interface()); err != nil {
+					return err
+				}
+			}
+			v.Set(m)
+			return nil
+		}
+	}
+	return a.typeError(v, obj, path)
+}
+
+// assignStruct assigns obj to v, which is a struct.
+func (a *assigner) assignStruct(v reflect.Value, obj any, path string) error {
+	switch o := obj.(type) {
+	case map[any]any:
+		for key, value := range o {
+			s, ok := key.(string)
+			if !ok {
+				return a.typeError(v, obj, path)
+			}
+			if err := a.assignField(v, s, value, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		for key, value := range o {
+			if err := a.assignField(v, key, value, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		// Other string-keyed maps, e.g., map[string]int (see
+		// UnmarshalOptions.InferMapValueTypes).
+		if objV := reflect.ValueOf(obj); objV.Kind() == reflect.Map && objV.Type().Key().Kind() == reflect.String {
+			for it := objV.MapRange(); it.Next(); {
+				if err := a.assignField(v, it.Key().String(), it.Value().Interface(), path); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return a.typeError(v, obj, path)
+}
+
+// assignField assigns value to the field of v (which is a struct) with the given name, if any.
+func (a *assigner) assignField(v reflect.Value, name string, value any, path string) error {
+	field, ok := a.findField(v.Type(), name)
+	if !ok {
+		if a.rejectUnknownKeys {
+			return UnknownStructKeyError
+		}
+		if a.opts.UnknownKeyFn != nil {
+			a.opts.UnknownKeyFn(name, value)
+		}
+		return nil
+	}
+
+	fieldPath := path + "." + name
+	// Walk to the field, allocating pointers to embedded structs as needed.
+	fieldV := v
+	for i, index := range field.Index {
+		if i > 0 && fieldV.Kind() == reflect.Pointer {
+			if fieldV.IsNil() {
+				if !fieldV.CanSet() {
+					return &UnmarshalIntoTypeError{Value: value, Type: field.Type, Path: fieldPath}
+				}
+				fieldV.Set(reflect.New(fieldV.Type().Elem()))
+			}
+			fieldV = fieldV.Elem()
+		}
+		fieldV = fieldV.Field(index)
+	}
+	return a.assign(fieldV, value, fieldPath)
+}
+
+// findField finds the exported field of t (which is a struct type) corresponding to the given key.
+func (a *assigner) findField(t reflect.Type, key string) (reflect.StructField, bool) {
+	if a.tagName == "" {
+		field, ok := t.FieldByName(key)
+		return field, ok && field.IsExported()
+	}
+
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup(a.tagName); ok {
+			if tag == "-" {
+				continue
+			}
+			if tagName, _, _ := strings.Cut(tag, ","); tagName != "" {
+				name = tagName
+			}
+		}
+		if name == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// typeError returns an *UnmarshalIntoTypeError for assigning obj to v.
+func (a *assigner) typeError(v reflect.Value, obj any, path string) error {
+	return &UnmarshalIntoTypeError{Value: obj, Type: v.Type(), Path: path}
+}
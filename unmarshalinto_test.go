@@ -0,0 +1,363 @@
+// Copyright 2024 Viet-Trung Luu.
+// Use of this source code is governed by the license in the LICENSE file.
+
+// This file tests unmarshalinto.go.
+
+package umsgpack_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/viettrungluu/umsgpack"
+)
+
+type testUnmarshalIntoInner struct {
+	Value int
+}
+
+type testUnmarshalIntoEmbedded struct {
+	Embedded string
+}
+
+type testUnmarshalIntoStruct struct {
+	testUnmarshalIntoEmbedded
+	Name    string
+	Count   uint16
+	Ratio   float32
+	Flag    bool
+	Tags    []string
+	Inner   testUnmarshalIntoInner
+	Ptr     *testUnmarshalIntoInner
+	Map     map[string]int
+	Any     any
+	When    time.Time
+	Data    []byte
+	private int
+}
+
+// testUnmarshalInto is a helper for testing UnmarshalInto/UnmarshalBytesInto: it marshals obj,
+// and unmarshals it into a new value of the same type as expected, checking that it's equal to
+// expected (if err is nil) or that the expected error is returned.
+func testUnmarshalInto(t *testing.T, opts *UnmarshalOptions, obj any, expected any, err error) {
+	encoded, marshalErr := MarshalToBytes(nil, obj)
+	if marshalErr != nil {
+		t.Fatalf("MarshalToBytes failed for obj=%#v: %v", obj, marshalErr)
+	}
+
+	check := func(target reflect.Value, actualErr error) {
+		if err == nil {
+			if actualErr != nil {
+				t.Errorf("unexpected error for obj=%#v: %v", obj, actualErr)
+			} else if !reflect.DeepEqual(target.Elem().Interface(), expected) {
+				t.Errorf("unexpected result for obj=%#v: %#v (expected: %#v)", obj, target.Elem().Interface(), expected)
+			}
+		} else if !errors.Is(actualErr, err) && reflect.TypeOf(actualErr) != reflect.TypeOf(err) {
+			t.Errorf("unexpected error for obj=%#v: %v (expected: %v)", obj, actualErr, err)
+		}
+	}
+
+	target := reflect.New(reflect.TypeOf(expected))
+	check(target, UnmarshalInto(opts, bytes.NewBuffer(encoded), target.Interface()))
+	target = reflect.New(reflect.TypeOf(expected))
+	check(target, UnmarshalBytesInto(opts, encoded, target.Interface()))
+}
+
+func TestUnmarshalInto(t *testing.T) {
+	typeErr := &UnmarshalIntoTypeError{}
+
+	// Scalars:
+	testUnmarshalInto(t, nil, 123, int(123), nil)
+	testUnmarshalInto(t, nil, 123, int8(123), nil)
+	testUnmarshalInto(t, nil, 123, uint32(123), nil)
+	testUnmarshalInto(t, nil, uint(123), int64(123), nil)
+	testUnmarshalInto(t, nil, 123, float64(123), nil)
+	testUnmarshalInto(t, nil, float32(1.5), float64(1.5), nil)
+	testUnmarshalInto(t, nil, float64(1.5), float32(1.5), nil)
+	testUnmarshalInto(t, nil, "hi", "hi", nil)
+	testUnmarshalInto(t, nil, true, true, nil)
+	testUnmarshalInto(t, nil, 123, any(123), nil)
+	testUnmarshalInto(t, nil, nil, (*int)(nil), nil)
+	testUnmarshalInto(t, nil, 123, testMarshalType4(123), nil)
+	testUnmarshalInto(t, nil, time.Unix(123, 0), time.Unix(123, 0), nil)
+	// Overflows and mismatches:
+	testUnmarshalInto(t, nil, 128, int8(0), typeErr)
+	testUnmarshalInto(t, nil, -1, uint(0), typeErr)
+	testUnmarshalInto(t, nil, uint(1<<63), int(0), typeErr)
+	testUnmarshalInto(t, nil, float64(1e300), float32(0), typeErr)
+	testUnmarshalInto(t, nil, 1.5, int(0), typeErr)
+	testUnmarshalInto(t, nil, "hi", int(0), typeErr)
+	testUnmarshalInto(t, nil, 1, "", typeErr)
+	testUnmarshalInto(t, nil, 1, false, typeErr)
+
+	// Pointers:
+	i := 123
+	testUnmarshalInto(t, nil, 123, &i, nil)
+
+	// Slices and arrays:
+	testUnmarshalInto(t, nil, []int{1, 2, 3}, []int{1, 2, 3}, nil)
+	testUnmarshalInto(t, nil, []int{1, 2, 3}, [3]uint8{1, 2, 3}, nil)
+	testUnmarshalInto(t, nil, []byte{1, 2, 3}, []byte{1, 2, 3}, nil)
+	testUnmarshalInto(t, nil, []byte{1, 2, 3}, [3]byte{1, 2, 3}, nil)
+	testUnmarshalInto(t, nil, []any{"a", 1}, []any{"a", 1}, nil)
+	testUnmarshalInto(t, nil, []int{1, 2, 3}, [2]int{}, typeErr)
+	testUnmarshalInto(t, nil, []any{"a", 1}, []string{}, typeErr)
+	testUnmarshalInto(t, nil, "abc", []byte{}, typeErr)
+
+	// Maps:
+	testUnmarshalInto(t, nil, map[string]int{"a": 1, "b": 2}, map[string]int{"a": 1, "b": 2}, nil)
+	testUnmarshalInto(t, nil, map[int]string{1: "a"}, map[int64]string{1: "a"}, nil)
+	testUnmarshalInto(t, nil, map[string]int{"a": 1}, map[any]any{"a": 1}, nil)
+	testUnmarshalInto(t, nil, map[string]any{"a": "b"}, map[string]int{}, typeErr)
+
+	// Structs:
+	obj := map[string]any{
+		"Embedded": "embedded",
+		"Name":     "name",
+		"Count":    42,
+		"Ratio":    0.5,
+		"Flag":     true,
+		"Tags":     []string{"x", "y"},
+		"Inner":    map[string]any{"Value": 1},
+		"Ptr":      map[string]any{"Value": 2},
+		"Map":      map[string]any{"z": 3},
+		"Any":      []any{"hi"},
+		"When":     time.Unix(123, 456),
+		"Data":     []byte("data"),
+		"private":  123,
+		"Unknown":  "ignored",
+	}
+	expected := testUnmarshalIntoStruct{
+		testUnmarshalIntoEmbedded: testUnmarshalIntoEmbedded{Embedded: "embedded"},
+		Name:                      "name",
+		Count:                     42,
+		Ratio:                     0.5,
+		Flag:                      true,
+		Tags:                      []string{"x", "y"},
+		Inner:                     testUnmarshalIntoInner{Value: 1},
+		Ptr:                       &testUnmarshalIntoInner{Value: 2},
+		Map:                       map[string]int{"z": 3},
+		Any:                       []any{"hi"},
+		When:                      time.Unix(123, 456),
+		Data:                      []byte("data"),
+	}
+	testUnmarshalInto(t, nil, obj, expected, nil)
+	testUnmarshalInto(t, nil, map[string]any{}, testUnmarshalIntoStruct{}, nil)
+	testUnmarshalInto(t, nil, map[string]any{"Count": -1}, testUnmarshalIntoStruct{}, typeErr)
+	testUnmarshalInto(t, nil, map[string]any{"Inner": map[string]any{"Value": "x"}}, testUnmarshalIntoStruct{}, typeErr)
+	testUnmarshalInto(t, nil, map[any]any{1: 2}, testUnmarshalIntoStruct{}, typeErr)
+	testUnmarshalInto(t, nil, []any{}, testUnmarshalIntoStruct{}, typeErr)
+}
+
+func TestUnmarshalInto_errorPath(t *testing.T) {
+	encoded, _ := MarshalToBytes(nil, map[string]any{"Inner": map[string]any{"Value": "x"}})
+	var target testUnmarshalIntoStruct
+	err := UnmarshalBytesInto(nil, encoded, &target)
+	if typeErr, ok := err.(*UnmarshalIntoTypeError); !ok || typeErr.Path != ".Inner.Value" || typeErr.Value != "x" || typeErr.Type != reflect.TypeOf(0) {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+
+	encoded, _ = MarshalToBytes(nil, []any{1, "x"})
+	var target2 []int
+	err = UnmarshalBytesInto(nil, encoded, &target2)
+	if typeErr, ok := err.(*UnmarshalIntoTypeError); !ok || typeErr.Path != "[1]" {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+}
+
+type testUnmarshalIntoMaps struct {
+	Ints   map[string]int
+	Any    map[string]any
+	ByUint map[uint8]string
+}
+
+func TestUnmarshalInto_mapFields(t *testing.T) {
+	obj := map[string]any{
+		"Ints":   map[string]any{"a": 1, "b": uint(2)},
+		"Any":    map[string]any{"x": "y", "z": []any{1, nil}, "n": nil},
+		"ByUint": map[any]any{1: "one", uint(255): "max"},
+	}
+	expected := testUnmarshalIntoMaps{
+		Ints:   map[string]int{"a": 1, "b": 2},
+		Any:    map[string]any{"x": "y", "z": []any{1, nil}, "n": nil},
+		ByUint: map[uint8]string{1: "one", 255: "max"},
+	}
+	testUnmarshalInto(t, nil, obj, expected, nil)
+
+	// Empty maps are allocated; nil leaves a nil map.
+	testUnmarshalInto(t, nil, map[string]any{"Ints": map[string]any{}, "Any": nil}, testUnmarshalIntoMaps{Ints: map[string]int{}}, nil)
+
+	testCases := []struct {
+		obj   any
+		value any
+		typ   reflect.Type
+		path  string
+	}{
+		// Keys that aren't convertible.
+		{map[string]any{"Ints": map[any]any{1: 1}}, 1, reflect.TypeOf(""), ".Ints[1]"},
+		{map[string]any{"ByUint": map[any]any{256: "x"}}, 256, reflect.TypeOf(uint8(0)), ".ByUint[256]"},
+		{map[string]any{"ByUint": map[any]any{"a": "x"}}, "a", reflect.TypeOf(uint8(0)), `.ByUint["a"]`},
+		// Values that aren't convertible.
+		{map[string]any{"Ints": map[string]any{"a": "x"}}, "x", reflect.TypeOf(0), `.Ints["a"]`},
+		{map[string]any{"Ints": map[string]any{"a": 1.5}}, 1.5, reflect.TypeOf(0), `.Ints["a"]`},
+		// Not a map.
+		{map[string]any{"Any": []any{}}, []any{}, reflect.TypeOf(map[string]any{}), ".Any"},
+	}
+	for i, tC := range testCases {
+		encoded, _ := MarshalToBytes(nil, tC.obj)
+		var target testUnmarshalIntoMaps
+		err := UnmarshalBytesInto(nil, encoded, &target)
+		if typeErr, ok := err.(*UnmarshalIntoTypeError); !ok || !reflect.DeepEqual(typeErr.Value, tC.value) || typeErr.Type != tC.typ || typeErr.Path != tC.path {
+			t.Errorf("%v: unexpected error: %#v", i, err)
+		}
+	}
+}
+
+func TestUnmarshalInto_invalidTarget(t *testing.T) {
+	var i int
+	if err := UnmarshalBytesInto(nil, []byte{0x01}, i); err != InvalidUnmarshalTargetError {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := UnmarshalBytesInto(nil, []byte{0x01}, (*int)(nil)); err != InvalidUnmarshalTargetError {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := UnmarshalBytesInto(nil, []byte{0x01}, nil); err != InvalidUnmarshalTargetError {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// Unmarshal errors are passed through.
+	if err := UnmarshalBytesInto(nil, []byte{}, &i); err != io.EOF {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := UnmarshalInto(nil, bytes.NewBuffer([]byte{0xc1}), &i); !errors.Is(err, InvalidFormatError) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestUnmarshalInto_binToBase64String(t *testing.T) {
+	typeErr := &UnmarshalIntoTypeError{}
+	opts := &UnmarshalOptions{BinToBase64String: true}
+
+	testUnmarshalInto(t, nil, []byte("hello"), "", typeErr)
+	testUnmarshalInto(t, opts, []byte("hello"), "aGVsbG8=", nil)
+	testUnmarshalInto(t, opts, []byte{}, "", nil)
+	testUnmarshalInto(t, opts, "hello", "hello", nil)
+	testUnmarshalInto(t, opts, map[string]any{"Name": []byte{0xff, 0xfe}}, testUnmarshalIntoStruct{Name: "//4="}, nil)
+	// Binary is still assigned to []byte.
+	testUnmarshalInto(t, opts, []byte("hello"), []byte("hello"), nil)
+
+	// Round trip: bin -> base64 string -> bin.
+	data := []byte{0x00, 0x01, 0x80, 0xff}
+	encoded, _ := MarshalToBytes(nil, data)
+	var s string
+	if err := UnmarshalBytesInto(opts, encoded, &s); err != nil || s != "AAGA/w==" {
+		t.Fatalf("Unexpected result: %q, %v", s, err)
+	}
+	reencoded, err := MarshalToBytes(nil, s)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedReencoded, _ := MarshalToBytes(&MarshalOptions{BytesToBase64String: true}, data)
+	if !bytes.Equal(reencoded, expectedReencoded) {
+		t.Errorf("Unexpected result: %v (expected: %v)", reencoded, expectedReencoded)
+	}
+}
+
+func TestUnmarshalInto_unknownKeyFn(t *testing.T) {
+	unknown := map[string]any{}
+	opts := &UnmarshalOptions{
+		UnknownKeyFn: func(key string, value any) {
+			if _, ok := unknown[key]; ok {
+				t.Errorf("Unexpected repeated key: %q", key)
+			}
+			unknown[key] = value
+		},
+	}
+
+	obj := map[string]any{
+		"Name":     "name",
+		"Embedded": "embedded",
+		"Unknown":  []any{1, "x"},
+		"private":  123,
+		"Inner":    map[string]any{"Value": 1, "Extra": true},
+		"name":     "lowercase",
+	}
+	encoded, _ := MarshalToBytes(nil, obj)
+	var target testUnmarshalIntoStruct
+	if err := UnmarshalBytesInto(opts, encoded, &target); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := testUnmarshalIntoStruct{
+		testUnmarshalIntoEmbedded: testUnmarshalIntoEmbedded{Embedded: "embedded"},
+		Name:                      "name",
+		Inner:                     testUnmarshalIntoInner{Value: 1},
+	}
+	if !reflect.DeepEqual(target, expected) {
+		t.Errorf("Unexpected result: %#v (expected: %#v)", target, expected)
+	}
+	expectedUnknown := map[string]any{
+		"Unknown": []any{1, "x"},
+		"private": 123,
+		"Extra":   true,
+		"name":    "lowercase",
+	}
+	if !reflect.DeepEqual(unknown, expectedUnknown) {
+		t.Errorf("Unexpected unknown keys: %#v (expected: %#v)", unknown, expectedUnknown)
+	}
+}
+
+func TestUnmarshalInto_strictSignedness(t *testing.T) {
+	opts := &UnmarshalOptions{StrictSignedness: true}
+
+	// uint 8 (from a uint) into int.
+	testUnmarshalInto(t, nil, uint(123), int(123), nil)
+	testUnmarshalInto(t, opts, uint(123), int(0), SignednessMismatchError)
+	testUnmarshalInto(t, opts, map[string]any{"Value": uint(1)}, testUnmarshalIntoInner{}, SignednessMismatchError)
+	// int (including fixint) into uint.
+	testUnmarshalInto(t, nil, 123, uint16(123), nil)
+	testUnmarshalInto(t, opts, 123, uint16(0), SignednessMismatchError)
+	testUnmarshalInto(t, opts, -1000, uint(0), SignednessMismatchError)
+	// Matching signedness is fine.
+	testUnmarshalInto(t, opts, 123, int8(123), nil)
+	testUnmarshalInto(t, opts, uint(123), uint8(123), nil)
+	testUnmarshalInto(t, opts, map[string]any{"Value": 1}, testUnmarshalIntoInner{Value: 1}, nil)
+	// As are floats and any.
+	testUnmarshalInto(t, opts, uint(123), float64(123), nil)
+	testUnmarshalInto(t, opts, uint(123), any(uint(123)), nil)
+}
+
+func TestUnmarshalInto_inferMapValueTypes(t *testing.T) {
+	opts := &UnmarshalOptions{StringKeyedMaps: true, InferMapValueTypes: true}
+	testUnmarshalInto(t, opts, map[string]any{"a": 1}, map[string]int64{"a": 1}, nil)
+	testUnmarshalInto(t, opts, map[string]any{"a": 1}, map[any]any{"a": 1}, nil)
+	testUnmarshalInto(t, opts, map[string]any{"Value": 1}, testUnmarshalIntoInner{Value: 1}, nil)
+	testUnmarshalInto(t, opts, map[string]any{"Value": "x"}, testUnmarshalIntoInner{}, &UnmarshalIntoTypeError{})
+}
+
+func TestUnmarshalBytesInto_wideIntegers(t *testing.T) {
+	opts := &UnmarshalOptions{WideIntegers: true}
+	var i8 int8
+	if err := UnmarshalBytesInto(opts, []byte{0xff}, &i8); err != nil || i8 != -1 {
+		t.Errorf("Unexpected result: %v, %v", i8, err)
+	}
+	var u uint
+	if err := UnmarshalBytesInto(opts, []byte{0xcd, 0x01, 0x00}, &u); err != nil || u != 256 {
+		t.Errorf("Unexpected result: %v, %v", u, err)
+	}
+	var f float64
+	if err := UnmarshalBytesInto(opts, []byte{0xd0, 0x80}, &f); err != nil || f != -128 {
+		t.Errorf("Unexpected result: %v, %v", f, err)
+	}
+	var i int
+	if err := UnmarshalBytesInto(opts, []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, &i); err == nil {
+		t.Errorf("Unexpected success: %v", i)
+	}
+	var u8 uint8
+	if err := UnmarshalBytesInto(&UnmarshalOptions{WideIntegers: true, StrictSignedness: true}, []byte{0x01}, &u8); err != SignednessMismatchError {
+		t.Errorf("Unexpected result: %v, %v", u8, err)
+	}
+}